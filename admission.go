@@ -0,0 +1,89 @@
+package mc
+
+import (
+	"sync/atomic"
+)
+
+const (
+	// sketchDepth is the number of rows of a sketch.
+	sketchDepth = 4
+	// sketchMax is the max value of counters, as in the 4-bit counters of TinyLFU.
+	sketchMax = 15
+	// sketchSampleFactor is the number of recorded accesses per counter of a row
+	// after which all counters are halved.
+	sketchSampleFactor = 10
+)
+
+// sketch is a count-min sketch estimating recent access frequencies of keys, which is the
+// frequency filter of TinyLFU (https://arxiv.org/abs/1512.00727). Counters are halved once
+// enough accesses are recorded, so that keys which were popular long ago age out.
+// It is safe for concurrent use; races between increments and halving only make estimates
+// slightly less accurate.
+type sketch struct {
+	counters []uint32 // sketchDepth rows
+	mask     uint32   // width of rows minus 1
+	added    uint32
+	resetAt  uint32
+}
+
+// newSketch creates a sketch for about n keys.
+func newSketch(n int) *sketch {
+	width := 64
+	for width < n && width < 1<<20 {
+		width *= 2
+	}
+	return &sketch{
+		counters: make([]uint32, sketchDepth*width),
+		mask:     uint32(width - 1),
+		resetAt:  uint32(width * sketchSampleFactor),
+	}
+}
+
+// sketchHash returns the hash of key in sketches. The FNV-1a hash of the default Hasher is
+// remixed, since keys of a shard have the same hash modulo the number of shards, and so the same
+// low bits, which would leave most counters of the rows of its sketch unused.
+func sketchHash(key string) uint32 {
+	return fmix32(fnv32a(key))
+}
+
+// index returns the index of key's counter in row i.
+func (s *sketch) index(h uint32, i int) int {
+	// double hashing with a rotated hash as the second one
+	h += uint32(i) * (h>>17 | h<<15 | 1)
+	return i*int(s.mask+1) + int(h&s.mask)
+}
+
+// increment records an access of key.
+func (s *sketch) increment(key string) {
+	h := sketchHash(key)
+	for i := 0; i < sketchDepth; i++ {
+		c := &s.counters[s.index(h, i)]
+		if n := atomic.LoadUint32(c); n < sketchMax {
+			atomic.CompareAndSwapUint32(c, n, n+1)
+		}
+	}
+	if atomic.AddUint32(&s.added, 1) == s.resetAt {
+		s.reset()
+	}
+}
+
+// estimate returns the estimated frequency of key.
+func (s *sketch) estimate(key string) uint32 {
+	h := sketchHash(key)
+	min := uint32(sketchMax)
+	for i := 0; i < sketchDepth; i++ {
+		if n := atomic.LoadUint32(&s.counters[s.index(h, i)]); n < min {
+			min = n
+		}
+	}
+	return min
+}
+
+// reset halves all counters.
+func (s *sketch) reset() {
+	for i := range s.counters {
+		c := &s.counters[i]
+		atomic.StoreUint32(c, atomic.LoadUint32(c)/2)
+	}
+	atomic.StoreUint32(&s.added, 0)
+}
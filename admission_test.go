@@ -0,0 +1,93 @@
+package mc
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func TestSketch(t *testing.T) {
+	s := newSketch(100)
+	for i := 0; i < 5; i++ {
+		s.increment("hot")
+	}
+	s.increment("cold")
+	if n := s.estimate("hot"); n != 5 {
+		t.Errorf("expected 5, got %d", n)
+	}
+	if n := s.estimate("cold"); n != 1 {
+		t.Errorf("expected 1, got %d", n)
+	}
+	for i := 0; i < 100; i++ {
+		s.increment("hot")
+	}
+	if n := s.estimate("hot"); n != sketchMax {
+		t.Errorf("expected %d, got %d", sketchMax, n)
+	}
+
+	s.reset()
+	if n := s.estimate("hot"); n != sketchMax/2 {
+		t.Errorf("expected counters halved, got %d", n)
+	}
+}
+
+func TestSketchShardCollisions(t *testing.T) {
+	// keys of one of 16 shards, which have the same low bits of their FNV-1a hashes
+	var keys []string
+	for i := 0; len(keys) < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		if FNV1a.Shard(key, 16) == 0 {
+			keys = append(keys, key)
+		}
+	}
+	s := newSketch(len(keys))
+	for i := 0; i < sketchDepth; i++ {
+		used := make(map[int]bool)
+		for _, key := range keys {
+			used[s.index(sketchHash(key), i)] = true
+		}
+		// about 63% of counters are used by as many random keys as counters
+		if width := int(s.mask + 1); len(used) < width/2 {
+			t.Errorf("keys of a shard use %d of %d counters of row %d", len(used), width, i)
+		}
+	}
+}
+
+func TestMemoryStoreAdmission(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore(MemoryStoreOptions{Shards: 1, MaxBytes: 10 * (itemOverhead + 13), Admission: true})
+
+	for i := 0; i < 10; i++ {
+		key := "hot" + strconv.Itoa(100+i)
+		st.Set(ctx, &Item{Key: key, Data: []byte("1234567")})
+		for j := 0; j < 3; j++ {
+			st.Get(ctx, key)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if err := st.Set(ctx, &Item{Key: "key" + strconv.Itoa(100+i), Data: []byte("1234567")}); err != nil {
+			t.Fatalf("Set %v", err)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, err := st.Get(ctx, "hot"+strconv.Itoa(100+i)); err != nil {
+			t.Errorf("hot item %d is evicted", i)
+		}
+	}
+	if admitted, rejected := st.AdmissionStats(); admitted != 0 || rejected != 100 {
+		t.Errorf("unexpected admission stats: %d admitted, %d rejected", admitted, rejected)
+	}
+
+	// frequently used new keys are admitted, once they are used more often than the hot
+	// items, which the gets above made 5 or 6 with collisions in the sketch
+	for i := 0; i < 10; i++ {
+		st.Set(ctx, &Item{Key: "new", Data: []byte("1234567")})
+	}
+	if _, err := st.Get(ctx, "new"); err != nil {
+		t.Errorf("popular new item is rejected")
+	}
+	if admitted, _ := st.AdmissionStats(); admitted != 1 {
+		t.Errorf("expected 1 admitted item, got %d", admitted)
+	}
+}
@@ -0,0 +1,188 @@
+package mc
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// arenaPageSize is the size of pages allocated by arenas, which is also the max size of values.
+	arenaPageSize = 1 << 20
+	// arenaMinChunk is the smallest chunk size.
+	arenaMinChunk = 64
+	// arenaAlign is the alignment of chunk sizes.
+	arenaAlign = 8
+	// DefaultGrowthFactor is the default ratio between chunk sizes of adjacent slab classes.
+	DefaultGrowthFactor = 1.25
+)
+
+// arena stores values in large pages outside the Go heap where the platform supports it,
+// so big caches don't inflate the heap which the GC paces and scans.
+// Like memcached slabs, pages are split into chunks of size classes growing by a factor
+// and never returned to the OS.
+type arena struct {
+	classes []*arenaClass
+}
+
+type arenaClass struct {
+	id   int
+	size int
+
+	mu        sync.Mutex
+	free      [][]byte
+	page      []byte // the rest of the current page
+	pages     int
+	used      int
+	requested int64 // bytes of data in used chunks
+}
+
+// chunk is a value in an arena. It is freed when its reference count drops to zero.
+type chunk struct {
+	buf   []byte
+	class *arenaClass
+	refs  int32
+}
+
+// newArena creates an arena whose chunk sizes grow by factor, which must be greater than 1.
+// As in memcached, the last class holds chunks of a whole page.
+func newArena(factor float64) *arena {
+	a := &arena{}
+	for size := arenaMinChunk; size <= arenaPageSize/2; {
+		a.classes = append(a.classes, &arenaClass{id: len(a.classes) + 1, size: size})
+		next := int(float64(size) * factor)
+		next = (next + arenaAlign - 1) / arenaAlign * arenaAlign
+		if next <= size {
+			next = size + arenaAlign
+		}
+		size = next
+	}
+	a.classes = append(a.classes, &arenaClass{id: len(a.classes) + 1, size: arenaPageSize})
+	return a
+}
+
+// class returns the smallest class which can hold n bytes.
+func (a *arena) class(n int) *arenaClass {
+	for _, c := range a.classes {
+		if n <= c.size {
+			return c
+		}
+	}
+	return nil
+}
+
+// alloc copies data into a new chunk owned by the caller, or returns ErrTooLarge.
+func (a *arena) alloc(data []byte) (*chunk, error) {
+	c := a.class(len(data))
+	if c == nil {
+		return nil, ErrTooLarge
+	}
+	buf, err := c.get(len(data))
+	if err != nil {
+		return nil, err
+	}
+	buf = buf[:len(data)]
+	copy(buf, data)
+	return &chunk{buf: buf, class: c, refs: 1}, nil
+}
+
+// get returns a free chunk for n bytes of data.
+func (c *arenaClass) get(n int) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if i := len(c.free); i > 0 {
+		buf := c.free[i-1]
+		c.free = c.free[:i-1]
+		c.used++
+		c.requested += int64(n)
+		return buf, nil
+	}
+	if len(c.page) < c.size {
+		page, err := allocPage(arenaPageSize)
+		if err != nil {
+			return nil, err
+		}
+		c.page = page
+		c.pages++
+	}
+	buf := c.page[:c.size:c.size]
+	c.page = c.page[c.size:]
+	c.used++
+	c.requested += int64(n)
+	return buf, nil
+}
+
+// put frees a chunk holding buf.
+func (c *arenaClass) put(buf []byte) {
+	c.mu.Lock()
+	c.free = append(c.free, buf[:c.size])
+	c.used--
+	c.requested -= int64(len(buf))
+	c.mu.Unlock()
+}
+
+// SlabClassStats is statistics of a slab class of MemoryStore, see "stats slabs" of memcached.
+type SlabClassStats struct {
+	ID            int
+	ChunkSize     int
+	ChunksPerPage int
+	TotalPages    int
+	TotalChunks   int
+	UsedChunks    int
+	FreeChunks    int   // freed chunks for reuse
+	FreeChunksEnd int   // never used chunks at the end of the current page
+	MemRequested  int64 // bytes of data stored in used chunks
+}
+
+// stats returns statistics of classes which have allocated pages.
+func (a *arena) stats() []SlabClassStats {
+	var stats []SlabClassStats
+	for _, c := range a.classes {
+		c.mu.Lock()
+		if c.pages > 0 {
+			perPage := arenaPageSize / c.size
+			stats = append(stats, SlabClassStats{
+				ID:            c.id,
+				ChunkSize:     c.size,
+				ChunksPerPage: perPage,
+				TotalPages:    c.pages,
+				TotalChunks:   c.pages * perPage,
+				UsedChunks:    c.used,
+				FreeChunks:    len(c.free),
+				FreeChunksEnd: len(c.page) / c.size,
+				MemRequested:  c.requested,
+			})
+		}
+		c.mu.Unlock()
+	}
+	return stats
+}
+
+// acquire takes a reference of the chunk. It fails if the chunk has been freed.
+func (ch *chunk) acquire() bool {
+	for {
+		n := atomic.LoadInt32(&ch.refs)
+		if n <= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&ch.refs, n, n+1) {
+			return true
+		}
+	}
+}
+
+// release drops a reference and frees the chunk when it is the last one.
+func (ch *chunk) release() {
+	if atomic.AddInt32(&ch.refs, -1) == 0 {
+		ch.class.put(ch.buf)
+	}
+}
+
+// bytes returns a heap copy of the chunk's data, or false if the chunk has been freed.
+func (ch *chunk) bytes() ([]byte, bool) {
+	if !ch.acquire() {
+		return nil, false
+	}
+	defer ch.release()
+	return append([]byte(nil), ch.buf...), true
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package mc
+
+// allocPage allocates a page on the Go heap on platforms without anonymous mappings.
+// A page is one pointer-free object, so the GC doesn't scan its contents.
+func allocPage(size int) ([]byte, error) {
+	return make([]byte, size), nil
+}
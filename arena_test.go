@@ -0,0 +1,132 @@
+package mc
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestArena(t *testing.T) {
+	a := newArena(2)
+
+	ch, err := a.alloc([]byte("hello"))
+	if err != nil {
+		t.Fatalf("alloc %v", err)
+	}
+	if ch.class.size != arenaMinChunk || string(ch.buf) != "hello" {
+		t.Errorf("unexpected chunk: %d %q", ch.class.size, ch.buf)
+	}
+
+	// a reader keeps the chunk alive after the owner releases it
+	if !ch.acquire() {
+		t.Fatalf("failed to acquire")
+	}
+	ch.release()
+	if len(ch.class.free) != 0 {
+		t.Errorf("chunk freed while referenced")
+	}
+	ch.release()
+	if len(ch.class.free) != 1 || ch.acquire() {
+		t.Errorf("chunk should be freed")
+	}
+
+	ch2, _ := a.alloc([]byte("world"))
+	if &ch2.buf[0] != &ch.buf[0] {
+		t.Errorf("freed chunk is not reused")
+	}
+
+	if _, err := a.alloc(make([]byte, arenaPageSize+1)); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestMemoryStoreArena(t *testing.T) {
+	st := NewMemoryStore(MemoryStoreOptions{Shards: 4, Arena: true, Index: IndexReadMostly})
+	testMemoryStore(t, st)
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := "k" + strconv.Itoa(i%10)
+				if g%2 == 0 {
+					st.Set(ctx, &Item{Key: key, Data: bytes.Repeat([]byte(key), i%50+1)})
+					continue
+				}
+				if it, err := st.Get(ctx, key); err == nil && !bytes.Equal(it.Data, bytes.Repeat([]byte(key), len(it.Data)/len(key))) {
+					t.Errorf("corrupted data of %s: %q", key, it.Data)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestArenaClasses(t *testing.T) {
+	a := newArena(DefaultGrowthFactor)
+	prev := 0
+	for _, c := range a.classes {
+		if c.size <= prev || c.size%arenaAlign != 0 {
+			t.Errorf("bad class size %d after %d", c.size, prev)
+		}
+		prev = c.size
+	}
+	if a.classes[0].size != arenaMinChunk || prev != arenaPageSize {
+		t.Errorf("unexpected class range: %d-%d", a.classes[0].size, prev)
+	}
+	if c := a.class(81); c.size != 104 { // 80*1.25 aligned
+		t.Errorf("unexpected class of 81 bytes: %d", c.size)
+	}
+}
+
+func TestMemoryStoreSlabStats(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore(MemoryStoreOptions{Arena: true, GrowthFactor: 2})
+	st.Set(ctx, &Item{Key: "a", Data: []byte("small")})
+	st.Set(ctx, &Item{Key: "b", Data: make([]byte, 100)})
+	st.Set(ctx, &Item{Key: "c", Data: make([]byte, 120)})
+	st.Delete(ctx, "c")
+
+	slabs := st.SlabStats()
+	if len(slabs) != 2 {
+		t.Fatalf("expected 2 active classes, got %+v", slabs)
+	}
+	if s := slabs[0]; s.ID != 1 || s.ChunkSize != 64 || s.UsedChunks != 1 || s.MemRequested != 5 ||
+		s.ChunksPerPage != arenaPageSize/64 || s.FreeChunksEnd != s.ChunksPerPage-1 {
+		t.Errorf("unexpected stats of class 1: %+v", s)
+	}
+	if s := slabs[1]; s.ChunkSize != 128 || s.UsedChunks != 1 || s.FreeChunks != 1 || s.MemRequested != 100 {
+		t.Errorf("unexpected stats of class 2: %+v", s)
+	}
+
+	res := &Response{}
+	if err := StatsHandler(st)(ctx, &Request{Command: "stats", Keys: []string{"slabs"}}, res); err != nil {
+		t.Fatalf("stats slabs: %v", err)
+	}
+	for _, line := range []string{"STAT 2:chunk_size 128\r\n", "STAT active_slabs 2\r\n", "STAT total_malloced 2097152\r\nEND"} {
+		if !strings.Contains(res.Response, line) {
+			t.Errorf("expected %q in %q", line, res.Response)
+		}
+	}
+
+	items, err := st.ProvideStats(ctx, StatsRequest{Scope: StatsItems})
+	if err != nil || statValue(items, "items:1:number") != "1" || statValue(items, "items:2:number") != "1" {
+		t.Errorf("unexpected stats items: %v %v", items, err)
+	}
+	settings, err := st.Stats(ctx, "settings")
+	if err != nil || statValue(settings, "slab_arena") != "yes" || statValue(settings, "growth_factor") != "2.00" ||
+		statValue(settings, "item_size_max") != strconv.Itoa(arenaPageSize) || statValue(settings, "evictions") != "off" {
+		t.Errorf("unexpected stats settings: %v %v", settings, err)
+	}
+
+	if NewMemoryStore(MemoryStoreOptions{}).SlabStats() != nil {
+		t.Errorf("expected no slab stats without arena")
+	}
+}
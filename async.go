@@ -0,0 +1,107 @@
+package mc
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"runtime/debug"
+)
+
+// asyncResult is the response of a request handled by a sequencer.
+type asyncResult struct {
+	out   net.Buffers
+	reply bool
+}
+
+// sequencer handles requests of a connection concurrently and writes their responses in the
+// order of the requests, see Server.AsyncRequests.
+type sequencer struct {
+	conn  net.Conn
+	dst   io.Writer // the writer of w, see writeBuffers
+	w     *bufio.Writer
+	slots chan chan asyncResult // responses in the order of requests
+	done  chan struct{}
+}
+
+// newSequencer creates a sequencer which handles up to n requests at a time.
+func newSequencer(conn net.Conn, dst io.Writer, w *bufio.Writer, n int) *sequencer {
+	q := &sequencer{
+		conn: conn,
+		dst:  dst,
+		w:    w,
+		// the writer waits for one slot while n-1 are queued
+		slots: make(chan chan asyncResult, n-1),
+		done:  make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// do runs fn in a goroutine and writes its response after the ones queued before.
+// It blocks while n requests are in flight.
+func (q *sequencer) do(fn func() (out net.Buffers, reply bool)) {
+	slot := make(chan asyncResult, 1)
+	q.slots <- slot
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("memcached server panic error: %s, stack: %s", err, string(debug.Stack()))
+				q.conn.Close()
+				slot <- asyncResult{}
+			}
+		}()
+		out, reply := fn()
+		slot <- asyncResult{out, reply}
+	}()
+}
+
+// reply writes out after the responses queued before.
+func (q *sequencer) reply(out string) {
+	slot := make(chan asyncResult, 1)
+	slot <- asyncResult{net.Buffers{[]byte(out)}, true}
+	q.slots <- slot
+}
+
+// run writes the responses in order.
+// It flushes whenever it waits, so ready responses are written together.
+func (q *sequencer) run() {
+	defer close(q.done)
+	var err error
+	fail := func() {
+		log.Printf("failed to write responses to %s: %v", q.conn.RemoteAddr().String(), err)
+		q.conn.Close() // stops reading requests
+	}
+	flush := func() {
+		if err != nil {
+			return
+		}
+		if err = q.w.Flush(); err != nil {
+			fail()
+		}
+	}
+	for slot := range q.slots {
+		var res asyncResult
+		select {
+		case res = <-slot:
+		default:
+			flush()
+			res = <-slot
+		}
+		if res.reply && err == nil {
+			if err = writeBuffers(q.dst, q.w, res.out); err != nil {
+				fail()
+			}
+		}
+		if len(q.slots) == 0 {
+			flush()
+		}
+	}
+	flush()
+}
+
+// close waits until the responses of all requests are written.
+func (q *sequencer) close() {
+	close(q.slots)
+	<-q.done
+}
@@ -0,0 +1,99 @@
+package mc
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAsyncRequestsOrder(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.AsyncRequests = 8
+	var sets int32
+	s.RegisterFunc("get", func(ctx context.Context, req *Request, res *Response) error {
+		if strings.HasPrefix(req.Keys[0], "slow") {
+			time.Sleep(200 * time.Millisecond)
+		}
+		res.AddValue(req.Keys[0], 0, []byte("v"), 0)
+		return res.End()
+	})
+	s.RegisterFunc("set", func(ctx context.Context, req *Request, res *Response) error {
+		atomic.AddInt32(&sets, 1)
+		return res.Stored()
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	conn.Write([]byte("get slow1\r\nget fast1\r\nset k 0 0 1 noreply\r\nx\r\nget slow2\r\n" +
+		"set k 0 0 x\r\nset k 0 0 1\r\ny\r\nget fast2\r\n"))
+	want := []string{
+		"VALUE slow1 0 1", "v", "END",
+		"VALUE fast1 0 1", "v", "END",
+		"VALUE slow2 0 1", "v", "END",
+		"CLIENT_ERROR MC Protocol error: cannot read bytes",
+		"STORED",
+		"VALUE fast2 0 1", "v", "END",
+	}
+	r := bufio.NewReader(conn)
+	for _, w := range want {
+		line, err := r.ReadString('\n')
+		if err != nil || !strings.HasPrefix(line, w) || !strings.HasSuffix(line, "\r\n") {
+			t.Fatalf("expected %q, got %q %v", w, line, err)
+		}
+	}
+	if d := time.Since(start); d > 350*time.Millisecond {
+		t.Errorf("slow requests are not handled concurrently: %v", d)
+	}
+	if n := atomic.LoadInt32(&sets); n != 2 {
+		t.Errorf("expected 2 sets, got %d", n)
+	}
+}
+
+func TestAsyncRequestsQuit(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.AsyncRequests = 2
+	s.RegisterFunc("get", func(ctx context.Context, req *Request, res *Response) error {
+		time.Sleep(50 * time.Millisecond)
+		res.AddValue(req.Keys[0], 0, []byte("v"), 0)
+		return res.End()
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	// more requests than AsyncRequests, and responses are written before quit closes the connection
+	conn.Write([]byte("get a\r\nget b\r\nget c\r\nquit\r\n"))
+	var b strings.Builder
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		b.Write(buf[:n])
+		if err != nil {
+			break
+		}
+	}
+	want := "VALUE a 0 1\r\nv\r\nEND\r\nVALUE b 0 1\r\nv\r\nEND\r\nVALUE c 0 1\r\nv\r\nEND\r\n"
+	if b.String() != want {
+		t.Errorf("unexpected responses %q", b.String())
+	}
+}
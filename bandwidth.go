@@ -0,0 +1,140 @@
+package mc
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxThrottledWrite is the max size of the writes of throttled connections, so large responses
+// are sent at a steady rate rather than in bursts.
+const maxThrottledWrite = 16 << 10
+
+// bandwidthLimiter is a token bucket of bytes per second, with a burst of one second.
+type bandwidthLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBandwidthLimiter(rate int64) *bandwidthLimiter {
+	return &bandwidthLimiter{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// reserve takes n bytes from the bucket and returns how long to wait before writing them.
+// Reservations can exceed the tokens left, so writers of a shared bucket queue fairly.
+func (b *bandwidthLimiter) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// clientLimiter is the limiter shared by the connections of a client IP.
+type clientLimiter struct {
+	*bandwidthLimiter
+	conns int
+}
+
+// throttledWriter writes to a connection within the bandwidth of its limiters.
+type throttledWriter struct {
+	w        io.Writer
+	limiters []*bandwidthLimiter
+	s        *Server
+	done     <-chan struct{} // closed when the connection is closed
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxThrottledWrite {
+			chunk = chunk[:maxThrottledWrite]
+		}
+		var wait time.Duration
+		for _, l := range t.limiters {
+			if d := l.reserve(len(chunk)); d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			atomic.AddUint64(&t.s.counters.throttled, 1)
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-t.done:
+				timer.Stop()
+				return written, net.ErrClosed
+			}
+		}
+		n, err := t.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// connWriter returns the writer of responses to conn, which is throttled by MaxConnBandwidth and
+// MaxClientBandwidth, and a function to call when conn is closed. Throttled writes stop waiting
+// when the closed channel is closed.
+func (s *Server) connWriter(conn net.Conn, closed <-chan struct{}) (io.Writer, func()) {
+	var limiters []*bandwidthLimiter
+	if s.MaxConnBandwidth > 0 {
+		limiters = append(limiters, newBandwidthLimiter(s.MaxConnBandwidth))
+	}
+	done := func() {}
+	if s.MaxClientBandwidth > 0 {
+		ip := clientIP(conn.RemoteAddr())
+		s.bwMu.Lock()
+		if s.bwClients == nil {
+			s.bwClients = make(map[string]*clientLimiter)
+		}
+		cl := s.bwClients[ip]
+		if cl == nil {
+			cl = &clientLimiter{bandwidthLimiter: newBandwidthLimiter(s.MaxClientBandwidth)}
+			s.bwClients[ip] = cl
+		}
+		cl.conns++
+		s.bwMu.Unlock()
+		limiters = append(limiters, cl.bandwidthLimiter)
+		done = func() {
+			s.bwMu.Lock()
+			defer s.bwMu.Unlock()
+			if cl.conns--; cl.conns == 0 {
+				delete(s.bwClients, ip)
+			}
+		}
+	}
+	if len(limiters) == 0 {
+		return conn, done
+	}
+	return &throttledWriter{w: conn, limiters: limiters, s: s, done: closed}, done
+}
+
+// clientIP returns the IP of a remote address, or the address itself if it has no port, like
+// unix sockets.
+func clientIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
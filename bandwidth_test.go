@@ -0,0 +1,84 @@
+package mc
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+func TestBandwidthLimiter(t *testing.T) {
+	b := newBandwidthLimiter(10000)
+	if d := b.reserve(10000); d != 0 {
+		t.Errorf("burst is throttled for %v", d)
+	}
+	if d := b.reserve(2000); d < 150*time.Millisecond || d > 200*time.Millisecond {
+		t.Errorf("expected to wait about 200ms, got %v", d)
+	}
+}
+
+func TestMaxConnBandwidth(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.MaxConnBandwidth = 100000
+	s.MaxClientBandwidth = 1 << 30
+	st := NewMemoryStore(MemoryStoreOptions{})
+	defer st.Close()
+	RegisterStore(s, st)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer s.Stop()
+
+	value := bytes.Repeat([]byte("x"), 150000)
+	mc := memcache.New(s.Addr().String())
+	mc.Timeout = 5 * time.Second
+	if err := mc.Set(&memcache.Item{Key: "big", Value: value}); err != nil {
+		t.Fatalf("failed to set: %v", err)
+	}
+	start := time.Now()
+	it, err := mc.Get("big")
+	if err != nil || !bytes.Equal(it.Value, value) {
+		t.Fatalf("failed to get: %v", err)
+	}
+	// the second 50KB wait for about half a second after the burst of 100KB
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("response is not throttled: %v", d)
+	}
+	if clientIP(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}) != "127.0.0.1" {
+		t.Errorf("unexpected client IP")
+	}
+	s.bwMu.Lock()
+	n := len(s.bwClients)
+	s.bwMu.Unlock()
+	if n != 1 {
+		t.Errorf("expected the limiter of 1 client, got %d", n)
+	}
+}
+
+func TestThrottledWriteStop(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.MaxConnBandwidth = 5000
+	st := NewMemoryStore(MemoryStoreOptions{})
+	defer st.Close()
+	RegisterStore(s, st)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+
+	mc := memcache.New(s.Addr().String())
+	mc.Timeout = 30 * time.Second
+	// the first chunk after the burst waits for about 2 seconds, longer than Stop waits for connections
+	if err := mc.Set(&memcache.Item{Key: "big", Value: bytes.Repeat([]byte("x"), 50000)}); err != nil {
+		t.Fatalf("failed to set: %v", err)
+	}
+	go mc.Get("big")
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	s.Stop()
+	if s.ClientCount() != 0 {
+		t.Errorf("throttled connection is still open after %v", time.Since(start))
+	}
+}
@@ -0,0 +1,29 @@
+package mc
+
+import (
+	"context"
+	"strings"
+)
+
+// serveBatch handles a mset or mdelete extension command by passing every item
+// to the handler of its command. It replies one result line per item followed by END.
+func (h *handlers) serveBatch(ctx context.Context, req *Request, res *Response) error {
+	results := make([]string, 0, len(req.Batch)+1)
+	for _, item := range req.Batch {
+		fn, ok := h.handler(item.Command)
+		if !ok {
+			results = append(results, RespErr+item.Command+" not implemented'")
+			continue
+		}
+
+		r := &Response{}
+		if err := fn(ctx, item, r); err != nil {
+			setError(item, r, err)
+		}
+		results = append(results, r.Response)
+	}
+	results = append(results, RespEnd)
+
+	res.Response = strings.Join(results, "\r\n")
+	return nil
+}
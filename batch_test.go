@@ -0,0 +1,68 @@
+package mc
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBatch(t *testing.T) {
+	in := "mset 2 noreply\r\nk1 0 0 2\r\nv1\r\nk2 5 0 3\r\nv22\r\nmdelete k1 k2\r\n"
+	r := bufio.NewReader(strings.NewReader(in))
+
+	req, err := readRequest(r, readOptions{batch: true, keepRaw: true})
+	if err != nil {
+		t.Fatalf("ReadRequest %+v", err)
+	}
+	if len(req.Batch) != 2 || !req.Noreply {
+		t.Fatalf("unexpected request: %+v", req)
+	}
+	if b := req.Batch[1]; b.Command != "set" || b.Key != "k2" || b.Flags != "5" || string(b.Data) != "v22" || !b.Noreply {
+		t.Errorf("unexpected item: %+v", b)
+	}
+	if string(req.Raw) != "mset 2 noreply\r\nk1 0 0 2\r\nv1\r\nk2 5 0 3\r\nv22\r\n" {
+		t.Errorf("Raw %q", req.Raw)
+	}
+
+	req, err = readRequest(r, readOptions{batch: true})
+	if err != nil {
+		t.Fatalf("ReadRequest %+v", err)
+	}
+	if len(req.Batch) != 2 || req.Batch[1].Command != "delete" || req.Batch[1].Key != "k2" {
+		t.Errorf("unexpected request: %+v", req)
+	}
+
+	if _, err := testReq("mset 1\r\nk1 0 0 2\r\nv1\r\n", t); err == nil {
+		t.Errorf("mset should be disabled by default")
+	}
+}
+
+func TestServeBatch(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.EnableBatchCommands = true
+	s.RegisterFunc("set", DefaultSet)
+	s.RegisterFunc("delete", DefaultDelete)
+	s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", s.ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("mset 2\r\nbatch1 0 0 1\r\na\r\nbatch2 0 0 1\r\nb\r\nmdelete batch1 batch3\r\n"))
+	expected := "STORED\r\nSTORED\r\nEND\r\nDELETED\r\nNOT_FOUND\r\nEND\r\n"
+	buf := make([]byte, len(expected))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("failed to read responses: %v", err)
+	}
+	if string(buf) != expected {
+		t.Errorf("unexpected responses: %q", buf)
+	}
+}
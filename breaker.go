@@ -0,0 +1,168 @@
+package mc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by handlers wrapped by CircuitBreaker while the breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerOptions configures CircuitBreaker.
+type BreakerOptions struct {
+	// Window is the duration in which requests and failures are counted. Default is 10s.
+	Window time.Duration
+	// MinRequests is the min number of requests in a window before the breaker can open. Default is 20.
+	MinRequests int
+	// ErrorRate opens the breaker when the ratio of failures in a window reaches it. Default is 0.5.
+	ErrorRate float64
+	// Timeout makes handlers slower than it count as failures. Handlers also get it as the context deadline.
+	// 0 means no timeout.
+	Timeout time.Duration
+	// OpenDuration is how long the breaker keeps open before it lets a probe request through. Default is 5s.
+	OpenDuration time.Duration
+	// Key returns which breaker a request belongs to, for example the backend it uses.
+	// Default is the command of the request.
+	Key func(ctx context.Context, req *Request) string
+	// Clock tells the time of windows, open durations and timeouts. Default is SystemClock.
+	// The context deadline of Timeout always uses the system time.
+	Clock Clock
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker is the state of one circuit.
+type breaker struct {
+	state       breakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probing     bool
+}
+
+// CircuitBreaker returns a middleware which fast-fails requests with ErrCircuitOpen
+// after the handler keeps failing, which protects struggling backends behind handlers.
+//
+// The breaker opens when the error rate in a window reaches ErrorRate. After OpenDuration
+// it becomes half-open and lets one request through: the breaker closes if it succeeds
+// and opens again if it fails. Expected errors like ErrNotFound and ErrExists are results
+// rather than failures, so they don't count, and handlers which panic count as failures.
+func CircuitBreaker(opts BreakerOptions) Middleware {
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = 20
+	}
+	if opts.ErrorRate <= 0 {
+		opts.ErrorRate = 0.5
+	}
+	if opts.OpenDuration <= 0 {
+		opts.OpenDuration = 5 * time.Second
+	}
+	if opts.Key == nil {
+		opts.Key = func(ctx context.Context, req *Request) string {
+			return req.Command
+		}
+	}
+
+	clock := clockOrSystem(opts.Clock)
+
+	var mu sync.Mutex
+	breakers := make(map[string]*breaker)
+
+	// allow reports whether the request can go through and if it is a half-open probe.
+	allow := func(key string, now time.Time) (ok bool, probe bool) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		b := breakers[key]
+		if b == nil {
+			b = &breaker{windowStart: now}
+			breakers[key] = b
+		}
+		switch b.state {
+		case breakerOpen:
+			if now.Sub(b.openedAt) < opts.OpenDuration {
+				return false, false
+			}
+			b.state = breakerHalfOpen
+			fallthrough
+		case breakerHalfOpen:
+			if b.probing {
+				return false, false
+			}
+			b.probing = true
+			return true, true
+		}
+		return true, false
+	}
+
+	done := func(key string, probe, failed bool, now time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		b := breakers[key]
+		if probe {
+			b.probing = false
+			if failed {
+				b.state, b.openedAt = breakerOpen, now
+			} else {
+				*b = breaker{windowStart: now}
+			}
+			return
+		}
+		if b.state != breakerClosed {
+			return
+		}
+
+		if now.Sub(b.windowStart) >= opts.Window {
+			b.windowStart, b.requests, b.failures = now, 0, 0
+		}
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= opts.MinRequests && float64(b.failures) >= opts.ErrorRate*float64(b.requests) {
+			b.state, b.openedAt = breakerOpen, now
+		}
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request, res *Response) error {
+			key := opts.Key(ctx, req)
+			ok, probe := allow(key, clock.Now())
+			if !ok {
+				return ErrCircuitOpen
+			}
+
+			if opts.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+				defer cancel()
+			}
+			start := clock.Now()
+			// a panic of the handler is recorded as a failure, so a probe can't keep the breaker
+			// half-open, and it goes on to the server
+			var err error
+			panicked := true
+			defer func() {
+				now := clock.Now()
+				failed := panicked || (err != nil && !expectedError(err)) || (opts.Timeout > 0 && now.Sub(start) > opts.Timeout)
+				done(key, probe, failed, now)
+			}()
+			err = next(ctx, req, res)
+			panicked = false
+			return err
+		}
+	}
+}
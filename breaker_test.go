@@ -0,0 +1,122 @@
+package mc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	failing := true
+	clock := NewFakeClock(time.Unix(1000, 0))
+	fn := CircuitBreaker(BreakerOptions{
+		MinRequests:  4,
+		ErrorRate:    0.5,
+		OpenDuration: 50 * time.Millisecond,
+		Clock:        clock,
+	})(func(ctx context.Context, req *Request, res *Response) error {
+		if failing {
+			return errors.New("backend is down")
+		}
+		return nil
+	})
+	call := func() error {
+		return fn(context.Background(), &Request{Command: "get"}, &Response{})
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := call(); err == ErrCircuitOpen {
+			t.Fatalf("breaker opened too early at request %d", i)
+		}
+	}
+	if err := call(); err != ErrCircuitOpen {
+		t.Fatalf("expected open breaker, got %v", err)
+	}
+
+	// a failed probe opens the breaker again
+	clock.Advance(40 * time.Millisecond)
+	if err := call(); err != ErrCircuitOpen {
+		t.Fatalf("expected open breaker before OpenDuration, got %v", err)
+	}
+	clock.Advance(20 * time.Millisecond)
+	if err := call(); err == ErrCircuitOpen {
+		t.Fatalf("expected a probe request")
+	}
+	if err := call(); err != ErrCircuitOpen {
+		t.Fatalf("expected open breaker after a failed probe, got %v", err)
+	}
+
+	// a successful probe closes the breaker
+	failing = false
+	clock.Advance(60 * time.Millisecond)
+	if err := call(); err != nil {
+		t.Fatalf("expected a successful probe, got %v", err)
+	}
+	if err := call(); err != nil {
+		t.Fatalf("expected closed breaker, got %v", err)
+	}
+
+	// breakers are per command by default
+	other := fn(context.Background(), &Request{Command: "set"}, &Response{})
+	if other != nil {
+		t.Errorf("unexpected error: %v", other)
+	}
+}
+
+func TestCircuitBreakerExpected(t *testing.T) {
+	fn := CircuitBreaker(BreakerOptions{MinRequests: 2})(func(ctx context.Context, req *Request, res *Response) error {
+		switch req.Command {
+		case "get":
+			return ErrNotFound
+		case "cas":
+			return ErrExists
+		}
+		return ErrNotStored
+	})
+	for i := 0; i < 10; i++ {
+		for _, cmd := range []string{"get", "cas", "add"} {
+			if err := fn(context.Background(), &Request{Command: cmd}, &Response{}); err == ErrCircuitOpen {
+				t.Fatalf("expected errors of %s open the breaker", cmd)
+			}
+		}
+	}
+}
+
+func TestCircuitBreakerProbePanic(t *testing.T) {
+	panicking := true
+	clock := NewFakeClock(time.Unix(1000, 0))
+	fn := CircuitBreaker(BreakerOptions{MinRequests: 1, OpenDuration: time.Second, Clock: clock})(
+		func(ctx context.Context, req *Request, res *Response) error {
+			if panicking {
+				panic("handler bug")
+			}
+			return nil
+		})
+	call := func() (panicked bool, err error) {
+		defer func() {
+			if recover() != nil {
+				panicked = true
+			}
+		}()
+		return false, fn(context.Background(), &Request{Command: "get"}, &Response{})
+	}
+
+	if panicked, _ := call(); !panicked {
+		t.Fatalf("expected the panic to go on")
+	}
+	if _, err := call(); err != ErrCircuitOpen {
+		t.Fatalf("expected open breaker after a panic, got %v", err)
+	}
+
+	// a panicking probe opens the breaker again rather than keeping it half-open
+	clock.Advance(time.Second)
+	if panicked, _ := call(); !panicked {
+		t.Fatalf("expected a probe request")
+	}
+	panicking = false
+	clock.Advance(time.Second)
+	if _, err := call(); err != nil {
+		t.Fatalf("expected a successful probe, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package mc
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidResponse is returned by response builders and StreamWriter for combinations which
+// violate the protocol, and by ReadResponse for lines which are not responses of the command.
+var ErrInvalidResponse = errors.New("invalid response")
+
+// AddValue adds a value to a retrieval response. cas is omitted if it is 0.
+// It fails if the response has been terminated by a line other than END.
+func (r *Response) AddValue(key string, flags uint32, data []byte, cas uint64) error {
+	if r.Response != "" && r.Response != RespEnd {
+		return ErrInvalidResponse
+	}
+	if !validKey(key) {
+		return ErrInvalidResponse
+	}
+	v := NewValue(key, flags, data)
+	if cas != 0 {
+		v.Cas = strconv.FormatUint(cas, 10)
+	}
+	r.Values = append(r.Values, v)
+	return nil
+}
+
+// End terminates a retrieval response.
+func (r *Response) End() error {
+	r.Response = RespEnd
+	return nil
+}
+
+// Stored replies STORED.
+func (r *Response) Stored() error { return r.reply(RespStored) }
+
+// NotStored replies NOT_STORED.
+func (r *Response) NotStored() error { return r.reply(RespNotStored) }
+
+// Exists replies EXISTS.
+func (r *Response) Exists() error { return r.reply(RespExists) }
+
+// Deleted replies DELETED.
+func (r *Response) Deleted() error { return r.reply(RespDeleted) }
+
+// Touched replies TOUCHED.
+func (r *Response) Touched() error { return r.reply(RespTouched) }
+
+// NotFound replies NOT_FOUND.
+func (r *Response) NotFound() error { return r.reply(RespNotFound) }
+
+// OK replies OK.
+func (r *Response) OK() error { return r.reply(RespOK) }
+
+// Numeric replies the value of incr and decr.
+func (r *Response) Numeric(n uint64) error { return r.reply(strconv.FormatUint(n, 10)) }
+
+// Version replies VERSION v.
+func (r *Response) Version(v string) error { return r.reply("VERSION " + v) }
+
+// ClientError replies CLIENT_ERROR msg.
+func (r *Response) ClientError(msg string) error { return r.reply(RespClientErr + msg) }
+
+// ServerError replies SERVER_ERROR msg.
+func (r *Response) ServerError(msg string) error { return r.reply(RespServerErr + msg) }
+
+// Stats replies STAT lines terminated by END. Names and values must not contain
+// whitespace.
+func (r *Response) Stats(stats []Stat) error {
+	if len(r.Values) > 0 {
+		return ErrInvalidResponse
+	}
+	var b strings.Builder
+	for _, st := range stats {
+		if st.Name == "" || strings.ContainsAny(st.Name, " \r\n") || strings.ContainsAny(st.Value, " \r\n") {
+			return ErrInvalidResponse
+		}
+		b.WriteString("STAT " + st.Name + " " + st.Value + "\r\n")
+	}
+	b.WriteString(RespEnd)
+	r.Response = b.String()
+	return nil
+}
+
+// MetaValue replies a value of a meta command, "VA <size> <flag>*" followed by data, which is
+// set as Data. Flags must not contain whitespace.
+func (r *Response) MetaValue(data []byte, flags ...string) error {
+	head, err := metaLine("VA "+strconv.Itoa(len(data)), flags)
+	if err != nil {
+		return err
+	}
+	if err := r.reply(head); err != nil {
+		return err
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	r.Data = data
+	return nil
+}
+
+// MetaHit replies "HD <flag>*" for a meta command without a value.
+func (r *Response) MetaHit(flags ...string) error {
+	line, err := metaLine("HD", flags)
+	if err != nil {
+		return err
+	}
+	return r.reply(line)
+}
+
+// MetaMiss replies EN for a miss of a meta command.
+func (r *Response) MetaMiss() error { return r.reply("EN") }
+
+// metaLine returns the line of a meta response of code and flags.
+func metaLine(code string, flags []string) (string, error) {
+	var b strings.Builder
+	b.WriteString(code)
+	for _, f := range flags {
+		if f == "" || strings.ContainsAny(f, " \r\n") {
+			return "", ErrInvalidResponse
+		}
+		b.WriteByte(' ')
+		b.WriteString(f)
+	}
+	return b.String(), nil
+}
+
+// reply sets a single line response, without a data block. It fails if the response has values.
+func (r *Response) reply(line string) error {
+	if len(r.Values) > 0 || strings.ContainsAny(line, "\r\n") {
+		return ErrInvalidResponse
+	}
+	r.Response, r.Data = line, nil
+	return nil
+}
+
+// Validate checks that the response can be sent over wire without breaking the protocol:
+// values must have valid keys and flags and be terminated by END, and a meta data block can't
+// follow values.
+func (r *Response) Validate() error {
+	if len(r.Values) > 0 && r.Data != nil {
+		return ErrInvalidResponse
+	}
+	if len(r.Values) == 0 {
+		return nil
+	}
+	if r.Response != RespEnd {
+		return ErrInvalidResponse
+	}
+	for _, v := range r.Values {
+		if !validKey(v.Key) {
+			return ErrInvalidResponse
+		}
+		if _, err := v.FlagsUint32(); err != nil {
+			return ErrInvalidResponse
+		}
+	}
+	return nil
+}
+
+// MaxKeyLength is the max length of keys.
+const MaxKeyLength = 250
+
+// validKey returns whether key can be sent over wire.
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > MaxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
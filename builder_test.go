@@ -0,0 +1,60 @@
+package mc
+
+import (
+	"testing"
+)
+
+func TestResponseBuilder(t *testing.T) {
+	res := &Response{}
+	if err := res.AddValue("k1", 1, []byte("123"), 0); err != nil {
+		t.Fatalf("AddValue %v", err)
+	}
+	if err := res.AddValue("k2", 2, []byte("456"), 9); err != nil {
+		t.Fatalf("AddValue %v", err)
+	}
+	res.End()
+	if r := res.String(); r != "VALUE k1 1 3\r\n123\r\nVALUE k2 2 3 9\r\n456\r\nEND\r\n" {
+		t.Errorf("%v", r)
+	}
+	if err := res.Validate(); err != nil {
+		t.Errorf("Validate %v", err)
+	}
+
+	if err := res.Stored(); err != ErrInvalidResponse {
+		t.Errorf("expected STORED after values to be rejected")
+	}
+
+	res = &Response{}
+	res.NotFound()
+	if err := res.AddValue("k1", 0, nil, 0); err != ErrInvalidResponse {
+		t.Errorf("expected VALUE after NOT_FOUND to be rejected")
+	}
+	if err := res.AddValue("bad key", 0, nil, 0); err != ErrInvalidResponse {
+		t.Errorf("expected invalid key to be rejected")
+	}
+	if err := res.ClientError("a\r\nb"); err != ErrInvalidResponse {
+		t.Errorf("expected multi-line error to be rejected")
+	}
+
+	invalid := Response{Response: RespStored, Values: []Value{{Key: "k1", Flags: "0"}}}
+	if err := invalid.Validate(); err != ErrInvalidResponse {
+		t.Errorf("expected values without END to be invalid")
+	}
+}
+
+func TestMetaResponseBuilder(t *testing.T) {
+	res := &Response{}
+	if err := res.MetaValue([]byte("abc"), "f3", "c7"); err != nil || res.String() != "VA 3 f3 c7\r\nabc\r\n" {
+		t.Errorf("unexpected response %q: %v", res.String(), err)
+	}
+	res = &Response{}
+	if err := res.MetaHit(); err != nil || res.String() != "HD\r\n" {
+		t.Errorf("unexpected response %q: %v", res.String(), err)
+	}
+	if err := res.MetaHit("t1 x"); err != ErrInvalidResponse {
+		t.Errorf("expected flags with spaces to be invalid")
+	}
+	if err := res.MetaValue(nil, "\r\nEN"); err != ErrInvalidResponse {
+		t.Errorf("expected flags with line breaks to be invalid")
+	}
+}
@@ -0,0 +1,58 @@
+package mc
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup file systems are mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// ErrNoMemoryLimit is returned by CgroupMemoryLimit if the process has no cgroup memory limit.
+var ErrNoMemoryLimit = errors.New("no cgroup memory limit")
+
+// CgroupMemoryLimit returns the memory limit of the cgroup of the process, like the limit of
+// its container, from memory.max of cgroup v2 or memory.limit_in_bytes of cgroup v1.
+// It returns ErrNoMemoryLimit if there is no limit or no cgroup, e.g. on other systems than Linux.
+func CgroupMemoryLimit() (int64, error) {
+	return cgroupMemoryLimit(cgroupRoot)
+}
+
+// maxBytesOfCgroup returns percent of the cgroup memory limit under root, or 0 if there is none.
+func maxBytesOfCgroup(root string, percent float64) int64 {
+	limit, err := cgroupMemoryLimit(root)
+	if err != nil {
+		log.Printf("memory store has no memory limit: %v", err)
+		return 0
+	}
+	max := int64(float64(limit) * percent / 100)
+	log.Printf("memory store limit is %d bytes, %g%% of the cgroup memory limit %d", max, percent, limit)
+	return max
+}
+
+func cgroupMemoryLimit(root string) (int64, error) {
+	for _, name := range []string{"memory.max", "memory/memory.limit_in_bytes"} {
+		b, err := ioutil.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			continue
+		}
+		v := strings.TrimSpace(string(b))
+		if v == "max" {
+			return 0, ErrNoMemoryLimit
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, errors.New("invalid cgroup memory limit " + v)
+		}
+		// cgroup v1 reports no limit as the max int64 rounded down to pages
+		if n <= 0 || n >= 1<<62 {
+			return 0, ErrNoMemoryLimit
+		}
+		return n, nil
+	}
+	return 0, ErrNoMemoryLimit
+}
@@ -0,0 +1,41 @@
+package mc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCgroupMemoryLimit(t *testing.T) {
+	for _, tt := range []struct {
+		file, content string
+		limit         int64
+		err           error
+	}{
+		{"memory.max", "536870912\n", 512 << 20, nil},
+		{"memory.max", "max\n", 0, ErrNoMemoryLimit},
+		{"memory/memory.limit_in_bytes", "1073741824\n", 1 << 30, nil},
+		{"memory/memory.limit_in_bytes", "9223372036854771712\n", 0, ErrNoMemoryLimit},
+		{"other", "1", 0, ErrNoMemoryLimit},
+	} {
+		root := t.TempDir()
+		path := filepath.Join(root, tt.file)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := ioutil.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if limit, err := cgroupMemoryLimit(root); limit != tt.limit || err != tt.err {
+			t.Errorf("%s %q: got %d %v, expected %d %v", tt.file, tt.content, limit, err, tt.limit, tt.err)
+		}
+	}
+
+	root := t.TempDir()
+	ioutil.WriteFile(filepath.Join(root, "memory.max"), []byte("1000\n"), 0o644)
+	if max := maxBytesOfCgroup(root, 80); max != 800 {
+		t.Errorf("unexpected max bytes %d", max)
+	}
+	if max := maxBytesOfCgroup(t.TempDir(), 80); max != 0 {
+		t.Errorf("unexpected max bytes %d without a limit", max)
+	}
+}
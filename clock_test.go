@@ -0,0 +1,75 @@
+package mc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c := NewFakeClock(start)
+
+	var fired []time.Time
+	c.AfterFunc(2*time.Second, func() { fired = append(fired, c.Now()) })
+	c.AfterFunc(time.Second, func() { fired = append(fired, c.Now()) })
+	stop := c.AfterFunc(time.Second, func() { t.Errorf("stopped function is called") })
+	if !stop() || stop() {
+		t.Errorf("stop should succeed only once")
+	}
+
+	c.Advance(1500 * time.Millisecond)
+	if !reflect.DeepEqual(fired, []time.Time{start.Add(time.Second)}) || !c.Now().Equal(start.Add(1500*time.Millisecond)) {
+		t.Errorf("unexpected calls %v at %v", fired, c.Now())
+	}
+	c.Advance(time.Second)
+	if len(fired) != 2 || !fired[1].Equal(start.Add(2*time.Second)) {
+		t.Errorf("unexpected calls %v", fired)
+	}
+}
+
+func TestStoreClock(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	st := NewMemoryStore(MemoryStoreOptions{Clock: clock})
+	h := handlerMap{}
+	RegisterStore(h, st)
+	get := func(key string) bool {
+		return len(h.call(&Request{Command: "get", Keys: []string{key}}).Values) == 1
+	}
+
+	h.call(&Request{Command: "set", Key: "ttl", Flags: "0", Exptime: 10, Data: []byte("v")})
+	h.call(&Request{Command: "set", Key: "k", Flags: "0", Data: []byte("v")})
+	clock.Advance(9 * time.Second)
+	if !get("ttl") {
+		t.Errorf("item expired early")
+	}
+	clock.Advance(time.Second)
+	if get("ttl") {
+		t.Errorf("item should expire after its ttl")
+	}
+
+	if res := h.call(&Request{Command: "flush_all", Exptime: 5}); res.Response != RespOK {
+		t.Fatalf("flush_all: %q", res.Response)
+	}
+	clock.Advance(4 * time.Second)
+	if !get("k") {
+		t.Errorf("item is flushed before the delay")
+	}
+	clock.Advance(time.Second)
+	if get("k") {
+		t.Errorf("item should be flushed after the delay")
+	}
+
+	// a new flush_all replaces the pending one
+	h.call(&Request{Command: "set", Key: "k", Flags: "0", Data: []byte("v")})
+	h.call(&Request{Command: "flush_all", Exptime: 5})
+	h.call(&Request{Command: "flush_all", Exptime: 20})
+	clock.Advance(10 * time.Second)
+	if !get("k") {
+		t.Errorf("replaced flush_all should not run")
+	}
+	if it, err := st.Get(context.Background(), "k"); err != nil || !it.Expiration.IsZero() {
+		t.Errorf("unexpected item %+v: %v", it, err)
+	}
+}
@@ -0,0 +1,65 @@
+package mc
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// ErrDuplicateHandler is returned by RegisterFunc for commands which have a handler already,
+// see Server.StrictRegistration.
+var ErrDuplicateHandler = errors.New("handler already registered")
+
+// Handlers returns the sorted commands which have registered handlers.
+func (s *Server) Handlers() []string {
+	return s.root.names()
+}
+
+// Handlers returns the sorted commands which have registered handlers of this virtual server.
+func (vs *VirtualServer) Handlers() []string {
+	return vs.h.names()
+}
+
+// CommandsHandler returns the handler of an admin extension command, like "commands", which
+// reports the commands served by s as STAT <command> <source> lines, to debug misconfigured
+// deployments. Sources are "handler" for registered handlers and "builtin" for commands served by
+// the server itself, and "STAT * default" is reported if there is a default handler.
+//
+//	s.RegisterFunc("commands", s.CommandsHandler())
+func (s *Server) CommandsHandler() HandlerFunc {
+	return s.commandsHandler(s.root)
+}
+
+// CommandsHandler returns the handler of the commands of this virtual server, like
+// Server.CommandsHandler.
+func (vs *VirtualServer) CommandsHandler() HandlerFunc {
+	return vs.s.commandsHandler(vs.h)
+}
+
+func (s *Server) commandsHandler(h *handlers) HandlerFunc {
+	return func(ctx context.Context, req *Request, res *Response) error {
+		sources := map[string]string{"quit": "builtin"}
+		for _, cmd := range []string{"version", "verbosity"} {
+			sources[cmd] = "builtin"
+		}
+		if s.EnableBatchCommands {
+			sources["mset"], sources["mdelete"] = "builtin", "builtin"
+		}
+		for _, cmd := range h.names() {
+			sources[cmd] = "handler"
+		}
+		cmds := make([]string, 0, len(sources))
+		for cmd := range sources {
+			cmds = append(cmds, cmd)
+		}
+		sort.Strings(cmds)
+		stats := make([]Stat, 0, len(cmds)+1)
+		for _, cmd := range cmds {
+			stats = append(stats, Stat{cmd, sources[cmd]})
+		}
+		if h.defaultHandler() != nil {
+			stats = append(stats, Stat{"*", "default"})
+		}
+		return res.Stats(stats)
+	}
+}
@@ -0,0 +1,63 @@
+package mc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestStrictRegistration(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	if err := s.RegisterFunc("get", DefaultGet); err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	if err := s.RegisterFunc("get", DefaultGet); err != nil {
+		t.Errorf("handlers are replaced unless registration is strict: %v", err)
+	}
+
+	s.StrictRegistration = true
+	if err := s.RegisterFunc("get", DefaultGet); err != ErrDuplicateHandler {
+		t.Errorf("expected ErrDuplicateHandler, got %v", err)
+	}
+	if err := RegisterStore(s, NewMemoryStore(MemoryStoreOptions{})); err != ErrDuplicateHandler {
+		t.Errorf("expected ErrDuplicateHandler of RegisterStore, got %v", err)
+	}
+	vs := s.Virtual("127.0.0.1:0")
+	vs.RegisterFunc("set", DefaultSet)
+	if err := vs.RegisterFunc("set", DefaultSet); err != ErrDuplicateHandler {
+		t.Errorf("expected ErrDuplicateHandler of the virtual server, got %v", err)
+	}
+	s.UnregisterFunc("get")
+	if err := s.RegisterFunc("get", DefaultGet); err != nil {
+		t.Errorf("failed to register an unregistered command: %v", err)
+	}
+}
+
+func TestCommandsHandler(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.RegisterFunc("get", DefaultGet)
+	s.RegisterFunc("set", DefaultSet)
+	s.RegisterFunc("version", DefaultVersion)
+	s.RegisterFunc("commands", s.CommandsHandler())
+	if cmds := s.Handlers(); !reflect.DeepEqual(cmds, []string{"commands", "get", "set", "version"}) {
+		t.Errorf("unexpected handlers %v", cmds)
+	}
+
+	res := &Response{}
+	if err := s.CommandsHandler()(context.Background(), &Request{Command: "commands"}, res); err != nil {
+		t.Fatalf("failed to list commands: %v", err)
+	}
+	want := "STAT commands handler\r\nSTAT get handler\r\nSTAT quit builtin\r\nSTAT set handler\r\n" +
+		"STAT verbosity builtin\r\nSTAT version handler\r\nEND\r\n"
+	if res.String() != want {
+		t.Errorf("unexpected response %q", res.String())
+	}
+
+	vs := s.Virtual("127.0.0.1:0")
+	vs.SetDefaultHandler(DefaultGet)
+	res = &Response{}
+	vs.CommandsHandler()(context.Background(), &Request{Command: "commands"}, res)
+	if want := "STAT quit builtin\r\nSTAT verbosity builtin\r\nSTAT version builtin\r\nSTAT * default\r\nEND\r\n"; res.String() != want {
+		t.Errorf("unexpected response of the virtual server %q", res.String())
+	}
+}
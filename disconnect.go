@@ -0,0 +1,76 @@
+package mc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// aLongTimeAgo is a read deadline which interrupts reads at once.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// connReader is the reader of connections of servers with CancelOnDisconnect. While a request is
+// served, it reads the connection in the background to notice the client closing it, like the
+// connections of net/http.
+type connReader struct {
+	conn net.Conn
+
+	done    chan struct{} // closed when the background read returns, nil if there is none
+	b       [1]byte       // the byte read in the background
+	hasByte bool
+	err     error // the error of the background read, returned by the next Read
+}
+
+// Read reads the byte and the error of the background read first.
+func (cr *connReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if cr.hasByte {
+		p[0] = cr.b[0]
+		cr.hasByte = false
+		return 1, nil
+	}
+	if cr.err != nil {
+		err := cr.err
+		cr.err = nil
+		return 0, err
+	}
+	return cr.conn.Read(p)
+}
+
+// startBackgroundRead reads the connection until stopBackgroundRead, and calls cancel if the client
+// closes it.
+func (cr *connReader) startBackgroundRead(cancel context.CancelFunc) {
+	cr.done = make(chan struct{})
+	go func() {
+		defer close(cr.done)
+		n, err := cr.conn.Read(cr.b[:])
+		var nerr net.Error
+		if n == 1 {
+			cr.hasByte = true
+		} else if err != nil && !(errors.As(err, &nerr) && nerr.Timeout()) {
+			cr.err = err
+			cancel()
+		}
+	}()
+}
+
+// stopBackgroundRead interrupts the background read and waits for it.
+func (cr *connReader) stopBackgroundRead() {
+	cr.conn.SetReadDeadline(aLongTimeAgo)
+	<-cr.done
+	cr.conn.SetReadDeadline(time.Time{})
+	cr.done = nil
+}
+
+// serveCancelable serves a request like serveRequest with a context which is canceled if the
+// client closes the connection of cr meanwhile.
+func (s *Server) serveCancelable(ctx context.Context, cr *connReader, conn net.Conn, st *connState, h *handlers, req *Request) (net.Buffers, bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cr.startBackgroundRead(cancel)
+	defer cr.stopBackgroundRead()
+	return s.serveRequest(ctx, conn, st, h, req)
+}
@@ -0,0 +1,64 @@
+package mc
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCancelOnDisconnect(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.CancelOnDisconnect = true
+	canceled := make(chan bool, 1)
+	s.RegisterFunc("scan", func(ctx context.Context, req *Request, res *Response) error {
+		select {
+		case <-ctx.Done():
+			canceled <- true
+		case <-time.After(time.Second):
+			canceled <- false
+		}
+		return res.End()
+	})
+	s.RegisterFunc("slow", func(ctx context.Context, req *Request, res *Response) error {
+		time.Sleep(100 * time.Millisecond)
+		return res.OK()
+	})
+	s.RegisterFunc("version", func(ctx context.Context, req *Request, res *Response) error {
+		return res.Version("1")
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	conn.Write([]byte("scan\r\n"))
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	if !<-canceled {
+		t.Errorf("request is not canceled when the client disconnects")
+	}
+
+	// requests sent while a request is served are read after it
+	conn, err = net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("slow\r\n"))
+	time.Sleep(50 * time.Millisecond)
+	conn.Write([]byte("version\r\n"))
+
+	want := "OK\r\nVERSION 1\r\n"
+	b := make([]byte, len(want))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(bufio.NewReader(conn), b); err != nil || string(b) != want {
+		t.Errorf("unexpected response %q: %v", b, err)
+	}
+}
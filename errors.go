@@ -0,0 +1,141 @@
+package mc
+
+import (
+	"errors"
+	"strings"
+)
+
+// Handlers can return these errors and the server replies the corresponding responses.
+var (
+	// ErrNotFound replies NOT_FOUND, or END for get and gets.
+	ErrNotFound = errors.New("not found")
+	// ErrExists replies EXISTS.
+	ErrExists = errors.New("exists")
+	// ErrNotStored replies NOT_STORED.
+	ErrNotStored = errors.New("not stored")
+	// ErrTooLarge replies SERVER_ERROR object too large for cache.
+	ErrTooLarge = errors.New("object too large for cache")
+	// ErrOutOfMemory replies SERVER_ERROR out of memory storing object.
+	ErrOutOfMemory = errors.New("out of memory storing object")
+	// ErrNotSupported replies SERVER_ERROR not supported.
+	ErrNotSupported = errors.New("not supported")
+	// ErrNonNumeric replies CLIENT_ERROR cannot increment or decrement non-numeric value.
+	ErrNonNumeric = errors.New("cannot increment or decrement non-numeric value")
+)
+
+// ErrorKind is the kind of an error response line.
+type ErrorKind int
+
+const (
+	// GenericError is ERROR, replied for unknown commands.
+	GenericError ErrorKind = iota + 1
+	// ClientError is CLIENT_ERROR, replied for malformed requests.
+	ClientError
+	// ServerError is SERVER_ERROR, replied for failures of the server.
+	ServerError
+)
+
+// ResponseError is an ERROR, CLIENT_ERROR or SERVER_ERROR response line.
+// Handlers returning it reply the line itself, so proxies can forward errors of backends.
+type ResponseError struct {
+	Kind    ErrorKind
+	Message string
+}
+
+// Error returns the response line.
+func (e *ResponseError) Error() string {
+	var prefix string
+	switch e.Kind {
+	case ClientError:
+		prefix = RespClientErr
+	case ServerError:
+		prefix = RespServerErr
+	default:
+		if e.Message == "" {
+			return "ERROR"
+		}
+		prefix = RespErr
+	}
+	return prefix + e.Message
+}
+
+// Unwrap returns the sentinel error of the line, if any, so errors.Is(err, ErrTooLarge) works for
+// SERVER_ERROR object too large for cache.
+func (e *ResponseError) Unwrap() error {
+	switch {
+	case e.Kind == ServerError && e.Message == ErrTooLarge.Error():
+		return ErrTooLarge
+	case e.Kind == ServerError && e.Message == ErrOutOfMemory.Error():
+		return ErrOutOfMemory
+	case e.Kind == ServerError && e.Message == ErrNotSupported.Error():
+		return ErrNotSupported
+	case e.Kind == ClientError && e.Message == ErrNonNumeric.Error():
+		return ErrNonNumeric
+	}
+	return nil
+}
+
+// parseErrorLine parses an error response line. It returns nil for other lines.
+func parseErrorLine(line string) *ResponseError {
+	switch {
+	case line == "ERROR":
+		return &ResponseError{Kind: GenericError}
+	case strings.HasPrefix(line, RespErr):
+		return &ResponseError{GenericError, line[len(RespErr):]}
+	case strings.HasPrefix(line, RespClientErr):
+		return &ResponseError{ClientError, line[len(RespClientErr):]}
+	case strings.HasPrefix(line, RespServerErr):
+		return &ResponseError{ServerError, line[len(RespServerErr):]}
+	}
+	return nil
+}
+
+// errorResponse returns the response line of an error returned by the handler of cmd,
+// and whether it is an expected result rather than a failure.
+func errorResponse(cmd string, err error) (line string, expected bool) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		if cmd == "get" || cmd == "gets" {
+			return RespEnd, true
+		}
+		return RespNotFound, true
+	case errors.Is(err, ErrExists):
+		return RespExists, true
+	case errors.Is(err, ErrNotStored):
+		return RespNotStored, true
+	case errors.Is(err, ErrTooLarge):
+		return RespServerErr + ErrTooLarge.Error(), true
+	case errors.Is(err, ErrOutOfMemory):
+		return RespServerErr + ErrOutOfMemory.Error(), true
+	case errors.Is(err, ErrNonNumeric):
+		return RespClientErr + ErrNonNumeric.Error(), true
+	}
+
+	var rerr *ResponseError
+	if errors.As(err, &rerr) {
+		return rerr.Error(), false
+	}
+	var perr Error
+	if errors.As(err, &perr) {
+		return RespClientErr + perr.Error(), false
+	}
+	return RespServerErr + err.Error(), false
+}
+
+// expectedError returns whether an error returned by a handler is an expected result,
+// like a miss or a failed cas, rather than a failure. See errorResponse.
+func expectedError(err error) bool {
+	_, expected := errorResponse("", err)
+	return expected
+}
+
+// setError sets the response of an error returned by the handler of req.
+// Values are kept only for misses of retrieval commands.
+func setError(req *Request, res *Response, err error) (expected bool) {
+	line, expected := errorResponse(req.Command, err)
+	if line != RespEnd {
+		res.Values = nil
+	}
+	res.Response, res.Data = line, nil
+	return expected
+}
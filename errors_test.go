@@ -0,0 +1,52 @@
+package mc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorResponse(t *testing.T) {
+	cases := []struct {
+		cmd  string
+		err  error
+		line string
+	}{
+		{"delete", ErrNotFound, RespNotFound},
+		{"get", ErrNotFound, RespEnd},
+		{"cas", fmt.Errorf("cas %s: %w", "k", ErrExists), RespExists},
+		{"add", ErrNotStored, RespNotStored},
+		{"set", ErrTooLarge, "SERVER_ERROR object too large for cache"},
+		{"set", ErrOutOfMemory, "SERVER_ERROR out of memory storing object"},
+		{"incr", ErrNonNumeric, "CLIENT_ERROR cannot increment or decrement non-numeric value"},
+		{"set", NewError("bad data"), "CLIENT_ERROR MC Protocol error: bad data"},
+		{"set", errors.New("db is down"), "SERVER_ERROR db is down"},
+		{"get", &ResponseError{ClientError, "bad command line format"}, "CLIENT_ERROR bad command line format"},
+		{"get", &ResponseError{Kind: GenericError}, "ERROR"},
+	}
+	for _, c := range cases {
+		if line, _ := errorResponse(c.cmd, c.err); line != c.line {
+			t.Errorf("%s %v: expected %q, got %q", c.cmd, c.err, c.line, line)
+		}
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+	s.RegisterFunc("add", func(ctx context.Context, req *Request, res *Response) error {
+		return ErrNotStored
+	})
+	s.RegisterFunc("get", func(ctx context.Context, req *Request, res *Response) error {
+		res.AddValue("a", 0, []byte("1"), 0)
+		return errors.New("db is down")
+	})
+
+	if line := roundTrip(t, addr, "add foo 0 0 1\r\n1\r\n"); line != "NOT_STORED\r\n" {
+		t.Errorf("unexpected response: %q", line)
+	}
+	if line := roundTrip(t, addr, "get a\r\n"); line != "SERVER_ERROR db is down\r\n" {
+		t.Errorf("unexpected response: %q", line)
+	}
+}
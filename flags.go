@@ -0,0 +1,58 @@
+package mc
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FlagsEnv is the environment variable of the feature flags of servers, see Server.Flags.
+const FlagsEnv = "GOMEMCACHED_FLAGS"
+
+// serverFlags set the experimental features of a server toggled by feature flags.
+var serverFlags = map[string]func(s *Server, v string) error{
+	"zerocopy": func(s *Server, v string) (err error) {
+		s.ZeroCopy, err = strconv.ParseBool(v)
+		return err
+	},
+	"async": func(s *Server, v string) (err error) {
+		s.AsyncRequests, err = strconv.Atoi(v)
+		return err
+	},
+	"batch": func(s *Server, v string) (err error) {
+		s.EnableBatchCommands, err = strconv.ParseBool(v)
+		return err
+	},
+}
+
+// flagsSetting formats flags for stats settings, which have no spaces.
+func flagsSetting(flags string) string {
+	if flags = strings.ReplaceAll(flags, " ", ""); flags == "" {
+		return "none"
+	}
+	return flags
+}
+
+// applyFlags sets the features toggled by s.Flags, or by FlagsEnv if it is empty.
+func (s *Server) applyFlags() error {
+	if s.Flags == "" {
+		s.Flags = os.Getenv(FlagsEnv)
+	}
+	for _, f := range strings.Split(s.Flags, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		name, v, _ := strings.Cut(f, "=")
+		set, ok := serverFlags[name]
+		if !ok {
+			log.Printf("unknown memcached server flag %s", name)
+			continue
+		}
+		if err := set(s, v); err != nil {
+			return errors.New("invalid memcached server flag " + f)
+		}
+	}
+	return nil
+}
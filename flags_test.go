@@ -0,0 +1,37 @@
+package mc
+
+import (
+	"context"
+	"testing"
+)
+
+func TestServerFlags(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.Flags = "zerocopy=1, async=4,unknown=x,batch=true"
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer s.Stop()
+	if !s.ZeroCopy || s.AsyncRequests != 4 || !s.EnableBatchCommands {
+		t.Errorf("flags are not applied: %+v", s)
+	}
+	stats, _ := s.Stats(context.Background(), "settings")
+	if statValue(stats, "zero_copy") != "yes" || statValue(stats, "flags") != "zerocopy=1,async=4,unknown=x,batch=true" {
+		t.Errorf("unexpected settings: %v", stats)
+	}
+
+	s = NewServer("127.0.0.1:0")
+	s.Flags = "async=many"
+	if err := s.Start(); err == nil {
+		s.Stop()
+		t.Errorf("expected an error for an invalid flag")
+	}
+}
+
+func TestServerFlagsEnv(t *testing.T) {
+	t.Setenv(FlagsEnv, "zerocopy=1")
+	s := NewServer("127.0.0.1:0")
+	if err := s.applyFlags(); err != nil || !s.ZeroCopy || s.Flags != "zerocopy=1" {
+		t.Errorf("flags of %s are not applied: %q %v", FlagsEnv, s.Flags, err)
+	}
+}
@@ -0,0 +1,28 @@
+package mc
+
+import "bufio"
+
+// Forward sends req to a memcached server through rw, like an upstream of a proxy handler, and
+// returns its response. Requests with noreply are sent without it and their responses are read
+// all the same: upstreams which reply such requests anyway, or reply errors to them, like
+// memcached for malformed ones, would otherwise leave responses on the connection which are taken
+// for the responses of later requests. The server doesn't reply the response to the client of a
+// noreply request, so noreply works end to end. quit is sent and has no response.
+// It is not safe for concurrent use of rw.
+func Forward(rw *bufio.ReadWriter, req *Request) (*Response, error) {
+	if req.Noreply {
+		c := *req
+		c.Noreply = false
+		req = &c
+	}
+	if err := WriteRequest(rw.Writer, req); err != nil {
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		return nil, err
+	}
+	if req.Command == "quit" {
+		return nil, nil
+	}
+	return ReadResponse(rw.Reader, req.Command)
+}
@@ -0,0 +1,94 @@
+package mc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForwardNoreply(t *testing.T) {
+	for _, tt := range []struct {
+		line  string
+		sent  string
+		reply string
+	}{
+		{"set a 0 0 1 noreply\r\nx\r\n", "set a 0 0 1\r\nx\r\n", RespStored},
+		{"delete a noreply\r\n", "delete a\r\n", RespDeleted},
+		{"flush_all 10 noreply\r\n", "flush_all 10\r\n", RespOK},
+		{"flush_all noreply\r\n", "flush_all\r\n", RespOK},
+		{"verbosity 1 noreply\r\n", "verbosity 1\r\n", RespOK},
+	} {
+		req, err := readRequest(bufio.NewReader(strings.NewReader(tt.line)), readOptions{generic: func(string) bool { return true }})
+		if err != nil || !req.Noreply {
+			t.Fatalf("%q: %v", tt.line, err)
+		}
+		var sent bytes.Buffer
+		// the upstream replies, and the response of the next request follows
+		rw := bufio.NewReadWriter(bufio.NewReader(strings.NewReader(tt.reply+"\r\nEND\r\n")), bufio.NewWriter(&sent))
+		res, err := Forward(rw, req)
+		if err != nil || res.Response != tt.reply {
+			t.Errorf("%q: unexpected response %+v: %v", tt.line, res, err)
+		}
+		if sent.String() != tt.sent {
+			t.Errorf("%q sent as %q", tt.line, sent.String())
+		}
+		if !req.Noreply {
+			t.Errorf("%q: request is modified", tt.line)
+		}
+		if res, err := ReadResponse(rw.Reader, "get"); err != nil || res.Response != RespEnd {
+			t.Errorf("%q: connection is out of sync: %+v %v", tt.line, res, err)
+		}
+	}
+}
+
+func TestForwardProxy(t *testing.T) {
+	upstream := NewServer("127.0.0.1:0")
+	st := NewMemoryStore(MemoryStoreOptions{})
+	defer st.Close()
+	RegisterStore(upstream, st)
+	if err := upstream.Start(); err != nil {
+		t.Fatalf("failed to start upstream: %v", err)
+	}
+	defer upstream.Stop()
+
+	conn, err := net.Dial("tcp", upstream.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial upstream: %v", err)
+	}
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	proxy := NewServer("127.0.0.1:0")
+	proxy.SetDefaultHandler(func(ctx context.Context, req *Request, res *Response) error {
+		up, err := Forward(rw, req)
+		if err != nil {
+			return err
+		}
+		*res = *up
+		return nil
+	})
+	if err := proxy.Start(); err != nil {
+		t.Fatalf("failed to start proxy: %v", err)
+	}
+	defer proxy.Stop()
+
+	client, err := net.Dial("tcp", proxy.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer client.Close()
+	client.Write([]byte("set a 0 0 1 noreply\r\nx\r\nset b 0 0 1 noreply\r\ny\r\ndelete b noreply\r\nget a b\r\n" +
+		"flush_all noreply\r\nget a\r\n"))
+
+	want := "VALUE a 0 1\r\nx\r\nEND\r\nEND\r\n"
+	b := make([]byte, len(want))
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(client, b); err != nil || string(b) != want {
+		t.Errorf("unexpected responses %q: %v", b, err)
+	}
+}
@@ -1,5 +1,10 @@
 module github.com/rpcxio/gomemcached
 
-go 1.13
+go 1.18
 
-require github.com/bradfitz/gomemcache v0.0.0-20190913173617-a41fca850d0b
+require (
+	github.com/Microsoft/go-winio v0.5.2
+	github.com/bradfitz/gomemcache v0.0.0-20190913173617-a41fca850d0b
+)
+
+require golang.org/x/sys v0.0.0-20210124154548-22da62e12c0c // indirect
@@ -0,0 +1,43 @@
+package mc
+
+// Middleware wraps a HandlerFunc to add behavior such as auth, logging or metrics.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Group registers handlers which share a chain of middlewares.
+type Group struct {
+	r   Registrar
+	mws []Middleware
+}
+
+// Group creates a handler group. Handlers registered by the group are wrapped by mws.
+func (s *Server) Group(mws ...Middleware) *Group {
+	return &Group{r: s, mws: append([]Middleware(nil), mws...)}
+}
+
+// Group creates a sub group which inherits middlewares of g and appends mws to them.
+func (g *Group) Group(mws ...Middleware) *Group {
+	all := make([]Middleware, 0, len(g.mws)+len(mws))
+	all = append(all, g.mws...)
+	all = append(all, mws...)
+	return &Group{r: g.r, mws: all}
+}
+
+// Use appends middlewares to this group.
+// It only affects handlers registered after it is called.
+func (g *Group) Use(mws ...Middleware) {
+	g.mws = append(g.mws, mws...)
+}
+
+// RegisterFunc registers a handler wrapped by middlewares of this group.
+// The first middleware is the outermost one.
+func (g *Group) RegisterFunc(cmd string, fn HandlerFunc) error {
+	return g.r.RegisterFunc(cmd, chain(fn, g.mws...))
+}
+
+// chain wraps fn with mws, mws[0] is the outermost.
+func chain(fn HandlerFunc, mws ...Middleware) HandlerFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		fn = mws[i](fn)
+	}
+	return fn
+}
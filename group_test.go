@@ -0,0 +1,45 @@
+package mc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func traceMiddleware(name string, trace *[]string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request, res *Response) error {
+			*trace = append(*trace, name)
+			return next(ctx, req, res)
+		}
+	}
+}
+
+func TestGroup(t *testing.T) {
+	var trace []string
+	s := NewServer("127.0.0.1:0")
+
+	g := s.Group(traceMiddleware("auth", &trace))
+	sub := g.Group(traceMiddleware("metrics", &trace))
+	sub.RegisterFunc("set", func(ctx context.Context, req *Request, res *Response) error {
+		trace = append(trace, "set")
+		return nil
+	})
+	g.RegisterFunc("get", func(ctx context.Context, req *Request, res *Response) error {
+		trace = append(trace, "get")
+		return nil
+	})
+
+	setFn, _ := s.root.handler("set")
+	setFn(context.Background(), &Request{}, &Response{})
+	if !reflect.DeepEqual(trace, []string{"auth", "metrics", "set"}) {
+		t.Errorf("wrong middleware order: %v", trace)
+	}
+
+	trace = nil
+	getFn, _ := s.root.handler("get")
+	getFn(context.Background(), &Request{}, &Response{})
+	if !reflect.DeepEqual(trace, []string{"auth", "get"}) {
+		t.Errorf("sub group middleware leaked into parent: %v", trace)
+	}
+}
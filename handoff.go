@@ -0,0 +1,130 @@
+package mc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ListenerFDEnv is the environment variable which tells a new process the file descriptors
+// of the listeners inherited from the old process, separated by commas: the one of the server
+// first and then the ones of its virtual servers in the order they were added.
+const ListenerFDEnv = "GOMEMCACHED_LISTENER_FD"
+
+// inheritedListeners returns the listeners passed by the old process, or nil if there are none.
+func inheritedListeners() ([]net.Listener, error) {
+	v := os.Getenv(ListenerFDEnv)
+	if v == "" {
+		return nil, nil
+	}
+	os.Unsetenv(ListenerFDEnv) // don't pass it to our own children
+
+	var lns []net.Listener
+	for _, field := range strings.Split(v, ",") {
+		ln, err := fileListener(field)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return nil, errors.New("invalid " + ListenerFDEnv + ": " + v + ": " + err.Error())
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// fileListener returns the listener of the file descriptor fd.
+func fileListener(fd string) (net.Listener, error) {
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(n), "listener")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// ListenerFile returns a duplicate of the listener's file descriptor,
+// which can be passed to another process. It is not supported on Windows.
+func (s *Server) ListenerFile() (*os.File, error) {
+	if s.ln == nil {
+		return nil, errors.New("memcached server has not started")
+	}
+	return listenerFile(s.ln)
+}
+
+// listenerFile returns a duplicate of the file descriptor of ln.
+func listenerFile(ln net.Listener) (*os.File, error) {
+	fl, ok := ln.(interface {
+		File() (*os.File, error)
+	})
+	if !ok {
+		return nil, errors.New("listener does not support handoff")
+	}
+	return fl.File()
+}
+
+// listenerFiles returns duplicates of the file descriptors of the listener of the server and
+// then of its virtual servers, in the order of ListenerFDEnv.
+func (s *Server) listenerFiles() ([]*os.File, error) {
+	f, err := s.ListenerFile()
+	if err != nil {
+		return nil, err
+	}
+	files := []*os.File{f}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, vs := range s.virtuals {
+		f, err := listenerFile(vs.ln)
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// Handoff starts a new process of the current executable with the same arguments,
+// passes the listeners of the server and its virtual servers to it and then gracefully shuts
+// down this server by Shutdown(ctx).
+// The new process accepts connections while this one drains its connections,
+// so binaries can be upgraded without dropping traffic.
+//
+// Start of the new process uses the inherited listeners instead of listening again, so it must
+// add the same virtual servers in the same order, as it does when it runs the same code.
+func (s *Server) Handoff(ctx context.Context) (*os.Process, error) {
+	files, err := s.listenerFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer closeFiles(files)
+
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	fds := make([]string, len(files))
+	for i := range files {
+		fds[i] = strconv.Itoa(3 + i) // ExtraFiles start from fd 3
+	}
+	cmd.Env = append(os.Environ(), ListenerFDEnv+"="+strings.Join(fds, ","))
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return cmd.Process, s.Shutdown(ctx)
+}
@@ -0,0 +1,122 @@
+//go:build !windows
+// +build !windows
+
+package mc
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestListenerInheritance(t *testing.T) {
+	old, addr := startTestServer(t)
+	old.RegisterFunc("version", func(ctx context.Context, req *Request, res *Response) error {
+		res.Response = "VERSION old"
+		return nil
+	})
+
+	f, err := old.ListenerFile()
+	if err != nil {
+		t.Fatalf("failed to get listener file: %v", err)
+	}
+	// the new server owns and closes the duplicated descriptor
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatalf("failed to dup: %v", err)
+	}
+	os.Setenv(ListenerFDEnv, strconv.Itoa(fd))
+
+	s := NewServer("127.0.0.1:0")
+	s.RegisterFunc("version", func(ctx context.Context, req *Request, res *Response) error {
+		res.Response = "VERSION new"
+		return nil
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start with inherited listener: %v", err)
+	}
+	defer s.Stop()
+
+	// an idle connection of the old server is closed by Shutdown
+	idle, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer idle.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := old.Shutdown(ctx); err != nil {
+		t.Fatalf("failed to shutdown: %v", err)
+	}
+
+	if line := roundTrip(t, addr, "version\r\n"); line != "VERSION new\r\n" {
+		t.Errorf("unexpected response: %q", line)
+	}
+}
+
+func TestListenerInheritanceVirtual(t *testing.T) {
+	version := func(v string) HandlerFunc {
+		return func(ctx context.Context, req *Request, res *Response) error {
+			res.Response = "VERSION " + v
+			return nil
+		}
+	}
+	old := NewServer("127.0.0.1:0")
+	old.RegisterFunc("version", version("old"))
+	old.Virtual("127.0.0.1:0").RegisterFunc("version", version("old virtual"))
+	if err := old.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer old.Stop()
+
+	files, err := old.listenerFiles()
+	if err != nil {
+		t.Fatalf("failed to get listener files: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 listener files, got %d", len(files))
+	}
+	// the new server owns and closes the duplicated descriptors
+	var fds []string
+	for _, f := range files {
+		fd, err := syscall.Dup(int(f.Fd()))
+		f.Close()
+		if err != nil {
+			t.Fatalf("failed to dup: %v", err)
+		}
+		fds = append(fds, strconv.Itoa(fd))
+	}
+	os.Setenv(ListenerFDEnv, strings.Join(fds, ","))
+
+	s := NewServer("127.0.0.1:0")
+	s.RegisterFunc("version", version("new"))
+	vs := s.Virtual("127.0.0.1:0")
+	vs.RegisterFunc("version", version("new virtual"))
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start with inherited listeners: %v", err)
+	}
+	defer s.Stop()
+	if s.Addr().String() != old.Addr().String() || vs.Addr().String() != old.virtuals[0].Addr().String() {
+		t.Errorf("listeners are not inherited: %v %v", s.Addr(), vs.Addr())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := old.Shutdown(ctx); err != nil {
+		t.Fatalf("failed to shutdown: %v", err)
+	}
+	if line := roundTrip(t, s.Addr().String(), "version\r\n"); line != "VERSION new\r\n" {
+		t.Errorf("unexpected response: %q", line)
+	}
+	if line := roundTrip(t, vs.Addr().String(), "version\r\n"); line != "VERSION new virtual\r\n" {
+		t.Errorf("unexpected response of the virtual server: %q", line)
+	}
+}
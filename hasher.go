@@ -0,0 +1,152 @@
+package mc
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math/bits"
+	"strings"
+)
+
+// Hasher places keys in the shards of a MemoryStore, see MemoryStoreOptions.Hasher.
+// The built-in hashers match the key placement of common clients, so stores can be sharded like
+// the servers of an existing fleet. Hashers which implement fmt.Stringer are named in stats settings.
+type Hasher interface {
+	// Shard returns the shard of key among n shards, from 0 to n-1.
+	Shard(key string, n int) int
+}
+
+// Built-in hashers.
+var (
+	// FNV1a is the 32-bit FNV-1a hash modulo the number of shards. It is the default.
+	FNV1a Hasher = modHasher{"fnv1a_32", fnv32a}
+	// Murmur3 is the 32-bit MurmurHash3 with seed 0 modulo the number of shards.
+	Murmur3 Hasher = modHasher{"murmur3_32", murmur3}
+	// CRC32 is the CRC-32 hash of libmemcached, bits 16 to 30 of the IEEE checksum, modulo the
+	// number of shards.
+	CRC32 Hasher = modHasher{"crc32", crc32Hash}
+	// JumpHash is the jump consistent hash of the 64-bit FNV-1a hash of keys. Only about 1/n of
+	// keys move when a store is resized to n shards.
+	JumpHash Hasher = jumpHasher{}
+)
+
+// modHasher places keys by a 32-bit hash modulo the number of shards.
+type modHasher struct {
+	name string
+	hash func(key string) uint32
+}
+
+func (h modHasher) Shard(key string, n int) int { return int(h.hash(key) % uint32(n)) }
+func (h modHasher) String() string              { return h.name }
+
+type jumpHasher struct{}
+
+func (jumpHasher) Shard(key string, n int) int { return jump(fnv64a(key), n) }
+func (jumpHasher) String() string              { return "jump" }
+
+// HashTags returns a Hasher which places keys by h applied to their hash tags, the part between
+// the first open and the next close, like "user1" of "{user1}:profile" with "{" and "}" as in
+// Redis Cluster. Related keys with the same tag are in one shard, so WithLock locks only one shard
+// for them. Keys without a tag, or with an empty one, are placed by the whole key.
+func HashTags(h Hasher, open, close string) Hasher {
+	return tagHasher{h: h, open: open, close: close}
+}
+
+type tagHasher struct {
+	h           Hasher
+	open, close string
+}
+
+func (t tagHasher) Shard(key string, n int) int { return t.h.Shard(t.tag(key), n) }
+func (t tagHasher) String() string              { return hasherName(t.h) + "+tags" }
+
+// tag returns the hash tag of key, or key if it has none.
+func (t tagHasher) tag(key string) string {
+	i := strings.Index(key, t.open)
+	if i < 0 {
+		return key
+	}
+	rest := key[i+len(t.open):]
+	j := strings.Index(rest, t.close)
+	if j <= 0 {
+		return key
+	}
+	return rest[:j]
+}
+
+// hasherName returns the name of h for stats settings.
+func hasherName(h Hasher) string {
+	if s, ok := h.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return "custom"
+}
+
+// fnv64a returns the 64-bit FNV-1a hash of key without allocations.
+func fnv64a(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// murmur3 returns the 32-bit MurmurHash3 of key with seed 0.
+func murmur3(key string) uint32 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+	var h uint32
+	n := len(key)
+	i := 0
+	for ; i+4 <= n; i += 4 {
+		k := uint32(key[i]) | uint32(key[i+1])<<8 | uint32(key[i+2])<<16 | uint32(key[i+3])<<24
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	var k uint32
+	switch n - i {
+	case 3:
+		k ^= uint32(key[i+2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(key[i+1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(key[i])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+	h ^= uint32(n)
+	return fmix32(h)
+}
+
+// fmix32 is the finalizer of MurmurHash3, which mixes all bits of h into every bit of the result.
+func fmix32(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// crc32Hash returns the CRC-32 hash of key like libmemcached.
+func crc32Hash(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key)) >> 16 & 0x7fff
+}
+
+// jump returns the bucket of hash among n buckets by the jump consistent hash of Lamping and Veach.
+func jump(hash uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		hash = hash*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64(hash>>33+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,98 @@
+package mc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHashes(t *testing.T) {
+	if h := murmur3("hello"); h != 0x248bfa47 {
+		t.Errorf("murmur3(hello) = %#x", h)
+	}
+	if h := murmur3("The quick brown fox jumps over the lazy dog"); h != 0x2e4ff723 {
+		t.Errorf("murmur3(fox) = %#x", h)
+	}
+	if h := crc32Hash("hello"); h != 0x3610 {
+		t.Errorf("crc32(hello) = %#x", h)
+	}
+}
+
+func TestJumpHash(t *testing.T) {
+	moved := 0
+	for i := 0; i < 10000; i++ {
+		key := "key" + strconv.Itoa(i)
+		a, b := JumpHash.Shard(key, 10), JumpHash.Shard(key, 11)
+		if a < 0 || a >= 10 || b < 0 || b >= 11 {
+			t.Fatalf("%s: shards %d and %d out of range", key, a, b)
+		}
+		if a != b {
+			if b != 10 {
+				t.Fatalf("%s moved from %d to %d rather than the new shard", key, a, b)
+			}
+			moved++
+		}
+	}
+	// about 1/11 of keys move
+	if moved < 700 || moved > 1100 {
+		t.Errorf("%d keys moved", moved)
+	}
+}
+
+func TestMemoryStoreHasher(t *testing.T) {
+	ctx := context.Background()
+	for _, h := range []Hasher{FNV1a, Murmur3, CRC32, JumpHash} {
+		st := NewMemoryStore(MemoryStoreOptions{Shards: 4, Hasher: h})
+		for i := 0; i < 100; i++ {
+			st.Set(ctx, &Item{Key: "k" + strconv.Itoa(i), Data: []byte("v")})
+		}
+		u := st.Usage()
+		for i, su := range u.Shards {
+			if su.Items == 0 {
+				t.Errorf("%v: shard %d is empty", h, i)
+			}
+		}
+		st.Resize(3, 0)
+		for i := 0; i < 100; i++ {
+			if _, err := st.Get(ctx, "k"+strconv.Itoa(i)); err != nil {
+				t.Errorf("%v: Get k%d after resize: %v", h, i, err)
+			}
+		}
+
+		res := &Response{}
+		StatsHandler(st)(ctx, &Request{Command: "stats", Keys: []string{"settings"}}, res)
+		if !strings.Contains(res.Response, "STAT hash_algorithm "+hasherName(h)+"\r\n") {
+			t.Errorf("unexpected settings: %q", res.Response)
+		}
+	}
+}
+
+func TestHashTags(t *testing.T) {
+	h := HashTags(FNV1a, "{", "}")
+	for _, tt := range []struct{ key, tag string }{
+		{"{user1}:profile", "user1"},
+		{"cart:{user1}", "user1"},
+		{"{user1}{x}", "user1"},
+		{"plain", "plain"},
+		{"{}user1", "{}user1"},
+		{"{user1", "{user1"},
+		{"a}{b}", "b"},
+	} {
+		if tag := h.(tagHasher).tag(tt.key); tag != tt.tag {
+			t.Errorf("tag of %q is %q, expected %q", tt.key, tag, tt.tag)
+		}
+	}
+
+	st := NewMemoryStore(MemoryStoreOptions{Shards: 16, Hasher: h})
+	l := st.current()
+	for i := 0; i < 100; i++ {
+		id := strconv.Itoa(i)
+		if l.shard("{user"+id+"}:profile") != l.shard("cart:{user"+id+"}") {
+			t.Fatalf("keys of user%s are in different shards", id)
+		}
+	}
+	if name := hasherName(h); name != "fnv1a_32+tags" {
+		t.Errorf("unexpected name %q", name)
+	}
+}
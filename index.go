@@ -0,0 +1,92 @@
+package mc
+
+import (
+	"sync"
+)
+
+// IndexType selects how a MemoryStore indexes items in its shards.
+type IndexType int
+
+const (
+	// IndexLocked is a map guarded by a read-write lock of its shard. It is the default.
+	IndexLocked IndexType = iota
+	// IndexReadMostly is a sync.Map, so gets don't take the lock of their shard, and mostly take
+	// no lock at all, since sync.Map serves keys which are not new from a read-only map.
+	// Writers still serialize on the shard lock, and new keys cost more than with IndexLocked.
+	// It suits workloads with more than 95% reads.
+	IndexReadMostly
+)
+
+// index maps keys to entries of a shard. Writers always hold the shard lock.
+type index interface {
+	get(key string) (*entry, bool)
+	put(key string, e *entry)
+	del(key string)
+	size() int
+	each(fn func(key string, e *entry))
+	// lockFree returns whether get is safe without the shard lock.
+	lockFree() bool
+}
+
+func newIndex(t IndexType) index {
+	if t == IndexReadMostly {
+		return &readMostlyIndex{}
+	}
+	return mapIndex{}
+}
+
+type mapIndex map[string]*entry
+
+func (m mapIndex) get(key string) (*entry, bool) {
+	e, ok := m[key]
+	return e, ok
+}
+
+func (m mapIndex) put(key string, e *entry) { m[key] = e }
+func (m mapIndex) del(key string)           { delete(m, key) }
+func (m mapIndex) size() int                { return len(m) }
+func (m mapIndex) lockFree() bool           { return false }
+
+func (m mapIndex) each(fn func(key string, e *entry)) {
+	for k, e := range m {
+		fn(k, e)
+	}
+}
+
+// readMostlyIndex is the index of IndexReadMostly, a sync.Map with its size.
+type readMostlyIndex struct {
+	m sync.Map
+	n int // guarded by the shard lock
+}
+
+func (m *readMostlyIndex) get(key string) (*entry, bool) {
+	e, ok := m.m.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return e.(*entry), true
+}
+
+func (m *readMostlyIndex) put(key string, e *entry) {
+	// writers hold the shard lock, so the key can't be added between Load and Store
+	if _, ok := m.m.Load(key); !ok {
+		m.n++
+	}
+	m.m.Store(key, e)
+}
+
+func (m *readMostlyIndex) del(key string) {
+	if _, loaded := m.m.LoadAndDelete(key); loaded {
+		m.n--
+	}
+}
+
+func (m *readMostlyIndex) size() int      { return m.n }
+func (m *readMostlyIndex) lockFree() bool { return true }
+
+func (m *readMostlyIndex) each(fn func(key string, e *entry)) {
+	m.m.Range(func(k, v interface{}) bool {
+		fn(k.(string), v.(*entry))
+		return true
+	})
+}
@@ -0,0 +1,109 @@
+package mc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+)
+
+// KeyHashOptions configures HashLongKeys.
+type KeyHashOptions struct {
+	// Prefix is prepended to hashed keys. Default is "sha1:".
+	Prefix string
+	// DetectCollisions stores the original key in front of the data, so values of other keys
+	// with the same hash are treated as misses. Prepend, incr and decr of long keys are
+	// rejected then, as they would break the stored key.
+	DetectCollisions bool
+}
+
+// HashLongKeys returns a middleware which replaces keys longer than MaxKeyLength by their
+// SHA-1 hashes before calling the handler, like many client libraries do, so applications can
+// use long natural keys. Keys in values of responses are the original ones.
+// Requests with hashed keys are copies without Raw, as it has the original keys.
+func HashLongKeys(opts KeyHashOptions) Middleware {
+	if opts.Prefix == "" {
+		opts.Prefix = "sha1:"
+	}
+	hash := func(key string) string {
+		sum := sha1.Sum([]byte(key))
+		return opts.Prefix + hex.EncodeToString(sum[:])
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request, res *Response) error {
+			originals := make(map[string]string) // hashed -> original
+			r, err := transformKeys(req, opts, hash, originals)
+			if err != nil {
+				return err
+			}
+			if len(originals) == 0 {
+				return next(ctx, req, res)
+			}
+
+			if err := next(ctx, r, res); err != nil {
+				return err
+			}
+			values := res.Values[:0]
+			for _, v := range res.Values {
+				if original, ok := originals[v.Key]; ok {
+					if opts.DetectCollisions {
+						header := []byte(original + "\n")
+						if !bytes.HasPrefix(v.Data, header) {
+							continue // another key with the same hash
+						}
+						v.Data = v.Data[len(header):]
+					}
+					v.Key = original
+				}
+				values = append(values, v)
+			}
+			res.Values = values
+			return nil
+		}
+	}
+}
+
+// transformKeys returns a copy of req whose long keys are hashed, recording the original keys.
+func transformKeys(req *Request, opts KeyHashOptions, hash func(string) string, originals map[string]string) (*Request, error) {
+	r := *req
+	replace := func(key string) string {
+		if len(key) <= MaxKeyLength {
+			return key
+		}
+		h := hash(key)
+		originals[h] = key
+		return h
+	}
+
+	if len(req.Key) > MaxKeyLength {
+		r.Raw = nil
+		r.Key = replace(req.Key)
+		if opts.DetectCollisions {
+			switch req.Command {
+			case "set", "add", "replace", "cas":
+				r.Data = append([]byte(req.Key+"\n"), req.Data...)
+			case "prepend", "incr", "decr":
+				return nil, NewError("command " + req.Command + " is not supported for long keys")
+			}
+		}
+	}
+	if len(req.Keys) > 0 {
+		r.Keys = make([]string, len(req.Keys))
+		for i, key := range req.Keys {
+			if r.Keys[i] = replace(key); r.Keys[i] != key {
+				r.Raw = nil
+			}
+		}
+	}
+	if len(req.Batch) > 0 {
+		r.Batch = make([]*Request, len(req.Batch))
+		for i, item := range req.Batch {
+			var err error
+			if r.Batch[i], err = transformKeys(item, opts, hash, originals); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &r, nil
+}
@@ -0,0 +1,66 @@
+package mc
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestHashLongKeys(t *testing.T) {
+	ctx := context.Background()
+	long := strings.Repeat("k", 300)
+
+	for _, detect := range []bool{false, true} {
+		st := NewMemoryStore(MemoryStoreOptions{})
+		h := handlerMap{}
+		RegisterStore(h, st)
+		for cmd, fn := range h {
+			h[cmd] = HashLongKeys(KeyHashOptions{DetectCollisions: detect})(fn)
+		}
+
+		if res := h.call(&Request{Command: "set", Key: long, Flags: "1", Data: []byte("v")}); res.Response != RespStored {
+			t.Fatalf("set: %q", res.Response)
+		}
+		if _, err := st.Get(ctx, long); err != ErrNotFound {
+			t.Errorf("long key should be hashed: %v", err)
+		}
+		if st.Len() != 1 {
+			t.Errorf("expected 1 item, got %d", st.Len())
+		}
+
+		res := h.call(&Request{Command: "get", Keys: []string{"short", long}})
+		if len(res.Values) != 1 || res.Values[0].Key != long || string(res.Values[0].Data) != "v" {
+			t.Errorf("get: %+v", res.Values)
+		}
+	}
+}
+
+func TestHashLongKeysCollision(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore(MemoryStoreOptions{})
+	h := handlerMap{}
+	RegisterStore(h, st)
+	mw := HashLongKeys(KeyHashOptions{Prefix: "h:", DetectCollisions: true})
+	for cmd, fn := range h {
+		h[cmd] = mw(fn)
+	}
+
+	// Simulate a colliding key by storing another original key under the hash of long.
+	long := strings.Repeat("a", 251)
+	sum := sha1.Sum([]byte(long))
+	hashed := "h:" + hex.EncodeToString(sum[:])
+	h.call(&Request{Command: "set", Key: long, Flags: "0", Data: []byte("v")})
+	if it, err := st.Get(ctx, hashed); err != nil || string(it.Data) != long+"\nv" {
+		t.Fatalf("original key should be stored with the value: %+v %v", it, err)
+	}
+	st.Set(ctx, &Item{Key: hashed, Data: []byte(strings.Repeat("b", 251) + "\nother")})
+
+	if res := h.call(&Request{Command: "get", Keys: []string{long}}); len(res.Values) != 0 {
+		t.Errorf("colliding value should be a miss: %+v", res.Values)
+	}
+	if res := h.call(&Request{Command: "incr", Key: long, Value: 1}); !strings.HasPrefix(res.Response, RespClientErr) {
+		t.Errorf("incr of long key: %q", res.Response)
+	}
+}
@@ -0,0 +1,93 @@
+package mc
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"strings"
+)
+
+// DefaultPort is used when an address has no port.
+const DefaultPort = "11211"
+
+// listen listens on addr which is a TCP address or a URL like:
+//
+//	tcp://127.0.0.1:11211
+//	tcp4://localhost:11211 (resolves and listens on IPv4 only)
+//	tcp6://[fe80::1%eth0]:11211 (resolves and listens on IPv6 only)
+//	unix:///tmp/memcached.sock
+//	npipe:////./pipe/memcached (Windows only)
+func listen(addr string) (net.Listener, error) {
+	network, address, err := parseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch network {
+	case "unix":
+		return net.Listen("unix", unixSocketPath(addr, runtime.GOOS == "windows"))
+	case "npipe":
+		return listenPipe(pipePath(addr))
+	default:
+		return net.Listen(network, address)
+	}
+}
+
+// parseAddr returns the network and the address of addr.
+// TCP addresses without ports use DefaultPort, IPv6 hosts may omit brackets
+// and zone IDs may be written either as % or as the escaped %25.
+func parseAddr(addr string) (network, address string, err error) {
+	network, address = "tcp", addr
+	if i := strings.Index(addr, "://"); i >= 0 {
+		network, address = addr[:i], addr[i+3:]
+	}
+
+	switch network {
+	case "unix", "npipe":
+		return network, address, nil
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return "", "", errors.New("unsupported network in address: " + addr)
+	}
+
+	// drop the path or query of URLs like tcp://host:port/
+	if i := strings.IndexAny(address, "/?"); i >= 0 {
+		address = address[:i]
+	}
+	address = strings.Replace(address, "%25", "%", -1)
+
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return network, address, nil
+	}
+
+	// no port
+	host := strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+	if ip := net.ParseIP(host); ip == nil && strings.Contains(host, ":") && !strings.Contains(host, "%") {
+		return "", "", errors.New("invalid address: " + addr)
+	}
+	return network, net.JoinHostPort(host, DefaultPort), nil
+}
+
+// unixSocketPath returns the socket path of a unix:// URL.
+// The path is not parsed as a URL path so relative paths and Windows paths
+// like unix://C:/tmp/mc.sock or unix:///C:/tmp/mc.sock work.
+func unixSocketPath(addr string, windows bool) string {
+	p := strings.TrimPrefix(addr, "unix://")
+	if !windows {
+		return p
+	}
+	if len(p) >= 3 && p[0] == '/' && p[2] == ':' { // /C:/...
+		p = p[1:]
+	}
+	return strings.Replace(p, "/", `\`, -1)
+}
+
+// pipePath returns the named pipe path of a npipe:// URL.
+// Both npipe:////./pipe/name and npipe://name mean \\.\pipe\name.
+func pipePath(addr string) string {
+	p := strings.TrimPrefix(addr, "npipe://")
+	if !strings.HasPrefix(p, "//") && !strings.HasPrefix(p, `\\`) {
+		p = `\\.\pipe\` + p
+	}
+	return strings.Replace(p, "/", `\`, -1)
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package mc
+
+import (
+	"errors"
+	"net"
+)
+
+// listenPipe listens on a Windows named pipe, which is not supported on this platform.
+func listenPipe(path string) (net.Listener, error) {
+	return nil, errors.New("named pipes are only supported on Windows: " + path)
+}
@@ -0,0 +1,85 @@
+package mc
+
+import (
+	"testing"
+)
+
+func TestUnixSocketPath(t *testing.T) {
+	cases := []struct {
+		addr    string
+		windows bool
+		path    string
+	}{
+		{"unix:///tmp/memcached.sock", false, "/tmp/memcached.sock"},
+		{"unix://memcached.sock", false, "memcached.sock"},
+		{"unix://C:/tmp/memcached.sock", true, `C:\tmp\memcached.sock`},
+		{"unix:///C:/tmp/memcached.sock", true, `C:\tmp\memcached.sock`},
+		{"unix://memcached.sock", true, "memcached.sock"},
+	}
+	for _, c := range cases {
+		if p := unixSocketPath(c.addr, c.windows); p != c.path {
+			t.Errorf("%s: expected %s, got %s", c.addr, c.path, p)
+		}
+	}
+}
+
+func TestPipePath(t *testing.T) {
+	cases := map[string]string{
+		"npipe:////./pipe/memcached": `\\.\pipe\memcached`,
+		"npipe://memcached":          `\\.\pipe\memcached`,
+	}
+	for addr, path := range cases {
+		if p := pipePath(addr); p != path {
+			t.Errorf("%s: expected %s, got %s", addr, path, p)
+		}
+	}
+}
+
+func TestParseAddr(t *testing.T) {
+	cases := []struct {
+		addr    string
+		network string
+		address string
+	}{
+		{"127.0.0.1:11211", "tcp", "127.0.0.1:11211"},
+		{":11211", "tcp", ":11211"},
+		{"localhost", "tcp", "localhost:11211"},
+		{"tcp://localhost:11212", "tcp", "localhost:11212"},
+		{"tcp://localhost:11212/", "tcp", "localhost:11212"},
+		{"tcp4://localhost", "tcp4", "localhost:11211"},
+		{"tcp6://[::1]:11212", "tcp6", "[::1]:11212"},
+		{"tcp6://::1", "tcp6", "[::1]:11211"},
+		{"tcp6://[fe80::1%eth0]:11212", "tcp6", "[fe80::1%eth0]:11212"},
+		{"tcp6://[fe80::1%25eth0]:11212", "tcp6", "[fe80::1%eth0]:11212"},
+		{"tcp6://[fe80::1%eth0]", "tcp6", "[fe80::1%eth0]:11211"},
+		{"unix:///tmp/memcached.sock", "unix", "/tmp/memcached.sock"},
+	}
+	for _, c := range cases {
+		network, address, err := parseAddr(c.addr)
+		if err != nil {
+			t.Errorf("%s: %v", c.addr, err)
+			continue
+		}
+		if network != c.network || address != c.address {
+			t.Errorf("%s: expected %s %s, got %s %s", c.addr, c.network, c.address, network, address)
+		}
+	}
+
+	for _, addr := range []string{"udp://127.0.0.1:11211", "tcp6://fe80::zz"} {
+		if _, _, err := parseAddr(addr); err == nil {
+			t.Errorf("%s: expected an error", addr)
+		}
+	}
+}
+
+func TestListenTCP4(t *testing.T) {
+	ln, err := listen("tcp4://127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	if ln.Addr().Network() != "tcp" {
+		t.Errorf("unexpected network: %s", ln.Addr().Network())
+	}
+}
@@ -0,0 +1,12 @@
+package mc
+
+import (
+	"net"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// listenPipe listens on a Windows named pipe.
+func listenPipe(path string) (net.Listener, error) {
+	return winio.ListenPipe(path, nil)
+}
@@ -0,0 +1,158 @@
+package mc
+
+import (
+	"context"
+	"time"
+)
+
+// ItemVersion is the version of a write, by which LastWriteWins resolves conflicts.
+type ItemVersion struct {
+	Time int64  // unix nanoseconds when the write was accepted
+	Node string // the node which accepted the write
+}
+
+// IsZero returns whether the item has no version.
+func (v ItemVersion) IsZero() bool {
+	return v.Time == 0 && v.Node == ""
+}
+
+// Applier is implemented by stores which apply writes replicated from other nodes.
+// Apply returns ErrNotStored if the write is discarded by conflict resolution.
+type Applier interface {
+	Apply(ctx context.Context, item *Item) error
+}
+
+// LWWOptions configures LastWriteWins.
+type LWWOptions struct {
+	// Node is the unique name of this node, recorded in versions of local writes.
+	Node string
+	// Now returns the time of local writes. Default is time.Now.
+	Now func() time.Time
+	// TieBreaker returns whether a wins over b, which were written at the same time.
+	// It must be the same on all nodes. Default is that the greater node name wins.
+	TieBreaker func(a, b ItemVersion) bool
+}
+
+// LWWStore is a Store with last-write-wins conflict resolution.
+type LWWStore struct {
+	Store
+	opts LWWOptions
+}
+
+// LastWriteWins returns a store for multi-writer setups: local writes by Set are versioned
+// with the time and this node, and writes replicated from other nodes by Apply are stored only
+// if their versions are newer than the stored ones, so all nodes converge whatever order they
+// receive writes in. Deletes are not versioned.
+func LastWriteWins(st Store, opts LWWOptions) *LWWStore {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.TieBreaker == nil {
+		opts.TieBreaker = func(a, b ItemVersion) bool { return a.Node > b.Node }
+	}
+	return &LWWStore{Store: st, opts: opts}
+}
+
+// newer returns whether a wins over b.
+func (s *LWWStore) newer(a, b ItemVersion) bool {
+	if a.Time != b.Time {
+		return a.Time > b.Time
+	}
+	return a != b && s.opts.TieBreaker(a, b)
+}
+
+// stamp versions a local write.
+func (s *LWWStore) stamp(it *Item) {
+	it.Version = ItemVersion{Time: s.opts.Now().UnixNano(), Node: s.opts.Node}
+}
+
+// Set stores a local write with a new version. Writes are best kept with a version later than
+// the stored one even if clocks of nodes drift, so the stored version is bumped if needed.
+func (s *LWWStore) Set(ctx context.Context, item *Item) error {
+	return s.update(ctx, item.Key, func(cur *Item) (*Item, error) {
+		s.stamp(item)
+		if cur != nil && !s.newer(item.Version, cur.Version) {
+			item.Version.Time = cur.Version.Time + 1
+		}
+		return item, nil
+	})
+}
+
+// MSet stores local writes with new versions.
+func (s *LWWStore) MSet(ctx context.Context, items []*Item) error {
+	for _, it := range items {
+		if err := s.Set(ctx, it); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply implements Applier. Items without versions are treated as local writes.
+func (s *LWWStore) Apply(ctx context.Context, item *Item) error {
+	if item.Version.IsZero() {
+		return s.Set(ctx, item)
+	}
+	return s.update(ctx, item.Key, func(cur *Item) (*Item, error) {
+		if cur != nil && !s.newer(item.Version, cur.Version) {
+			return nil, ErrNotStored
+		}
+		return item, nil
+	})
+}
+
+// Update implements Updater. Items returned by fn are versioned as local writes.
+func (s *LWWStore) Update(ctx context.Context, key string, fn func(it *Item) (*Item, error)) error {
+	return s.update(ctx, key, func(cur *Item) (*Item, error) {
+		it, err := fn(cur)
+		if err != nil {
+			return nil, err
+		}
+		s.stamp(it)
+		if cur != nil && !s.newer(it.Version, cur.Version) {
+			it.Version.Time = cur.Version.Time + 1
+		}
+		return it, nil
+	})
+}
+
+// update modifies an item with the Updater of the underlying store, or by reading and then
+// writing it, which is not atomic, if there is none.
+func (s *LWWStore) update(ctx context.Context, key string, fn func(it *Item) (*Item, error)) error {
+	if u, ok := s.Store.(Updater); ok {
+		return u.Update(ctx, key, fn)
+	}
+	cur, err := s.Store.Get(ctx, key)
+	if err == ErrNotFound {
+		cur, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+	it, err := fn(cur)
+	if err != nil {
+		return err
+	}
+	return s.Store.Set(ctx, it)
+}
+
+// Range ranges keys of the underlying store, or returns ErrNotSupported if it is not a KeyRanger.
+func (s *LWWStore) Range(ctx context.Context, fn func(key string) bool) error {
+	if r, ok := s.Store.(KeyRanger); ok {
+		return r.Range(ctx, fn)
+	}
+	return ErrNotSupported
+}
+
+// Peek implements Peeker with the Peeker of the underlying store, or with MGet if it has none.
+func (s *LWWStore) Peek(ctx context.Context, keys []string) (map[string]*Item, error) {
+	return peek(ctx, s.Store, keys)
+}
+
+// Flush flushes the underlying store if it is a Flusher.
+func (s *LWWStore) Flush(ctx context.Context) error {
+	if f, ok := s.Store.(Flusher); ok {
+		return f.Flush(ctx)
+	}
+	return ErrNotSupported
+}
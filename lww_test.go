@@ -0,0 +1,63 @@
+package mc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLastWriteWins(t *testing.T) {
+	ctx := context.Background()
+	clock := time.Unix(100, 0)
+	now := func() time.Time { return clock }
+	a := LastWriteWins(NewMemoryStore(MemoryStoreOptions{}), LWWOptions{Node: "a", Now: now})
+	b := LastWriteWins(NewMemoryStore(MemoryStoreOptions{}), LWWOptions{Node: "b", Now: now})
+
+	// concurrent writes at the same time are resolved by the tie-breaker on both nodes
+	wa, wb := &Item{Key: "k", Data: []byte("from a")}, &Item{Key: "k", Data: []byte("from b")}
+	a.Set(ctx, wa)
+	b.Set(ctx, wb)
+	if err := a.Apply(ctx, &Item{Key: "k", Data: wb.Data, Version: wb.Version}); err != nil {
+		t.Errorf("newer write is not applied: %v", err)
+	}
+	if err := b.Apply(ctx, &Item{Key: "k", Data: wa.Data, Version: wa.Version}); err != ErrNotStored {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+	for _, st := range []*LWWStore{a, b} {
+		if it, _ := st.Get(ctx, "k"); string(it.Data) != "from b" {
+			t.Errorf("nodes don't converge: %q", it.Data)
+		}
+	}
+
+	// local writes are newer than the stored version even if the clock is behind
+	clock = time.Unix(50, 0)
+	a.Set(ctx, &Item{Key: "k", Data: []byte("later")})
+	if it, _ := a.Get(ctx, "k"); string(it.Data) != "later" || it.Version.Time <= wb.Version.Time || it.Version.Node != "a" {
+		t.Errorf("unexpected item: %+v", it)
+	}
+
+	// RegisterStore uses the versioned Updater
+	h := handlerMap{}
+	RegisterStore(h, a)
+	h.call(&Request{Command: "set", Key: "n", Flags: "0", Data: []byte("1")})
+	if res := h.call(&Request{Command: "incr", Key: "n", Value: 1}); res.Response != "2" {
+		t.Errorf("unexpected incr response: %q", res.Response)
+	}
+	if it, _ := a.Get(ctx, "n"); it.Version.Node != "a" {
+		t.Errorf("incr is not versioned: %+v", it.Version)
+	}
+}
+
+func TestSyncerLastWriteWins(t *testing.T) {
+	ctx := context.Background()
+	primary := NewMemoryStore(MemoryStoreOptions{})
+	local := LastWriteWins(NewMemoryStore(MemoryStoreOptions{}), LWWOptions{Node: "b"})
+	primary.Set(ctx, &Item{Key: "k", Data: []byte("old"), Version: ItemVersion{Time: 1, Node: "a"}})
+	local.Set(ctx, &Item{Key: "k", Data: []byte("new")})
+
+	s := &Syncer{Local: local, Primary: primary}
+	s.Pass(ctx)
+	if it, _ := local.Get(ctx, "k"); string(it.Data) != "new" || s.Stats().Repaired != 0 {
+		t.Errorf("newer local item is replaced: %q", it.Data)
+	}
+}
@@ -4,6 +4,7 @@ package mc
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -27,6 +28,82 @@ type Request struct {
 	Value   uint64
 	Cas     string
 	Noreply bool
+	// Batch is the requests in a mset or mdelete extension command.
+	Batch []*Request
+	// Stats is the decoded stats command. Its arguments are also in Keys.
+	Stats *StatsRequest
+	// Raw is the raw bytes of the request, including the data block,
+	// with line endings normalized to \r\n. It is only set if the server keeps raw requests,
+	// and Data is a slice of it then.
+	Raw []byte
+
+	// borrowed is set if Data is a slice of the read buffer of the connection, see Server.ZeroCopy.
+	borrowed bool
+}
+
+// FlagsUint32 returns Flags as a number. Flags of requests read by ReadRequest are always valid.
+func (r *Request) FlagsUint32() (uint32, error) {
+	return parseFlags(r.Flags)
+}
+
+// Clone returns a deep copy of r. If Data is a slice of Raw, it is a slice of the copied Raw.
+func (r *Request) Clone() *Request {
+	c := *r
+	if r.Keys != nil {
+		c.Keys = append([]string(nil), r.Keys...)
+	}
+	if r.Raw != nil {
+		c.Raw = append([]byte(nil), r.Raw...)
+	}
+	if r.Data != nil {
+		c.Data = cloneData(r.Data, r.Raw, c.Raw)
+	}
+	c.borrowed = false
+	if r.Stats != nil {
+		st := *r.Stats
+		st.Args = append([]string(nil), r.Stats.Args...)
+		c.Stats = &st
+	}
+	if r.Batch != nil {
+		c.Batch = make([]*Request, len(r.Batch))
+		for i, item := range r.Batch {
+			c.Batch[i] = item.Clone()
+		}
+	}
+	return &c
+}
+
+// String formats r for logs. Unlike %+v, it shows the sizes of data and only a short prefix of it,
+// see FormatRequest.
+func (r *Request) String() string {
+	return FormatRequest(nil, r)
+}
+
+// cloneData copies data, or returns the same slice of rawCopy if data is the data block of raw,
+// which is followed by \r\n.
+func cloneData(data, raw, rawCopy []byte) []byte {
+	if start := len(raw) - len(data) - 2; len(data) > 0 && start >= 0 && &raw[start] == &data[0] {
+		return rawCopy[start : start+len(data) : start+len(data)]
+	}
+	return append([]byte{}, data...)
+}
+
+// StatsRequest returns the decoded stats command, which is decoded from Keys if the request
+// was not read by the server.
+func (r *Request) StatsRequest() (StatsRequest, error) {
+	if r.Stats != nil {
+		return *r.Stats, nil
+	}
+	return ParseStatsRequest(r.Keys)
+}
+
+// parseFlags parses client flags which are 32-bit unsigned integers.
+func parseFlags(s string) (uint32, error) {
+	flags, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, NewError("bad flags " + s)
+	}
+	return uint32(flags), nil
 }
 
 // Error is memcached protocol error.
@@ -45,16 +122,178 @@ func NewError(description string) Error {
 
 // ReadRequest reads a request from reader
 func ReadRequest(r *bufio.Reader) (req *Request, err error) {
-	lineBytes, _, err := r.ReadLine()
+	return readRequest(r, readOptions{})
+}
+
+// readOptions configures readRequest.
+type readOptions struct {
+	// generic returns whether an unknown command is returned as a request whose Keys are
+	// its arguments instead of an error.
+	generic func(cmd string) bool
+	// keepRaw keeps the raw bytes of requests in Request.Raw.
+	keepRaw bool
+	// batch accepts the mset and mdelete extension commands.
+	batch bool
+	// maxLine is the max length of command lines. 0 means DefaultMaxLineLength.
+	maxLine int
+	// zeroCopy lets Data of storage requests alias the buffer of the reader, see readData.
+	zeroCopy bool
+}
+
+// DefaultMaxLineLength is the default max length of command lines, excluding data blocks.
+const DefaultMaxLineLength = 8 * 1024
+
+// maxLineLength returns the max length of command lines of the option n.
+func maxLineLength(n int) int {
+	if n <= 0 {
+		return DefaultMaxLineLength
+	}
+	return n
+}
+
+// ErrLineTooLong is returned by ReadRequest for command lines longer than the max length.
+// The rest of the line is not read, so the connection can't be used anymore.
+var ErrLineTooLong = NewError("line too long")
+
+// readLine reads a command line without its line ending. Lines longer than the buffer of r
+// are joined from the fragments returned by ReadLine, and lines longer than max are rejected
+// with ErrLineTooLong.
+func readLine(r *bufio.Reader, max int) ([]byte, error) {
+	line, isPrefix, err := r.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	if isPrefix {
+		// the fragment is overwritten by the next read
+		buf := append([]byte(nil), line...)
+		for isPrefix && len(buf) <= max {
+			if line, isPrefix, err = r.ReadLine(); err != nil {
+				return nil, err
+			}
+			buf = append(buf, line...)
+		}
+		line = buf
+	}
+	if len(line) > max {
+		return nil, ErrLineTooLong
+	}
+	return line, nil
+}
+
+// readRequest reads a request from reader.
+func readRequest(r *bufio.Reader, opts readOptions) (*Request, error) {
+	opts.maxLine = maxLineLength(opts.maxLine)
+	lineBytes, err := readLine(r, opts.maxLine)
 	if err != nil {
 		return nil, err
 	}
+	var raw []byte
+	if opts.keepRaw {
+		raw = make([]byte, 0, len(lineBytes)+2)
+		raw = append(append(raw, lineBytes...), "\r\n"...)
+	}
 	line := string(lineBytes)
 	arr := strings.Fields(line)
 	if len(arr) < 1 {
 		return nil, NewError("empty line")
 	}
 
+	req, err := parseRequest(r, arr, raw, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.keepRaw && req.Raw == nil {
+		req.Raw = raw
+	}
+	return req, nil
+}
+
+// readBlock fills data from r and reads the trailing \r\n.
+func readBlock(r *bufio.Reader, data []byte) error {
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	c, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if c != '\r' {
+		return NewError("expected \\r")
+	}
+	c, err = r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if c != '\n' {
+		return NewError("expected \\n")
+	}
+	return nil
+}
+
+// readData reads a data block of n bytes and the trailing \r\n into req.Data.
+// If raw is not nil, req.Raw is set to raw followed by the data block and req.Data is a slice of it.
+// Otherwise, if zeroCopy is set and the block fits in the buffer of r, req.Data is a slice of the
+// buffer, which is valid only until the next read from r.
+func readData(r *bufio.Reader, req *Request, n int, raw []byte, zeroCopy bool) error {
+	if n < 0 {
+		return NewError("bad data chunk")
+	}
+	if raw == nil && zeroCopy && n+2 <= r.Size() {
+		b, err := r.Peek(n + 2)
+		if err != nil {
+			return err
+		}
+		if b[n] != '\r' {
+			return NewError("expected \\r")
+		}
+		if b[n+1] != '\n' {
+			return NewError("expected \\n")
+		}
+		req.Data = b[:n:n] // appends don't overwrite the buffer
+		req.borrowed = true
+		r.Discard(n + 2)
+		return nil
+	}
+	if raw != nil {
+		req.Raw = make([]byte, len(raw)+n+2)
+		copy(req.Raw, raw)
+		req.Data = req.Raw[len(raw) : len(raw)+n]
+	} else {
+		req.Data = make([]byte, n)
+	}
+
+	if err := readBlock(r, req.Data); err != nil {
+		return err
+	}
+	if raw != nil {
+		copy(req.Raw[len(raw)+n:], "\r\n")
+	}
+	return nil
+}
+
+// parseRequest parses a request of the command line arr.
+func parseRequest(r *bufio.Reader, arr []string, raw []byte, opts readOptions) (req *Request, err error) {
+	if opts.batch {
+		switch arr[0] {
+		case "mset":
+			return parseMset(r, arr, raw, opts.maxLine)
+		case "mdelete":
+			// format:
+			// mdelete <key>+ [noreply]\r\n
+			req := &Request{Command: arr[0], Keys: arr[1:]}
+			if n := len(req.Keys); n > 0 && req.Keys[n-1] == "noreply" {
+				req.Keys, req.Noreply = req.Keys[:n-1], true
+			}
+			if len(req.Keys) == 0 {
+				return nil, NewError(fmt.Sprintf("too few params to command %q", arr[0]))
+			}
+			for _, key := range req.Keys {
+				req.Batch = append(req.Batch, &Request{Command: "delete", Key: key, Noreply: req.Noreply})
+			}
+			return req, nil
+		}
+	}
+
 	switch arr[0] {
 	case "set", "add", "replace", "append", "prepend":
 		// format:
@@ -67,6 +306,9 @@ func ReadRequest(r *bufio.Reader) (req *Request, err error) {
 		req.Command = arr[0]
 		req.Key = arr[1]
 		req.Flags = arr[2]
+		if _, err := parseFlags(req.Flags); err != nil {
+			return nil, err
+		}
 
 		// always use epoch
 		req.Exptime, err = strconv.ParseInt(arr[3], 10, 64)
@@ -86,29 +328,9 @@ func ReadRequest(r *bufio.Reader) (req *Request, err error) {
 		if len(arr) > 5 && arr[5] == "noreply" {
 			req.Noreply = true
 		}
-		req.Data = make([]byte, bytes)
-
-		n, err := io.ReadFull(r, req.Data)
-		if err != nil {
-			return nil, err
-		}
-		if n != bytes {
-			return nil, NewError(fmt.Sprintf("Read only %d bytes of %d bytes of expected data", n, bytes))
-		}
-		c, err := r.ReadByte()
-		if err != nil {
-			return nil, err
-		}
-		if c != '\r' {
-			return nil, NewError("expected \\r")
-		}
-		c, err = r.ReadByte()
-		if err != nil {
+		if err := readData(r, req, bytes, raw, opts.zeroCopy); err != nil {
 			return nil, err
 		}
-		if c != '\n' {
-			return nil, NewError("expected \\n")
-		}
 		return req, nil
 	case "cas":
 		// format:
@@ -121,6 +343,9 @@ func ReadRequest(r *bufio.Reader) (req *Request, err error) {
 		req.Command = arr[0]
 		req.Key = arr[1]
 		req.Flags = arr[2]
+		if _, err := parseFlags(req.Flags); err != nil {
+			return nil, err
+		}
 
 		req.Exptime, err = strconv.ParseInt(arr[3], 10, 64)
 		if err != nil {
@@ -140,32 +365,15 @@ func ReadRequest(r *bufio.Reader) (req *Request, err error) {
 		if len(arr) > 6 && arr[6] == "noreply" {
 			req.Noreply = true
 		}
-		req.Data = make([]byte, bytes)
-		n, err := io.ReadFull(r, req.Data)
-		if err != nil {
-			return nil, err
-		}
-		if n != bytes {
-			return nil, NewError(fmt.Sprintf("Read only %d bytes of %d bytes of expected data", n, bytes))
-		}
-		c, err := r.ReadByte()
-		if err != nil {
-			return nil, err
-		}
-		if c != '\r' {
-			return nil, NewError("expected \\r")
-		}
-		c, err = r.ReadByte()
-		if err != nil {
+		if err := readData(r, req, bytes, raw, opts.zeroCopy); err != nil {
 			return nil, err
 		}
-		if c != '\n' {
-			return nil, NewError("expected \\n")
-		}
 		return req, nil
 	case "delete":
 		// format:
-		// delete <key> [noreply]\r\n
+		// delete <key> [0] [noreply]\r\n
+		// Like memcached, extra keys are rejected rather than ignored, and the legacy hold time
+		// must be 0. Multiple keys are deleted by the mdelete extension command.
 		if len(arr) < 2 {
 			return nil, NewError(fmt.Sprintf("too few params to command %q", arr[0]))
 		}
@@ -173,8 +381,16 @@ func ReadRequest(r *bufio.Reader) (req *Request, err error) {
 		req.Command = arr[0]
 		req.Key = arr[1]
 
-		if len(arr) > 2 && arr[2] == "noreply" {
+		args := arr[2:]
+		if len(args) > 0 && args[0] == "0" {
+			args = args[1:]
+		}
+		if len(args) > 0 && args[0] == "noreply" {
 			req.Noreply = true
+			args = args[1:]
+		}
+		if len(args) > 0 {
+			return nil, NewError("bad command line format. Usage: delete <key> [noreply]")
 		}
 		return req, nil
 	case "get", "gets":
@@ -199,9 +415,10 @@ func ReadRequest(r *bufio.Reader) (req *Request, err error) {
 		req.Command = arr[0]
 		req.Key = arr[1]
 
+		// the delta is a 64-bit unsigned integer, negative deltas are rejected
 		req.Value, err = strconv.ParseUint(arr[2], 10, 64)
 		if err != nil {
-			return nil, NewError("cannot read value " + err.Error())
+			return nil, NewError("invalid numeric delta argument")
 		}
 
 		if len(arr) > 3 && arr[3] == "noreply" {
@@ -233,8 +450,12 @@ func ReadRequest(r *bufio.Reader) (req *Request, err error) {
 		}
 		return req, nil
 	case "flush_all":
-		// flush_all [delay]\r\n
+		// flush_all [delay] [noreply]\r\n
 		req := &Request{Command: arr[0]}
+		if len(arr) > 1 && arr[len(arr)-1] == "noreply" {
+			req.Noreply = true
+			arr = arr[:len(arr)-1]
+		}
 
 		if len(arr) > 1 {
 			req.Exptime, err = strconv.ParseInt(arr[1], 10, 64)
@@ -251,11 +472,212 @@ func ReadRequest(r *bufio.Reader) (req *Request, err error) {
 	case "stats":
 		// stats\r\n
 		// stats <args>\r\n
-		req := &Request{Command: arr[0]}
+		st, err := ParseStatsRequest(arr[1:])
+		if err != nil {
+			return nil, err
+		}
+		req := &Request{Command: arr[0], Stats: &st}
 		if len(arr) > 1 {
 			req.Keys = arr[1:]
 		}
 		return req, nil
 	}
+	if opts.generic != nil && opts.generic(arr[0]) {
+		// <command name> <args>* [noreply]\r\n
+		req := &Request{Command: arr[0], Keys: arr[1:]}
+		if n := len(req.Keys); n > 0 && req.Keys[n-1] == "noreply" {
+			req.Keys, req.Noreply = req.Keys[:n-1], true
+		}
+		if len(req.Keys) > 0 {
+			req.Key = req.Keys[0]
+		}
+		if req.Command == "ms" {
+			// meta set has a data block:
+			// ms <key> <datalen> <flag>*\r\n
+			// <data block>\r\n
+			if len(req.Keys) < 2 {
+				return nil, NewError(fmt.Sprintf("too few params to command %q", arr[0]))
+			}
+			bytes, err := strconv.Atoi(req.Keys[1])
+			if err != nil {
+				return nil, NewError("cannot read bytes " + err.Error())
+			}
+			if err := readData(r, req, bytes, raw, opts.zeroCopy); err != nil {
+				return nil, err
+			}
+		}
+		return req, nil
+	}
 	return nil, NewError(fmt.Sprintf("unknown command %q", arr[0]))
 }
+
+// MaxBatchSize is the max number of items in a mset extension command.
+const MaxBatchSize = 1024
+
+// parseMset parses the mset extension command.
+func parseMset(r *bufio.Reader, arr []string, raw []byte, maxLine int) (*Request, error) {
+	// format:
+	// mset <count> [noreply]\r\n
+	// then <count> items of:
+	// <key> <flags> <exptime> <bytes>\r\n
+	// <data block>\r\n
+	if len(arr) < 2 {
+		return nil, NewError(fmt.Sprintf("too few params to command %q", arr[0]))
+	}
+	count, err := strconv.Atoi(arr[1])
+	if err != nil || count < 1 || count > MaxBatchSize {
+		return nil, NewError("bad item count " + arr[1])
+	}
+	req := &Request{Command: arr[0], Raw: raw}
+	req.Noreply = len(arr) > 2 && arr[2] == "noreply"
+
+	for i := 0; i < count; i++ {
+		lineBytes, err := readLine(r, maxLine)
+		if err != nil {
+			return nil, err
+		}
+		var itemRaw []byte
+		if raw != nil {
+			itemRaw = append(append([]byte(nil), lineBytes...), "\r\n"...)
+		}
+		fields := strings.Fields(string(lineBytes))
+		if len(fields) != 4 {
+			return nil, NewError(fmt.Sprintf("bad item %d of command %q", i, arr[0]))
+		}
+
+		item, err := parseRequest(r, append([]string{"set"}, fields...), itemRaw, readOptions{})
+		if err != nil {
+			return nil, err
+		}
+		item.Noreply = req.Noreply
+		if raw != nil {
+			req.Raw = append(req.Raw, item.Raw...)
+			item.Raw = nil
+		}
+		req.Batch = append(req.Batch, item)
+	}
+	return req, nil
+}
+
+// ErrInvalidRequest is returned by WriteRequest for requests which can't be written without
+// breaking the protocol, like keys with spaces.
+var ErrInvalidRequest = errors.New("invalid request")
+
+// WriteRequest writes req in the text protocol, so it can be sent to a memcached server.
+// Every command read by ReadRequest is supported, including the mset and mdelete extensions.
+// Other commands are written as generic commands with Keys as arguments, and meta set is
+// followed by Data as its data block, whose length must be its second argument like ReadRequest
+// reads it. Raw is ignored. It doesn't flush w.
+func WriteRequest(w *bufio.Writer, req *Request) error {
+	line, err := requestLine(req)
+	if err != nil {
+		return err
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+
+	switch req.Command {
+	case "set", "add", "replace", "append", "prepend", "cas":
+		writeData(w, req.Data)
+	case "mset":
+		for _, item := range req.Batch {
+			itemLine, err := requestLine(&Request{Command: "set", Key: item.Key, Flags: item.Flags,
+				Exptime: item.Exptime, Data: item.Data})
+			if err != nil {
+				return err
+			}
+			w.WriteString(itemLine[len("set "):])
+			w.WriteString("\r\n")
+			writeData(w, item.Data)
+		}
+	case "ms":
+		writeData(w, req.Data)
+	}
+	return nil
+}
+
+// writeData writes a data block.
+func writeData(w *bufio.Writer, data []byte) {
+	w.Write(data)
+	w.WriteString("\r\n")
+}
+
+// requestLine returns the command line of req without the line ending.
+func requestLine(req *Request) (string, error) {
+	if !validKey(req.Command) {
+		return "", ErrInvalidRequest
+	}
+	flags := req.Flags
+	if flags == "" {
+		flags = "0"
+	}
+	exptime := strconv.FormatInt(req.Exptime, 10)
+
+	var fields []string
+	keys := []string{req.Key}
+	switch req.Command {
+	case "set", "add", "replace", "append", "prepend":
+		fields = []string{req.Command, req.Key, flags, exptime, strconv.Itoa(len(req.Data))}
+	case "cas":
+		if _, err := strconv.ParseUint(req.Cas, 10, 64); err != nil {
+			return "", ErrInvalidRequest
+		}
+		fields = []string{req.Command, req.Key, flags, exptime, strconv.Itoa(len(req.Data)), req.Cas}
+	case "get", "gets":
+		fields, keys = append([]string{req.Command}, req.Keys...), req.Keys
+	case "delete":
+		fields = []string{req.Command, req.Key}
+	case "incr", "decr":
+		fields = []string{req.Command, req.Key, strconv.FormatUint(req.Value, 10)}
+	case "touch":
+		fields = []string{req.Command, req.Key, exptime}
+	case "flush_all":
+		fields, keys = []string{req.Command}, nil
+		if req.Exptime != 0 {
+			fields = append(fields, exptime)
+		}
+	case "version", "quit":
+		fields, keys = []string{req.Command}, nil
+	case "stats":
+		args := req.Keys
+		if req.Stats != nil {
+			args = strings.Fields(req.Stats.String())
+		}
+		fields, keys = append([]string{req.Command}, args...), args
+	case "mset":
+		if len(req.Batch) == 0 || len(req.Batch) > MaxBatchSize {
+			return "", ErrInvalidRequest
+		}
+		fields, keys = []string{req.Command, strconv.Itoa(len(req.Batch))}, nil
+	case "mdelete":
+		fields, keys = append([]string{req.Command}, req.Keys...), req.Keys
+	case "ms":
+		if len(req.Keys) < 2 || req.Keys[1] != strconv.Itoa(len(req.Data)) {
+			return "", ErrInvalidRequest
+		}
+		fields, keys = append([]string{req.Command}, req.Keys...), req.Keys
+	default:
+		fields, keys = append([]string{req.Command}, req.Keys...), req.Keys
+	}
+	if len(keys) == 0 && (req.Command == "get" || req.Command == "gets" || req.Command == "mdelete") {
+		return "", ErrInvalidRequest
+	}
+	for _, key := range keys {
+		if !validKey(key) {
+			return "", ErrInvalidRequest
+		}
+	}
+	if _, err := parseFlags(flags); err != nil {
+		return "", ErrInvalidRequest
+	}
+
+	switch req.Command {
+	case "get", "gets", "version", "quit", "stats":
+	default:
+		// generic commands have noreply as their last argument too
+		if req.Noreply {
+			fields = append(fields, "noreply")
+		}
+	}
+	return strings.Join(fields, " "), nil
+}
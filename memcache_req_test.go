@@ -2,7 +2,10 @@ package mc
 
 import (
 	"bufio"
+	"bytes"
+	"math/rand"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -83,3 +86,294 @@ func TestError(t *testing.T) {
 	}
 	t.Fatalf("ReadRequest did not return error")
 }
+
+func TestRaw(t *testing.T) {
+	in := "set KEY 0 0 10 noreply\r\n1234567890\r\nget a b\r\n"
+	r := bufio.NewReader(strings.NewReader(in))
+
+	ret, err := readRequest(r, readOptions{keepRaw: true})
+	if err != nil {
+		t.Fatalf("ReadRequest %+v", err)
+	}
+	if string(ret.Raw) != "set KEY 0 0 10 noreply\r\n1234567890\r\n" {
+		t.Errorf("Raw %q", ret.Raw)
+	}
+	if string(ret.Data) != "1234567890" {
+		t.Errorf("Data %s", ret.Data)
+	}
+
+	ret, err = readRequest(r, readOptions{keepRaw: true})
+	if err != nil {
+		t.Fatalf("ReadRequest %+v", err)
+	}
+	if string(ret.Raw) != "get a b\r\n" {
+		t.Errorf("Raw %q", ret.Raw)
+	}
+}
+
+func TestFlags(t *testing.T) {
+	ret, err := testReq("set KEY 4294967295 0 1\r\n1\r\n", t)
+	if err != nil {
+		t.Fatalf("ReadRequest %+v", err)
+	}
+	if flags, err := ret.FlagsUint32(); err != nil || flags != 4294967295 {
+		t.Errorf("Flags %d %v", flags, err)
+	}
+
+	for _, in := range []string{"set KEY 4294967296 0 1\r\n1\r\n", "cas KEY -1 0 1 1\r\n1\r\n", "set KEY f1 0 1\r\n1\r\n"} {
+		if _, err := testReq(in, t); err == nil {
+			t.Errorf("%q: expected bad flags error", in)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("set KEY 0 0 5\r\nhello\r\n"))
+	req, err := readRequest(r, readOptions{keepRaw: true})
+	if err != nil {
+		t.Fatalf("ReadRequest %+v", err)
+	}
+	req.Keys = []string{"KEY"}
+	req.Batch = []*Request{{Command: "set", Key: "a", Data: []byte("1")}}
+
+	c := req.Clone()
+	if !reflect.DeepEqual(c, req) {
+		t.Fatalf("clone differs: %+v %+v", c, req)
+	}
+	c.Keys[0] = "x"
+	c.Data[0] = 'j'
+	c.Batch[0].Data[0] = '2'
+	if req.Keys[0] != "KEY" || string(req.Data) != "hello" || string(req.Raw) != "set KEY 0 0 5\r\nhello\r\n" || string(req.Batch[0].Data) != "1" {
+		t.Errorf("modifying the clone changed the request: %+v", req)
+	}
+	if string(c.Raw) != "set KEY 0 0 5\r\njello\r\n" {
+		t.Errorf("Data of the clone is not a slice of its Raw: %q", c.Raw)
+	}
+}
+
+func TestLineTooLong(t *testing.T) {
+	long := "get " + strings.Repeat("k", DefaultMaxLineLength) + "\r\n"
+	if _, err := testReq(long, t); err != ErrLineTooLong {
+		t.Errorf("expected ErrLineTooLong, got %v", err)
+	}
+	// longer than the buffer of the reader
+	r := bufio.NewReaderSize(strings.NewReader(long), 16)
+	if _, err := readRequest(r, readOptions{maxLine: 100}); err != ErrLineTooLong {
+		t.Errorf("expected ErrLineTooLong, got %v", err)
+	}
+
+	r = bufio.NewReader(strings.NewReader("mset 1\r\n" + strings.Repeat("k", 100) + " 0 0 1\r\na\r\n"))
+	if _, err := readRequest(r, readOptions{batch: true, maxLine: 50}); err != ErrLineTooLong {
+		t.Errorf("expected ErrLineTooLong for mset items, got %v", err)
+	}
+}
+
+func TestLongLines(t *testing.T) {
+	keys := make([]string, 2000)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	in := "get " + strings.Join(keys, " ") + "\r\n" +
+		"set " + strings.Repeat("k", 200) + " 0 0 2\r\nab\r\n"
+	r := bufio.NewReaderSize(strings.NewReader(in), 16)
+
+	req, err := readRequest(r, readOptions{maxLine: 64 * 1024})
+	if err != nil || !reflect.DeepEqual(req.Keys, keys) {
+		t.Fatalf("ReadRequest %d keys: %v", len(req.Keys), err)
+	}
+	req, err = readRequest(r, readOptions{keepRaw: true})
+	if err != nil || req.Key != strings.Repeat("k", 200) || string(req.Data) != "ab" ||
+		string(req.Raw) != in[len(in)-len(req.Raw):] {
+		t.Errorf("ReadRequest %+v: %v", req, err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	cases := []struct {
+		in      string
+		noreply bool
+		ok      bool
+	}{
+		{"delete k\r\n", false, true},
+		{"delete k noreply\r\n", true, true},
+		{"delete k 0\r\n", false, true},
+		{"delete k 0 noreply\r\n", true, true},
+		{"delete k 10\r\n", false, false},
+		{"delete k1 k2\r\n", false, false},
+		{"delete k noreply x\r\n", false, false},
+	}
+	for _, c := range cases {
+		req, err := testReq(c.in, t)
+		if !c.ok {
+			if _, ok := err.(Error); !ok {
+				t.Errorf("%q: expected protocol error, got %v", c.in, err)
+			}
+			continue
+		}
+		if err != nil || req.Key != "k" || req.Noreply != c.noreply {
+			t.Errorf("%q: unexpected request %+v: %v", c.in, req, err)
+		}
+	}
+}
+
+// randomRequest returns a random request of a command which ReadRequest parses, in the form
+// it is parsed to.
+func randomRequest(rnd *rand.Rand) *Request {
+	key := func() string {
+		const chars = "abcdefghijklmnopqrstuvwxyz0123456789:_-"
+		b := make([]byte, 1+rnd.Intn(MaxKeyLength))
+		for i := range b {
+			b[i] = chars[rnd.Intn(len(chars))]
+		}
+		return string(b)
+	}
+	data := func() []byte {
+		b := make([]byte, rnd.Intn(100))
+		rnd.Read(b) // any bytes, including \r\n
+		return b
+	}
+	cmds := []string{"set", "add", "replace", "append", "prepend", "cas", "get", "gets", "delete",
+		"incr", "decr", "touch", "flush_all", "version", "stats"}
+	req := &Request{Command: cmds[rnd.Intn(len(cmds))]}
+	switch req.Command {
+	case "set", "add", "replace", "append", "prepend", "cas":
+		req.Key, req.Flags, req.Exptime, req.Data = key(), strconv.FormatUint(uint64(rnd.Uint32()), 10), rnd.Int63n(1<<40)-1<<20, data()
+		if req.Command == "cas" {
+			req.Cas = strconv.FormatUint(rnd.Uint64(), 10)
+		}
+		req.Noreply = rnd.Intn(2) == 0
+	case "get", "gets":
+		for n := 1 + rnd.Intn(10); n > 0; n-- {
+			req.Keys = append(req.Keys, key())
+		}
+	case "delete", "incr", "decr", "touch":
+		req.Key = key()
+		if req.Command == "touch" {
+			req.Exptime = rnd.Int63()
+		} else if req.Command != "delete" {
+			req.Value = rnd.Uint64()
+		}
+		req.Noreply = rnd.Intn(2) == 0
+	case "flush_all":
+		req.Exptime = rnd.Int63n(100)
+		req.Noreply = rnd.Intn(2) == 0
+	case "stats":
+		st := StatsRequest{}
+		if rnd.Intn(2) == 0 {
+			st.Scope = StatsSlabs
+			req.Keys = []string{"slabs"}
+		}
+		req.Stats = &st
+	}
+	return req
+}
+
+func TestWriteRequestRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		req := randomRequest(rnd)
+		var b bytes.Buffer
+		w := bufio.NewWriter(&b)
+		if err := WriteRequest(w, req); err != nil {
+			t.Fatalf("WriteRequest %+v: %v", req, err)
+		}
+		w.Flush()
+		wire := b.String()
+
+		got, err := ReadRequest(bufio.NewReader(&b))
+		if err != nil || !reflect.DeepEqual(got, req) {
+			t.Fatalf("%q parsed to %+v (%v), expected %+v", wire, got, err, req)
+		}
+		if b.Len() != 0 {
+			t.Fatalf("%q: %d bytes left", wire, b.Len())
+		}
+	}
+}
+
+func TestWriteBatchRequest(t *testing.T) {
+	for _, line := range []string{
+		"mset 2\r\na 1 0 1\r\nx\r\nb 0 100 2\r\nyz\r\n",
+		"mset 1 noreply\r\na 0 0 0\r\n\r\n",
+		"mdelete a b c\r\n",
+		"mdelete a noreply\r\n",
+		"mn\r\n",
+		"mg key v t noreply\r\n",
+		"ms key 2 T0 F5\r\nab\r\n",
+		"ms key 0 noreply\r\n\r\n",
+	} {
+		opts := readOptions{batch: true, generic: func(cmd string) bool { return true }}
+		req, err := readRequest(bufio.NewReader(strings.NewReader(line)), opts)
+		if err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		var b bytes.Buffer
+		w := bufio.NewWriter(&b)
+		if err := WriteRequest(w, req); err != nil {
+			t.Fatalf("WriteRequest %q: %v", line, err)
+		}
+		w.Flush()
+		if b.String() != line {
+			t.Errorf("%q written as %q", line, b.String())
+		}
+	}
+}
+
+func TestReadGenericRequest(t *testing.T) {
+	opts := readOptions{generic: func(cmd string) bool { return true }}
+	r := bufio.NewReader(strings.NewReader("ms foo 2 T0 noreply\r\nab\r\nmg foo v\r\n"))
+	req, err := readRequest(r, opts)
+	if err != nil {
+		t.Fatalf("ms: %v", err)
+	}
+	want := &Request{Command: "ms", Key: "foo", Keys: []string{"foo", "2", "T0"}, Data: []byte("ab"), Noreply: true}
+	if !reflect.DeepEqual(req, want) {
+		t.Errorf("expected %+v, got %+v", want, req)
+	}
+	// the data block of ms is not taken for the next request
+	if req, err = readRequest(r, opts); err != nil || req.Command != "mg" || !reflect.DeepEqual(req.Keys, []string{"foo", "v"}) {
+		t.Errorf("unexpected request after ms: %+v %v", req, err)
+	}
+}
+
+func TestWriteInvalidRequest(t *testing.T) {
+	for _, req := range []*Request{
+		{Command: "set", Key: "a b", Data: []byte("x")},
+		{Command: "set", Key: "a", Flags: "x", Data: []byte("x")},
+		{Command: "get"},
+		{Command: "get", Keys: []string{"a", "b\r\nflush_all"}},
+		{Command: "cas", Key: "a", Cas: "-1"},
+		{Command: "mset"},
+		{Command: "ms", Key: "a", Keys: []string{"a", "3"}, Data: []byte("x")},
+		{Command: "bad command"},
+	} {
+		var b bytes.Buffer
+		w := bufio.NewWriter(&b)
+		if err := WriteRequest(w, req); err != ErrInvalidRequest {
+			t.Errorf("%+v: expected ErrInvalidRequest, got %v", req, err)
+		}
+		if w.Flush(); b.Len() != 0 {
+			t.Errorf("%+v: %q written", req, b.String())
+		}
+	}
+}
+
+func TestZeroCopy(t *testing.T) {
+	in := "set a 0 0 2\r\nab\r\nset b 0 0 20\r\n" + strings.Repeat("x", 20) + "\r\nset c 0 0 2\r\nabXX"
+	r := bufio.NewReaderSize(strings.NewReader(in), 16)
+
+	req, err := readRequest(r, readOptions{zeroCopy: true})
+	if err != nil || string(req.Data) != "ab" || !req.borrowed || cap(req.Data) != 2 {
+		t.Fatalf("ReadRequest %+v: %v", req, err)
+	}
+	if c := req.Clone(); c.borrowed {
+		t.Errorf("clone is borrowed")
+	}
+	// larger than the buffer
+	req, err = readRequest(r, readOptions{zeroCopy: true})
+	if err != nil || string(req.Data) != strings.Repeat("x", 20) || req.borrowed {
+		t.Errorf("ReadRequest %+v: %v", req, err)
+	}
+	if _, err = readRequest(r, readOptions{zeroCopy: true}); err == nil {
+		t.Errorf("expected an error for a bad data block")
+	}
+}
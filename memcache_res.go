@@ -1,14 +1,20 @@
 package mc
 
 import (
+	"bufio"
 	"bytes"
+	"net"
 	"strconv"
+	"strings"
 )
 
 // Response is a memcached response.
 type Response struct {
 	Response string
 	Values   []Value
+	// Data is the data block of a meta response like "VA <size> <flag>*", which is written after
+	// the line of Response. It is nil for responses without a data block.
+	Data []byte
 }
 
 // Value is data in responses.
@@ -19,6 +25,24 @@ type Value struct {
 	Cas  string
 }
 
+// NewValue creates a value with numeric flags.
+func NewValue(key string, flags uint32, data []byte) Value {
+	return Value{Key: key, Flags: strconv.FormatUint(uint64(flags), 10), Data: data}
+}
+
+// FlagsUint32 returns Flags as a number. Empty flags are 0.
+func (v Value) FlagsUint32() (uint32, error) {
+	if v.Flags == "" {
+		return 0, nil
+	}
+	return parseFlags(v.Flags)
+}
+
+// SetFlags sets Flags from a number.
+func (v *Value) SetFlags(flags uint32) {
+	v.Flags = strconv.FormatUint(uint64(flags), 10)
+}
+
 // String converts Response to string to send over wire.
 func (r Response) String() string {
 	// format:
@@ -28,27 +52,225 @@ func (r Response) String() string {
 	var b bytes.Buffer
 
 	for i := range r.Values {
-		//b.WriteString(fmt.Sprintf("VALUE %s %s %d\r\n", r.Values[i].Key, r.Values[i].Flags, len(r.Values[i].Data)))
-		b.WriteString("VALUE ")
-		b.WriteString(r.Values[i].Key)
-		b.WriteString(" ")
-		b.WriteString(r.Values[i].Flags)
-		b.WriteString(" ")
-		b.WriteString(strconv.Itoa(len(r.Values[i].Data)))
-
-		if r.Values[i].Cas != "" {
-			b.WriteString(" ")
-			b.WriteString(r.Values[i].Cas)
-		}
-
-		b.WriteString("\r\n")
-
+		b.Write(appendValueLine(nil, &r.Values[i]))
 		b.Write(r.Values[i].Data)
 		b.WriteString("\r\n")
 	}
 
 	b.WriteString(r.Response)
 	b.WriteString("\r\n")
+	if r.Data != nil {
+		b.Write(r.Data)
+		b.WriteString("\r\n")
+	}
 
 	return b.String()
 }
+
+// minVectoredData is the min length of data blocks which buffers doesn't copy.
+const minVectoredData = 512
+
+// buffers returns the wire format of r like String, as buffers for a vectored write.
+// Data blocks of at least minVectoredData bytes are not copied, so they must not be modified
+// until the buffers are written.
+func (r Response) buffers() net.Buffers {
+	var bufs net.Buffers
+	var b []byte // lines and small data blocks after the last buffer
+	for i := range r.Values {
+		v := &r.Values[i]
+		b = appendValueLine(b, v)
+		if len(v.Data) < minVectoredData {
+			b = append(append(b, v.Data...), "\r\n"...)
+			continue
+		}
+		bufs = append(bufs, b, v.Data)
+		b = []byte("\r\n")
+	}
+	b = append(append(b, r.Response...), "\r\n"...)
+	switch {
+	case r.Data == nil:
+	case len(r.Data) < minVectoredData:
+		b = append(append(b, r.Data...), "\r\n"...)
+	default:
+		bufs = append(bufs, b, r.Data)
+		b = []byte("\r\n")
+	}
+	return append(bufs, b)
+}
+
+// appendValueLine appends VALUE <key> <flags> <bytes> [<cas unique>]\r\n of v to b.
+func appendValueLine(b []byte, v *Value) []byte {
+	b = append(b, "VALUE "...)
+	b = append(b, v.Key...)
+	b = append(b, ' ')
+	if v.Flags == "" {
+		b = append(b, '0')
+	} else {
+		b = append(b, v.Flags...)
+	}
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(len(v.Data)), 10)
+	if v.Cas != "" {
+		b = append(b, ' ')
+		b = append(b, v.Cas...)
+	}
+	return append(b, "\r\n"...)
+}
+
+// ReadResponse reads the response of a cmd request from r, as written by Response.String.
+// Values are read for get and gets, the data block of VA for mg, the STAT lines of stats and the result lines of mset and
+// mdelete are kept in Response up to END, and other commands have single line responses.
+// It returns ErrInvalidResponse for lines which are not a response of cmd, unless cmd is not a
+// standard command. Error lines are responses rather than errors of ReadResponse, Response.Err
+// returns them as *ResponseError. Requests with noreply and quit have no responses, so it must not be called for them.
+func ReadResponse(r *bufio.Reader, cmd string) (*Response, error) {
+	res := &Response{}
+	for {
+		lineBytes, err := readLine(r, DefaultMaxLineLength)
+		if err != nil {
+			return nil, err
+		}
+		line := string(lineBytes)
+
+		switch {
+		case isErrorLine(line) && (cmd == "get" || cmd == "gets" || cmd == "stats"):
+			// an error replaces values, see setError
+			return &Response{Response: line}, nil
+		case (cmd == "mset" || cmd == "mdelete") && res.Response == "" && batchError(cmd, line):
+			return &Response{Response: line}, nil
+		case cmd == "get" || cmd == "gets":
+			if line == RespEnd {
+				res.Response = line
+				return res, nil
+			}
+			v, n, err := parseValueLine(line, cmd == "gets")
+			if err != nil {
+				return nil, err
+			}
+			v.Data = make([]byte, n)
+			if err := readBlock(r, v.Data); err != nil {
+				return nil, err
+			}
+			res.Values = append(res.Values, v)
+		case cmd == "mg" && strings.HasPrefix(line, "VA "):
+			fields := strings.Split(line, " ")
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n < 0 {
+				return nil, ErrInvalidResponse
+			}
+			res.Response, res.Data = line, make([]byte, n)
+			if err := readBlock(r, res.Data); err != nil {
+				return nil, err
+			}
+			return res, nil
+		case cmd == "stats" || cmd == "mset" || cmd == "mdelete":
+			if cmd == "stats" && res.Response == "" && line != RespEnd && !strings.HasPrefix(line, "STAT ") {
+				res.Response = line // e.g. RESET
+				return res, nil
+			}
+			if res.Response != "" {
+				res.Response += "\r\n"
+			}
+			res.Response += line
+			if line == RespEnd {
+				return res, nil
+			}
+		default:
+			if !isErrorLine(line) && !validResponseLine(cmd, line) {
+				return nil, ErrInvalidResponse
+			}
+			res.Response = line
+			return res, nil
+		}
+	}
+}
+
+// parseValueLine parses VALUE <key> <flags> <bytes> [<cas unique>] and returns the value without data
+// and its length. cas is required for gets.
+func parseValueLine(line string, cas bool) (Value, int, error) {
+	fields := strings.Split(line, " ")
+	if len(fields) < 4 || len(fields) > 5 || fields[0] != "VALUE" || cas != (len(fields) == 5) {
+		return Value{}, 0, ErrInvalidResponse
+	}
+	v := Value{Key: fields[1], Flags: fields[2]}
+	if !validKey(v.Key) {
+		return Value{}, 0, ErrInvalidResponse
+	}
+	if _, err := parseFlags(v.Flags); err != nil {
+		return Value{}, 0, ErrInvalidResponse
+	}
+	n, err := strconv.Atoi(fields[3])
+	if err != nil || n < 0 {
+		return Value{}, 0, ErrInvalidResponse
+	}
+	if cas {
+		if _, err := strconv.ParseUint(fields[4], 10, 64); err != nil {
+			return Value{}, 0, ErrInvalidResponse
+		}
+		v.Cas = fields[4]
+	}
+	return v, n, nil
+}
+
+// isErrorLine returns whether line is an ERROR, CLIENT_ERROR or SERVER_ERROR response.
+func isErrorLine(line string) bool {
+	return parseErrorLine(line) != nil
+}
+
+// Err returns the error of an ERROR, CLIENT_ERROR or SERVER_ERROR response as a *ResponseError,
+// or nil for other responses.
+func (r *Response) Err() error {
+	if e := parseErrorLine(r.Response); e != nil {
+		return e
+	}
+	return nil
+}
+
+// IsError returns whether the response is an ERROR, CLIENT_ERROR or SERVER_ERROR line.
+func (r *Response) IsError() bool {
+	return isErrorLine(r.Response)
+}
+
+// IsClientError returns whether the response is a CLIENT_ERROR line.
+func (r *Response) IsClientError() bool {
+	e := parseErrorLine(r.Response)
+	return e != nil && e.Kind == ClientError
+}
+
+// IsMiss returns whether the response is NOT_FOUND, or END without values, the miss of get and gets.
+func (r *Response) IsMiss() bool {
+	return r.Response == RespNotFound || r.Response == RespEnd && len(r.Values) == 0
+}
+
+// batchError returns whether the first line of a mset or mdelete response fails the whole command,
+// rather than being the result of its first item. Items fail with SERVER_ERROR, or ERROR if their
+// command is not implemented, which names the command of the item.
+func batchError(cmd, line string) bool {
+	return line == "ERROR" || strings.HasPrefix(line, RespClientErr) || strings.HasPrefix(line, RespErr+cmd+" ")
+}
+
+// validResponseLine returns whether line is a successful single line response of a cmd request.
+func validResponseLine(cmd, line string) bool {
+	switch cmd {
+	case "set", "add", "replace", "append", "prepend":
+		return line == RespStored || line == RespNotStored
+	case "cas":
+		return line == RespStored || line == RespExists || line == RespNotFound
+	case "delete":
+		return line == RespDeleted || line == RespNotFound
+	case "touch":
+		return line == RespTouched || line == RespNotFound
+	case "incr", "decr":
+		if line == RespNotFound {
+			return true
+		}
+		_, err := strconv.ParseUint(line, 10, 64)
+		return err == nil
+	case "flush_all":
+		return line == RespOK
+	case "version":
+		return strings.HasPrefix(line, "VERSION ")
+	default:
+		return true
+	}
+}
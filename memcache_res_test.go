@@ -1,6 +1,14 @@
 package mc
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
+	"math/rand"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -25,8 +33,8 @@ func TestRespEnd(t *testing.T) {
 
 func TestRespValueEnd(t *testing.T) {
 	res := Response{
-		"END",
-		[]Value{
+		Response: "END",
+		Values: []Value{
 			Value{"k1", "f1", []byte("123"), ""},
 		},
 	}
@@ -39,8 +47,8 @@ func TestRespValueEnd(t *testing.T) {
 
 func TestRespMultipleValue(t *testing.T) {
 	res := Response{
-		"END",
-		[]Value{
+		Response: "END",
+		Values: []Value{
 			Value{"k1", "f1", []byte("123"), ""},
 			Value{"k2", "f2", []byte("456"), ""},
 		},
@@ -51,3 +59,209 @@ func TestRespMultipleValue(t *testing.T) {
 		t.Errorf("%v", r)
 	}
 }
+
+func TestRespFlags(t *testing.T) {
+	v := NewValue("k1", 42, []byte("1"))
+	if flags, err := v.FlagsUint32(); err != nil || flags != 42 {
+		t.Errorf("Flags %d %v", flags, err)
+	}
+
+	res := Response{Response: "END", Values: []Value{{Key: "k1", Data: []byte("1")}}}
+	if r := res.String(); r != "VALUE k1 0 1\r\n1\r\nEND\r\n" {
+		t.Errorf("%v", r)
+	}
+}
+
+// randomResponse returns a random response of a cmd request.
+func randomResponse(rnd *rand.Rand, cmd string) *Response {
+	res := &Response{}
+	switch cmd {
+	case "get", "gets":
+		for n := rnd.Intn(5); n > 0; n-- {
+			data := make([]byte, rnd.Intn(100))
+			rnd.Read(data)
+			var cas uint64
+			if cmd == "gets" {
+				cas = 1 + uint64(rnd.Int63())
+			}
+			res.AddValue("key"+strconv.Itoa(rnd.Intn(1000)), rnd.Uint32(), data, cas)
+		}
+		res.End()
+	case "stats":
+		var stats []Stat
+		for n := rnd.Intn(5); n > 0; n-- {
+			stats = append(stats, Stat{"stat" + strconv.Itoa(n), strconv.Itoa(rnd.Int())})
+		}
+		res.Stats(stats)
+	case "incr":
+		res.Numeric(rnd.Uint64())
+	default:
+		lines := []string{RespStored, RespNotStored, RespExists, RespNotFound, RespDeleted, RespTouched, RespOK}
+		res.reply(lines[rnd.Intn(len(lines))])
+	}
+	if rnd.Intn(10) == 0 {
+		errs := []string{"ERROR", RespClientErr + "bad data chunk", RespServerErr + "out of memory"}
+		res = &Response{Response: errs[rnd.Intn(len(errs))]}
+	}
+	return res
+}
+
+func TestReadResponseRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	cmds := []string{"get", "gets", "stats", "incr", "mn"}
+	for i := 0; i < 10000; i++ {
+		cmd := cmds[rnd.Intn(len(cmds))]
+		res := randomResponse(rnd, cmd)
+		wire := res.String()
+		r := bufio.NewReader(strings.NewReader(wire))
+		got, err := ReadResponse(r, cmd)
+		if len(res.Values) == 0 {
+			res.Values = nil
+		}
+		if err != nil || !reflect.DeepEqual(got, res) {
+			t.Fatalf("%s: %q parsed to %+v (%v), expected %+v", cmd, wire, got, err, res)
+		}
+		if r.Buffered() != 0 {
+			t.Fatalf("%s: %q: %d bytes left", cmd, wire, r.Buffered())
+		}
+	}
+}
+
+func TestResponseBuffers(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		res := randomResponse(rnd, "gets")
+		if i%2 == 0 {
+			for j := range res.Values {
+				res.Values[j].Data = make([]byte, rnd.Intn(4*minVectoredData))
+				rnd.Read(res.Values[j].Data)
+			}
+		}
+		bufs := res.buffers()
+		var b []byte
+		for _, buf := range bufs {
+			b = append(b, buf...)
+		}
+		if string(b) != res.String() {
+			t.Fatalf("buffers %q differ from %q", b, res.String())
+		}
+	}
+}
+
+func TestMetaResponseBuffers(t *testing.T) {
+	for _, n := range []int{0, 10, 4 * minVectoredData} {
+		res := &Response{}
+		res.MetaValue(bytes.Repeat([]byte("x"), n), "f0")
+		var b []byte
+		for _, buf := range res.buffers() {
+			b = append(b, buf...)
+		}
+		want := "VA " + strconv.Itoa(n) + " f0\r\n" + strings.Repeat("x", n) + "\r\n"
+		if string(b) != want || res.String() != want {
+			t.Errorf("%d bytes: unexpected wire format %q", n, b)
+		}
+	}
+}
+
+func TestReadResponse(t *testing.T) {
+	for _, tt := range []struct {
+		cmd, wire string
+		res       *Response
+		err       error
+	}{
+		{"set", "STORED\r\n", &Response{Response: RespStored}, nil},
+		{"set", "DELETED\r\n", nil, ErrInvalidResponse},
+		{"incr", "12\r\n", &Response{Response: "12"}, nil},
+		{"incr", "-1\r\n", nil, ErrInvalidResponse},
+		{"delete", "SERVER_ERROR busy\r\n", &Response{Response: "SERVER_ERROR busy"}, nil},
+		{"version", "VERSION 1.6\r\n", &Response{Response: "VERSION 1.6"}, nil},
+		{"get", "VALUE k 0 1 5\r\nx\r\nEND\r\n", nil, ErrInvalidResponse},
+		{"gets", "VALUE k 0 1 5\r\nx\r\nEND\r\n", &Response{Response: RespEnd, Values: []Value{{"k", "0", []byte("x"), "5"}}}, nil},
+		{"get", "VALUE k 0 1\r\nxy\r\nEND\r\n", nil, NewError("expected \\r")},
+		{"mg", "VA 3 f0\r\nabc\r\n", &Response{Response: "VA 3 f0", Data: []byte("abc")}, nil},
+		{"mg", "EN\r\n", &Response{Response: "EN"}, nil},
+		{"stats", "RESET\r\n", &Response{Response: RespReset}, nil},
+		{"stats", "STAT pid 1\r\nSTAT uptime 2\r\nEND\r\n", &Response{Response: "STAT pid 1\r\nSTAT uptime 2\r\nEND"}, nil},
+		{"mset", "STORED\r\nSERVER_ERROR object too large for cache\r\nEND\r\n",
+			&Response{Response: "STORED\r\nSERVER_ERROR object too large for cache\r\nEND"}, nil},
+		{"mset", "SERVER_ERROR out of memory\r\nEND\r\n", &Response{Response: "SERVER_ERROR out of memory\r\nEND"}, nil},
+		{"mset", "ERROR mset not implemented'\r\n", &Response{Response: "ERROR mset not implemented'"}, nil},
+		{"mdelete", "CLIENT_ERROR bad command line format\r\n", &Response{Response: "CLIENT_ERROR bad command line format"}, nil},
+	} {
+		res, err := ReadResponse(bufio.NewReader(strings.NewReader(tt.wire)), tt.cmd)
+		if !reflect.DeepEqual(res, tt.res) || err != tt.err {
+			t.Errorf("%s %q: got %+v (%v), expected %+v (%v)", tt.cmd, tt.wire, res, err, tt.res, tt.err)
+		}
+	}
+}
+
+func TestReadResponseFromServer(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+	RegisterStore(s, NewMemoryStore(MemoryStoreOptions{}))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+
+	for _, tt := range []struct {
+		req  *Request
+		want string
+	}{
+		{&Request{Command: "set", Key: "k", Flags: "3", Data: []byte("1\r\n2")}, "STORED\r\n"},
+		{&Request{Command: "gets", Keys: []string{"k", "missing"}}, "VALUE k 3 4 "},
+		{&Request{Command: "incr", Key: "k", Value: 1}, "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"},
+		{&Request{Command: "delete", Key: "k"}, "DELETED\r\n"},
+		{&Request{Command: "touch", Key: "k"}, "NOT_FOUND\r\n"},
+		{&Request{Command: "stats"}, "STAT "},
+		{&Request{Command: "flush_all"}, "OK\r\n"},
+	} {
+		if err := WriteRequest(w, tt.req); err != nil {
+			t.Fatalf("WriteRequest %+v: %v", tt.req, err)
+		}
+		w.Flush()
+		res, err := ReadResponse(r, tt.req.Command)
+		if err != nil || !strings.HasPrefix(res.String(), tt.want) {
+			t.Errorf("%s: got %+v (%v), expected %q", tt.req.Command, res, err, tt.want)
+		}
+	}
+}
+
+func TestResponseErrors(t *testing.T) {
+	for _, tt := range []struct {
+		res                     Response
+		isError, isClient, miss bool
+		err                     error
+	}{
+		{Response{Response: RespStored}, false, false, false, nil},
+		{Response{Response: RespEnd}, false, false, true, nil},
+		{Response{Response: RespEnd, Values: []Value{NewValue("k", 0, nil)}}, false, false, false, nil},
+		{Response{Response: RespNotFound}, false, false, true, nil},
+		{Response{Response: "ERROR"}, true, false, false, &ResponseError{Kind: GenericError}},
+		{Response{Response: "ERROR mg not implemented'"}, true, false, false, &ResponseError{GenericError, "mg not implemented'"}},
+		{Response{Response: "CLIENT_ERROR bad data chunk"}, true, true, false, &ResponseError{ClientError, "bad data chunk"}},
+		{Response{Response: "SERVER_ERROR out of memory"}, true, false, false, &ResponseError{ServerError, "out of memory"}},
+	} {
+		res := tt.res
+		if res.IsError() != tt.isError || res.IsClientError() != tt.isClient || res.IsMiss() != tt.miss {
+			t.Errorf("%q: IsError %v, IsClientError %v, IsMiss %v", res.Response, res.IsError(), res.IsClientError(), res.IsMiss())
+		}
+		if err := res.Err(); !reflect.DeepEqual(err, tt.err) {
+			t.Errorf("%q: got error %#v, expected %#v", res.Response, err, tt.err)
+		} else if err != nil && err.Error() != res.Response {
+			t.Errorf("%q: error line %q", res.Response, err.Error())
+		}
+	}
+
+	res := Response{Response: "SERVER_ERROR object too large for cache"}
+	if err := res.Err(); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("%v is not ErrTooLarge", err)
+	}
+	res = Response{Response: "CLIENT_ERROR cannot increment or decrement non-numeric value"}
+	if err := res.Err(); !errors.Is(err, ErrNonNumeric) {
+		t.Errorf("%v is not ErrNonNumeric", err)
+	}
+}
@@ -3,12 +3,13 @@ package mc
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
-	"net/url"
 	"runtime/debug"
-	"strings"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -30,6 +31,7 @@ var (
 	RespDeleted   = "DELETED"
 	RespTouched   = "TOUCHED"
 	RespNotFound  = "NOT_FOUND"
+	RespReset     = "RESET"
 	RespErr       = "ERROR "
 	RespClientErr = "CLIENT_ERROR "
 	RespServerErr = "SERVER_ERROR "
@@ -39,24 +41,176 @@ var (
 type RemoteConnKey struct{}
 
 // HandlerFunc is a function to handle a request and returns a response.
+// Handlers and middlewares must not modify the request, which is shared with the server and
+// other middlewares, unless the server copies requests, see Server.CopyRequests and Request.Clone.
 type HandlerFunc func(ctx context.Context, req *Request, res *Response) error
 
 // Server implements memcached server.
 type Server struct {
-	addr    string
-	ln      net.Listener
-	methods map[string]HandlerFunc // should init this map before working
-	clients sync.Map
-
-	stopped int32
+	// counters are first so they are 64-bit aligned for atomic access on 32-bit platforms
+	counters serverCounters
+	conns    int64 // number of clients, accessed atomically
+
+	// KeepRawRequest keeps the raw bytes of requests in Request.Raw so that proxy handlers
+	// can forward requests to upstreams verbatim. It must be set before Start.
+	KeepRawRequest bool
+	// EnableBatchCommands enables the mset and mdelete extension commands.
+	// Batches are passed to the handlers of "mset" and "mdelete" if they are registered,
+	// otherwise every item is passed to the handler of "set" or "delete".
+	// It must be set before Start.
+	EnableBatchCommands bool
+	// RequestTimeout returns the timeout hint of a request sent by the client, or 0 if there is none.
+	// Handlers get a context with the deadline of the hint, so deadlines propagate through
+	// the cache layer. See MetaTimeoutFlag. It must be set before Start.
+	RequestTimeout func(req *Request) time.Duration
+	// MaxPendingResponses enables pipelining: responses of requests which are already buffered
+	// are kept in the write buffer, up to this number, and flushed together. When the limit is
+	// reached, or the write buffer is full, the connection stops reading until the responses are
+	// written, so slow readers are throttled by TCP flow control instead of growing buffers.
+	// 0 flushes every response at once. It must be set before Start.
+	MaxPendingResponses int
+	// OnAccept is called with every accepted connection before it is served. The connection is
+	// closed if it returns false, so it can implement firewalls or per-source throttling.
+	// It runs in the accept loop and must not block. It must be set before Start.
+	OnAccept func(conn net.Conn) bool
+	// MaxLineLength is the max length of command lines, excluding data blocks. Connections
+	// which send longer lines are replied CLIENT_ERROR line too long and closed, like memcached.
+	// 0 means DefaultMaxLineLength. It must be set before Start.
+	MaxLineLength int
+	// ReadTimeout limits the time to read the rest of a request, including its data block, once
+	// its first byte arrives, so clients which stall in the middle of requests don't hold
+	// connections forever. Such connections are replied CLIENT_ERROR and closed. It doesn't
+	// limit how long connections wait for requests. 0 means no limit. It must be set before Start.
+	ReadTimeout time.Duration
+	// Clock tells the time of tap events. Default is SystemClock. Stores have their own clocks,
+	// see MemoryStoreOptions.Clock. It must be set before Start.
+	Clock Clock
+	// ZeroCopy lets Data of storage requests alias the read buffer of the connection instead of
+	// a copy, for data blocks which fit in it, see ReaderBuffsize. Such Data is valid only until the
+	// handler returns, so it suits handlers which forward data at once, like proxies; handlers
+	// which keep Data must copy it, as the handlers of RegisterStore do. It doesn't apply to
+	// requests kept raw by KeepRawRequest, batches or AsyncRequests. It must be set before Start.
+	ZeroCopy bool
+	// AsyncRequests handles up to this number of requests of each connection concurrently, so
+	// pipelined requests don't wait for slow handlers of the requests before, like lookups in
+	// remote stores. Responses are still written in the order of the requests. Requests of a
+	// connection don't see the effects of the concurrent ones before then, so enable it only for
+	// handlers of independent requests. MaxPendingResponses doesn't apply, responses
+	// which are ready are flushed together. 0 handles requests one by one. It must be set before Start.
+	AsyncRequests int
+	// MaxAsyncHandlers limits the handlers of AsyncRequests of all connections which run at a time.
+	// When the limit is reached, waiting Foreground requests start before Background ones, while
+	// a Background request starts after every few Foreground ones so it isn't starved. See
+	// RequestPriority. 0 means no limit. It must be set before Start.
+	MaxAsyncHandlers int
+	// RequestPriority returns the priority of a request for MaxAsyncHandlers, e.g. MetaPriorityFlag.
+	// If it is nil, requests have the priority of their connection, see ConnPriority.
+	// It must be set before Start.
+	RequestPriority func(ctx context.Context, req *Request) Priority
+	// ErrorHandler is called with the error which stops serving a listener of the server or of a
+	// virtual server after Start returned, e.g. when accepting connections fails permanently.
+	// It is not called for listeners closed by Stop or Shutdown. Run returns such errors too.
+	// It must be set before Start.
+	ErrorHandler func(err error)
+	// ShutdownTimeout limits how long Run waits for the connections to finish their requests when
+	// it shuts the server down. 0 means no limit. It must be set before Run.
+	ShutdownTimeout time.Duration
+	// CopyRequests passes a Clone of every request to handlers, so handlers which modify requests
+	// don't change what taps and logs see. It must be set before Start.
+	CopyRequests bool
+	// MaxProtocolErrors closes connections with more malformed requests than this number in
+	// ProtocolErrorWindow, like port scanners or clients of other protocols, after replying the
+	// last error. 0 means no limit. It must be set before Start.
+	MaxProtocolErrors int
+	// Silent closes connections of the listener of the server at their first protocol error
+	// without replying CLIENT_ERROR, so scanners of servers exposed to the internet get no
+	// response to garbage. Virtual servers have their own setting, see VirtualServer.Silent.
+	// It must be set before Start.
+	Silent bool
+	// ProtocolErrorWindow is the period of MaxProtocolErrors. Default is DefaultProtocolErrorWindow.
+	ProtocolErrorWindow time.Duration
+	// Flags toggles experimental features when the server starts, overriding their fields, so they
+	// can be tried without code changes. Like GODEBUG, it is a comma-separated list of name=value:
+	// zerocopy=0|1 sets ZeroCopy, async=<n> sets AsyncRequests and batch=0|1 sets
+	// EnableBatchCommands. Unknown names are ignored. If it is empty, FlagsEnv is read.
+	// It must be set before Start.
+	Flags string
+	// CancelOnDisconnect cancels the contexts of requests when their clients close the connection,
+	// so long-running handlers, like scans or large multi-gets, can stop early. Requests are
+	// served while the connection is read in the background, which costs a goroutine per request,
+	// unless the next requests are already buffered. Clients which close their side of the
+	// connection while they wait for responses cancel them too. It must be set before Start.
+	CancelOnDisconnect bool
+	// MaxConnBandwidth limits the bytes per second written to each connection, with bursts of up
+	// to one second, so clients of huge multi-gets don't saturate the network for the others.
+	// Responses of throttled connections are written in chunks rather than by vectored writes.
+	// 0 means no limit. It must be set before Start.
+	MaxConnBandwidth int64
+	// MaxClientBandwidth is like MaxConnBandwidth for all connections of each client IP together.
+	// It must be set before Start.
+	MaxClientBandwidth int64
+	// OnRequest is called with every request after it is parsed and before it is dispatched to its
+	// handler, unlike middlewares, which wrap handlers. It can modify the request, e.g. to rewrite
+	// keys or inject flags, which handlers, taps and logs see then. It returns false to reply res
+	// instead of calling the handler, e.g. to block some keys by res.ClientError. It is called
+	// concurrently for AsyncRequests. It must be set before Start.
+	OnRequest func(ctx context.Context, req *Request, res *Response) bool
+	// StrictRegistration makes RegisterFunc of the server and its virtual servers fail with
+	// ErrDuplicateHandler for commands which have a handler, instead of replacing it, so handlers
+	// registered twice by mistake are detected, e.g. by RegisterStore after a custom get handler.
+	// Call UnregisterFunc first to replace a handler. It must be set before registering handlers.
+	StrictRegistration bool
+	// MaxResponseSize limits the size of the values of a response, like a multi-get of many large
+	// values or the data block of a meta response, so a single request can't make the server buffer
+	// hundreds of MB. Responses over the limit are replied SERVER_ERROR, or truncated if
+	// TruncateResponses is set.
+	// Values written to a StreamWriter are exempt, since they are sent as they are written rather
+	// than buffered. 0 means no limit. It must be set before Start.
+	MaxResponseSize int
+	// TruncateResponses replies only the values which fit in MaxResponseSize, followed by END as
+	// if the other keys were missing, instead of SERVER_ERROR. Meta values which don't fit are
+	// replied EN like misses. It must be set before Start.
+	TruncateResponses bool
+
+	addr     string
+	ln       net.Listener
+	clients  sync.Map      // net.Conn -> *connState
+	connGone chan struct{} // signaled when a connection is closed
+
+	root     *handlers
+	mu       sync.Mutex // guards virtuals and hooks
+	virtuals []*VirtualServer
+	onStart  []func() error
+	onStop   []func()
+
+	taps     sync.Map // *Tap -> struct{}
+	tapCount int32
+	recorder atomic.Value // *Recorder
+	redactor atomic.Value // redactorHolder
+	metrics  atomic.Value // metricsHolder
+
+	verbosity int32 // see SetVerbosity
+	stopped   int32
+	failOnce  sync.Once
+	failed    chan struct{} // closed when serving fails
+	failErr   error
+
+	schedOnce sync.Once
+	sched     *scheduler // see MaxAsyncHandlers
+
+	bwMu      sync.Mutex
+	bwClients map[string]*clientLimiter // see MaxClientBandwidth
 }
 
 // NewServer creates a memcached server.
 func NewServer(addr string) *Server {
-	return &Server{
-		addr:    addr,
-		methods: make(map[string]HandlerFunc),
+	s := &Server{
+		addr:     addr,
+		root:     newHandlers(),
+		failed:   make(chan struct{}),
+		connGone: make(chan struct{}, 1),
 	}
+	return s
 }
 
 // Start starts the memcached server in a goroutine.
@@ -64,37 +218,136 @@ func NewServer(addr string) *Server {
 // requests on incoming connections. Accepted connections are configured to enable
 // TCP keep-alives when they are TCP network connections.
 func (s *Server) Start() error {
-	var err error
+	if err := s.applyFlags(); err != nil {
+		return err
+	}
 
-	if strings.Contains(s.addr, "://") {
-		var u *url.URL
-		u, err = url.Parse(s.addr)
-		if err != nil {
-			return err
-		}
+	inherited, err := inheritedListeners()
+	if err != nil {
+		return err
+	}
+	if len(inherited) > 0 {
+		s.ln, inherited = inherited[0], inherited[1:]
+	} else if s.ln, err = listen(s.addr); err != nil {
+		return err
+	}
 
-		switch u.Scheme {
-		case "unix":
-			s.ln, err = net.Listen("unix", u.Path)
-		default:
-			s.ln, err = net.Listen("tcp", u.Host)
-		}
-	} else {
-		s.ln, err = net.Listen("tcp", s.addr)
+	if err := s.startVirtuals(inherited); err != nil {
+		s.ln.Close()
+		return err
+	}
+	if err := s.runStartHooks(); err != nil {
+		s.ln.Close()
+		s.closeVirtuals()
+		return err
 	}
 
-	if err != nil {
+	log.Printf("memcached server starts on %s", s.ln.Addr())
+	go s.serveListener(s.ln, s.root, s.Silent)
+	return nil
+}
+
+// Addr returns the address the server listens on, e.g. the port chosen for ":0", or nil if it
+// has not started.
+func (s *Server) Addr() net.Addr {
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+// Run starts the server and blocks until ctx is done or serving fails, e.g. because accepting
+// connections fails, and then shuts the server down gracefully, see Shutdown and ShutdownTimeout.
+// It returns the error of serving or shutting down, so it composes with errgroup and similar
+// run groups, which cancel ctx to stop it.
+func (s *Server) Run(ctx context.Context) error {
+	if err := s.Start(); err != nil {
 		return err
 	}
 
-	log.Printf("memcached server starts on %s", s.addr)
-	go s.Serve(s.ln)
+	var err error
+	select {
+	case <-ctx.Done():
+	case <-s.failed:
+		err = s.failErr
+	}
+
+	shutdownCtx := context.Background()
+	if s.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, s.ShutdownTimeout)
+		defer cancel()
+	}
+	if serr := s.Shutdown(shutdownCtx); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// OnStart registers fn to be called by Start after the listeners are open and before connections
+// are accepted, e.g. to warm the store or register with discovery. Functions are called in the
+// order of registration, and Start fails with the error of the first one which fails.
+func (s *Server) OnStart(fn func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onStart = append(s.onStart, fn)
+}
+
+// OnStop registers fn to be called by Stop and Shutdown after the listeners are closed and the
+// connections are done, before they return. Functions are called in the reverse order of registration,
+// like deferred calls, so resources are released before the ones they depend on.
+func (s *Server) OnStop(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onStop = append(s.onStop, fn)
+}
+
+// runStartHooks calls the OnStart functions.
+func (s *Server) runStartHooks() error {
+	s.mu.Lock()
+	hooks := s.onStart
+	s.mu.Unlock()
+	for _, fn := range hooks {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// runStopHooks calls the OnStop functions.
+func (s *Server) runStopHooks() {
+	s.mu.Lock()
+	hooks := s.onStop
+	s.mu.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+}
+
 // Serve accepts incoming connections on the Listener ln, creating a new service goroutine for each.
 // The service goroutines read requests and then call registered handlers to reply to them.
 func (s *Server) Serve(ln net.Listener) error {
+	return s.serve(ln, s.root, s.Silent)
+}
+
+// serveListener serves ln with handlers h and reports the error which stops it to ErrorHandler
+// and Run, unless the server is stopping.
+func (s *Server) serveListener(ln net.Listener, h *handlers, silent bool) {
+	err := s.serve(ln, h, silent)
+	if err != nil && atomic.LoadInt32(&s.stopped) == 0 {
+		if s.ErrorHandler != nil {
+			s.ErrorHandler(err)
+		}
+		s.failOnce.Do(func() {
+			s.failErr = err
+			close(s.failed)
+		})
+	}
+}
+
+// serve serves connections of ln with handlers h.
+func (s *Server) serve(ln net.Listener, h *handlers, silent bool) error {
 	defer ln.Close()
 
 	var tempDelay time.Duration // how long to sleep on accept failure
@@ -110,6 +363,7 @@ func (s *Server) Serve(ln net.Listener) error {
 				if max := 1 * time.Second; tempDelay > max {
 					tempDelay = max
 				}
+				atomic.AddUint64(&s.counters.acceptErrors, 1)
 				log.Printf("accept error: %v; retrying in %v", err, tempDelay)
 				time.Sleep(tempDelay)
 				continue
@@ -123,77 +377,433 @@ func (s *Server) Serve(ln net.Listener) error {
 			conn.Close()
 			return nil
 		}
+		atomic.AddUint64(&s.counters.accepted, 1)
+		if s.OnAccept != nil && !s.OnAccept(conn) {
+			atomic.AddUint64(&s.counters.rejected, 1)
+			conn.Close()
+			continue
+		}
 
 		if tc, ok := conn.(*net.TCPConn); ok {
 			tc.SetNoDelay(true)
 			tc.SetKeepAlive(true)
 		}
+		conn = s.wrapConn(conn)
 
-		s.clients.Store(conn, struct{}{})
+		st := &connState{silent: silent, done: make(chan struct{})}
+		atomic.AddInt64(&s.conns, 1)
+		s.clients.Store(conn, st)
 
-		go s.handleConn(conn)
+		go s.handleConn(conn, st, h)
 	}
 }
 
 // RegisterFunc registers a handler to handle this command.
+// Commands unknown to the parser, like extension commands, are parsed as requests
+// which have only Command, Key (the first argument) and Keys (all arguments) set.
+// It is safe to call it while the server is running and it replaces the existing handler of cmd,
+// unless StrictRegistration is set.
 func (s *Server) RegisterFunc(cmd string, fn HandlerFunc) error {
-	s.methods[cmd] = fn
-	return nil
+	return s.root.register(cmd, fn, s.StrictRegistration)
+}
+
+// UnregisterFunc removes the handler of this command.
+// It is safe to call it while the server is running.
+func (s *Server) UnregisterFunc(cmd string) {
+	s.root.unregister(cmd)
+}
+
+// SetDefaultHandler sets a handler which handles commands that have no registered handler,
+// including commands unknown to the parser.
+// Set it to nil to reply "ERROR" for such commands again.
+func (s *Server) SetDefaultHandler(fn HandlerFunc) {
+	s.root.setDefault(fn)
+}
+
+// handlers is a set of handlers of commands, which is safe for concurrent use.
+type handlers struct {
+	mu       sync.Mutex   // serializes writers of methods
+	methods  atomic.Value // map[string]HandlerFunc, copied on write
+	fallback atomic.Value // HandlerFunc for commands without handlers
+}
+
+func newHandlers() *handlers {
+	h := &handlers{}
+	h.methods.Store(make(map[string]HandlerFunc))
+	return h
+}
+
+// register sets the handler of cmd. If strict is set, it fails for commands which have one.
+func (h *handlers) register(cmd string, fn HandlerFunc, strict bool) error {
+	var err error
+	h.update(func(m map[string]HandlerFunc) {
+		if _, ok := m[cmd]; ok && strict {
+			err = ErrDuplicateHandler
+			return
+		}
+		m[cmd] = fn
+	})
+	return err
+}
+
+// unregister removes the handler of cmd.
+func (h *handlers) unregister(cmd string) {
+	h.update(func(m map[string]HandlerFunc) {
+		delete(m, cmd)
+	})
+}
+
+// update copies the handler map, applies fn to the copy and publishes it.
+func (h *handlers) update(fn func(m map[string]HandlerFunc)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	old := h.methods.Load().(map[string]HandlerFunc)
+	m := make(map[string]HandlerFunc, len(old)+1)
+	for k, v := range old {
+		m[k] = v
+	}
+	fn(m)
+	h.methods.Store(m)
+}
+
+func (h *handlers) setDefault(fn HandlerFunc) {
+	h.fallback.Store(fn)
+}
+
+// defaultHandler returns the handler set by setDefault.
+func (h *handlers) defaultHandler() HandlerFunc {
+	fn, _ := h.fallback.Load().(HandlerFunc)
+	return fn
 }
 
-func (s *Server) handleConn(conn net.Conn) {
+// names returns the sorted commands of the registered handlers.
+func (h *handlers) names() []string {
+	m := h.methods.Load().(map[string]HandlerFunc)
+	cmds := make([]string, 0, len(m))
+	for cmd := range m {
+		cmds = append(cmds, cmd)
+	}
+	sort.Strings(cmds)
+	return cmds
+}
+
+// registered returns whether a handler is registered for this command.
+func (h *handlers) registered(cmd string) bool {
+	_, ok := h.methods.Load().(map[string]HandlerFunc)[cmd]
+	return ok
+}
+
+// has returns whether this command has a registered handler or the default handler.
+func (h *handlers) has(cmd string) bool {
+	_, ok := h.handler(cmd)
+	return ok
+}
+
+// handler returns the handler of this command.
+func (h *handlers) handler(cmd string) (HandlerFunc, bool) {
+	fn, ok := h.methods.Load().(map[string]HandlerFunc)[cmd]
+	if !ok {
+		fn = h.defaultHandler()
+		ok = fn != nil
+	}
+	return fn, ok
+}
+
+// connState is the state of a connection.
+type connState struct {
+	active    int32  // 1 if a request is being handled
+	verbosity int32  // see SetConnVerbosity
+	protoErrs uint64 // accessed atomically
+	silent    bool   // see Server.Silent
+	// sched schedules the asynchronous requests of the connection, see Server.MaxAsyncHandlers
+	sched *scheduler
+	// done is closed when the connection is closed, to stop throttled writes waiting
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// protocol errors in the current ProtocolErrorWindow, used only by the connection
+	errWindowStart time.Time
+	errWindowCount int
+}
+
+// close closes the done channel of the connection.
+func (st *connState) close() {
+	st.closeOnce.Do(func() { close(st.done) })
+}
+
+func (s *Server) handleConn(conn net.Conn, st *connState, h *handlers) {
+	dst, release := s.connWriter(conn, st.done)
+	defer release()
+	w := bufio.NewWriterSize(dst, WriterBuffsize)
+	var seq *sequencer
+	if s.AsyncRequests > 0 {
+		seq = newSequencer(conn, dst, w, s.AsyncRequests)
+		st.sched = s.getScheduler()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	defer func() {
 		if err := recover(); err != nil {
 			fmt.Printf("memcached server panic error: %s, stack: %s", err, string(debug.Stack()))
 		}
+		st.close()
+		// asynchronous requests of closed connections are canceled before they are waited for
+		if s.CancelOnDisconnect {
+			cancel()
+		}
+		if seq != nil {
+			seq.close()
+		}
 		s.clients.Delete(conn)
 		conn.Close()
+		atomic.AddInt64(&s.conns, -1)
+		cancel()
+		select {
+		case s.connGone <- struct{}{}:
+		default:
+		}
 	}()
 
+	var cr *connReader
 	r := bufio.NewReaderSize(conn, ReaderBuffsize)
-	w := bufio.NewWriterSize(conn, WriterBuffsize)
+	if s.CancelOnDisconnect && seq == nil {
+		cr = &connReader{conn: conn}
+		r = bufio.NewReaderSize(cr, ReaderBuffsize)
+	}
 
-	ctx := context.Background()
 	ctx = context.WithValue(ctx, RemoteConnKey{}, conn)
+	ctx = NewSessionContext(ctx)
+	if seq == nil {
+		ctx = context.WithValue(ctx, streamKey{}, &StreamWriter{w: w})
+	}
+
+	// verbosity is built in unless a handler of it is registered
+	generic := func(cmd string) bool { return cmd == "verbosity" || h.has(cmd) }
 
+	// reply writes a response of the server itself, after the responses of the requests before
+	reply := func(out string) {
+		if seq != nil {
+			seq.reply(out)
+			return
+		}
+		w.WriteString(out)
+		w.Flush()
+	}
+
+	pending := 0
+	first := true
 	for atomic.LoadInt32(&s.stopped) == 0 {
-		req, err := ReadRequest(r)
+		if pending > 0 && (r.Buffered() == 0 || pending >= s.MaxPendingResponses) {
+			if pending >= s.MaxPendingResponses {
+				atomic.AddUint64(&s.counters.pendingSaturated, 1)
+			}
+			if err := w.Flush(); err != nil {
+				log.Printf("failed to write responses to %s: %v", conn.RemoteAddr().String(), err)
+				return
+			}
+			pending = 0
+		}
+
+		atomic.StoreInt32(&st.active, 0)
+		if _, err := r.Peek(1); err != nil {
+			log.Printf("ReadRequest from %s err: %v", conn.RemoteAddr().String(), err)
+			return
+		}
+		if first {
+			first = false
+			if proto := detectProtocol(r); proto != "" {
+				atomic.AddUint64(&s.counters.mismatches, 1)
+				log.Printf("closing %s: it speaks %s rather than the memcached protocol", conn.RemoteAddr().String(), proto)
+				return
+			}
+		}
+		atomic.StoreInt32(&st.active, 1)
+
+		if s.ReadTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+		}
+		req, err := readRequest(r, readOptions{
+			generic: generic,
+			keepRaw: s.KeepRawRequest,
+			batch:   s.EnableBatchCommands,
+			maxLine: s.MaxLineLength,
+			// handlers of asynchronous requests run while the next requests are read
+			zeroCopy: s.ZeroCopy && seq == nil,
+		})
+		if s.ReadTimeout > 0 {
+			conn.SetReadDeadline(time.Time{})
+		}
+		var nerr net.Error
+		if errors.As(err, &nerr) && nerr.Timeout() && s.ReadTimeout > 0 {
+			atomic.AddUint64(&s.counters.readTimeouts, 1)
+			log.Printf("ReadRequest from %s timed out", conn.RemoteAddr().String())
+			if !st.silent {
+				reply(RespClientErr + "read timeout\r\n")
+			}
+			return
+		}
+		if err == ErrLineTooLong {
+			s.protocolError(st, ErrLineTooLong)
+			log.Printf("ReadRequest from %s err: %v", conn.RemoteAddr().String(), err)
+			if !st.silent {
+				reply(RespClientErr + "line too long\r\n")
+			}
+			return
+		}
 		if perr, ok := err.(Error); ok {
 			log.Printf("%v ReadRequest protocol err: %v", conn, err)
-			w.WriteString(RespClientErr + perr.Error() + "\r\n")
-			w.Flush()
+			tooMany := s.protocolError(st, perr)
+			if st.silent {
+				log.Printf("closing %s silently after a protocol error", conn.RemoteAddr().String())
+				return
+			}
+			if seq == nil {
+				w.WriteString(RespClientErr + perr.Error() + "\r\n")
+				w.Flush()
+				pending = 0
+			} else {
+				seq.reply(RespClientErr + perr.Error() + "\r\n")
+			}
+			if tooMany {
+				log.Printf("closing %s after too many protocol errors", conn.RemoteAddr().String())
+				return
+			}
 			continue
 		} else if err != nil {
 			log.Printf("ReadRequest from %s err: %v", conn.RemoteAddr().String(), err)
 			return
 		}
 
-		cmd := req.Command
-		if cmd == "quit" {
+		if req.Command == "quit" {
 			log.Printf("client send quit, closed")
 			return
 		}
 
-		res := &Response{}
-		fn, exists := s.methods[cmd]
-		if exists {
-			err := fn(ctx, req, res)
-			if err != nil {
-				log.Printf("ERROR: %v, Conn: %v, Req: %+v\n", err, conn, req)
-				res.Response = RespServerErr + err.Error()
+		if seq != nil {
+			seq.do(func() (net.Buffers, bool) {
+				return s.serveRequest(ctx, conn, st, h, req)
+			})
+			continue
+		}
+		var out net.Buffers
+		var ok bool
+		if cr != nil && r.Buffered() == 0 {
+			out, ok = s.serveCancelable(ctx, cr, conn, st, h, req)
+		} else {
+			out, ok = s.serveRequest(ctx, conn, st, h, req)
+		}
+		if ok {
+			if err := writeBuffers(dst, w, out); err != nil {
+				log.Printf("failed to write responses to %s: %v", conn.RemoteAddr().String(), err)
+				return
 			}
-			if !req.Noreply {
-				w.WriteString(res.String())
+			if s.MaxPendingResponses > 0 {
+				pending++
+			} else {
 				w.Flush()
 			}
-		} else {
-			res.Response = RespErr + cmd + " not implemented'"
-			w.WriteString(res.String())
-			w.Flush()
 		}
 	}
 }
 
+// serveRequest handles a request of conn by the handlers h and returns the response to write,
+// or false if there is none because of noreply.
+func (s *Server) serveRequest(ctx context.Context, conn net.Conn, st *connState, h *handlers, req *Request) (net.Buffers, bool) {
+	// the priority is taken in every mode, so flags of RequestPriority never reach handlers
+	prio := s.requestPriority(ctx, req)
+	if st.sched != nil {
+		st.sched.acquire(prio)
+		defer st.sched.release()
+	}
+	if req.Noreply && StreamFromContext(ctx) != nil {
+		// responses of noreply are not written, so they can't be streamed either
+		ctx = context.WithValue(ctx, streamKey{}, (*StreamWriter)(nil))
+	}
+	res := &Response{}
+	intercepted := s.OnRequest != nil && !s.OnRequest(ctx, req, res)
+	cmd := req.Command
+	fn, exists := h.handler(cmd)
+	if req.Batch != nil && !h.registered(cmd) {
+		fn, exists = h.serveBatch, true
+	}
+	if cmd == "version" && !h.registered(cmd) {
+		fn, exists = s.version, true
+	}
+	if cmd == "verbosity" && !h.registered(cmd) {
+		fn, exists = s.verbosityCmd, true
+	}
+	if intercepted {
+		atomic.AddUint64(&s.counters.intercepted, 1)
+		exists = true // the response of OnRequest is replied like the one of a handler
+	} else if exists {
+		m := s.getMetrics()
+		var start time.Time
+		if m != nil {
+			start = time.Now()
+		}
+		if err := s.call(ctx, fn, req, res); err != nil && !setError(req, res, err) {
+			log.Printf("ERROR: %v, Conn: %v, Req: %s\n", err, conn, FormatRequest(s.getRedactor(), req))
+		}
+		if m != nil {
+			m.ObserveLatency(cmd, time.Since(start))
+		}
+	} else {
+		res.Response = RespErr + cmd + " not implemented'"
+	}
+	s.limitResponse(res)
+	s.publishTaps(conn.RemoteAddr(), req, res)
+	s.logExchange(conn, st, req, res)
+
+	wasStreamed := streamed(ctx)
+	if exists && req.Noreply {
+		return nil, false
+	}
+	if wasStreamed {
+		// the values have been written by the handler, only the terminator is left
+		if res.Response == "" {
+			return nil, false
+		}
+		return net.Buffers{[]byte(res.Response + "\r\n")}, true
+	}
+	return res.buffers(), true
+}
+
+// writeBuffers writes a response to w. Responses larger than the buffer of w, like multi-gets of
+// large values, are written to dst, the writer of w, by a vectored write after flushing w instead
+// of being copied through w.
+func writeBuffers(dst io.Writer, w *bufio.Writer, bufs net.Buffers) error {
+	n := 0
+	for _, b := range bufs {
+		n += len(b)
+	}
+	if n <= w.Size() || len(bufs) == 1 {
+		for _, b := range bufs {
+			w.Write(b)
+		}
+		return nil
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := bufs.WriteTo(dst)
+	return err
+}
+
+// call calls the handler with the deadline of the request's timeout hint.
+func (s *Server) call(ctx context.Context, fn HandlerFunc, req *Request, res *Response) error {
+	if s.RequestTimeout != nil {
+		if d := s.RequestTimeout(req); d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+	}
+	if s.CopyRequests {
+		req = req.Clone()
+	}
+	return fn(ctx, req, res)
+}
+
 // Stop stops this memcached sever.
 func (s *Server) Stop() error {
 	var err error
@@ -209,6 +819,7 @@ func (s *Server) Stop() error {
 	if err = s.ln.Close(); err != nil {
 		fmt.Printf("failed to close listener: %v", err)
 	}
+	s.closeVirtuals()
 
 	//Make on processing commamd to run over
 	time.Sleep(200 * time.Millisecond)
@@ -219,29 +830,107 @@ func (s *Server) Stop() error {
 	// 	time.Sleep(time.Millisecond)
 	// }
 
-	checkStart := time.Now()
+	// wait at most 1 second
+	s.waitConns(time.Second)
+
+	s.runStopHooks()
+	fmt.Println("memcached server stop")
+	return err
+}
+
+// Shutdown gracefully stops this memcached server.
+// It closes the listener, lets connections finish the requests in progress and closes idle connections.
+// Connections which are still busy are closed when ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&s.stopped, 0, 1) {
+		return nil
+	}
+
+	var err error
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	s.closeVirtuals()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
 	for {
-		found := false
 		s.clients.Range(func(k, v interface{}) bool {
-			found = true
-			return false
+			if atomic.LoadInt32(&v.(*connState).active) == 0 {
+				// wakes up the connection blocked on reading
+				k.(net.Conn).SetReadDeadline(time.Now())
+			}
+			return true
 		})
-		if found {
-			time.Sleep(10 * time.Millisecond)
+		if s.ClientCount() == 0 {
+			s.runStopHooks()
+			return err
 		}
-		// wait at most 1 second
-		if time.Since(checkStart).Seconds() > 1 {
-			break
+
+		select {
+		case <-ctx.Done():
+			s.drainConn()
+			s.runStopHooks()
+			return ctx.Err()
+		case <-ticker.C:
 		}
 	}
+}
 
-	fmt.Println("memcached server stop")
-	return err
+// waitConns waits until all connections are closed, at most timeout.
+func (s *Server) waitConns(timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for s.ClientCount() > 0 {
+		select {
+		case <-s.connGone:
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// ClientCount returns the number of open connections.
+func (s *Server) ClientCount() int {
+	return int(atomic.LoadInt64(&s.conns))
+}
+
+// ConnInfo describes an open connection.
+type ConnInfo struct {
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+	// Active is whether a request of the connection is being handled.
+	Active bool
+	// Verbosity is the level set by SetConnVerbosity.
+	Verbosity int
+	// ProtocolErrors is the number of malformed requests of the connection.
+	ProtocolErrors uint64
+}
+
+// Connections returns a snapshot of the open connections, sorted by remote address.
+func (s *Server) Connections() []ConnInfo {
+	conns := make([]ConnInfo, 0, s.ClientCount())
+	s.clients.Range(func(k, v interface{}) bool {
+		conn := k.(net.Conn)
+		conns = append(conns, ConnInfo{
+			RemoteAddr:     conn.RemoteAddr(),
+			LocalAddr:      conn.LocalAddr(),
+			Active:         atomic.LoadInt32(&v.(*connState).active) != 0,
+			Verbosity:      int(atomic.LoadInt32(&v.(*connState).verbosity)),
+			ProtocolErrors: atomic.LoadUint64(&v.(*connState).protoErrs),
+		})
+		return true
+	})
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].RemoteAddr.String() < conns[j].RemoteAddr.String()
+	})
+	return conns
 }
 
 // close connection of clients.
 func (s *Server) drainConn() {
 	s.clients.Range(func(k, v interface{}) bool {
+		v.(*connState).close()
 		k.(net.Conn).Close()
 		return true
 	})
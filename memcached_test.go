@@ -1,9 +1,14 @@
 package mc
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"io"
 	"net"
+	"reflect"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -99,12 +104,11 @@ var memStore sync.Map
 
 func DefaultGet(ctx context.Context, req *Request, res *Response) error {
 	for _, key := range req.Keys {
-		value, _ := memStore.Load(key)
-		res.Values = append(res.Values, Value{key, "0", value.([]byte), ""})
+		if value, exists := memStore.Load(key); exists {
+			res.AddValue(key, 0, value.([]byte), 0)
+		}
 	}
-
-	res.Response = RespEnd
-	return nil
+	return res.End()
 }
 
 func DefaultSet(ctx context.Context, req *Request, res *Response) error {
@@ -112,18 +116,15 @@ func DefaultSet(ctx context.Context, req *Request, res *Response) error {
 	value := req.Data
 	memStore.Store(key, value)
 
-	res.Response = RespStored
-	return nil
+	return res.Stored()
 }
 
 func DefaultDelete(ctx context.Context, req *Request, res *Response) error {
 	if _, exists := memStore.Load(req.Key); exists {
 		memStore.Delete(req.Key)
-		res.Response = RespDeleted
-	} else {
-		res.Response = RespNotFound
+		return res.Deleted()
 	}
-	return nil
+	return res.NotFound()
 }
 
 func DefaultIncr(ctx context.Context, req *Request, res *Response) error {
@@ -138,20 +139,395 @@ func DefaultIncr(ctx context.Context, req *Request, res *Response) error {
 		}
 	}
 
-	value := strconv.FormatUint(base+increment, 10)
-	memStore.Store(key, []byte(value))
+	value := base + increment
+	memStore.Store(key, []byte(strconv.FormatUint(value, 10)))
 
-	res.Response = value
-	return nil
+	return res.Numeric(value)
 }
 
 func DefaultFlushAll(ctx context.Context, req *Request, res *Response) error {
 	memStore = sync.Map{}
-	res.Response = RespOK
-	return nil
+	return res.OK()
 }
 
 func DefaultVersion(ctx context.Context, req *Request, res *Response) error {
-	res.Response = "VERSION 1"
-	return nil
+	return res.Version("1")
+}
+
+// startTestServer starts a server without handlers on a free port.
+func startTestServer(t *testing.T) (*Server, string) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("failed to get a free port: %v", err)
+	}
+	addr := "127.0.0.1:" + strconv.Itoa(port)
+	s := NewServer(addr)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	return s, addr
+}
+
+// roundTrip sends a raw request and reads one line of response.
+func roundTrip(t *testing.T, addr string, req string) string {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	return line
+}
+
+func TestRegisterAtRuntime(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	mc := memcache.New(addr)
+	if err := mc.Set(&memcache.Item{Key: "foo", Value: []byte("bar")}); err == nil {
+		t.Errorf("set should fail before registering")
+	}
+
+	s.RegisterFunc("set", DefaultSet)
+	if err := mc.Set(&memcache.Item{Key: "foo", Value: []byte("bar")}); err != nil {
+		t.Errorf("failed to set: %v", err)
+	}
+
+	s.UnregisterFunc("set")
+	if err := mc.Set(&memcache.Item{Key: "foo", Value: []byte("bar")}); err == nil {
+		t.Errorf("set should fail after unregistering")
+	}
+}
+
+func TestDefaultHandler(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	if line := roundTrip(t, addr, "get foo\r\n"); line != "ERROR get not implemented'\r\n" {
+		t.Errorf("unexpected response: %q", line)
+	}
+
+	s.SetDefaultHandler(func(ctx context.Context, req *Request, res *Response) error {
+		res.Response = "SERVER_ERROR no handler for " + req.Command + " " + strings.Join(req.Keys, ",")
+		return nil
+	})
+	if line := roundTrip(t, addr, "get foo\r\n"); line != "SERVER_ERROR no handler for get foo\r\n" {
+		t.Errorf("unexpected response: %q", line)
+	}
+	if line := roundTrip(t, addr, "mget a b\r\n"); line != "SERVER_ERROR no handler for mget a,b\r\n" {
+		t.Errorf("unexpected response: %q", line)
+	}
+}
+
+// TestPipelinedFraming checks the framing of pipelined text requests. The server implements only
+// the text protocol, so there are no binary or dual-protocol variants.
+func TestPipelinedFraming(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+	s.RegisterFunc("get", DefaultGet)
+	s.RegisterFunc("set", DefaultSet)
+	s.RegisterFunc("delete", DefaultDelete)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	// a value containing \r\n must not break framing
+	conn.Write([]byte("set pipe1 0 0 4\r\na\r\nb\r\n" +
+		"set pipe2 0 0 1 noreply\r\nc\r\n" +
+		"get pipe1 pipe2\r\n" +
+		"delete pipe2\r\n" +
+		"delete pipe2 noreply\r\n" +
+		"delete pipe2\r\n"))
+
+	expected := "STORED\r\n" +
+		"VALUE pipe1 0 4\r\na\r\nb\r\nVALUE pipe2 0 1\r\nc\r\nEND\r\n" +
+		"DELETED\r\n" +
+		"NOT_FOUND\r\n"
+	buf := make([]byte, len(expected))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("failed to read responses: %v", err)
+	}
+	if string(buf) != expected {
+		t.Errorf("unexpected responses: %q", buf)
+	}
+}
+
+// TestPipelinedFramingAsync checks the framing of pipelined requests whose responses are written
+// by AsyncRequests. The requests are independent, since they don't see the effects of each other.
+func TestPipelinedFramingAsync(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.AsyncRequests = 4
+	s.RegisterFunc("get", DefaultGet)
+	s.RegisterFunc("set", DefaultSet)
+	s.RegisterFunc("delete", DefaultDelete)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("set async1 0 0 4\r\na\r\nb\r\n" +
+		"set async2 0 0 1 noreply\r\nc\r\n" +
+		"get missing\r\n" +
+		"delete missing noreply\r\n" +
+		"delete missing\r\n" +
+		"version\r\n"))
+
+	expected := "STORED\r\n" +
+		"END\r\n" +
+		"NOT_FOUND\r\n" +
+		"VERSION " + s.Version() + "\r\n"
+	buf := make([]byte, len(expected))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("failed to read responses: %v", err)
+	}
+	if string(buf) != expected {
+		t.Errorf("unexpected responses: %q", buf)
+	}
+}
+
+func TestLargeResponses(t *testing.T) {
+	for _, async := range []int{0, 4} {
+		s := NewServer("127.0.0.1:0")
+		s.AsyncRequests = async
+		RegisterStore(s, NewMemoryStore(MemoryStoreOptions{}))
+		if err := s.Start(); err != nil {
+			t.Fatalf("failed to start: %v", err)
+		}
+
+		conn, err := net.Dial("tcp", s.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+		var keys []string
+		for i := 0; i < 8; i++ {
+			key := "k" + strconv.Itoa(i)
+			keys = append(keys, key)
+			WriteRequest(w, &Request{Command: "set", Key: key, Flags: "0", Data: []byte(strings.Repeat(key, i*WriterBuffsize/16))})
+		}
+		w.Flush()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for range keys {
+			if res, err := ReadResponse(r, "set"); err != nil || res.Response != RespStored {
+				t.Fatalf("set: %+v %v", res, err)
+			}
+		}
+
+		// pipelined, so vectored writes follow buffered responses
+		WriteRequest(w, &Request{Command: "get", Keys: keys[:2]})
+		WriteRequest(w, &Request{Command: "get", Keys: keys})
+		WriteRequest(w, &Request{Command: "get", Keys: keys[:2]})
+		w.Flush()
+		for _, n := range []int{2, len(keys), 2} {
+			res, err := ReadResponse(r, "get")
+			if err != nil || len(res.Values) != n {
+				t.Fatalf("get: %+v %v", res, err)
+			}
+			for i, v := range res.Values {
+				if string(v.Data) != strings.Repeat(keys[i], i*WriterBuffsize/16) {
+					t.Errorf("async %d: unexpected value of %s", async, v.Key)
+				}
+			}
+		}
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func TestCopyRequests(t *testing.T) {
+	mutate := func(ctx context.Context, req *Request, res *Response) error {
+		req.Key = "changed"
+		req.Data[0] = 'X'
+		return res.Stored()
+	}
+
+	for _, copyRequests := range []bool{false, true} {
+		s := NewServer("127.0.0.1:0")
+		s.CopyRequests = copyRequests
+		req := &Request{Command: "set", Key: "k", Data: []byte("v")}
+		s.call(context.Background(), mutate, req, &Response{})
+
+		unchanged := req.Key == "k" && string(req.Data) == "v"
+		if unchanged != copyRequests {
+			t.Errorf("CopyRequests %v: request is %+v", copyRequests, req)
+		}
+	}
+}
+
+func TestMaxLineLength(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+	s.RegisterFunc("get", DefaultGet)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("get " + strings.Repeat("k ", DefaultMaxLineLength) + "\r\n"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	// the connection may be reset as the rest of the line is unread
+	buf, err := io.ReadAll(conn)
+	if string(buf) != "CLIENT_ERROR line too long\r\n" {
+		t.Errorf("unexpected response: %q %v", buf, err)
+	}
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	var calls []string
+	s, addr := NewServer("127.0.0.1:0"), ""
+	s.OnStart(func() error {
+		calls = append(calls, "start1")
+		addr = s.ln.Addr().String() // listening before hooks
+		return nil
+	})
+	s.OnStart(func() error { calls = append(calls, "start2"); return nil })
+	s.OnStop(func() { calls = append(calls, "stop1") })
+	s.OnStop(func() { calls = append(calls, "stop2") })
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if line := roundTrip(t, addr, "get k\r\n"); line != "ERROR get not implemented'\r\n" {
+		t.Errorf("unexpected response %q", line)
+	}
+	s.Shutdown(context.Background())
+	if want := []string{"start1", "start2", "stop2", "stop1"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("hooks called %v, expected %v", calls, want)
+	}
+
+	s = NewServer("127.0.0.1:0")
+	errWarm := errors.New("warming failed")
+	s.OnStart(func() error { return errWarm })
+	if err := s.Start(); err != errWarm {
+		t.Fatalf("expected the hook error, got %v", err)
+	}
+	if _, err := net.Dial("tcp", s.ln.Addr().String()); err == nil {
+		t.Errorf("listener is open after failed start")
+	}
+}
+
+func TestRun(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	started := make(chan struct{})
+	var stopped bool
+	s.OnStart(func() error { close(started); return nil })
+	s.OnStop(func() { stopped = true })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+	<-started
+	cancel()
+	if err := <-done; err != nil || !stopped {
+		t.Errorf("Run returned %v after cancel, stop hooks called: %v", err, stopped)
+	}
+
+	s = NewServer("127.0.0.1:0")
+	started = make(chan struct{})
+	s.OnStart(func() error { close(started); return nil })
+	go func() { done <- s.Run(context.Background()) }()
+	<-started
+	s.ln.Close() // accept fails
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Run returned nil after the listener failed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run didn't return after the listener failed")
+	}
+}
+
+func TestServeErrors(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	if s.Addr() != nil {
+		t.Errorf("unstarted server has address %v", s.Addr())
+	}
+	errs := make(chan error, 1)
+	s.ErrorHandler = func(err error) { errs <- err }
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if line := roundTrip(t, s.Addr().String(), "get k\r\n"); line != "ERROR get not implemented'\r\n" {
+		t.Errorf("unexpected response %q", line)
+	}
+
+	s.ln.Close() // accepting fails
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Errorf("ErrorHandler called with nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ErrorHandler is not called")
+	}
+	s.Stop()
+	select {
+	case err := <-errs:
+		t.Errorf("ErrorHandler called after Stop: %v", err)
+	default:
+	}
+}
+
+func TestOnRequest(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	st := NewMemoryStore(MemoryStoreOptions{})
+	defer st.Close()
+	RegisterStore(s, st)
+	s.OnRequest = func(ctx context.Context, req *Request, res *Response) bool {
+		for _, k := range append([]string{req.Key}, req.Keys...) {
+			if strings.HasPrefix(k, "blocked:") {
+				res.ClientError("key is blocked")
+				return false
+			}
+		}
+		if req.Key != "" {
+			req.Key = "tenant1:" + req.Key
+		}
+		for i, k := range req.Keys {
+			req.Keys[i] = "tenant1:" + k
+		}
+		return true
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer s.Stop()
+	addr := s.Addr().String()
+
+	if line := roundTrip(t, addr, "set foo 0 0 3\r\nbar\r\n"); line != "STORED\r\n" {
+		t.Errorf("unexpected response %q", line)
+	}
+	if _, err := st.Get(context.Background(), "tenant1:foo"); err != nil {
+		t.Errorf("key is not rewritten: %v", err)
+	}
+	if line := roundTrip(t, addr, "get blocked:foo\r\n"); line != "CLIENT_ERROR key is blocked\r\n" {
+		t.Errorf("unexpected response %q", line)
+	}
+	if line := roundTrip(t, addr, "delete blocked:foo noreply\r\nversion\r\n"); !strings.HasPrefix(line, "VERSION") {
+		t.Errorf("noreply request is replied %q", line)
+	}
+	stats, _ := s.Stats(context.Background(), "")
+	if statValue(stats, "requests_intercepted") != "2" {
+		t.Errorf("unexpected stats %v", stats)
+	}
 }
@@ -0,0 +1,743 @@
+package mc
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// itemOverhead is the estimated memory used by an item besides its key and data.
+const itemOverhead = 64
+
+// accessResolution is the resolution of access times of items used by eviction.
+const accessResolution = time.Millisecond
+
+// evictionSamples is the number of items sampled to find one to evict.
+const evictionSamples = 5
+
+// sketchItemSize is the assumed average item size used to size frequency sketches.
+const sketchItemSize = 128
+
+// errRejected is returned by shard.put when the admission policy rejects an item.
+var errRejected = errors.New("rejected by admission policy")
+
+// MemoryStoreOptions configures MemoryStore.
+type MemoryStoreOptions struct {
+	// Shards is the number of shards, each has its own lock. Default is 64.
+	Shards int
+	// MaxBytes is the memory limit of items, split evenly among shards.
+	// The least recently used of some sampled items is evicted when a shard is full.
+	// 0 means no limit.
+	MaxBytes int64
+	// MaxBytesPercent sets MaxBytes to this percentage of the cgroup memory limit of the process
+	// if MaxBytes is 0, so the limit of a container isn't repeated in the options, see
+	// CgroupMemoryLimit. The derived limit is logged. There is no limit if the process has none.
+	MaxBytesPercent float64
+	// Index selects how shards index items. Default is IndexLocked.
+	Index IndexType
+	// Hasher places keys in shards. Default is FNV1a. HashTags keeps related keys in one shard.
+	Hasher Hasher
+	// Arena stores data of items in large pages outside the Go heap, which keeps GC cost low for
+	// big caches. Values larger than 1MB are rejected, and data is copied out of the arena by Get.
+	Arena bool
+	// GrowthFactor is the ratio between chunk sizes of adjacent slab classes of the arena,
+	// like the -f option of memcached. It must be greater than 1 and defaults to DefaultGrowthFactor.
+	GrowthFactor float64
+	// Admission enables the TinyLFU admission policy when MaxBytes is set: a new item which would
+	// evict others is stored only if its key is accessed more frequently than the key to evict,
+	// so keys which are used once don't push out popular items. Rejected items are dropped
+	// silently, as if they were evicted at once.
+	Admission bool
+	// MemoryTarget enables evictions under memory pressure, so a process whose memory is limited,
+	// like in a container, isn't killed: a background ticker checks the memory of the Go runtime
+	// every second, and evicts items of the excess above MemoryTarget, evenly from all shards.
+	// Evicted items are freed by the next GC. The pages of the Arena are not included on most
+	// platforms. Close stops it. 0 disables it.
+	MemoryTarget int64
+	// RateInterval enables a background ticker which computes rates of the counters in every
+	// interval, see Rates. Close stops it.
+	RateInterval time.Duration
+	// Clock tells the time for expiration and eviction. Default is SystemClock.
+	// The ticker of RateInterval always uses the system time.
+	Clock Clock
+	// Seed seeds the sampling of items to evict, so evictions are reproducible.
+	Seed int64
+	// PrefixDelimiter enables statistics of key prefixes, the parts of keys before the first
+	// delimiter, like "user" of "user:1" with ":", so the memory and hit ratio of each feature
+	// sharing the cache can be seen, see PrefixStats. Keys without the delimiter are not counted.
+	// Every distinct prefix of the items is kept, so keys must have few of them. Lookups are counted
+	// for the prefixes of PrefixQuotas and up to 1024 others, so gets of random prefixes can't grow
+	// the statistics.
+	PrefixDelimiter string
+	// PrefixQuotas limits the items of prefixes, so one feature sharing the cache can't evict the
+	// items of others. It requires PrefixDelimiter.
+	PrefixQuotas map[string]PrefixQuota
+}
+
+// MemoryStore is an in-memory sharded Store with expiration and eviction.
+type MemoryStore struct {
+	opts   MemoryStoreOptions
+	layout atomic.Value // *layout
+	arena  *arena
+	cas    uint64
+
+	// counters of shards dropped by resizes, guarded by resizeMu
+	resizeMu                         sync.RWMutex
+	retired                          StoreCounters
+	retiredAdmitted, retiredRejected uint64
+
+	rates     atomic.Value // Rates
+	prefixes  *prefixStats // nil if PrefixDelimiter is not set
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// entry is an item in a shard.
+type entry struct {
+	// lastAccess is first so it is 64-bit aligned for atomic access on 32-bit platforms
+	lastAccess int64 // unix nano, accessed atomically
+	item       Item
+	size       int64
+	pos        int    // position in shard.keys
+	prefixPos  int    // position in prefixUsage.keys if the prefix has a quota
+	chunk      *chunk // data in the arena if it is enabled
+}
+
+type shard struct {
+	// counters accessed atomically, first so they are 64-bit aligned on 32-bit platforms
+	gets      uint64
+	hits      uint64
+	sets      uint64
+	evictions uint64
+
+	mu    sync.RWMutex
+	items index
+	keys  []string // for sampling
+	bytes int64
+	max   int64
+	rand  *rand.Rand
+
+	freq     *sketch // nil if admission is disabled
+	admitted uint64
+	rejected uint64
+
+	delim    string                  // see MemoryStoreOptions.PrefixDelimiter
+	prefixes map[string]*prefixUsage // nil if PrefixDelimiter is not set
+	quotas   map[string]PrefixQuota  // the share of the shard, nil if there are none
+}
+
+// NewMemoryStore creates a MemoryStore.
+func NewMemoryStore(opts MemoryStoreOptions) *MemoryStore {
+	if opts.Shards <= 0 {
+		opts.Shards = 64
+	}
+	if opts.GrowthFactor <= 1 {
+		opts.GrowthFactor = DefaultGrowthFactor
+	}
+	if opts.Hasher == nil {
+		opts.Hasher = FNV1a
+	}
+	if opts.MaxBytes == 0 && opts.MaxBytesPercent > 0 {
+		opts.MaxBytes = maxBytesOfCgroup(cgroupRoot, opts.MaxBytesPercent)
+	}
+	opts.Clock = clockOrSystem(opts.Clock)
+	s := &MemoryStore{opts: opts}
+	if opts.Arena {
+		s.arena = newArena(opts.GrowthFactor)
+	}
+	s.closed = make(chan struct{})
+	s.rates.Store(Rates{})
+	if opts.PrefixDelimiter != "" {
+		s.prefixes = &prefixStats{delim: opts.PrefixDelimiter, quotas: opts.PrefixQuotas}
+	}
+	s.layout.Store(s.newLayout(opts.Shards, opts.MaxBytes))
+	if opts.RateInterval > 0 {
+		go s.computeRates(time.NewTicker(opts.RateInterval), time.Now())
+	}
+	if opts.MemoryTarget > 0 {
+		go s.watchMemory(time.NewTicker(pressureInterval))
+	}
+	return s
+}
+
+// newLayout creates n empty shards sharing maxBytes.
+func (s *MemoryStore) newLayout(n int, maxBytes int64) *layout {
+	l := &layout{shards: make([]*shard, n), maxBytes: maxBytes, hasher: s.opts.Hasher}
+	for i := range l.shards {
+		l.shards[i] = &shard{
+			items: newIndex(s.opts.Index),
+			max:   maxBytes / int64(n),
+			rand:  rand.New(rand.NewSource(s.opts.Seed + int64(i))),
+		}
+		if s.opts.Admission && maxBytes > 0 {
+			l.shards[i].freq = newSketch(int(l.shards[i].max / sketchItemSize))
+		}
+		if s.opts.PrefixDelimiter != "" {
+			l.shards[i].delim = s.opts.PrefixDelimiter
+			l.shards[i].prefixes = make(map[string]*prefixUsage)
+			l.shards[i].quotas = shardQuotas(s.opts.PrefixQuotas, n)
+		}
+	}
+	return l
+}
+
+// current returns the current layout.
+func (s *MemoryStore) current() *layout {
+	return s.layout.Load().(*layout)
+}
+
+// shards returns the shards of the current layout, and those being migrated if it is resizing.
+func (s *MemoryStore) shards() []*shard {
+	l := s.current()
+	if l.prev == nil {
+		return l.shards
+	}
+	return append(append([]*shard(nil), l.prev.shards...), l.shards...)
+}
+
+// fnv32a returns the FNV-1a hash of key without allocations.
+func fnv32a(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// Get returns a copy of the item, or ErrNotFound.
+func (s *MemoryStore) Get(ctx context.Context, key string) (*Item, error) {
+	now := s.opts.Clock.Now()
+	l := s.current()
+	sh := l.shard(key)
+	if sh.freq != nil {
+		sh.freq.increment(key)
+	}
+	atomic.AddUint64(&sh.gets, 1)
+	var lookups *prefixLookups
+	if s.prefixes != nil {
+		lookups = s.prefixes.lookup(key)
+	}
+	it, e, ok := s.lookup(l, key, now, false)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// avoids writing hot entries from all cores
+	if t := now.UnixNano(); t-atomic.LoadInt64(&e.lastAccess) > int64(accessResolution) {
+		atomic.StoreInt64(&e.lastAccess, t)
+	}
+	atomic.AddUint64(&sh.hits, 1)
+	if lookups != nil {
+		atomic.AddUint64(&lookups.hits, 1)
+	}
+	return it, nil
+}
+
+// lookup returns a copy of the item of key and its entry, starting at layout l. Expired items
+// which have not been removed yet are returned only if stale is set. It counts no access.
+func (s *MemoryStore) lookup(l *layout, key string, now time.Time, stale bool) (*Item, *entry, bool) {
+	for {
+		e, ok := l.load(key)
+		for !ok && s.current() != l {
+			// the layout changed while the item may be moving between layouts
+			l = s.current()
+			e, ok = l.load(key)
+		}
+		if !ok || (!stale && e.item.Expired(now)) {
+			return nil, nil, false
+		}
+		it := e.item
+		if e.chunk != nil {
+			if it.Data, ok = e.chunk.bytes(); !ok {
+				// freed by a concurrent writer, which replaced or deleted the entry before
+				continue
+			}
+		}
+		return &it, e, true
+	}
+}
+
+// MGet returns copies of found items.
+func (s *MemoryStore) MGet(ctx context.Context, keys []string) (map[string]*Item, error) {
+	items := make(map[string]*Item, len(keys))
+	for _, key := range keys {
+		if it, err := s.Get(ctx, key); err == nil {
+			items[key] = it
+		}
+	}
+	return items, nil
+}
+
+// Peek implements Peeker.
+func (s *MemoryStore) Peek(ctx context.Context, keys []string) (map[string]*Item, error) {
+	now := s.opts.Clock.Now()
+	items := make(map[string]*Item, len(keys))
+	for _, key := range keys {
+		if it, _, ok := s.lookup(s.current(), key, now, false); ok {
+			items[key] = it
+		}
+	}
+	return items, nil
+}
+
+// Set stores a copy of the item and assigns a new cas to it.
+// It returns ErrTooLarge if the item can't fit in a shard.
+func (s *MemoryStore) Set(ctx context.Context, item *Item) error {
+	l, unlock := s.lockKeys(item.Key)
+	defer unlock()
+	sh := l.shard(item.Key)
+	if sh.freq != nil {
+		sh.freq.increment(item.Key)
+	}
+	return s.store(sh, item, s.opts.Clock.Now())
+}
+
+// Update implements Updater. fn runs with the lock of the item's shard held,
+// so it must not call other methods of the store.
+func (s *MemoryStore) Update(ctx context.Context, key string, fn func(it *Item) (*Item, error)) error {
+	l, unlock := s.lockKeys(key)
+	defer unlock()
+	sh := l.shard(key)
+	if sh.freq != nil {
+		sh.freq.increment(key)
+	}
+
+	now := s.opts.Clock.Now()
+	var cur *Item
+	if e, ok := sh.items.get(key); ok && !e.item.Expired(now) {
+		it := e.item
+		if e.chunk != nil {
+			// the chunk can't be freed while the shard is locked
+			it.Data = append([]byte(nil), e.chunk.buf...)
+		}
+		cur = &it
+	}
+	it, err := fn(cur)
+	if err != nil {
+		return err
+	}
+	it.Key = key
+	return s.store(sh, it, now)
+}
+
+// Incr implements Incrementer. It parses the value in place instead of copying the item.
+func (s *MemoryStore) Incr(ctx context.Context, key string, delta uint64, decr bool) (uint64, error) {
+	l, unlock := s.lockKeys(key)
+	defer unlock()
+	sh := l.shard(key)
+	if sh.freq != nil {
+		sh.freq.increment(key)
+	}
+
+	now := s.opts.Clock.Now()
+	e, ok := sh.items.get(key)
+	if !ok || e.item.Expired(now) {
+		return 0, ErrNotFound
+	}
+	data := e.item.Data
+	if e.chunk != nil {
+		data = e.chunk.buf // the chunk can't be freed while the shard is locked
+	}
+	n, err := incrValue(data, delta, decr)
+	if err != nil {
+		return 0, err
+	}
+	it := e.item
+	it.Data = []byte(strconv.FormatUint(n, 10))
+	return n, s.store(sh, &it, now)
+}
+
+// store stores a copy of the item in sh and assigns a new cas to it. Callers hold sh.mu.
+func (s *MemoryStore) store(sh *shard, item *Item, now time.Time) error {
+	e := &entry{
+		item:       *item,
+		size:       int64(len(item.Key)+len(item.Data)) + itemOverhead,
+		lastAccess: now.UnixNano(),
+	}
+	if s.arena != nil {
+		ch, err := s.arena.alloc(item.Data)
+		if err != nil {
+			return err
+		}
+		e.chunk, e.item.Data = ch, nil
+		e.size += int64(ch.class.size - len(item.Data))
+	}
+	e.item.Cas = atomic.AddUint64(&s.cas, 1)
+	item.Cas = e.item.Cas
+	atomic.AddUint64(&sh.sets, 1)
+
+	err := sh.put(e, now)
+	if err != nil && e.chunk != nil {
+		e.chunk.release()
+	}
+	if err == errRejected {
+		return nil
+	}
+	return err
+}
+
+// MSet stores copies of all items.
+func (s *MemoryStore) MSet(ctx context.Context, items []*Item) error {
+	for _, it := range items {
+		if err := s.Set(ctx, it); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete deletes the item, or returns ErrNotFound.
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	l, unlock := s.lockKeys(key)
+	defer unlock()
+
+	sh := l.shard(key)
+	e, ok := sh.items.get(key)
+	if !ok {
+		return ErrNotFound
+	}
+	sh.remove(e)
+	if e.item.Expired(s.opts.Clock.Now()) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Flush removes all items.
+func (s *MemoryStore) Flush(ctx context.Context) error {
+	for _, sh := range s.shards() {
+		sh.mu.Lock()
+		// lock-free readers may see the index, so it is emptied instead of replaced
+		for len(sh.keys) > 0 {
+			e, _ := sh.items.get(sh.keys[len(sh.keys)-1])
+			sh.remove(e)
+		}
+		sh.mu.Unlock()
+	}
+	return nil
+}
+
+// Range calls fn for keys of all unexpired items until fn returns false.
+// Keys which are written while the store is resizing may be missed or repeated.
+func (s *MemoryStore) Range(ctx context.Context, fn func(key string) bool) error {
+	now := s.opts.Clock.Now()
+	for _, sh := range s.shards() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		sh.mu.RLock()
+		keys := make([]string, 0, sh.items.size())
+		sh.items.each(func(k string, e *entry) {
+			if !e.item.Expired(now) {
+				keys = append(keys, k)
+			}
+		})
+		sh.mu.RUnlock()
+
+		for _, k := range keys {
+			if !fn(k) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// Len returns the number of items, including expired ones which have not been removed.
+func (s *MemoryStore) Len() int {
+	n := 0
+	for _, sh := range s.shards() {
+		sh.mu.RLock()
+		n += sh.items.size()
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
+// Bytes returns the estimated memory used by items.
+func (s *MemoryStore) Bytes() int64 {
+	var n int64
+	for _, sh := range s.shards() {
+		sh.mu.RLock()
+		n += sh.bytes
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
+// AdmissionStats returns the numbers of new items admitted and rejected by the admission policy
+// when they would evict others.
+func (s *MemoryStore) AdmissionStats() (admitted, rejected uint64) {
+	s.resizeMu.RLock()
+	defer s.resizeMu.RUnlock()
+	for _, sh := range s.shards() {
+		sh.mu.RLock()
+		admitted += sh.admitted
+		rejected += sh.rejected
+		sh.mu.RUnlock()
+	}
+	return admitted + s.retiredAdmitted, rejected + s.retiredRejected
+}
+
+// SlabStats returns statistics of the slab classes which have allocated pages,
+// or nil if the arena is not enabled.
+func (s *MemoryStore) SlabStats() []SlabClassStats {
+	if s.arena == nil {
+		return nil
+	}
+	return s.arena.stats()
+}
+
+// itemSizeMax returns the max size of items in l, or 0 if it is unlimited.
+// Sizes of items in shards include their keys and itemOverhead.
+func (s *MemoryStore) itemSizeMax(l *layout) int64 {
+	max := l.maxBytes / int64(len(l.shards))
+	if s.arena != nil && (max == 0 || max > arenaPageSize) {
+		max = arenaPageSize
+	}
+	return max
+}
+
+// Clock returns the clock of the store.
+func (s *MemoryStore) Clock() Clock {
+	return s.opts.Clock
+}
+
+// Stats implements StatsReporter, see ProvideStats.
+func (s *MemoryStore) Stats(ctx context.Context, group string) ([]Stat, error) {
+	req, err := ParseStatsRequest(strings.Fields(group))
+	if err != nil {
+		return nil, err
+	}
+	return s.ProvideStats(ctx, req)
+}
+
+// ProvideStats implements StatsProvider. It reports the items and counters for the general
+// statistics, the rates of the last interval for "rates", the Usage for "usage", the PrefixStats
+// for "prefixes", the slab classes of the arena for "slabs", the items of slab classes for "items"
+// and the options for "settings".
+func (s *MemoryStore) ProvideStats(ctx context.Context, req StatsRequest) ([]Stat, error) {
+	switch req.Scope {
+	case StatsGeneral:
+		stats := []Stat{
+			{"curr_items", strconv.Itoa(s.Len())},
+			{"bytes", strconv.FormatInt(s.Bytes(), 10)},
+			{"limit_maxbytes", strconv.FormatInt(s.current().maxBytes, 10)},
+		}
+		c := s.Counters()
+		stats = append(stats,
+			Stat{"cmd_get", strconv.FormatUint(c.Gets, 10)},
+			Stat{"cmd_set", strconv.FormatUint(c.Sets, 10)},
+			Stat{"get_hits", strconv.FormatUint(c.Hits, 10)},
+			Stat{"get_misses", strconv.FormatUint(c.Gets-c.Hits, 10)},
+			Stat{"evictions", strconv.FormatUint(c.Evictions, 10)},
+		)
+		if s.opts.Admission {
+			admitted, rejected := s.AdmissionStats()
+			stats = append(stats,
+				Stat{"admission_admitted", strconv.FormatUint(admitted, 10)},
+				Stat{"admission_rejected", strconv.FormatUint(rejected, 10)},
+			)
+		}
+		return stats, nil
+	case "rates":
+		if len(req.Args) > 0 {
+			return nil, ErrNotSupported
+		}
+		if s.opts.RateInterval <= 0 {
+			return nil, ErrNotSupported
+		}
+		return s.Rates().stats(), nil
+	case "usage":
+		if len(req.Args) > 0 {
+			return nil, ErrNotSupported
+		}
+		return s.Usage().stats(), nil
+	case "prefixes":
+		if len(req.Args) > 0 || s.prefixes == nil {
+			return nil, ErrNotSupported
+		}
+		return prefixStatsList(s.PrefixStats()), nil
+	case StatsItems:
+		if s.arena == nil {
+			return nil, ErrNotSupported
+		}
+		var stats []Stat
+		for _, c := range s.SlabStats() {
+			stats = append(stats, Stat{"items:" + strconv.Itoa(c.ID) + ":number", strconv.Itoa(c.UsedChunks)})
+		}
+		return stats, nil
+	case StatsSettings:
+		l := s.current()
+		index := "locked"
+		if s.opts.Index == IndexReadMostly {
+			index = "read_mostly"
+		}
+		policy := "sampled_lru"
+		if s.opts.Admission && l.maxBytes > 0 {
+			policy = "tinylfu"
+		}
+		stats := []Stat{
+			{"maxbytes", strconv.FormatInt(l.maxBytes, 10)},
+			{"item_size_max", strconv.FormatInt(s.itemSizeMax(l), 10)},
+			{"evictions", onOff(l.maxBytes > 0)},
+			{"eviction_policy", policy},
+			{"eviction_samples", strconv.Itoa(evictionSamples)},
+			{"shards", strconv.Itoa(len(l.shards))},
+			{"resizing", yesNo(l.prev != nil)},
+			{"index", index},
+			{"hash_algorithm", hasherName(s.opts.Hasher)},
+			{"slab_arena", yesNo(s.arena != nil)},
+			{"admission", yesNo(s.opts.Admission)},
+			{"rate_interval", s.opts.RateInterval.String()},
+			{"memory_target", strconv.FormatInt(s.opts.MemoryTarget, 10)},
+			{"prefix_stats", yesNo(s.prefixes != nil)},
+		}
+		if s.arena != nil {
+			stats = append(stats, Stat{"growth_factor", strconv.FormatFloat(s.opts.GrowthFactor, 'f', 2, 64)})
+		}
+		return stats, nil
+	case StatsSlabs:
+		var stats []Stat
+		var malloced int64
+		slabs := s.SlabStats()
+		for _, c := range slabs {
+			id := strconv.Itoa(c.ID) + ":"
+			stats = append(stats,
+				Stat{id + "chunk_size", strconv.Itoa(c.ChunkSize)},
+				Stat{id + "chunks_per_page", strconv.Itoa(c.ChunksPerPage)},
+				Stat{id + "total_pages", strconv.Itoa(c.TotalPages)},
+				Stat{id + "total_chunks", strconv.Itoa(c.TotalChunks)},
+				Stat{id + "used_chunks", strconv.Itoa(c.UsedChunks)},
+				Stat{id + "free_chunks", strconv.Itoa(c.FreeChunks)},
+				Stat{id + "free_chunks_end", strconv.Itoa(c.FreeChunksEnd)},
+				Stat{id + "mem_requested", strconv.FormatInt(c.MemRequested, 10)},
+			)
+			malloced += int64(c.TotalPages) * arenaPageSize
+		}
+		return append(stats,
+			Stat{"active_slabs", strconv.Itoa(len(slabs))},
+			Stat{"total_malloced", strconv.FormatInt(malloced, 10)},
+		), nil
+	}
+	return nil, ErrNotSupported
+}
+
+// load returns the entry of key without holding the lock of sh.
+func (sh *shard) load(key string) (*entry, bool) {
+	if sh.items.lockFree() {
+		return sh.items.get(key)
+	}
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	return sh.items.get(key)
+}
+
+// put adds or replaces an entry, evicting others if the shard is full. Callers hold sh.mu.
+func (sh *shard) put(e *entry, now time.Time) error {
+	if sh.max > 0 && e.size > sh.max {
+		return ErrTooLarge
+	}
+	if err := sh.applyQuota(e, now); err != nil {
+		return err
+	}
+	if old, ok := sh.items.get(e.item.Key); ok {
+		sh.replace(old, e, now)
+		return nil
+	} else if sh.freq != nil && sh.bytes+e.size > sh.max && len(sh.keys) > 0 {
+		victim := sh.victim(now)
+		if !victim.item.Expired(now) && sh.freq.estimate(e.item.Key) <= sh.freq.estimate(victim.item.Key) {
+			sh.rejected++
+			return errRejected
+		}
+		sh.admitted++
+		sh.evict(victim, now)
+	}
+	sh.insert(e, now)
+	return nil
+}
+
+// replace replaces an entry by e of the same key, evicting others if the shard is full.
+// The key stays in the index, so lock-free readers never miss it, and the data of old is freed
+// only after e is published. Callers hold sh.mu.
+func (sh *shard) replace(old, e *entry, now time.Time) {
+	for sh.max > 0 && sh.bytes-old.size+e.size > sh.max && len(sh.keys) > 1 {
+		if victim := sh.victim(now); victim != old {
+			sh.evict(victim, now)
+		}
+	}
+
+	e.pos, e.prefixPos = old.pos, old.prefixPos
+	sh.items.put(e.item.Key, e)
+	sh.bytes += e.size - old.size
+	sh.account(e, 0, e.size-old.size)
+	if old.chunk != nil {
+		old.chunk.release()
+	}
+}
+
+// insert adds an entry of a new key, evicting others if the shard is full. Callers hold sh.mu.
+func (sh *shard) insert(e *entry, now time.Time) {
+	for sh.max > 0 && sh.bytes+e.size > sh.max && len(sh.keys) > 0 {
+		sh.evict(sh.victim(now), now)
+	}
+
+	e.pos = len(sh.keys)
+	sh.keys = append(sh.keys, e.item.Key)
+	sh.items.put(e.item.Key, e)
+	sh.bytes += e.size
+	sh.account(e, 1, e.size)
+}
+
+// remove removes an entry and frees its data. Callers hold sh.mu.
+func (sh *shard) remove(e *entry) {
+	sh.unlink(e)
+	if e.chunk != nil {
+		e.chunk.release()
+	}
+}
+
+// unlink removes an entry without freeing its data. Callers hold sh.mu.
+func (sh *shard) unlink(e *entry) {
+	last := len(sh.keys) - 1
+	if e.pos != last {
+		moved, _ := sh.items.get(sh.keys[last])
+		moved.pos = e.pos
+		sh.keys[e.pos] = moved.item.Key
+	}
+	sh.keys = sh.keys[:last]
+	sh.items.del(e.item.Key)
+	sh.bytes -= e.size
+	sh.account(e, -1, -e.size)
+}
+
+// evict removes an entry to make room for others and counts it if it has not expired.
+// Callers hold sh.mu.
+func (sh *shard) evict(e *entry, now time.Time) {
+	if !e.item.Expired(now) {
+		atomic.AddUint64(&sh.evictions, 1)
+	}
+	sh.remove(e)
+}
+
+// victim samples some entries and returns an expired one or the least recently used one.
+// Callers hold sh.mu.
+func (sh *shard) victim(now time.Time) *entry {
+	var victim *entry
+	for i := 0; i < evictionSamples; i++ {
+		e, _ := sh.items.get(sh.keys[sh.rand.Intn(len(sh.keys))])
+		if e.item.Expired(now) {
+			return e
+		}
+		if victim == nil || atomic.LoadInt64(&e.lastAccess) < atomic.LoadInt64(&victim.lastAccess) {
+			victim = e
+		}
+	}
+	return victim
+}
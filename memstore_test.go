@@ -0,0 +1,184 @@
+package mc
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+func TestMemoryStore(t *testing.T) {
+	for _, index := range []IndexType{IndexLocked, IndexReadMostly} {
+		testMemoryStore(t, NewMemoryStore(MemoryStoreOptions{Shards: 4, Index: index}))
+	}
+}
+
+func testMemoryStore(t *testing.T, st *MemoryStore) {
+	ctx := context.Background()
+
+	st.Set(ctx, &Item{Key: "a", Data: []byte("1")})
+	st.Set(ctx, &Item{Key: "b", Data: []byte("2"), Expiration: time.Now().Add(-time.Second)})
+
+	it, err := st.Get(ctx, "a")
+	if err != nil || string(it.Data) != "1" || it.Cas == 0 {
+		t.Fatalf("unexpected item %+v: %v", it, err)
+	}
+	if _, err := st.Get(ctx, "b"); err != ErrNotFound {
+		t.Errorf("expired item should not be found: %v", err)
+	}
+	if err := st.Delete(ctx, "a"); err != nil {
+		t.Errorf("Delete %v", err)
+	}
+	if err := st.Delete(ctx, "a"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	st.Set(ctx, &Item{Key: "c", Data: []byte("3")})
+	st.Flush(ctx)
+	if _, err := st.Get(ctx, "c"); err != ErrNotFound || st.Len() != 0 || st.Bytes() != 0 {
+		t.Errorf("store is not flushed: %d items, %d bytes", st.Len(), st.Bytes())
+	}
+}
+
+func benchmarkMemoryStore(b *testing.B, index IndexType) {
+	st := NewMemoryStore(MemoryStoreOptions{Index: index})
+	ctx := context.Background()
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		st.Set(ctx, &Item{Key: keys[i], Data: []byte("value")})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := keys[i%len(keys)]
+			if i%100 < 97 { // 97% reads
+				st.Get(ctx, key)
+			} else {
+				st.Set(ctx, &Item{Key: key, Data: []byte("value")})
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkMemoryStoreLocked(b *testing.B)     { benchmarkMemoryStore(b, IndexLocked) }
+func BenchmarkMemoryStoreReadMostly(b *testing.B) { benchmarkMemoryStore(b, IndexReadMostly) }
+
+func TestMemoryStoreEviction(t *testing.T) {
+	st := NewMemoryStore(MemoryStoreOptions{Shards: 1, MaxBytes: 10 * (itemOverhead + 13)})
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		if err := st.Set(ctx, &Item{Key: "key" + strconv.Itoa(100+i), Data: []byte("1234567")}); err != nil {
+			t.Fatalf("Set %v", err)
+		}
+	}
+	if st.Len() != 10 || st.Bytes() != 10*(itemOverhead+13) {
+		t.Errorf("store exceeds its limit: %d items, %d bytes", st.Len(), st.Bytes())
+	}
+
+	if err := st.Set(ctx, &Item{Key: "big", Data: make([]byte, 1000)}); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+
+	settings, _ := st.Stats(ctx, "settings")
+	if statValue(settings, "item_size_max") != strconv.Itoa(10*(itemOverhead+13)) || statValue(settings, "evictions") != "on" ||
+		statValue(settings, "eviction_policy") != "sampled_lru" {
+		t.Errorf("unexpected stats settings: %v", settings)
+	}
+}
+
+func TestRegisterStore(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+	RegisterStore(s, NewMemoryStore(MemoryStoreOptions{}))
+
+	mc := memcache.New(addr)
+	if err := mc.Add(&memcache.Item{Key: "k", Value: []byte("10"), Flags: 3}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := mc.Add(&memcache.Item{Key: "k", Value: []byte("10")}); err != memcache.ErrNotStored {
+		t.Errorf("expected not stored, got %v", err)
+	}
+	if n, err := mc.Increment("k", 5); err != nil || n != 15 {
+		t.Errorf("incr: %d %v", n, err)
+	}
+	if n, err := mc.Decrement("k", 100); err != nil || n != 0 {
+		t.Errorf("decr: %d %v", n, err)
+	}
+	if line := roundTrip(t, addr, "append k 0 0 1\r\n1\r\n"); line != "STORED\r\n" {
+		t.Errorf("append: %q", line)
+	}
+
+	it, err := mc.Get("k")
+	if err != nil || string(it.Value) != "01" || it.Flags != 3 {
+		t.Fatalf("get: %+v %v", it, err)
+	}
+	it.Value = []byte("v2")
+	if err := mc.CompareAndSwap(it); err != nil {
+		t.Errorf("cas: %v", err)
+	}
+	if err := mc.CompareAndSwap(it); err != memcache.ErrCASConflict {
+		t.Errorf("expected cas conflict, got %v", err)
+	}
+	if err := mc.Touch("k", 100); err != nil {
+		t.Errorf("touch: %v", err)
+	}
+	if err := mc.Delete("k"); err != nil {
+		t.Errorf("delete: %v", err)
+	}
+	if err := mc.Delete("k"); err != memcache.ErrCacheMiss {
+		t.Errorf("expected cache miss, got %v", err)
+	}
+	if err := mc.FlushAll(); err != nil {
+		t.Errorf("flush_all: %v", err)
+	}
+}
+
+func TestMemoryStoreReplaceVisible(t *testing.T) {
+	for name, opts := range map[string]MemoryStoreOptions{
+		"locked":      {Shards: 1},
+		"read-mostly": {Shards: 1, Index: IndexReadMostly},
+		"arena":       {Shards: 1, Index: IndexReadMostly, Arena: true},
+	} {
+		st := NewMemoryStore(opts)
+		ctx := context.Background()
+		st.Set(ctx, &Item{Key: "k", Data: []byte("0")})
+
+		done := make(chan struct{})
+		misses := make(chan int)
+		for r := 0; r < 4; r++ {
+			go func() {
+				n := 0
+				for {
+					select {
+					case <-done:
+						misses <- n
+						return
+					default:
+					}
+					if _, err := st.Get(ctx, "k"); err != nil {
+						n++
+					}
+				}
+			}()
+		}
+		for i := 0; i < 5000; i++ {
+			st.Set(ctx, &Item{Key: "k", Data: []byte(strconv.Itoa(i))})
+		}
+		close(done)
+		total := 0
+		for r := 0; r < 4; r++ {
+			total += <-misses
+		}
+		if total > 0 {
+			t.Errorf("%s: readers missed a replaced key %d times", name, total)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package mc
+
+import (
+	"context"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics observes requests handled by a server.
+type Metrics interface {
+	// ObserveLatency is called with the time the handler of cmd took.
+	ObserveLatency(cmd string, d time.Duration)
+}
+
+// metricsHolder lets atomic.Value store different Metrics implementations.
+type metricsHolder struct {
+	m Metrics
+}
+
+// SetMetrics sets the metrics which observe requests. Set it to nil to disable metrics.
+func (s *Server) SetMetrics(m Metrics) {
+	s.metrics.Store(metricsHolder{m})
+}
+
+// getMetrics returns the metrics set by SetMetrics.
+func (s *Server) getMetrics() Metrics {
+	h, _ := s.metrics.Load().(metricsHolder)
+	return h.m
+}
+
+// histSubBits is the number of bits of sub-buckets of a power of two,
+// so recorded values are within 1/16 of the real ones.
+const histSubBits = 4
+
+const histSubBuckets = 1 << histSubBits
+
+// histogram is a log-linear histogram of durations in nanoseconds like HDR histograms:
+// each power of two range is split into histSubBuckets linear buckets.
+type histogram struct {
+	counts [(64 - histSubBits + 1) * histSubBuckets]uint64
+}
+
+// histIndex returns the bucket of v.
+func histIndex(v uint64) int {
+	if v < histSubBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(v) - 1
+	sub := (v >> uint(exp-histSubBits)) & (histSubBuckets - 1)
+	return (exp-histSubBits+1)*histSubBuckets + int(sub)
+}
+
+// histUpper returns the highest value of the bucket i.
+func histUpper(i int) uint64 {
+	if i < histSubBuckets {
+		return uint64(i)
+	}
+	shift := uint(i/histSubBuckets - 1)
+	sub := uint64(i % histSubBuckets)
+	return (histSubBuckets+sub+1)<<shift - 1
+}
+
+func (h *histogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	atomic.AddUint64(&h.counts[histIndex(uint64(d))], 1)
+}
+
+// snapshot returns the counts of buckets and their total.
+func (h *histogram) snapshot() (counts []uint64, total uint64) {
+	counts = make([]uint64, len(h.counts))
+	for i := range h.counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+		total += counts[i]
+	}
+	return counts, total
+}
+
+// percentiles returns the durations under which p percent of the recorded durations are,
+// for each p of ps in ascending order.
+func percentiles(counts []uint64, total uint64, ps []float64) []time.Duration {
+	ds := make([]time.Duration, len(ps))
+	if total == 0 {
+		return ds
+	}
+	var seen uint64
+	j := 0
+	for i, n := range counts {
+		seen += n
+		for j < len(ps) && float64(seen) >= math.Ceil(ps[j]/100*float64(total)) && seen > 0 {
+			ds[j] = time.Duration(histUpper(i))
+			j++
+		}
+		if j == len(ps) {
+			break
+		}
+	}
+	return ds
+}
+
+// LatencyPercentiles are the percentiles reported by LatencyHistograms.
+var LatencyPercentiles = []float64{50, 95, 99, 99.9}
+
+// LatencyHistograms is a Metrics which records latencies of each command in a histogram
+// with a relative error of 1/16. It is also a StatsReporter of the group "latency".
+type LatencyHistograms struct {
+	hists sync.Map // command -> *histogram
+}
+
+// NewLatencyHistograms creates LatencyHistograms.
+func NewLatencyHistograms() *LatencyHistograms {
+	return &LatencyHistograms{}
+}
+
+// ObserveLatency implements Metrics.
+func (h *LatencyHistograms) ObserveLatency(cmd string, d time.Duration) {
+	hist, ok := h.hists.Load(cmd)
+	if !ok {
+		hist, _ = h.hists.LoadOrStore(cmd, &histogram{})
+	}
+	hist.(*histogram).record(d)
+}
+
+// Percentile returns the latency under which p percent of requests of cmd are handled,
+// or 0 if there are none.
+func (h *LatencyHistograms) Percentile(cmd string, p float64) time.Duration {
+	hist, ok := h.hists.Load(cmd)
+	if !ok {
+		return 0
+	}
+	counts, total := hist.(*histogram).snapshot()
+	return percentiles(counts, total, []float64{p})[0]
+}
+
+// ResetStats implements StatsResetter. It drops the recorded latencies.
+func (h *LatencyHistograms) ResetStats(ctx context.Context) error {
+	h.hists.Range(func(k, v interface{}) bool {
+		h.hists.Delete(k)
+		return true
+	})
+	return nil
+}
+
+// Stats implements StatsReporter. For the group "latency", it reports the count and
+// the LatencyPercentiles of each command in microseconds, e.g. STAT get:p99 120.
+func (h *LatencyHistograms) Stats(ctx context.Context, group string) ([]Stat, error) {
+	if group != "latency" {
+		return nil, ErrNotSupported
+	}
+
+	var cmds []string
+	h.hists.Range(func(k, v interface{}) bool {
+		cmds = append(cmds, k.(string))
+		return true
+	})
+	sort.Strings(cmds)
+
+	var stats []Stat
+	for _, cmd := range cmds {
+		hist, _ := h.hists.Load(cmd)
+		counts, total := hist.(*histogram).snapshot()
+		stats = append(stats, Stat{cmd + ":count", strconv.FormatUint(total, 10)})
+		for i, d := range percentiles(counts, total, LatencyPercentiles) {
+			name := cmd + ":p" + strconv.FormatFloat(LatencyPercentiles[i], 'f', -1, 64)
+			stats = append(stats, Stat{name, strconv.FormatFloat(float64(d)/float64(time.Microsecond), 'f', 1, 64)})
+		}
+	}
+	return stats, nil
+}
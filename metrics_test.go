@@ -0,0 +1,68 @@
+package mc
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		v := uint64(r.Int63()) >> uint(r.Intn(63))
+		upper := histUpper(histIndex(v))
+		if upper < v || float64(upper-v) > float64(v)/histSubBuckets {
+			t.Fatalf("bad bucket of %d: upper %d", v, upper)
+		}
+	}
+	if histIndex(1<<63) >= len(histogram{}.counts) {
+		t.Errorf("bucket of the max value is out of range")
+	}
+}
+
+func TestLatencyHistograms(t *testing.T) {
+	h := NewLatencyHistograms()
+	for i := 1; i <= 1000; i++ {
+		h.ObserveLatency("get", time.Duration(i)*time.Microsecond)
+	}
+
+	for _, c := range []struct {
+		p float64
+		d time.Duration
+	}{{50, 500 * time.Microsecond}, {99, 990 * time.Microsecond}, {100, 1000 * time.Microsecond}} {
+		got := h.Percentile("get", c.p)
+		if got < c.d || got > c.d+c.d/histSubBuckets {
+			t.Errorf("p%v: expected about %v, got %v", c.p, c.d, got)
+		}
+	}
+	if h.Percentile("set", 50) != 0 {
+		t.Errorf("expected 0 for unobserved commands")
+	}
+
+	stats, err := MultiStats(NewMemoryStore(MemoryStoreOptions{}), h).Stats(context.Background(), "latency")
+	if err != nil || len(stats) != 5 || stats[0] != (Stat{"get:count", "1000"}) || stats[4].Name != "get:p99.9" {
+		t.Errorf("unexpected stats: %v %v", stats, err)
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+	h := NewLatencyHistograms()
+	s.SetMetrics(h)
+	s.RegisterFunc("get", DefaultGet)
+	s.RegisterFunc("stats", StatsHandler(h))
+
+	roundTrip(t, addr, "get a\r\n")
+	if line := roundTrip(t, addr, "stats latency\r\n"); !strings.HasPrefix(line, "STAT get:count 1") {
+		t.Errorf("unexpected stats: %q", line)
+	}
+
+	s.SetMetrics(nil)
+	roundTrip(t, addr, "get a\r\n")
+	if line := roundTrip(t, addr, "stats latency\r\n"); !strings.HasPrefix(line, "STAT get:count 1") {
+		t.Errorf("metrics should be disabled: %q", line)
+	}
+}
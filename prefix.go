@@ -0,0 +1,179 @@
+package mc
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// PrefixStats are the statistics of the items whose keys have a prefix,
+// see MemoryStoreOptions.PrefixDelimiter.
+type PrefixStats struct {
+	Items int
+	Bytes int64 // estimated memory used by the items
+	Gets  uint64
+	Hits  uint64
+}
+
+// HitRatio returns the proportion of lookups which found items, 0 if there were none.
+func (p PrefixStats) HitRatio() float64 {
+	if p.Gets == 0 {
+		return 0
+	}
+	return float64(p.Hits) / float64(p.Gets)
+}
+
+// keyPrefix returns the part of key before the first delim, or false if key doesn't have delim.
+func keyPrefix(key, delim string) (string, bool) {
+	if i := strings.Index(key, delim); i >= 0 {
+		return key[:i], true
+	}
+	return "", false
+}
+
+// prefixUsage is the memory used by the items of a prefix in a shard.
+type prefixUsage struct {
+	items int
+	bytes int64
+	keys  []string // for sampling, only if the prefix has a quota
+}
+
+// maxPrefixLookups is the max number of prefixes whose lookups are counted besides the ones
+// with quotas, so misses of random prefixes can't grow the statistics without limit.
+const maxPrefixLookups = 1024
+
+// prefixLookups counts the lookups of keys of a prefix. Counters are accessed atomically.
+type prefixLookups struct {
+	gets, hits uint64
+}
+
+// prefixStats keeps the statistics of key prefixes of a MemoryStore.
+// The memory used by prefixes is kept by shards, see shard.account.
+type prefixStats struct {
+	count   int64 // prefixes in lookups, accessed atomically, first so it is 64-bit aligned
+	delim   string
+	quotas  map[string]PrefixQuota // prefixes which are always counted
+	lookups sync.Map               // prefix -> *prefixLookups
+}
+
+// lookup counts a lookup of key, and returns the counters of its prefix or nil. Lookups of new
+// prefixes are not counted once there are maxPrefixLookups, unless they have quotas.
+func (p *prefixStats) lookup(key string) *prefixLookups {
+	prefix, ok := keyPrefix(key, p.delim)
+	if !ok {
+		return nil
+	}
+	v, ok := p.lookups.Load(prefix)
+	if !ok {
+		if _, quota := p.quotas[prefix]; !quota && atomic.LoadInt64(&p.count) >= maxPrefixLookups {
+			return nil
+		}
+		var loaded bool
+		if v, loaded = p.lookups.LoadOrStore(prefix, &prefixLookups{}); !loaded {
+			atomic.AddInt64(&p.count, 1)
+		}
+	}
+	c := v.(*prefixLookups)
+	atomic.AddUint64(&c.gets, 1)
+	return c
+}
+
+// reset resets the lookup counters.
+func (p *prefixStats) reset() {
+	p.lookups.Range(func(k, v interface{}) bool {
+		if _, loaded := p.lookups.LoadAndDelete(k); loaded {
+			atomic.AddInt64(&p.count, -1)
+		}
+		return true
+	})
+}
+
+// account adds the entry e, or removes it if n is -1, with size to the usage of its prefix.
+// n is 0 when e replaces an entry of the same key. Callers hold sh.mu.
+func (sh *shard) account(e *entry, n int, size int64) {
+	if sh.prefixes == nil {
+		return
+	}
+	prefix, ok := keyPrefix(e.item.Key, sh.delim)
+	if !ok {
+		return
+	}
+	u := sh.prefixes[prefix]
+	if u == nil {
+		u = &prefixUsage{}
+		sh.prefixes[prefix] = u
+	}
+	u.items += n
+	u.bytes += size
+	if _, ok := sh.quotas[prefix]; ok {
+		switch n {
+		case 1:
+			e.prefixPos = len(u.keys)
+			u.keys = append(u.keys, e.item.Key)
+		case -1:
+			last := len(u.keys) - 1
+			if e.prefixPos != last {
+				moved, _ := sh.items.get(u.keys[last])
+				moved.prefixPos = e.prefixPos
+				u.keys[e.prefixPos] = moved.item.Key
+			}
+			u.keys = u.keys[:last]
+		}
+	}
+	if u.items == 0 {
+		delete(sh.prefixes, prefix)
+	}
+}
+
+// PrefixStats returns the statistics of key prefixes, or nil if MemoryStoreOptions.PrefixDelimiter
+// is not set. Prefixes which were looked up but have no items are reported too, up to
+// maxPrefixLookups of them.
+func (s *MemoryStore) PrefixStats() map[string]PrefixStats {
+	if s.prefixes == nil {
+		return nil
+	}
+	stats := make(map[string]PrefixStats)
+	for _, sh := range s.shards() {
+		sh.mu.RLock()
+		for prefix, u := range sh.prefixes {
+			p := stats[prefix]
+			p.Items += u.items
+			p.Bytes += u.bytes
+			stats[prefix] = p
+		}
+		sh.mu.RUnlock()
+	}
+	s.prefixes.lookups.Range(func(k, v interface{}) bool {
+		c := v.(*prefixLookups)
+		p := stats[k.(string)]
+		p.Gets = atomic.LoadUint64(&c.gets)
+		p.Hits = atomic.LoadUint64(&c.hits)
+		stats[k.(string)] = p
+		return true
+	})
+	return stats
+}
+
+// prefixStatsList returns the statistics of prefixes as prefix:<prefix>:<stat>, ordered by prefix.
+func prefixStatsList(stats map[string]PrefixStats) []Stat {
+	prefixes := make([]string, 0, len(stats))
+	for prefix := range stats {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	var list []Stat
+	for _, prefix := range prefixes {
+		p, id := stats[prefix], "prefix:"+prefix+":"
+		list = append(list,
+			Stat{id + "items", strconv.Itoa(p.Items)},
+			Stat{id + "bytes", strconv.FormatInt(p.Bytes, 10)},
+			Stat{id + "get_hits", strconv.FormatUint(p.Hits, 10)},
+			Stat{id + "get_misses", strconv.FormatUint(p.Gets-p.Hits, 10)},
+			Stat{id + "hit_ratio", strconv.FormatFloat(p.HitRatio(), 'f', 4, 64)},
+		)
+	}
+	return list
+}
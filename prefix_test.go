@@ -0,0 +1,87 @@
+package mc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryStorePrefixStats(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore(MemoryStoreOptions{Shards: 4, PrefixDelimiter: ":"})
+	st.Set(ctx, &Item{Key: "user:1", Data: []byte("a")})
+	st.Set(ctx, &Item{Key: "user:2", Data: []byte("bb")})
+	st.Set(ctx, &Item{Key: "user:2", Data: []byte("ccc")})
+	st.Set(ctx, &Item{Key: "session:1", Data: []byte("d")})
+	st.Set(ctx, &Item{Key: "plain", Data: []byte("e")})
+	st.Delete(ctx, "session:1")
+	st.Get(ctx, "user:1")
+	st.Get(ctx, "user:3")
+	st.Get(ctx, "session:1")
+
+	stats := st.PrefixStats()
+	user := PrefixStats{Items: 2, Bytes: int64(len("user:1")+1+len("user:2")+3) + 2*itemOverhead, Gets: 2, Hits: 1}
+	if len(stats) != 2 || stats["user"] != user || stats["session"] != (PrefixStats{Gets: 1}) {
+		t.Errorf("unexpected prefix stats: %+v", stats)
+	}
+	if r := stats["user"].HitRatio(); r != 0.5 {
+		t.Errorf("unexpected hit ratio %v", r)
+	}
+
+	// moved entries keep their prefixes
+	if err := st.Resize(2, 0); err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+	for st.Resizing() {
+		time.Sleep(time.Millisecond)
+	}
+	if p := st.PrefixStats()["user"]; p.Items != 2 || p.Bytes != user.Bytes {
+		t.Errorf("unexpected prefix stats after resize: %+v", p)
+	}
+
+	res := &Response{}
+	StatsHandler(st)(ctx, &Request{Command: "stats", Keys: []string{"prefixes"}}, res)
+	if !strings.HasPrefix(res.Response, "STAT prefix:session:items 0\r\n") ||
+		!strings.Contains(res.Response, "STAT prefix:user:items 2\r\n") ||
+		!strings.Contains(res.Response, "STAT prefix:user:hit_ratio 0.5000\r\n") {
+		t.Errorf("unexpected stats prefixes: %q", res.Response)
+	}
+
+	st.ResetStats(ctx)
+	st.Flush(ctx)
+	if stats := st.PrefixStats(); len(stats) != 0 {
+		t.Errorf("unexpected prefix stats after reset and flush: %+v", stats)
+	}
+	if NewMemoryStore(MemoryStoreOptions{}).PrefixStats() != nil {
+		t.Errorf("prefix stats should be disabled by default")
+	}
+}
+
+func TestMemoryStorePrefixLookupsLimit(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore(MemoryStoreOptions{
+		PrefixDelimiter: ":",
+		PrefixQuotas:    map[string]PrefixQuota{"user": {MaxItems: 10}},
+	})
+	for i := 0; i < 2*maxPrefixLookups; i++ {
+		st.Get(ctx, "random"+strconv.Itoa(i)+":1")
+	}
+	st.Get(ctx, "user:1")
+
+	stats := st.PrefixStats()
+	if len(stats) != maxPrefixLookups+1 {
+		t.Errorf("expected %d prefixes, got %d", maxPrefixLookups+1, len(stats))
+	}
+	if stats["user"].Gets != 1 {
+		t.Errorf("lookups of a prefix with a quota are not counted: %+v", stats["user"])
+	}
+
+	// prefixes are counted again after a reset
+	st.prefixes.reset()
+	st.Get(ctx, "other:1")
+	if st.PrefixStats()["other"].Gets != 1 {
+		t.Errorf("lookups are not counted after a reset")
+	}
+}
@@ -0,0 +1,54 @@
+package mc
+
+import (
+	"runtime/metrics"
+	"time"
+)
+
+// pressureInterval is how often the memory of the process is checked, see
+// MemoryStoreOptions.MemoryTarget.
+const pressureInterval = time.Second
+
+// watchMemory evicts items whenever the memory of the Go runtime exceeds the MemoryTarget,
+// until the store is closed.
+func (s *MemoryStore) watchMemory(ticker *time.Ticker) {
+	defer ticker.Stop()
+
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+		{Name: "/gc/cycles/total:gc-cycles"},
+	}
+	evicted, evictedAt := false, uint64(0)
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			metrics.Read(samples)
+			used := int64(samples[0].Value.Uint64() - samples[1].Value.Uint64())
+			cycles := samples[2].Value.Uint64()
+			// memory of evicted items is freed by the next GC, so the excess is evicted once
+			if used > s.opts.MemoryTarget && (!evicted || cycles != evictedAt) {
+				s.evictBytes(used - s.opts.MemoryTarget)
+				evicted, evictedAt = true, cycles
+			}
+		}
+	}
+}
+
+// evictBytes evicts items of about n bytes, evenly from all shards.
+func (s *MemoryStore) evictBytes(n int64) {
+	now := s.opts.Clock.Now()
+	shards := s.shards()
+	share := (n + int64(len(shards)) - 1) / int64(len(shards))
+	for _, sh := range shards {
+		sh.mu.Lock()
+		for freed := int64(0); freed < share && len(sh.keys) > 0; {
+			e := sh.victim(now)
+			freed += e.size
+			sh.evict(e, now)
+		}
+		sh.mu.Unlock()
+	}
+}
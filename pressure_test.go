@@ -0,0 +1,41 @@
+package mc
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreEvictBytes(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore(MemoryStoreOptions{Shards: 4})
+	for i := 0; i < 1000; i++ {
+		st.Set(ctx, &Item{Key: "k" + strconv.Itoa(i), Data: make([]byte, 100)})
+	}
+	before := st.Bytes()
+	st.evictBytes(before / 2)
+	freed := before - st.Bytes()
+	if freed < before/2 || freed > before/2+4*200 {
+		t.Errorf("freed %d of %d bytes", freed, before)
+	}
+	if c := st.Counters(); c.Evictions != uint64(1000-st.Len()) {
+		t.Errorf("unexpected evictions %d of %d items", c.Evictions, 1000-st.Len())
+	}
+}
+
+func TestMemoryStoreMemoryTarget(t *testing.T) {
+	ctx := context.Background()
+	// any process uses more than 1 byte
+	st := NewMemoryStore(MemoryStoreOptions{Shards: 4, MemoryTarget: 1})
+	defer st.Close()
+	for i := 0; i < 100; i++ {
+		st.Set(ctx, &Item{Key: "k" + strconv.Itoa(i), Data: []byte("v")})
+	}
+	for start := time.Now(); st.Len() > 0 && time.Since(start) < 3*time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := st.Len(); n != 0 {
+		t.Errorf("%d items are left under memory pressure", n)
+	}
+}
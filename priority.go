@@ -0,0 +1,119 @@
+package mc
+
+import (
+	"context"
+	"sync"
+)
+
+// Priority is the class of a request for scheduling under saturation, see Server.MaxAsyncHandlers.
+type Priority int
+
+const (
+	// Foreground is the priority of requests of clients waiting for them, like gets. It is the default.
+	Foreground Priority = iota
+	// Background is the priority of requests which can wait, like sets of cache warmers.
+	Background
+)
+
+// ConnPriority is the priority of the requests of a connection, if Server.RequestPriority is nil.
+// Handlers set it in the session of the connection, e.g. after authenticating a cache warmer.
+var ConnPriority = NewSessionKey[Priority]("priority")
+
+// maxForegroundStreak is how many foreground requests can start in a row while background
+// requests wait, so background requests are not starved.
+const maxForegroundStreak = 8
+
+// MetaPriorityFlag returns a Server.RequestPriority which makes meta commands with the flag
+// background requests, e.g. "mg foo v B" with flag 'B'. The flag should be a letter which the meta
+// protocol doesn't use. It is removed from the request in every mode, not only with
+// MaxAsyncHandlers, so handlers see the flags they know only.
+// Other requests have the priority of their connection, see ConnPriority.
+func MetaPriorityFlag(flag byte) func(ctx context.Context, req *Request) Priority {
+	return func(ctx context.Context, req *Request) Priority {
+		if metaCommands[req.Command] {
+			for i := 1; i < len(req.Keys); i++ {
+				if f := req.Keys[i]; len(f) == 1 && f[0] == flag {
+					req.Keys = append(req.Keys[:i:i], req.Keys[i+1:]...)
+					return Background
+				}
+			}
+		}
+		p, _ := ConnPriority.Get(ctx)
+		return p
+	}
+}
+
+// requestPriority returns the priority of req by RequestPriority or ConnPriority.
+func (s *Server) requestPriority(ctx context.Context, req *Request) Priority {
+	var p Priority
+	if s.RequestPriority != nil {
+		p = s.RequestPriority(ctx, req)
+	} else {
+		p, _ = ConnPriority.Get(ctx)
+	}
+	if p != Foreground {
+		return Background
+	}
+	return Foreground
+}
+
+// scheduler limits the requests which run at a time, and starts waiting foreground requests
+// before background ones.
+type scheduler struct {
+	mu      sync.Mutex
+	free    int
+	waiting [2][]chan struct{} // waiting requests of each priority in order
+	streak  int                // foreground requests started in a row while background ones waited
+}
+
+func newScheduler(n int) *scheduler {
+	return &scheduler{free: n}
+}
+
+// acquire waits until a request of priority p can run.
+func (q *scheduler) acquire(p Priority) {
+	q.mu.Lock()
+	if q.free > 0 {
+		q.free--
+		q.mu.Unlock()
+		return
+	}
+	ready := make(chan struct{})
+	q.waiting[p] = append(q.waiting[p], ready)
+	q.mu.Unlock()
+	<-ready
+}
+
+// release starts the next waiting request when a request finishes.
+func (q *scheduler) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	fg, bg := len(q.waiting[Foreground]), len(q.waiting[Background])
+	p := Foreground
+	switch {
+	case fg == 0 && bg == 0:
+		q.free++
+		return
+	case fg == 0 || bg > 0 && q.streak >= maxForegroundStreak:
+		p = Background
+	}
+	if p == Foreground && bg > 0 {
+		q.streak++
+	} else {
+		q.streak = 0
+	}
+	ready := q.waiting[p][0]
+	q.waiting[p][0] = nil
+	q.waiting[p] = q.waiting[p][1:]
+	close(ready)
+}
+
+// getScheduler returns the scheduler of MaxAsyncHandlers, or nil if there is no limit.
+func (s *Server) getScheduler() *scheduler {
+	s.schedOnce.Do(func() {
+		if s.MaxAsyncHandlers > 0 {
+			s.sched = newScheduler(s.MaxAsyncHandlers)
+		}
+	})
+	return s.sched
+}
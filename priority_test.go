@@ -0,0 +1,111 @@
+package mc
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	q := newScheduler(1)
+	q.acquire(Foreground)
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	queued := func() int {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return len(q.waiting[Foreground]) + len(q.waiting[Background])
+	}
+	wait := func(p Priority, name string) {
+		n := queued()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.acquire(p)
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			q.release()
+		}()
+		for queued() == n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wait(Background, "b1")
+	wait(Background, "b2")
+	for _, name := range []string{"f1", "f2", "f3", "f4", "f5", "f6", "f7", "f8", "f9", "f10"} {
+		wait(Foreground, name)
+	}
+	q.release()
+	wg.Wait()
+
+	want := []string{"f1", "f2", "f3", "f4", "f5", "f6", "f7", "f8", "b1", "f9", "f10", "b2"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("expected order %v, got %v", want, order)
+	}
+	if q.free != 1 {
+		t.Errorf("expected 1 free slot, got %d", q.free)
+	}
+}
+
+func TestMetaPriorityFlag(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.RequestPriority = MetaPriorityFlag('B')
+	ctx := NewSessionContext(context.Background())
+
+	req := &Request{Command: "mg", Key: "foo", Keys: []string{"foo", "v", "B", "T0"}}
+	if p := s.requestPriority(ctx, req); p != Background || !reflect.DeepEqual(req.Keys, []string{"foo", "v", "T0"}) {
+		t.Errorf("unexpected priority %d of %v", p, req.Keys)
+	}
+	if p := s.requestPriority(ctx, &Request{Command: "mg", Keys: []string{"foo", "v"}}); p != Foreground {
+		t.Errorf("unexpected priority %d", p)
+	}
+	ConnPriority.Set(ctx, Background)
+	if p := s.requestPriority(ctx, &Request{Command: "get", Keys: []string{"foo"}}); p != Background {
+		t.Errorf("connection priority is ignored: %d", p)
+	}
+}
+
+func TestMaxAsyncHandlers(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.AsyncRequests = 4
+	s.MaxAsyncHandlers = 1
+	s.RegisterFunc("version", func(ctx context.Context, req *Request, res *Response) error {
+		return res.Version("1")
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer s.Stop()
+
+	if line := roundTrip(t, s.Addr().String(), "version\r\n"); line != "VERSION 1\r\n" {
+		t.Errorf("unexpected response %q", line)
+	}
+	if q := s.getScheduler(); q == nil || q.free != 1 {
+		t.Errorf("slot of the request is not released")
+	}
+}
+
+func TestMetaPriorityFlagStripped(t *testing.T) {
+	for _, async := range []int{0, 4} {
+		s := NewServer("127.0.0.1:0")
+		s.AsyncRequests = async
+		s.RequestPriority = MetaPriorityFlag('B')
+		s.RegisterFunc("mg", func(ctx context.Context, req *Request, res *Response) error {
+			res.Response = "HD " + strings.Join(req.Keys[1:], " ")
+			return nil
+		})
+		if err := s.Start(); err != nil {
+			t.Fatalf("failed to start: %v", err)
+		}
+		if line := roundTrip(t, s.Addr().String(), "mg foo v B\r\n"); line != "HD v\r\n" {
+			t.Errorf("flag is not removed with %d async requests: %q", async, line)
+		}
+		s.Stop()
+	}
+}
@@ -0,0 +1,99 @@
+package mc
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultProtocolErrorWindow is the default of Server.ProtocolErrorWindow.
+const DefaultProtocolErrorWindow = time.Minute
+
+// Kinds of protocol errors of requests, reported by stats and ProtocolErrorMetrics.
+const (
+	ProtoErrUnknownCommand = "unknown_command"
+	ProtoErrLineTooLong    = "line_too_long"
+	ProtoErrBadDataChunk   = "bad_data_chunk"
+	ProtoErrBadFormat      = "bad_command_format"
+)
+
+// protoErrKinds are the kinds of protocol errors in the order of serverCounters.protoErrs.
+var protoErrKinds = [...]string{ProtoErrUnknownCommand, ProtoErrLineTooLong, ProtoErrBadDataChunk, ProtoErrBadFormat}
+
+// ProtocolErrorMetrics is implemented by Metrics which count protocol errors of requests,
+// see Server.SetMetrics.
+type ProtocolErrorMetrics interface {
+	// ObserveProtocolError is called with the kind of every protocol error, like
+	// ProtoErrUnknownCommand.
+	ObserveProtocolError(kind string)
+}
+
+// protoErrIndex returns the index of the kind of err in protoErrKinds.
+func protoErrIndex(err Error) int {
+	switch d := err.Description; {
+	case strings.HasPrefix(d, "unknown command"):
+		return 0
+	case err == ErrLineTooLong:
+		return 1
+	case d == "bad data chunk" || strings.HasPrefix(d, "expected \\"):
+		return 2
+	}
+	return 3
+}
+
+// protocolError counts a protocol error of the connection of st, and returns whether the
+// connection has more than MaxProtocolErrors in ProtocolErrorWindow and must be closed.
+func (s *Server) protocolError(st *connState, err Error) bool {
+	i := protoErrIndex(err)
+	atomic.AddUint64(&s.counters.protoErrs[i], 1)
+	atomic.AddUint64(&st.protoErrs, 1)
+	if m, ok := s.getMetrics().(ProtocolErrorMetrics); ok {
+		m.ObserveProtocolError(protoErrKinds[i])
+	}
+
+	if s.MaxProtocolErrors <= 0 {
+		return false
+	}
+	window := s.ProtocolErrorWindow
+	if window <= 0 {
+		window = DefaultProtocolErrorWindow
+	}
+	now := clockOrSystem(s.Clock).Now()
+	if now.Sub(st.errWindowStart) > window {
+		st.errWindowStart, st.errWindowCount = now, 0
+	}
+	st.errWindowCount++
+	if st.errWindowCount <= s.MaxProtocolErrors {
+		return false
+	}
+	atomic.AddUint64(&s.counters.protoErrCloses, 1)
+	return true
+}
+
+// httpMethods are the methods which start the request lines of HTTP clients.
+var httpMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS", "PATCH", "CONNECT", "TRACE", "PRI"}
+
+// detectProtocol returns the protocol of clients of other protocols, "HTTP" or "TLS", from the
+// buffered bytes of the first request of a connection, or "" for memcached clients. Such clients
+// are closed at once rather than sent a CLIENT_ERROR for every line.
+func detectProtocol(r *bufio.Reader) string {
+	b, _ := r.Peek(r.Buffered())
+	// a TLS handshake record of any TLS version
+	if len(b) > 0 && b[0] == 0x16 && (len(b) == 1 || b[1] == 0x03) {
+		return "TLS"
+	}
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		b = b[:i]
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 3 && strings.HasPrefix(fields[2], "HTTP/") {
+		for _, m := range httpMethods {
+			if fields[0] == m {
+				return "HTTP"
+			}
+		}
+	}
+	return ""
+}
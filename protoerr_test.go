@@ -0,0 +1,165 @@
+package mc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type protoErrCounter struct {
+	mu    sync.Mutex
+	kinds map[string]int
+}
+
+func (c *protoErrCounter) ObserveLatency(cmd string, d time.Duration) {}
+
+func (c *protoErrCounter) ObserveProtocolError(kind string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.kinds[kind]++
+}
+
+func TestProtoErrIndex(t *testing.T) {
+	for _, tt := range []struct {
+		err  Error
+		kind string
+	}{
+		{NewError(`unknown command "GET"`), ProtoErrUnknownCommand},
+		{ErrLineTooLong, ProtoErrLineTooLong},
+		{NewError("bad data chunk"), ProtoErrBadDataChunk},
+		{NewError("expected \\r"), ProtoErrBadDataChunk},
+		{NewError(`too few params to command "set"`), ProtoErrBadFormat},
+	} {
+		if kind := protoErrKinds[protoErrIndex(tt.err)]; kind != tt.kind {
+			t.Errorf("%v: got %s, expected %s", tt.err, kind, tt.kind)
+		}
+	}
+}
+
+func TestMaxProtocolErrors(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.MaxProtocolErrors = 2
+	s.RegisterFunc("get", DefaultGet)
+	m := &protoErrCounter{kinds: map[string]int{}}
+	s.SetMetrics(m)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /\r\nget a\r\nset a 0 0 x\r\nHost: localhost\r\nget a\r\n"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf, _ := io.ReadAll(conn)
+	if strings.Count(string(buf), "CLIENT_ERROR") != 3 || strings.Count(string(buf), "END\r\n") != 1 {
+		t.Errorf("unexpected responses before the connection is closed: %q", buf)
+	}
+
+	stats, _ := s.Stats(context.Background(), "")
+	if statValue(stats, "protocol_errors") != "3" || statValue(stats, "protocol_errors_unknown_command") != "2" ||
+		statValue(stats, "protocol_errors_bad_command_format") != "1" || statValue(stats, "protocol_error_closes") != "1" {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+	m.mu.Lock()
+	if m.kinds[ProtoErrUnknownCommand] != 2 || m.kinds[ProtoErrBadFormat] != 1 {
+		t.Errorf("unexpected metrics: %v", m.kinds)
+	}
+	m.mu.Unlock()
+	s.ResetStats(context.Background())
+	if stats, _ := s.Stats(context.Background(), ""); statValue(stats, "protocol_errors") != "0" {
+		t.Errorf("protocol errors are not reset: %v", stats)
+	}
+}
+
+func TestDetectProtocol(t *testing.T) {
+	for _, tt := range []struct{ in, proto string }{
+		{"GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", "HTTP"},
+		{"PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", "HTTP"},
+		{"\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03", "TLS"},
+		{"get / HTTP/1.1\r\n", ""},
+		{"get a b\r\n", ""},
+		{"GET /\r\n", ""},
+	} {
+		r := bufio.NewReader(strings.NewReader(tt.in))
+		r.Peek(1)
+		if proto := detectProtocol(r); proto != tt.proto {
+			t.Errorf("%q: got %q, expected %q", tt.in, proto, tt.proto)
+		}
+	}
+}
+
+func TestProtocolMismatch(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.RegisterFunc("get", DefaultGet)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer s.Stop()
+
+	for _, in := range []string{"GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", "\x16\x03\x01\x02\x00\x01"} {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		conn.Write([]byte(in))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		// the connection may be reset if the request is not read whole
+		buf, err := io.ReadAll(conn)
+		var nerr net.Error
+		if len(buf) != 0 || errors.As(err, &nerr) && nerr.Timeout() {
+			t.Errorf("%q: expected the connection to be closed without responses, got %q %v", in, buf, err)
+		}
+		conn.Close()
+	}
+	if stats, _ := s.Stats(context.Background(), ""); statValue(stats, "protocol_mismatches") != "2" {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+}
+
+func TestSilent(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.RegisterFunc("get", DefaultGet)
+	vs := s.Virtual("127.0.0.1:0")
+	vs.RegisterFunc("get", DefaultGet)
+	vs.Silent = true
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer s.Stop()
+
+	dial := func(addr string) net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		conn.Write([]byte("get a\r\nbogus\r\nget a\r\n"))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		return conn
+	}
+
+	conn := dial(s.Addr().String())
+	defer conn.Close()
+	want := "END\r\nCLIENT_ERROR MC Protocol error: unknown command \"bogus\"\r\nEND\r\n"
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != want {
+		t.Errorf("unexpected responses: %q %v", buf, err)
+	}
+
+	conn = dial(vs.Addr().String())
+	defer conn.Close()
+	buf, err := io.ReadAll(conn)
+	var nerr net.Error
+	if string(buf) != "END\r\n" || errors.As(err, &nerr) && nerr.Timeout() {
+		t.Errorf("expected the connection to be closed after the first response, got %q %v", buf, err)
+	}
+}
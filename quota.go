@@ -0,0 +1,119 @@
+package mc
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// PrefixQuota limits the items of a key prefix in a MemoryStore, see
+// MemoryStoreOptions.PrefixQuotas. Like MaxBytes, limits are split evenly among shards.
+type PrefixQuota struct {
+	// MaxBytes is the memory limit of the items of the prefix. 0 means no limit.
+	MaxBytes int64
+	// MaxItems is the max number of items of the prefix. 0 means no limit.
+	MaxItems int
+	// Reject fails writes beyond the quota with ErrOutOfMemory, unless expired items of the
+	// prefix make room. By default, other items of the prefix are evicted.
+	Reject bool
+}
+
+// shardQuotas returns the shares of quotas of each of n shards, at least one item or byte.
+func shardQuotas(quotas map[string]PrefixQuota, n int) map[string]PrefixQuota {
+	if len(quotas) == 0 {
+		return nil
+	}
+	shares := make(map[string]PrefixQuota, len(quotas))
+	for prefix, q := range quotas {
+		if q.MaxBytes > 0 {
+			q.MaxBytes = (q.MaxBytes + int64(n) - 1) / int64(n)
+		}
+		if q.MaxItems > 0 {
+			q.MaxItems = (q.MaxItems + n - 1) / n
+		}
+		shares[prefix] = q
+	}
+	return shares
+}
+
+// applyQuota makes room for e within the quota of its prefix by evicting other items of the
+// prefix, or returns ErrOutOfMemory if the quota rejects writes beyond it. Callers hold sh.mu.
+func (sh *shard) applyQuota(e *entry, now time.Time) error {
+	if sh.quotas == nil {
+		return nil
+	}
+	prefix, ok := keyPrefix(e.item.Key, sh.delim)
+	if !ok {
+		return nil
+	}
+	q, ok := sh.quotas[prefix]
+	if !ok {
+		return nil
+	}
+	if q.MaxBytes > 0 && e.size > q.MaxBytes {
+		return ErrTooLarge
+	}
+
+	old, _ := sh.items.get(e.item.Key)
+	for {
+		u := sh.prefixes[prefix]
+		if u == nil {
+			return nil
+		}
+		items, bytes := u.items+1, u.bytes+e.size
+		if old != nil {
+			items, bytes = items-1, bytes-old.size
+		}
+		if (q.MaxItems == 0 || items <= q.MaxItems) && (q.MaxBytes == 0 || bytes <= q.MaxBytes) {
+			return nil
+		}
+		victim := sh.prefixVictim(u, old, now)
+		if victim == nil || q.Reject && !victim.item.Expired(now) {
+			return ErrOutOfMemory
+		}
+		sh.evict(victim, now)
+	}
+}
+
+// evictExpired removes the expired items of a prefix with a quota. Callers hold sh.mu.
+func (sh *shard) evictExpired(prefix string, now time.Time) {
+	u := sh.prefixes[prefix]
+	if u == nil {
+		return
+	}
+	var expired []*entry
+	for _, k := range u.keys {
+		if e, ok := sh.items.get(k); ok && e.item.Expired(now) {
+			expired = append(expired, e)
+		}
+	}
+	for _, e := range expired {
+		sh.evict(e, now)
+	}
+}
+
+// prefixVictim samples some entries of a prefix other than old and returns an expired one or
+// the least recently used one, or nil if there are no others. Callers hold sh.mu.
+func (sh *shard) prefixVictim(u *prefixUsage, old *entry, now time.Time) *entry {
+	var victim *entry
+	for i := 0; i < evictionSamples; i++ {
+		e, _ := sh.items.get(u.keys[sh.rand.Intn(len(u.keys))])
+		if e == old {
+			continue
+		}
+		if e.item.Expired(now) {
+			return e
+		}
+		if victim == nil || atomic.LoadInt64(&e.lastAccess) < atomic.LoadInt64(&victim.lastAccess) {
+			victim = e
+		}
+	}
+	if victim == nil {
+		// every sample was old
+		for _, k := range u.keys {
+			if e, _ := sh.items.get(k); e != old {
+				return e
+			}
+		}
+	}
+	return victim
+}
@@ -0,0 +1,76 @@
+package mc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemoryStorePrefixQuotas(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Unix(1000, 0))
+	st := NewMemoryStore(MemoryStoreOptions{
+		Shards:          1,
+		Clock:           clock,
+		PrefixDelimiter: ":",
+		PrefixQuotas: map[string]PrefixQuota{
+			"batch":   {MaxItems: 10},
+			"session": {MaxBytes: 5 * (itemOverhead + 20), Reject: true},
+		},
+	})
+
+	for i := 0; i < 100; i++ {
+		st.Set(ctx, &Item{Key: "user:" + strconv.Itoa(i), Data: []byte("x")})
+	}
+	for i := 0; i < 100; i++ {
+		if err := st.Set(ctx, &Item{Key: "batch:" + strconv.Itoa(i), Data: []byte("x")}); err != nil {
+			t.Fatalf("Set batch:%d: %v", i, err)
+		}
+		clock.Advance(time.Millisecond)
+	}
+	stats := st.PrefixStats()
+	if stats["batch"].Items != 10 || stats["user"].Items != 100 || st.Counters().Evictions != 90 {
+		t.Errorf("unexpected items after evictions in the quota: %+v", stats)
+	}
+	// the latest item survives evictions of its prefix
+	if _, err := st.Get(ctx, "batch:99"); err != nil {
+		t.Errorf("Get batch:99: %v", err)
+	}
+	// replacing an item doesn't evict others
+	st.Set(ctx, &Item{Key: "batch:99", Data: []byte("y")})
+	if n := st.PrefixStats()["batch"].Items; n != 10 {
+		t.Errorf("unexpected items after a replace: %d", n)
+	}
+
+	// session items are 20 bytes with the key and data, so 5 of them fit
+	for i := 0; i < 5; i++ {
+		key := "session:" + strconv.Itoa(i)
+		item := &Item{Key: key, Data: make([]byte, 20-len(key)), Expiration: clock.Now().Add(time.Duration(i+1) * time.Second)}
+		if err := st.Set(ctx, item); err != nil {
+			t.Fatalf("Set %s: %v", key, err)
+		}
+	}
+	if err := st.Set(ctx, &Item{Key: "session:5", Data: make([]byte, 11)}); !errors.Is(err, ErrOutOfMemory) {
+		t.Errorf("expected ErrOutOfMemory beyond the quota, got %v", err)
+	}
+	if err := st.Set(ctx, &Item{Key: "session:6", Data: make([]byte, 400)}); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge for an item larger than the quota, got %v", err)
+	}
+	// expired items make room
+	clock.Advance(time.Second)
+	if err := st.Set(ctx, &Item{Key: "session:5", Data: make([]byte, 11)}); err != nil {
+		t.Errorf("Set session:5 after session:0 expired: %v", err)
+	}
+	if n := st.PrefixStats()["session"].Items; n != 5 {
+		t.Errorf("unexpected session items: %d", n)
+	}
+}
+
+func TestShardQuotas(t *testing.T) {
+	q := shardQuotas(map[string]PrefixQuota{"a": {MaxBytes: 1000, MaxItems: 3, Reject: true}}, 4)
+	if q["a"] != (PrefixQuota{MaxBytes: 250, MaxItems: 1, Reject: true}) {
+		t.Errorf("unexpected shard quotas: %+v", q)
+	}
+}
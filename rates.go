@@ -0,0 +1,123 @@
+package mc
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// StoreCounters are the numbers of operations of a MemoryStore since it was created.
+type StoreCounters struct {
+	Gets      uint64 // lookups of keys, by Get or MGet
+	Hits      uint64 // lookups which found items
+	Sets      uint64 // stored items
+	Evictions uint64 // unexpired items evicted for room
+}
+
+// Counters returns the counters of the store.
+func (s *MemoryStore) Counters() StoreCounters {
+	s.resizeMu.RLock()
+	defer s.resizeMu.RUnlock()
+	c := s.retired
+	for _, sh := range s.shards() {
+		c.Gets += atomic.LoadUint64(&sh.gets)
+		c.Hits += atomic.LoadUint64(&sh.hits)
+		c.Sets += atomic.LoadUint64(&sh.sets)
+		c.Evictions += atomic.LoadUint64(&sh.evictions)
+	}
+	return c
+}
+
+// ResetStats implements StatsResetter. It resets the counters, admission statistics and lookups
+// of prefixes, but keeps the items.
+func (s *MemoryStore) ResetStats(ctx context.Context) error {
+	s.resizeMu.Lock()
+	defer s.resizeMu.Unlock()
+	s.retired = StoreCounters{}
+	s.retiredAdmitted, s.retiredRejected = 0, 0
+	if s.prefixes != nil {
+		s.prefixes.reset()
+	}
+	for _, sh := range s.shards() {
+		atomic.StoreUint64(&sh.gets, 0)
+		atomic.StoreUint64(&sh.hits, 0)
+		atomic.StoreUint64(&sh.sets, 0)
+		atomic.StoreUint64(&sh.evictions, 0)
+		sh.mu.Lock()
+		sh.admitted, sh.rejected = 0, 0
+		sh.mu.Unlock()
+	}
+	return nil
+}
+
+// Rates are the rates of the counters of a MemoryStore in an interval.
+type Rates struct {
+	// Interval is the length of the interval. It is 0 before the first interval ends.
+	Interval     time.Duration
+	GetsPerSec   float64
+	SetsPerSec   float64
+	EvictsPerSec float64
+	// HitRatio is the proportion of lookups which found items, 0 if there were none.
+	HitRatio float64
+}
+
+// Rates returns the rates of the last interval computed by the ticker enabled by
+// MemoryStoreOptions.RateInterval.
+func (s *MemoryStore) Rates() Rates {
+	return s.rates.Load().(Rates)
+}
+
+// Close stops the background work of the store.
+func (s *MemoryStore) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return nil
+}
+
+// computeRates snapshots the counters every tick until the store is closed.
+// The counters are zero at start, and after resets, which count from zero in their interval.
+func (s *MemoryStore) computeRates(ticker *time.Ticker, start time.Time) {
+	defer ticker.Stop()
+
+	var last StoreCounters
+	lastTime := start
+	for {
+		select {
+		case <-s.closed:
+			return
+		case now := <-ticker.C:
+			c := s.Counters()
+			if c.Gets < last.Gets || c.Hits < last.Hits || c.Sets < last.Sets || c.Evictions < last.Evictions {
+				last = StoreCounters{}
+			}
+			s.rates.Store(newRates(last, c, now.Sub(lastTime)))
+			last, lastTime = c, now
+		}
+	}
+}
+
+// newRates computes the rates of counters which changed from prev to cur in d.
+func newRates(prev, cur StoreCounters, d time.Duration) Rates {
+	secs := d.Seconds()
+	r := Rates{
+		Interval:     d,
+		GetsPerSec:   float64(cur.Gets-prev.Gets) / secs,
+		SetsPerSec:   float64(cur.Sets-prev.Sets) / secs,
+		EvictsPerSec: float64(cur.Evictions-prev.Evictions) / secs,
+	}
+	if gets := cur.Gets - prev.Gets; gets > 0 {
+		r.HitRatio = float64(cur.Hits-prev.Hits) / float64(gets)
+	}
+	return r
+}
+
+// stats returns the rates as stats.
+func (r Rates) stats() []Stat {
+	return []Stat{
+		{"interval", strconv.FormatFloat(r.Interval.Seconds(), 'f', 3, 64)},
+		{"get_rate", strconv.FormatFloat(r.GetsPerSec, 'f', 2, 64)},
+		{"set_rate", strconv.FormatFloat(r.SetsPerSec, 'f', 2, 64)},
+		{"eviction_rate", strconv.FormatFloat(r.EvictsPerSec, 'f', 2, 64)},
+		{"hit_ratio", strconv.FormatFloat(r.HitRatio, 'f', 4, 64)},
+	}
+}
@@ -0,0 +1,64 @@
+package mc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewRates(t *testing.T) {
+	r := newRates(StoreCounters{Gets: 10, Hits: 5}, StoreCounters{Gets: 30, Hits: 20, Sets: 4, Evictions: 2}, 2*time.Second)
+	if r.GetsPerSec != 10 || r.SetsPerSec != 2 || r.EvictsPerSec != 1 || r.HitRatio != 0.75 {
+		t.Errorf("unexpected rates: %+v", r)
+	}
+	if r := newRates(StoreCounters{}, StoreCounters{}, time.Second); r.HitRatio != 0 {
+		t.Errorf("expected no hit ratio without gets: %+v", r)
+	}
+}
+
+func TestMemoryStoreRates(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore(MemoryStoreOptions{RateInterval: 200 * time.Millisecond})
+	defer st.Close()
+
+	st.Set(ctx, &Item{Key: "a", Data: []byte("1")})
+	st.Get(ctx, "a")
+	st.Get(ctx, "b")
+	if c := st.Counters(); c.Gets != 2 || c.Hits != 1 || c.Sets != 1 {
+		t.Errorf("unexpected counters: %+v", c)
+	}
+
+	// the first interval has the operations
+	r := st.Rates()
+	for start := time.Now(); r.Interval == 0 && time.Since(start) < time.Second; r = st.Rates() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if r.Interval == 0 || r.GetsPerSec <= 0 || r.HitRatio != 0.5 {
+		t.Errorf("unexpected rates: %+v", r)
+	}
+
+	res := &Response{}
+	StatsHandler(st)(ctx, &Request{Command: "stats", Keys: []string{"rates"}}, res)
+	if !strings.Contains(res.Response, "STAT hit_ratio 0.5000\r\n") {
+		t.Errorf("unexpected stats rates: %q", res.Response)
+	}
+}
+
+func TestMemoryStoreResetStats(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore(MemoryStoreOptions{Shards: 2})
+	st.Set(ctx, &Item{Key: "a", Data: []byte("1")})
+	st.Get(ctx, "a")
+
+	res := &Response{}
+	if err := StatsHandler(st)(ctx, &Request{Command: "stats", Keys: []string{"reset"}}, res); err != nil || res.Response != RespReset {
+		t.Fatalf("unexpected response: %q %v", res.Response, err)
+	}
+	if c := st.Counters(); c != (StoreCounters{}) {
+		t.Errorf("counters are not reset: %+v", c)
+	}
+	if st.Len() != 1 {
+		t.Errorf("items should be kept, got %d", st.Len())
+	}
+}
@@ -0,0 +1,249 @@
+package mc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// captureMagic is the header of capture files.
+const captureMagic = "MCCAP1\n"
+
+// CaptureRecord is a chunk of raw traffic of a connection.
+type CaptureRecord struct {
+	// Offset is the time since the connection was accepted.
+	Offset time.Duration
+	// FromClient is true for the traffic sent by the client.
+	FromClient bool
+	Data       []byte
+}
+
+// Recorder captures raw traffic of every connection into its own file in Dir.
+//
+// Each file starts with a magic header followed by records of
+// <offset nanoseconds int64><direction byte><length uint32><data>, all in big endian.
+type Recorder struct {
+	Dir string
+
+	seq uint64
+}
+
+// NewRecorder creates a recorder which writes capture files into dir.
+func NewRecorder(dir string) *Recorder {
+	return &Recorder{Dir: dir}
+}
+
+// SetRecorder sets the recorder capturing new connections. Set it to nil to stop capturing.
+// Connections accepted before are not affected.
+func (s *Server) SetRecorder(rec *Recorder) {
+	s.recorder.Store(rec)
+}
+
+// wrapConn returns conn which records traffic if a recorder is set.
+func (s *Server) wrapConn(conn net.Conn) net.Conn {
+	rec, _ := s.recorder.Load().(*Recorder)
+	if rec == nil {
+		return conn
+	}
+	rc, err := rec.Wrap(conn)
+	if err != nil {
+		log.Printf("failed to record connection from %s: %v", conn.RemoteAddr(), err)
+		return conn
+	}
+	return rc
+}
+
+// Wrap returns a connection which records all traffic of conn.
+func (rec *Recorder) Wrap(conn net.Conn) (net.Conn, error) {
+	name := fmt.Sprintf("%d-%d.mcap", time.Now().UnixNano(), atomic.AddUint64(&rec.seq, 1))
+	f, err := os.Create(filepath.Join(rec.Dir, name))
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	if _, err := w.WriteString(captureMagic); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &recordingConn{Conn: conn, f: f, w: w, start: time.Now()}, nil
+}
+
+// recordingConn is a net.Conn which records its traffic.
+type recordingConn struct {
+	net.Conn
+
+	mu     sync.Mutex
+	f      *os.File
+	w      *bufio.Writer
+	start  time.Time
+	closed bool
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.record(true, b[:n])
+	}
+	return n, err
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.record(false, b[:n])
+	}
+	return n, err
+}
+
+func (c *recordingConn) Close() error {
+	err := c.Conn.Close()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		c.w.Flush()
+		c.f.Close()
+	}
+	return err
+}
+
+func (c *recordingConn) record(fromClient bool, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	writeCaptureRecord(c.w, CaptureRecord{
+		Offset:     time.Since(c.start),
+		FromClient: fromClient,
+		Data:       data,
+	})
+}
+
+func writeCaptureRecord(w io.Writer, rec CaptureRecord) error {
+	var hdr [13]byte
+	binary.BigEndian.PutUint64(hdr[0:8], uint64(rec.Offset))
+	if rec.FromClient {
+		hdr[8] = 1
+	}
+	binary.BigEndian.PutUint32(hdr[9:13], uint32(len(rec.Data)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(rec.Data)
+	return err
+}
+
+// ReadCapture reads all records of a capture file.
+func ReadCapture(path string) ([]CaptureRecord, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(captureMagic) || string(data[:len(captureMagic)]) != captureMagic {
+		return nil, errors.New("not a capture file: " + path)
+	}
+	data = data[len(captureMagic):]
+
+	var records []CaptureRecord
+	for len(data) > 0 {
+		if len(data) < 13 {
+			return records, io.ErrUnexpectedEOF
+		}
+		n := int(binary.BigEndian.Uint32(data[9:13]))
+		if len(data) < 13+n {
+			return records, io.ErrUnexpectedEOF
+		}
+		records = append(records, CaptureRecord{
+			Offset:     time.Duration(binary.BigEndian.Uint64(data[0:8])),
+			FromClient: data[8] == 1,
+			Data:       data[13 : 13+n],
+		})
+		data = data[13+n:]
+	}
+	return records, nil
+}
+
+// Replay sends client traffic of capture files to the memcached server at addr,
+// one connection per file. Responses are read and discarded.
+// speed scales the original timing: 1 replays at the original speed, 2 twice as fast,
+// and 0 sends as fast as possible.
+func Replay(ctx context.Context, addr string, speed float64, paths ...string) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(paths))
+	for _, path := range paths {
+		records, err := ReadCapture(path)
+		if err != nil {
+			return err
+		}
+
+		wg.Add(1)
+		go func(records []CaptureRecord) {
+			defer wg.Done()
+			if err := replayConn(ctx, addr, speed, records); err != nil {
+				errs <- err
+			}
+		}(records)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+func replayConn(ctx context.Context, addr string, speed float64, records []CaptureRecord) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, conn)
+		close(done)
+	}()
+
+	start := time.Now()
+	for _, rec := range records {
+		if !rec.FromClient {
+			continue
+		}
+		if speed > 0 {
+			wait := time.Duration(float64(rec.Offset)/speed) - time.Since(start)
+			if wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+		if _, err := conn.Write(rec.Data); err != nil {
+			return err
+		}
+	}
+
+	// wait a little for the last responses
+	if tc, ok := conn.(*net.TCPConn); ok {
+		tc.CloseWrite()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+		}
+	}
+	return nil
+}
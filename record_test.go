@@ -0,0 +1,52 @@
+package mc
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, addr := startTestServer(t)
+	defer s.Stop()
+	var sets int32
+	s.RegisterFunc("set", func(ctx context.Context, req *Request, res *Response) error {
+		atomic.AddInt32(&sets, 1)
+		res.Response = RespStored
+		return nil
+	})
+
+	s.SetRecorder(NewRecorder(dir))
+	roundTrip(t, addr, "set foo 0 0 3\r\nbar\r\n")
+	s.SetRecorder(nil)
+	time.Sleep(100 * time.Millisecond)
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.mcap"))
+	if len(paths) != 1 {
+		t.Fatalf("expected 1 capture file, got %d", len(paths))
+	}
+	records, err := ReadCapture(paths[0])
+	if err != nil {
+		t.Fatalf("failed to read capture: %v", err)
+	}
+	if len(records) != 2 || !records[0].FromClient || string(records[1].Data) != "STORED\r\n" {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+
+	if err := Replay(context.Background(), addr, 0, paths...); err != nil {
+		t.Fatalf("failed to replay: %v", err)
+	}
+	if n := atomic.LoadInt32(&sets); n != 2 {
+		t.Errorf("expected 2 sets, got %d", n)
+	}
+}
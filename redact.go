@@ -0,0 +1,209 @@
+package mc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// Redactor masks keys and values before they leave the process, for example in logs and taps.
+type Redactor interface {
+	RedactKey(key string) string
+	RedactValue(data []byte) []byte
+}
+
+// HashRedactor replaces keys with a prefix of their SHA-256 hashes and values with their sizes.
+// The same key is always redacted to the same string so logs can still be correlated.
+type HashRedactor struct{}
+
+// RedactKey returns "sha256:" and the first 16 hex digits of the key hash.
+func (HashRedactor) RedactKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// RedactValue returns a placeholder with the size of data.
+func (HashRedactor) RedactValue(data []byte) []byte {
+	if data == nil {
+		return nil
+	}
+	return []byte("<" + strconv.Itoa(len(data)) + " bytes>")
+}
+
+// redactorHolder lets atomic.Value store different Redactor implementations.
+type redactorHolder struct {
+	r Redactor
+}
+
+// SetRedactor sets the redactor used by logs and taps of this server. Set it to nil to disable redaction.
+func (s *Server) SetRedactor(r Redactor) {
+	s.redactor.Store(redactorHolder{r})
+}
+
+// getRedactor returns the redactor set by SetRedactor.
+func (s *Server) getRedactor() Redactor {
+	h, _ := s.redactor.Load().(redactorHolder)
+	return h.r
+}
+
+// RedactRequest returns a copy of req whose keys and data are redacted by r.
+// It returns req itself if r is nil.
+func RedactRequest(r Redactor, req *Request) *Request {
+	if r == nil || req == nil {
+		return req
+	}
+	c := *req
+	if c.Key != "" {
+		c.Key = r.RedactKey(c.Key)
+	}
+	if c.Keys != nil {
+		c.Keys = make([]string, len(req.Keys))
+		for i, k := range req.Keys {
+			c.Keys[i] = r.RedactKey(k)
+		}
+	}
+	c.Data = r.RedactValue(c.Data)
+	c.Raw = r.RedactValue(c.Raw)
+	if c.Batch != nil {
+		c.Batch = make([]*Request, len(req.Batch))
+		for i, item := range req.Batch {
+			c.Batch[i] = RedactRequest(r, item)
+		}
+	}
+	return &c
+}
+
+// RedactResponse returns a copy of res whose keys and data of values, and meta data block, are
+// redacted by r.
+// It returns res itself if r is nil.
+func RedactResponse(r Redactor, res *Response) *Response {
+	if r == nil || res == nil {
+		return res
+	}
+	c := *res
+	c.Values = make([]Value, len(res.Values))
+	for i, v := range res.Values {
+		v.Key = r.RedactKey(v.Key)
+		v.Data = r.RedactValue(v.Data)
+		c.Values[i] = v
+	}
+	if res.Data != nil {
+		c.Data = r.RedactValue(res.Data)
+	}
+	return &c
+}
+
+// Limits of FormatRequest and FormatResponse, so that requests and responses of any size make
+// short log lines.
+const (
+	maxLoggedKeys = 8
+	maxLoggedData = 32
+)
+
+// FormatRequest formats req for logs, like `set k flags=0 exptime=60 bytes=5 data="hello"`.
+// At most maxLoggedKeys keys and maxLoggedData bytes of data are shown. Keys and data are redacted
+// by r if it is not nil, while sizes are of the original data.
+func FormatRequest(r Redactor, req *Request) string {
+	if req == nil {
+		return "<nil>"
+	}
+	var b strings.Builder
+	b.WriteString(req.Command)
+	// generic commands have the key also in Keys, with their other arguments
+	if req.Key != "" && len(req.Keys) == 0 {
+		b.WriteByte(' ')
+		b.WriteString(redactKey(r, req.Key))
+	}
+	writeKeys(&b, r, req.Keys)
+	if req.Flags != "" {
+		b.WriteString(" flags=" + req.Flags)
+	}
+	if req.Exptime != 0 {
+		b.WriteString(" exptime=" + strconv.FormatInt(req.Exptime, 10))
+	}
+	if req.Value != 0 {
+		b.WriteString(" value=" + strconv.FormatUint(req.Value, 10))
+	}
+	if req.Cas != "" {
+		b.WriteString(" cas=" + req.Cas)
+	}
+	if req.Data != nil {
+		b.WriteString(" bytes=" + strconv.Itoa(len(req.Data)) + " data=")
+		writeLoggedData(&b, r, req.Data)
+	}
+	if req.Batch != nil {
+		b.WriteString(" batch=" + strconv.Itoa(len(req.Batch)))
+	}
+	if req.Noreply {
+		b.WriteString(" noreply")
+	}
+	return b.String()
+}
+
+// FormatResponse formats res for logs, like `END [VALUE k 0 5 "hello"]`, with the limits and
+// redaction of FormatRequest.
+func FormatResponse(r Redactor, res *Response) string {
+	if res == nil {
+		return "<nil>"
+	}
+	var b strings.Builder
+	b.WriteString(res.Response)
+	if res.Data != nil {
+		b.WriteByte(' ')
+		writeLoggedData(&b, r, res.Data)
+	}
+	if len(res.Values) == 0 {
+		return b.String()
+	}
+	b.WriteString(" [")
+	for i := range res.Values {
+		if i == maxLoggedKeys {
+			b.WriteString(", ... " + strconv.Itoa(len(res.Values)-i) + " more")
+			break
+		}
+		v := &res.Values[i]
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("VALUE " + redactKey(r, v.Key) + " " + v.Flags + " " + strconv.Itoa(len(v.Data)))
+		if v.Cas != "" {
+			b.WriteString(" " + v.Cas)
+		}
+		b.WriteByte(' ')
+		writeLoggedData(&b, r, v.Data)
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+func redactKey(r Redactor, key string) string {
+	if r == nil {
+		return key
+	}
+	return r.RedactKey(key)
+}
+
+// writeKeys writes up to maxLoggedKeys keys and the number of the others.
+func writeKeys(b *strings.Builder, r Redactor, keys []string) {
+	for i, k := range keys {
+		if i == maxLoggedKeys {
+			b.WriteString(" ... " + strconv.Itoa(len(keys)-i) + " more")
+			return
+		}
+		b.WriteByte(' ')
+		b.WriteString(redactKey(r, k))
+	}
+}
+
+// writeLoggedData writes data quoted, redacted by r or truncated to maxLoggedData bytes.
+func writeLoggedData(b *strings.Builder, r Redactor, data []byte) {
+	if r != nil {
+		data = r.RedactValue(data)
+	}
+	if len(data) <= maxLoggedData {
+		b.WriteString(strconv.Quote(string(data)))
+		return
+	}
+	b.WriteString(strconv.Quote(string(data[:maxLoggedData])) + "...")
+}
@@ -0,0 +1,132 @@
+package mc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactRequest(t *testing.T) {
+	req := &Request{Command: "set", Key: "user:42", Data: []byte("secret")}
+	red := RedactRequest(HashRedactor{}, req)
+
+	if red.Key == req.Key || red.Key != (HashRedactor{}).RedactKey("user:42") {
+		t.Errorf("key is not redacted: %s", red.Key)
+	}
+	if string(red.Data) != "<6 bytes>" {
+		t.Errorf("data is not redacted: %s", red.Data)
+	}
+	if req.Key != "user:42" || string(req.Data) != "secret" {
+		t.Errorf("original request is modified: %+v", req)
+	}
+	if RedactRequest(nil, req) != req {
+		t.Errorf("nil redactor should return the request itself")
+	}
+}
+
+func TestRedactTap(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.SetRedactor(HashRedactor{})
+	tap := s.Tap(TapOptions{})
+	defer tap.Close()
+
+	res := &Response{Values: []Value{{Key: "user:42", Data: []byte("secret")}}}
+	s.publishTaps(nil, &Request{Command: "get", Keys: []string{"user:42"}}, res)
+
+	e := <-tap.C
+	if e.Request.Keys[0] == "user:42" || e.Response.Values[0].Key == "user:42" || string(e.Response.Values[0].Data) == "secret" {
+		t.Errorf("tap event is not redacted: %s", e)
+	}
+
+	res = &Response{}
+	res.MetaValue([]byte("secret"), "f0")
+	s.publishTaps(nil, &Request{Command: "mg", Key: "user:42", Keys: []string{"user:42", "v", "f"}}, res)
+	if e := <-tap.C; string(e.Response.Data) != "<6 bytes>" {
+		t.Errorf("meta value is not redacted: %q", e.Response.Data)
+	}
+}
+
+func TestFormatRequest(t *testing.T) {
+	keys := []string{"k1", "k2", "k3", "k4", "k5", "k6", "k7", "k8", "k9", "k10"}
+	data := []byte(strings.Repeat("x", 100))
+	cases := []struct {
+		req  *Request
+		want string
+	}{
+		{&Request{Command: "set", Key: "k", Flags: "0", Exptime: 60, Data: []byte("hello"), Noreply: true},
+			`set k flags=0 exptime=60 bytes=5 data="hello" noreply`},
+		{&Request{Command: "get", Keys: keys}, "get k1 k2 k3 k4 k5 k6 k7 k8 ... 2 more"},
+		{&Request{Command: "incr", Key: "n", Value: 5}, "incr n value=5"},
+		{&Request{Command: "verbosity", Key: "1", Keys: []string{"1"}, Noreply: true}, "verbosity 1 noreply"},
+		{&Request{Command: "cas", Key: "k", Flags: "1", Cas: "9", Data: data},
+			`cas k flags=1 cas=9 bytes=100 data="` + strings.Repeat("x", maxLoggedData) + `"...`},
+	}
+	for _, c := range cases {
+		if s := c.req.String(); s != c.want {
+			t.Errorf("expected %s, got %s", c.want, s)
+		}
+	}
+
+	s := FormatRequest(HashRedactor{}, &Request{Command: "set", Key: "user:42", Data: []byte("secret")})
+	if strings.Contains(s, "user:42") || strings.Contains(s, "secret") || !strings.Contains(s, `bytes=6 data="<6 bytes>"`) {
+		t.Errorf("request is not redacted: %s", s)
+	}
+}
+
+func TestFormatResponse(t *testing.T) {
+	res := &Response{Response: "END", Values: []Value{{Key: "k1", Flags: "0", Data: []byte("abc")}, {Key: "k2", Flags: "1", Data: []byte("de"), Cas: "7"}}}
+	if s := FormatResponse(nil, res); s != `END [VALUE k1 0 3 "abc", VALUE k2 1 2 7 "de"]` {
+		t.Errorf("unexpected response: %s", s)
+	}
+	if s := FormatResponse(nil, &Response{Response: "STORED"}); s != "STORED" {
+		t.Errorf("unexpected response: %s", s)
+	}
+	if s := FormatResponse(HashRedactor{}, res); strings.Contains(s, "k1") || strings.Contains(s, "abc") {
+		t.Errorf("response is not redacted: %s", s)
+	}
+
+	meta := &Response{}
+	meta.MetaValue([]byte("secret"), "f0")
+	if s := FormatResponse(nil, meta); s != `VA 6 f0 "secret"` {
+		t.Errorf("unexpected meta response: %s", s)
+	}
+	if s := FormatResponse(HashRedactor{}, meta); s != `VA 6 f0 "<6 bytes>"` {
+		t.Errorf("meta response is not redacted: %s", s)
+	}
+}
+
+func TestRedactTapBatch(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.SetRedactor(HashRedactor{})
+	tap := s.Tap(TapOptions{})
+	defer tap.Close()
+	hidden := s.Tap(TapOptions{HideData: true, Redactor: nopRedactor{}})
+	defer hidden.Close()
+
+	req := &Request{Command: "mset", Batch: []*Request{
+		{Command: "set", Key: "user:1", Data: []byte("secret1")},
+		{Command: "set", Key: "user:2", Data: []byte("secret2")},
+	}}
+	s.publishTaps(nil, req, &Response{Response: RespEnd})
+
+	e := <-tap.C
+	for i, item := range e.Request.Batch {
+		if strings.HasPrefix(item.Key, "user:") || strings.HasPrefix(string(item.Data), "secret") {
+			t.Errorf("batch item %d is not redacted: %s %s", i, item.Key, item.Data)
+		}
+	}
+	e = <-hidden.C
+	for i, item := range e.Request.Batch {
+		if item.Data != nil {
+			t.Errorf("data of batch item %d is not hidden: %s", i, item.Data)
+		}
+	}
+	if req.Batch[0].Key != "user:1" || string(req.Batch[1].Data) != "secret2" {
+		t.Errorf("original batch is modified: %+v %+v", req.Batch[0], req.Batch[1])
+	}
+}
+
+// nopRedactor keeps keys and values.
+type nopRedactor struct{}
+
+func (nopRedactor) RedactKey(key string) string    { return key }
+func (nopRedactor) RedactValue(data []byte) []byte { return data }
@@ -0,0 +1,232 @@
+package mc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// replicaSweepMin is the min number of fills of a replica between sweeps of its cas map.
+const replicaSweepMin = 1024
+
+// ReadReplica returns a Store for near-cache replicas of read-heavy deployments: reads are
+// served by local, and misses are read through from primary and cached in local.
+// Writes go to primary first and are applied to local only if primary succeeds,
+// so local never has writes which primary rejected.
+// primary is usually an adapter of a client of the upstream server.
+//
+// The replica is an Updater and an Incrementer, so commands which read and modify items, like
+// incr, append and cas, run on primary rather than on the possibly stale local copy. They are
+// rejected with ErrNotSupported if primary doesn't implement these interfaces. Items read from
+// local have the cas of primary, so the cas of gets can be checked by primary.
+func ReadReplica(local, primary Store) Store {
+	return &readReplica{sweepAt: replicaSweepMin, local: local, primary: primary}
+}
+
+type readReplica struct {
+	// accessed atomically, first so they are 64-bit aligned on 32-bit platforms
+	fills   int64 // fills since the last sweep
+	sweepAt int64 // fills which start the next sweep
+
+	local, primary Store
+	// cas has the copies cached by the replica, and loses them as local evicts them, see sweep
+	cas sync.Map // key -> casPair of the local copy
+}
+
+// casPair is the cas of a local copy and the cas of the item of primary it copies.
+type casPair struct {
+	local, primary uint64
+}
+
+// primaryCas sets the cas of primary to a local copy, unless local has changed it since.
+func (r *readReplica) primaryCas(it *Item) {
+	if v, ok := r.cas.Load(it.Key); ok && v.(casPair).local == it.Cas {
+		it.Cas = v.(casPair).primary
+	}
+}
+
+func (r *readReplica) Get(ctx context.Context, key string) (*Item, error) {
+	it, err := r.local.Get(ctx, key)
+	if err == nil {
+		r.primaryCas(it)
+		return it, nil
+	}
+	if err != ErrNotFound {
+		return nil, err
+	}
+	// the copy is gone, like evicted or expired
+	r.cas.Delete(key)
+	if it, err = r.primary.Get(ctx, key); err != nil {
+		return nil, err
+	}
+	r.fill(ctx, it)
+	return it, nil
+}
+
+func (r *readReplica) MGet(ctx context.Context, keys []string) (map[string]*Item, error) {
+	items, err := r.local.MGet(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, key := range keys {
+		if it, ok := items[key]; ok {
+			r.primaryCas(it)
+		} else {
+			r.cas.Delete(key)
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return items, nil
+	}
+
+	fetched, err := r.primary.MGet(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for key, it := range fetched {
+		r.fill(ctx, it)
+		items[key] = it
+	}
+	return items, nil
+}
+
+// fill caches an item read from primary in local. Failures only cost a later miss.
+func (r *readReplica) fill(ctx context.Context, it *Item) {
+	cp := *it
+	if r.local.Set(ctx, &cp) != nil {
+		r.cas.Delete(it.Key)
+		return
+	}
+	r.cas.Store(it.Key, casPair{local: cp.Cas, primary: it.Cas})
+	if atomic.AddInt64(&r.fills, 1) == atomic.LoadInt64(&r.sweepAt) {
+		go r.sweep(context.Background())
+	}
+}
+
+// sweep removes the cas pairs of copies which local has evicted, expired or replaced, so the cas
+// map doesn't grow to the keys of primary. It runs after as many fills as there were pairs left by
+// the previous sweep, so its cost is amortized by fills.
+func (r *readReplica) sweep(ctx context.Context) {
+	pairs := make(map[string]casPair)
+	var keys []string
+	r.cas.Range(func(k, v interface{}) bool {
+		pairs[k.(string)] = v.(casPair)
+		keys = append(keys, k.(string))
+		return true
+	})
+	left := int64(len(keys))
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > 100 {
+			batch = batch[:100]
+		}
+		keys = keys[len(batch):]
+		// peek doesn't count as accesses of the copies, which would keep them from eviction
+		items, err := peek(ctx, r.local, batch)
+		if err != nil {
+			break
+		}
+		for _, key := range batch {
+			if it, ok := items[key]; ok && it.Cas == pairs[key].local {
+				continue
+			}
+			// pairs stored by fills since the snapshot are kept
+			if v, ok := r.cas.Load(key); ok && v.(casPair) == pairs[key] {
+				r.cas.Delete(key)
+				left--
+			}
+		}
+	}
+	if left < replicaSweepMin {
+		left = replicaSweepMin
+	}
+	atomic.StoreInt64(&r.sweepAt, left)
+	atomic.StoreInt64(&r.fills, 0)
+}
+
+func (r *readReplica) Set(ctx context.Context, item *Item) error {
+	if err := r.primary.Set(ctx, item); err != nil {
+		return err
+	}
+	r.fill(ctx, item)
+	return nil
+}
+
+func (r *readReplica) MSet(ctx context.Context, items []*Item) error {
+	if err := r.primary.MSet(ctx, items); err != nil {
+		return err
+	}
+	for _, it := range items {
+		r.fill(ctx, it)
+	}
+	return nil
+}
+
+func (r *readReplica) Delete(ctx context.Context, key string) error {
+	err := r.primary.Delete(ctx, key)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	// a missing item upstream must not be served locally either
+	r.local.Delete(ctx, key)
+	r.cas.Delete(key)
+	return err
+}
+
+// Update runs fn atomically on primary and then refreshes the local copy of key.
+func (r *readReplica) Update(ctx context.Context, key string, fn func(it *Item) (*Item, error)) error {
+	u, ok := r.primary.(Updater)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := u.Update(ctx, key, fn); err != nil {
+		return err
+	}
+	r.refresh(ctx, key)
+	return nil
+}
+
+// Incr increments the value of key on primary and then refreshes the local copy of key.
+func (r *readReplica) Incr(ctx context.Context, key string, delta uint64, decr bool) (uint64, error) {
+	inc, ok := r.primary.(Incrementer)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	n, err := inc.Incr(ctx, key, delta, decr)
+	if err != nil {
+		return 0, err
+	}
+	r.refresh(ctx, key)
+	return n, nil
+}
+
+// refresh replaces the local copy of key by the item of primary, which has the cas and
+// expiration set by primary. The local copy is dropped if primary can't be read.
+func (r *readReplica) refresh(ctx context.Context, key string) {
+	it, err := r.primary.Get(ctx, key)
+	if err != nil {
+		r.local.Delete(ctx, key)
+		r.cas.Delete(key)
+		return
+	}
+	r.fill(ctx, it)
+}
+
+// Flush flushes primary and then local. It returns ErrNotSupported if any of them is not a Flusher.
+func (r *readReplica) Flush(ctx context.Context) error {
+	pf, ok := r.primary.(Flusher)
+	lf, lok := r.local.(Flusher)
+	if !ok || !lok {
+		return ErrNotSupported
+	}
+	if err := pf.Flush(ctx); err != nil {
+		return err
+	}
+	r.cas.Range(func(k, v interface{}) bool {
+		r.cas.Delete(k)
+		return true
+	})
+	return lf.Flush(ctx)
+}
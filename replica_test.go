@@ -0,0 +1,148 @@
+package mc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// failingStore fails all writes.
+type failingStore struct {
+	Store
+}
+
+func (failingStore) Set(ctx context.Context, item *Item) error { return errors.New("primary is down") }
+
+func TestReadReplica(t *testing.T) {
+	ctx := context.Background()
+	local, primary := NewMemoryStore(MemoryStoreOptions{}), NewMemoryStore(MemoryStoreOptions{})
+	st := ReadReplica(local, primary)
+
+	if err := st.Set(ctx, &Item{Key: "a", Data: []byte("1")}); err != nil {
+		t.Fatalf("Set %v", err)
+	}
+	if _, err := primary.Get(ctx, "a"); err != nil {
+		t.Errorf("write is not forwarded: %v", err)
+	}
+	if _, err := local.Get(ctx, "a"); err != nil {
+		t.Errorf("write is not applied locally: %v", err)
+	}
+
+	// misses are read through
+	primary.Set(ctx, &Item{Key: "b", Data: []byte("2")})
+	items, err := st.MGet(ctx, []string{"a", "b", "c"})
+	if err != nil || len(items) != 2 || string(items["b"].Data) != "2" {
+		t.Errorf("unexpected items: %v %v", items, err)
+	}
+	if _, err := local.Get(ctx, "b"); err != nil {
+		t.Errorf("read-through item is not cached: %v", err)
+	}
+
+	// local copies of items missing upstream are removed
+	primary.Delete(ctx, "b")
+	if err := st.Delete(ctx, "b"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := local.Get(ctx, "b"); err != ErrNotFound {
+		t.Errorf("local copy is not deleted: %v", err)
+	}
+
+	st = ReadReplica(local, failingStore{primary})
+	if err := st.Set(ctx, &Item{Key: "x", Data: []byte("1")}); err == nil {
+		t.Errorf("expected the error of primary")
+	}
+	if _, err := local.Get(ctx, "x"); err != ErrNotFound {
+		t.Errorf("failed write is applied locally: %v", err)
+	}
+}
+
+func TestReadReplicaUpdates(t *testing.T) {
+	ctx := context.Background()
+	local, primary := NewMemoryStore(MemoryStoreOptions{}), NewMemoryStore(MemoryStoreOptions{})
+	rep := ReadReplica(local, primary)
+	s := NewServer("127.0.0.1:0")
+	RegisterStore(s, rep)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer s.Stop()
+	addr := s.Addr().String()
+
+	roundTrip(t, addr, "set n 0 0 1\r\n1\r\n")
+	// another writer updates primary, the local copy is stale
+	primary.Set(ctx, &Item{Key: "n", Data: []byte("10")})
+	if line := roundTrip(t, addr, "incr n 5\r\n"); line != "15\r\n" {
+		t.Errorf("incr is not applied on primary: %q", line)
+	}
+	if it, err := local.Get(ctx, "n"); err != nil || string(it.Data) != "15" {
+		t.Errorf("local copy is not refreshed: %v %v", it, err)
+	}
+
+	primary.Set(ctx, &Item{Key: "s", Data: []byte("b")})
+	local.Set(ctx, &Item{Key: "s", Data: []byte("stale")})
+	if line := roundTrip(t, addr, "append s 0 0 1\r\nc\r\n"); line != "STORED\r\n" {
+		t.Errorf("unexpected response %q", line)
+	}
+	if it, _ := primary.Get(ctx, "s"); string(it.Data) != "bc" {
+		t.Errorf("append is not applied on primary: %s", it.Data)
+	}
+	// gets of the local copy have the cas of primary, which cas checks
+	it, _ := primary.Get(ctx, "s")
+	if l, _ := rep.Get(ctx, "s"); l.Cas != it.Cas {
+		t.Errorf("local copy has cas %d, primary %d", l.Cas, it.Cas)
+	}
+	line := roundTrip(t, addr, "cas s 0 0 1 "+strconv.FormatUint(it.Cas, 10)+"\r\nd\r\n")
+	if line != "STORED\r\n" {
+		t.Errorf("cas of primary is not accepted: %q", line)
+	}
+
+	st := ReadReplica(local, plainStore{primary})
+	if _, err := st.(Incrementer).Incr(ctx, "n", 1, false); err != ErrNotSupported {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
+
+func TestReadReplicaCasSweep(t *testing.T) {
+	ctx := context.Background()
+	local := NewMemoryStore(MemoryStoreOptions{Shards: 1, MaxBytes: 16 << 10})
+	primary := NewMemoryStore(MemoryStoreOptions{})
+	rep := ReadReplica(local, primary).(*readReplica)
+
+	n := 4 * replicaSweepMin
+	for i := 0; i < n; i++ {
+		key := "k" + strconv.Itoa(i)
+		primary.Set(ctx, &Item{Key: key, Data: []byte("value")})
+		if _, err := rep.Get(ctx, key); err != nil {
+			t.Fatalf("Get %v", err)
+		}
+	}
+	// local keeps few of the copies, and the pairs of evicted ones are swept
+	count := func() int {
+		c := 0
+		rep.cas.Range(func(k, v interface{}) bool {
+			c++
+			return true
+		})
+		return c
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for count() > 2*replicaSweepMin && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c := count(); c > 2*replicaSweepMin {
+		t.Errorf("cas map keeps %d pairs of %d fills", c, n)
+	}
+
+	// local misses drop the pair even if primary misses too
+	primary.Delete(ctx, "k0")
+	local.Delete(ctx, "k0")
+	rep.cas.Store("k0", casPair{})
+	if _, err := rep.Get(ctx, "k0"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, ok := rep.cas.Load("k0"); ok {
+		t.Errorf("the pair of a missing copy is kept")
+	}
+}
@@ -0,0 +1,216 @@
+package mc
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ErrResizing is returned by Resize while a previous resize is in progress.
+var ErrResizing = errors.New("resize in progress")
+
+// migrateBatch is the max number of entries moved while the lock of a shard is held.
+const migrateBatch = 128
+
+// layout is a set of shards. While a store is resized, prev is the layout whose entries
+// are being moved to shards.
+type layout struct {
+	shards   []*shard
+	maxBytes int64
+	hasher   Hasher
+	prev     *layout
+}
+
+func (l *layout) index(key string) int {
+	return l.hasher.Shard(key, len(l.shards))
+}
+
+func (l *layout) shard(key string) *shard {
+	return l.shards[l.index(key)]
+}
+
+// shardsOf returns the shards of keys ordered by their indexes.
+func (l *layout) shardsOf(keys []string) []*shard {
+	indexes := make([]int, 0, len(keys))
+	seen := make(map[int]bool, len(keys))
+	for _, key := range keys {
+		if i := l.index(key); !seen[i] {
+			seen[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	shards := make([]*shard, len(indexes))
+	for i, index := range indexes {
+		shards[i] = l.shards[index]
+	}
+	return shards
+}
+
+// load returns the entry of key without locks. Entries which have not been moved
+// from the previous layout are looked up first, as they are moved by writers before
+// they write new entries.
+func (l *layout) load(key string) (*entry, bool) {
+	if l.prev != nil {
+		if e, ok := l.prev.shard(key).load(key); ok {
+			return e, true
+		}
+	}
+	return l.shard(key).load(key)
+}
+
+// lockKeys locks the shards of keys in the current layout and returns the layout and
+// the function to unlock them. Keys which are still in the previous layout are moved first.
+// Shards of previous layouts are always locked before those of newer ones, and shards of
+// a layout in the order of their indexes, so lockKeys never deadlocks.
+func (s *MemoryStore) lockKeys(keys ...string) (*layout, func()) {
+	for {
+		l := s.current()
+		var olds []*shard
+		if l.prev != nil {
+			olds = l.prev.shardsOf(keys)
+		}
+		shards := l.shardsOf(keys)
+		for _, sh := range olds {
+			sh.mu.Lock()
+		}
+		for _, sh := range shards {
+			sh.mu.Lock()
+		}
+		unlock := func() {
+			for _, sh := range shards {
+				sh.mu.Unlock()
+			}
+		}
+
+		if s.current() != l {
+			// resized in the meantime
+			unlock()
+			for _, sh := range olds {
+				sh.mu.Unlock()
+			}
+			continue
+		}
+		if l.prev != nil {
+			now := s.opts.Clock.Now()
+			for _, key := range keys {
+				if e, ok := l.prev.shard(key).items.get(key); ok {
+					moveEntry(l.prev.shard(key), l.shard(key), e, now)
+				}
+			}
+		}
+		for _, sh := range olds {
+			sh.mu.Unlock()
+		}
+		return l, unlock
+	}
+}
+
+// moveEntry moves an entry from shard from to shard to, whose keys don't have it.
+// It is added to to before it is removed from from, so lock-free readers always see it.
+// Callers hold the locks of both shards.
+func moveEntry(from, to *shard, e *entry, now time.Time) {
+	if _, ok := to.items.get(e.item.Key); ok || (to.max > 0 && e.size > to.max) {
+		from.remove(e)
+		return
+	}
+	moved := &entry{
+		item:       e.item,
+		size:       e.size,
+		lastAccess: atomic.LoadInt64(&e.lastAccess),
+		chunk:      e.chunk, // owned by moved now
+	}
+	to.insert(moved, now)
+	from.unlink(e)
+}
+
+// Resize changes the number of shards and the memory limit of the store without blocking it.
+// Items are moved to the new shards by their writers, and by a background goroutine which
+// moves a few items at a time. Items which don't fit in the new limit are evicted.
+// It returns ErrResizing if a previous resize has not finished.
+func (s *MemoryStore) Resize(shards int, maxBytes int64) error {
+	if shards <= 0 || maxBytes < 0 {
+		return errors.New("invalid shards or memory limit")
+	}
+
+	s.resizeMu.Lock()
+	defer s.resizeMu.Unlock()
+	cur := s.current()
+	if cur.prev != nil {
+		return ErrResizing
+	}
+	l := s.newLayout(shards, maxBytes)
+	l.prev = cur
+	s.layout.Store(l)
+	go s.migrate(l)
+	return nil
+}
+
+// Resizing returns whether a resize is in progress.
+func (s *MemoryStore) Resizing() bool {
+	return s.current().prev != nil
+}
+
+// migrate moves all entries of l.prev to l and then drops l.prev.
+func (s *MemoryStore) migrate(l *layout) {
+	for _, old := range l.prev.shards {
+		for done := false; !done; {
+			old.mu.Lock()
+			now := s.opts.Clock.Now()
+			for n := 0; n < migrateBatch && len(old.keys) > 0; n++ {
+				e, _ := old.items.get(old.keys[len(old.keys)-1])
+				sh := l.shard(e.item.Key)
+				sh.mu.Lock()
+				moveEntry(old, sh, e, now)
+				sh.mu.Unlock()
+			}
+			done = len(old.keys) == 0
+			old.mu.Unlock()
+			// lets writers waiting for the lock go
+			runtime.Gosched()
+		}
+	}
+
+	s.resizeMu.Lock()
+	defer s.resizeMu.Unlock()
+	for _, sh := range l.prev.shards {
+		s.retired.Gets += atomic.LoadUint64(&sh.gets)
+		s.retired.Hits += atomic.LoadUint64(&sh.hits)
+		s.retired.Sets += atomic.LoadUint64(&sh.sets)
+		s.retired.Evictions += atomic.LoadUint64(&sh.evictions)
+		sh.mu.RLock()
+		s.retiredAdmitted += sh.admitted
+		s.retiredRejected += sh.rejected
+		sh.mu.RUnlock()
+	}
+	s.layout.Store(&layout{shards: l.shards, maxBytes: l.maxBytes, hasher: l.hasher})
+}
+
+// ResizeHandler handles an admin command which resizes st:
+//
+//	<command name> <shards> <max bytes>\r\n
+//
+// It replies OK when the resize starts, and SERVER_ERROR resize in progress if it can't.
+func ResizeHandler(st *MemoryStore) HandlerFunc {
+	return func(ctx context.Context, req *Request, res *Response) error {
+		if len(req.Keys) < 2 {
+			return res.ClientError("usage: " + req.Command + " <shards> <max bytes>")
+		}
+		shards, err := strconv.Atoi(req.Keys[0])
+		if err != nil || shards <= 0 {
+			return res.ClientError("bad shards " + req.Keys[0])
+		}
+		maxBytes, err := strconv.ParseInt(req.Keys[1], 10, 64)
+		if err != nil || maxBytes < 0 {
+			return res.ClientError("bad max bytes " + req.Keys[1])
+		}
+		if err := st.Resize(shards, maxBytes); err != nil {
+			return err
+		}
+		return res.OK()
+	}
+}
@@ -0,0 +1,125 @@
+package mc
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitResized waits for the resize of st to finish.
+func waitResized(t *testing.T, st *MemoryStore) {
+	for start := time.Now(); st.Resizing(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("resize doesn't finish")
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore(MemoryStoreOptions{Shards: 4, Arena: true})
+	for i := 0; i < 1000; i++ {
+		st.Set(ctx, &Item{Key: "key" + strconv.Itoa(i), Data: []byte(strconv.Itoa(i))})
+	}
+
+	if err := st.Resize(16, 0); err != nil {
+		t.Fatalf("Resize %v", err)
+	}
+	if _, err := st.Get(ctx, "key1"); err != nil {
+		t.Errorf("item is not found while resizing: %v", err)
+	}
+	waitResized(t, st)
+
+	if n := len(st.current().shards); n != 16 || st.Len() != 1000 {
+		t.Errorf("unexpected store: %d shards, %d items", n, st.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		it, err := st.Get(ctx, "key"+strconv.Itoa(i))
+		if err != nil || string(it.Data) != strconv.Itoa(i) {
+			t.Fatalf("unexpected item %d: %+v %v", i, it, err)
+		}
+	}
+	if c := st.Counters(); c.Sets != 1000 || c.Hits != 1001 {
+		t.Errorf("counters are lost: %+v", c)
+	}
+
+	// shrinking the limit evicts items
+	if err := st.Resize(2, 100*(itemOverhead+arenaMinChunk+6)); err != nil {
+		t.Fatalf("Resize %v", err)
+	}
+	waitResized(t, st)
+	if n := st.Len(); n > 100 || n < 50 {
+		t.Errorf("unexpected items after shrinking: %d", n)
+	}
+}
+
+func TestResizeConcurrency(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore(MemoryStoreOptions{Shards: 2, Index: IndexReadMostly})
+	const keys, workers, ops = 50, 4, 300
+	for i := 0; i < keys; i++ {
+		st.Set(ctx, &Item{Key: "counter" + strconv.Itoa(i), Data: []byte("0")})
+	}
+	for i := 0; i < 2000; i++ {
+		st.Set(ctx, &Item{Key: "filler" + strconv.Itoa(i), Data: []byte("x")})
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < ops; i++ {
+				key := "counter" + strconv.Itoa(i%keys)
+				st.Update(ctx, key, func(it *Item) (*Item, error) {
+					n, _ := strconv.Atoi(string(it.Data))
+					it.Data = []byte(strconv.Itoa(n + 1))
+					return it, nil
+				})
+				if _, err := st.Get(ctx, "filler"+strconv.Itoa(i)); err != nil {
+					t.Errorf("filler%d is missing: %v", i, err)
+					return
+				}
+			}
+		}(w)
+	}
+	for _, n := range []int{7, 32, 3} {
+		for st.Resize(n, 0) == ErrResizing {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wg.Wait()
+	waitResized(t, st)
+
+	total := 0
+	for i := 0; i < keys; i++ {
+		it, err := st.Get(ctx, "counter"+strconv.Itoa(i))
+		if err != nil {
+			t.Fatalf("counter%d is missing", i)
+		}
+		n, _ := strconv.Atoi(string(it.Data))
+		total += n
+	}
+	if total != workers*ops {
+		t.Errorf("expected total %d, got %d", workers*ops, total)
+	}
+}
+
+func TestResizeHandler(t *testing.T) {
+	st := NewMemoryStore(MemoryStoreOptions{})
+	h := handlerMap{"resize": ResizeHandler(st)}
+	if res := h.call(&Request{Command: "resize", Keys: []string{"8", "1000000"}}); res.Response != RespOK {
+		t.Errorf("unexpected response: %q", res.Response)
+	}
+	waitResized(t, st)
+	if l := st.current(); len(l.shards) != 8 || l.maxBytes != 1000000 {
+		t.Errorf("store is not resized: %d shards, %d bytes", len(l.shards), l.maxBytes)
+	}
+	for _, args := range [][]string{{"8"}, {"0", "10"}, {"8", "-1"}} {
+		if res := h.call(&Request{Command: "resize", Keys: args}); res.Response[:len(RespClientErr)] != RespClientErr {
+			t.Errorf("unexpected response of %v: %q", args, res.Response)
+		}
+	}
+}
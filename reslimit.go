@@ -0,0 +1,53 @@
+package mc
+
+import "sync/atomic"
+
+// errResponseTooLarge is the error line of responses larger than Server.MaxResponseSize.
+var errResponseTooLarge = RespServerErr + "out of memory writing get response"
+
+// limitResponse applies MaxResponseSize to the values of res. It keeps the values which fit if
+// TruncateResponses is set, or replaces res with SERVER_ERROR otherwise.
+func (s *Server) limitResponse(res *Response) {
+	if s.MaxResponseSize <= 0 {
+		return
+	}
+	if res.Data != nil {
+		s.limitMetaResponse(res)
+		return
+	}
+	if len(res.Values) == 0 {
+		return
+	}
+	var line [64]byte
+	size := 0
+	for i := range res.Values {
+		v := &res.Values[i]
+		size += len(appendValueLine(line[:0], v)) + len(v.Data) + 2
+		if size <= s.MaxResponseSize {
+			continue
+		}
+		if s.TruncateResponses {
+			atomic.AddUint64(&s.counters.truncated, 1)
+			res.Values = res.Values[:i]
+			return
+		}
+		atomic.AddUint64(&s.counters.tooLarge, 1)
+		res.Values, res.Response = nil, errResponseTooLarge
+		return
+	}
+}
+
+// limitMetaResponse applies MaxResponseSize to the data block of a meta response. A value which
+// doesn't fit is replied as a miss if TruncateResponses is set.
+func (s *Server) limitMetaResponse(res *Response) {
+	if len(res.Response)+len(res.Data)+4 <= s.MaxResponseSize {
+		return
+	}
+	if s.TruncateResponses {
+		atomic.AddUint64(&s.counters.truncated, 1)
+		res.Response, res.Data = "EN", nil
+		return
+	}
+	atomic.AddUint64(&s.counters.tooLarge, 1)
+	res.Response, res.Data = errResponseTooLarge, nil
+}
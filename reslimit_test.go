@@ -0,0 +1,70 @@
+package mc
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestLimitResponse(t *testing.T) {
+	values := func() *Response {
+		data := bytes.Repeat([]byte("x"), 100)
+		return &Response{Response: RespEnd, Values: []Value{
+			{Key: "k1", Flags: "0", Data: data}, {Key: "k2", Flags: "0", Data: data}, {Key: "k3", Flags: "0", Data: data},
+		}}
+	}
+	// every value is 118 bytes on the wire: "VALUE k1 0 100\r\n", its data and "\r\n"
+	s := NewServer("127.0.0.1:0")
+	s.MaxResponseSize = 236
+
+	res := values()
+	s.limitResponse(res)
+	if res.Response != errResponseTooLarge || res.Values != nil {
+		t.Errorf("unexpected response: %s", FormatResponse(nil, res))
+	}
+
+	s.TruncateResponses = true
+	res = values()
+	s.limitResponse(res)
+	if res.Response != RespEnd || len(res.Values) != 2 {
+		t.Errorf("unexpected truncated response: %s", FormatResponse(nil, res))
+	}
+
+	s.MaxResponseSize = 354
+	res = values()
+	s.limitResponse(res)
+	if len(res.Values) != 3 {
+		t.Errorf("response which fits is truncated: %s", FormatResponse(nil, res))
+	}
+
+	// a meta value is 113 bytes on the wire: "VA 100 f0\r\n", its data and "\r\n"
+	meta := func() *Response {
+		res := &Response{}
+		res.MetaValue(bytes.Repeat([]byte("x"), 100), "f0")
+		return res
+	}
+	s.MaxResponseSize = 112
+	s.TruncateResponses = false
+	res = meta()
+	s.limitResponse(res)
+	if res.Response != errResponseTooLarge || res.Data != nil {
+		t.Errorf("unexpected meta response: %s", FormatResponse(nil, res))
+	}
+	s.TruncateResponses = true
+	res = meta()
+	s.limitResponse(res)
+	if res.Response != "EN" || res.Data != nil {
+		t.Errorf("unexpected truncated meta response: %s", FormatResponse(nil, res))
+	}
+	s.MaxResponseSize = 113
+	res = meta()
+	s.limitResponse(res)
+	if len(res.Data) != 100 {
+		t.Errorf("meta response which fits is truncated: %s", FormatResponse(nil, res))
+	}
+
+	stats, _ := s.Stats(context.Background(), "")
+	if statValue(stats, "responses_truncated") != "2" || statValue(stats, "responses_too_large") != "2" {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+}
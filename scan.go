@@ -0,0 +1,246 @@
+package mc
+
+import (
+	"context"
+	"hash/fnv"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyRanger ranges over keys, for example keys of a store.
+// Range stops when fn returns false.
+type KeyRanger interface {
+	Range(ctx context.Context, fn func(key string) bool) error
+}
+
+// DefaultScanCount is the number of keys returned by scan if count is not specified.
+const DefaultScanCount = 10
+
+// ScanHandler returns a handler of the scan extension command, which enumerates keys of kr in pages:
+//
+//	scan <cursor> [match <pattern>] [count <n>]\r\n
+//
+// It replies the cursor of the next page followed by keys of this page:
+//
+//	CURSOR <next cursor>\r\n
+//	KEY <key>\r\n
+//	...
+//	END\r\n
+//
+// The first cursor is 0 and the next cursor is 0 after the last page. Keys are ordered by their hashes,
+// and then by themselves for equal hashes, and the cursor is a position in the hash space. Pages don't
+// end between keys of the same hash, so they can have more than count keys, and keys existing during
+// the whole scan are returned once. Patterns are matched by path.Match.
+//
+// A page ranges all keys of kr only if the scan can't be resumed: the remaining keys of the latest
+// scans are kept for a while for their next pages, which then cost only their size. Keys deleted
+// since are still returned then. Pages are streamed to the connection if it supports it.
+//
+// Register it to enable the command:
+//
+//	s.RegisterFunc("scan", mc.ScanHandler(kr))
+func ScanHandler(kr KeyRanger) HandlerFunc {
+	cursors := &scanCursors{m: make(map[scanPos]*scanSnapshot)}
+	return func(ctx context.Context, req *Request, res *Response) error {
+		cursor, pattern, count, err := parseScanArgs(req.Keys)
+		if err != nil {
+			res.Response = RespClientErr + err.Error()
+			return nil
+		}
+
+		now := time.Now()
+		items, ok := cursors.take(scanPos{cursor, pattern}, now)
+		if !ok {
+			var matchErr error
+			items, matchErr, err = scanKeys(ctx, kr, cursor, pattern)
+			if err != nil {
+				return err
+			}
+			if matchErr != nil {
+				res.Response = RespClientErr + "bad pattern " + matchErr.Error()
+				return nil
+			}
+		}
+
+		page, rest := scanPage(items, count)
+		var next uint64
+		if len(rest) > 0 {
+			next = page[len(page)-1].hash
+			cursors.put(scanPos{next, pattern}, rest, now)
+		}
+
+		head := "CURSOR " + strconv.FormatUint(next, 10)
+		if sw := StreamFromContext(ctx); sw != nil {
+			if err := sw.WriteLine(head); err != nil {
+				return err
+			}
+			for _, it := range page {
+				if err := sw.WriteLine("KEY " + it.key); err != nil {
+					return err
+				}
+			}
+			res.Response = RespEnd
+			return nil
+		}
+		var b strings.Builder
+		b.WriteString(head)
+		for _, it := range page {
+			b.WriteString("\r\nKEY ")
+			b.WriteString(it.key)
+		}
+		b.WriteString("\r\n" + RespEnd)
+		res.Response = b.String()
+		return nil
+	}
+}
+
+// scanKeys returns the keys of kr after cursor which match pattern, in scan order.
+// matchErr is the error of a bad pattern.
+func scanKeys(ctx context.Context, kr KeyRanger, cursor uint64, pattern string) (items []scanItem, matchErr, err error) {
+	err = kr.Range(ctx, func(key string) bool {
+		kh := keyHash(key)
+		if kh <= cursor {
+			return true
+		}
+		if pattern != "" {
+			ok, err := path.Match(pattern, key)
+			if err != nil {
+				matchErr = err
+				return false
+			}
+			if !ok {
+				return true
+			}
+		}
+		items = append(items, scanItem{kh, key})
+		return true
+	})
+	sort.Slice(items, func(i, j int) bool { return items[i].less(items[j]) })
+	return items, matchErr, err
+}
+
+// scanPage splits items in scan order into a page of at least count items and the rest.
+// Items of the same hash are in the same page, so the cursor of the next page skips none of them.
+func scanPage(items []scanItem, count int) (page, rest []scanItem) {
+	n := count
+	if n > len(items) {
+		n = len(items)
+	}
+	for n > 0 && n < len(items) && items[n].hash == items[n-1].hash {
+		n++
+	}
+	return items[:n], items[n:]
+}
+
+// scanSnapshots is the max number of scans whose remaining keys are kept for their next pages.
+const scanSnapshots = 16
+
+// scanSnapshotTTL is how long the remaining keys of a scan are kept for its next page.
+const scanSnapshotTTL = time.Minute
+
+// scanPos is the position of the next page of a scan.
+type scanPos struct {
+	cursor  uint64
+	pattern string
+}
+
+// scanSnapshot is the remaining keys of a scan in scan order.
+type scanSnapshot struct {
+	items []scanItem
+	at    time.Time
+}
+
+// scanCursors keeps the remaining keys of the latest scans by the positions of their next pages,
+// so scans are resumed without ranging all keys again.
+type scanCursors struct {
+	mu sync.Mutex
+	m  map[scanPos]*scanSnapshot
+}
+
+// take removes and returns the remaining keys of the scan at pos.
+func (c *scanCursors) take(pos scanPos, now time.Time) ([]scanItem, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snap, ok := c.m[pos]
+	if !ok {
+		return nil, false
+	}
+	delete(c.m, pos)
+	return snap.items, now.Sub(snap.at) < scanSnapshotTTL
+}
+
+// put keeps the remaining keys of the scan at pos, dropping expired snapshots and then the oldest
+// one if there are too many.
+func (c *scanCursors) put(pos scanPos, items []scanItem, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var oldest *scanPos
+	for p, snap := range c.m {
+		if now.Sub(snap.at) >= scanSnapshotTTL {
+			delete(c.m, p)
+			continue
+		}
+		if oldest == nil || snap.at.Before(c.m[*oldest].at) {
+			p := p
+			oldest = &p
+		}
+	}
+	if len(c.m) >= scanSnapshots && oldest != nil {
+		delete(c.m, *oldest)
+	}
+	c.m[pos] = &scanSnapshot{items: items, at: now}
+}
+
+// parseScanArgs parses arguments of the scan command.
+func parseScanArgs(args []string) (cursor uint64, pattern string, count int, err error) {
+	if len(args) < 1 {
+		return 0, "", 0, NewError(`too few params to command "scan"`)
+	}
+	cursor, err = strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return 0, "", 0, NewError("bad cursor " + args[0])
+	}
+
+	count = DefaultScanCount
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return 0, "", 0, NewError("missing value of " + args[i])
+		}
+		switch args[i] {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil || count < 1 {
+				return 0, "", 0, NewError("bad count " + args[i+1])
+			}
+		default:
+			return 0, "", 0, NewError("unknown option " + args[i])
+		}
+	}
+	return cursor, pattern, count, nil
+}
+
+// keyHash returns the position of key in the scan order.
+func keyHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+type scanItem struct {
+	hash uint64
+	key  string
+}
+
+// less returns whether it is before o in scan order.
+func (it scanItem) less(o scanItem) bool {
+	if it.hash != o.hash {
+		return it.hash < o.hash
+	}
+	return it.key < o.key
+}
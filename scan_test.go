@@ -0,0 +1,147 @@
+package mc
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type mapRanger map[string]bool
+
+func (m mapRanger) Range(ctx context.Context, fn func(key string) bool) error {
+	for k := range m {
+		if !fn(k) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestScan(t *testing.T) {
+	keys := mapRanger{}
+	for i := 0; i < 25; i++ {
+		keys["user:"+strconv.Itoa(i)] = true
+		keys["session:"+strconv.Itoa(i)] = true
+	}
+	fn := ScanHandler(keys)
+
+	seen := map[string]bool{}
+	cursor := "0"
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatalf("too many pages")
+		}
+		res := &Response{}
+		fn(context.Background(), &Request{Command: "scan", Keys: []string{cursor, "match", "user:*", "count", "10"}}, res)
+
+		lines := strings.Split(res.Response, "\r\n")
+		if lines[len(lines)-1] != RespEnd || !strings.HasPrefix(lines[0], "CURSOR ") {
+			t.Fatalf("unexpected response: %q", res.Response)
+		}
+		for _, line := range lines[1 : len(lines)-1] {
+			key := strings.TrimPrefix(line, "KEY ")
+			if seen[key] || !strings.HasPrefix(key, "user:") {
+				t.Fatalf("unexpected key: %s", key)
+			}
+			seen[key] = true
+		}
+
+		cursor = strings.TrimPrefix(lines[0], "CURSOR ")
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(seen) != 25 {
+		t.Errorf("expected 25 keys, got %d", len(seen))
+	}
+}
+
+// countingRanger counts the ranges of its keys.
+type countingRanger struct {
+	mapRanger
+	ranges int
+}
+
+func (r *countingRanger) Range(ctx context.Context, fn func(key string) bool) error {
+	r.ranges++
+	return r.mapRanger.Range(ctx, fn)
+}
+
+func TestScanResume(t *testing.T) {
+	kr := &countingRanger{mapRanger: mapRanger{}}
+	for i := 0; i < 100; i++ {
+		kr.mapRanger["k"+strconv.Itoa(i)] = true
+	}
+	fn := ScanHandler(kr)
+
+	scan := func(cursor string) (string, int) {
+		res := &Response{}
+		fn(context.Background(), &Request{Command: "scan", Keys: []string{cursor, "count", "10"}}, res)
+		lines := strings.Split(res.Response, "\r\n")
+		return strings.TrimPrefix(lines[0], "CURSOR "), len(lines) - 2
+	}
+	n, pages := 0, 0
+	for cursor := "0"; ; pages++ {
+		next, keys := scan(cursor)
+		n += keys
+		if cursor = next; cursor == "0" {
+			break
+		}
+	}
+	if n != 100 || kr.ranges != 1 {
+		t.Errorf("%d keys in %d pages ranged keys %d times", n, pages, kr.ranges)
+	}
+
+	// a scan which can't be resumed ranges keys again
+	cursor, _ := scan("0")
+	scan(cursor)
+	if _, keys := scan(cursor); keys == 0 || kr.ranges != 3 {
+		t.Errorf("repeated page has %d keys after %d ranges", keys, kr.ranges)
+	}
+}
+
+func TestScanPageTies(t *testing.T) {
+	items := []scanItem{{1, "a"}, {2, "b"}, {2, "c"}, {3, "d"}}
+	page, rest := scanPage(items, 2)
+	if len(page) != 3 || len(rest) != 1 || rest[0].key != "d" {
+		t.Errorf("keys of the same hash are split: %v %v", page, rest)
+	}
+	if page, rest = scanPage(items, 10); len(page) != 4 || len(rest) != 0 {
+		t.Errorf("unexpected last page: %v %v", page, rest)
+	}
+}
+
+func TestScanStreamed(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.RegisterFunc("scan", ScanHandler(mapRanger{"a": true}))
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("scan 0\r\n"))
+	want := "CURSOR 0\r\nKEY a\r\nEND\r\n"
+	b := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, b); err != nil || string(b) != want {
+		t.Errorf("unexpected response %q: %v", b, err)
+	}
+}
+
+func TestScanBadArgs(t *testing.T) {
+	fn := ScanHandler(mapRanger{})
+	for _, args := range [][]string{{}, {"x"}, {"0", "count"}, {"0", "count", "0"}, {"0", "foo", "bar"}} {
+		res := &Response{}
+		fn(context.Background(), &Request{Command: "scan", Keys: args}, res)
+		if !strings.HasPrefix(res.Response, RespClientErr) {
+			t.Errorf("%v: expected client error, got %q", args, res.Response)
+		}
+	}
+}
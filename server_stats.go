@@ -0,0 +1,139 @@
+package mc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// serverCounters are counters of a server, accessed atomically.
+type serverCounters struct {
+	pendingSaturated uint64 // times connections stopped reading for MaxPendingResponses
+	accepted         uint64
+	rejected         uint64 // connections rejected by OnAccept
+	acceptErrors     uint64 // temporary accept errors which were retried
+	readTimeouts     uint64 // connections closed for ReadTimeout
+	protoErrs        [len(protoErrKinds)]uint64
+	protoErrCloses   uint64 // connections closed for MaxProtocolErrors
+	mismatches       uint64 // connections closed for other protocols, see detectProtocol
+	truncated        uint64 // responses truncated to MaxResponseSize
+	tooLarge         uint64 // responses replaced by SERVER_ERROR for MaxResponseSize
+	throttled        uint64 // writes delayed by MaxConnBandwidth or MaxClientBandwidth
+	intercepted      uint64 // requests replied by OnRequest
+}
+
+// Stats implements StatsReporter, see ProvideStats.
+func (s *Server) Stats(ctx context.Context, group string) ([]Stat, error) {
+	req, err := ParseStatsRequest(strings.Fields(group))
+	if err != nil {
+		return nil, err
+	}
+	return s.ProvideStats(ctx, req)
+}
+
+// ProvideStats implements StatsProvider. It reports counters of the server for the general
+// statistics, its options for "settings" and the open connections for "conns".
+// Combine it with other reporters by MultiStats to serve the stats command.
+func (s *Server) ProvideStats(ctx context.Context, req StatsRequest) ([]Stat, error) {
+	switch req.Scope {
+	case StatsGeneral:
+		stats := []Stat{
+			{"curr_connections", strconv.Itoa(s.ClientCount())},
+			{"total_connections", strconv.FormatUint(atomic.LoadUint64(&s.counters.accepted), 10)},
+			{"rejected_connections", strconv.FormatUint(atomic.LoadUint64(&s.counters.rejected), 10)},
+			{"accept_errors", strconv.FormatUint(atomic.LoadUint64(&s.counters.acceptErrors), 10)},
+			{"pending_saturated", strconv.FormatUint(atomic.LoadUint64(&s.counters.pendingSaturated), 10)},
+			{"read_timeouts", strconv.FormatUint(atomic.LoadUint64(&s.counters.readTimeouts), 10)},
+		}
+		var total uint64
+		for i, kind := range protoErrKinds {
+			n := atomic.LoadUint64(&s.counters.protoErrs[i])
+			total += n
+			stats = append(stats, Stat{"protocol_errors_" + kind, strconv.FormatUint(n, 10)})
+		}
+		return append(stats,
+			Stat{"protocol_errors", strconv.FormatUint(total, 10)},
+			Stat{"protocol_error_closes", strconv.FormatUint(atomic.LoadUint64(&s.counters.protoErrCloses), 10)},
+			Stat{"protocol_mismatches", strconv.FormatUint(atomic.LoadUint64(&s.counters.mismatches), 10)},
+			Stat{"responses_truncated", strconv.FormatUint(atomic.LoadUint64(&s.counters.truncated), 10)},
+			Stat{"responses_too_large", strconv.FormatUint(atomic.LoadUint64(&s.counters.tooLarge), 10)},
+			Stat{"bandwidth_throttled", strconv.FormatUint(atomic.LoadUint64(&s.counters.throttled), 10)},
+			Stat{"requests_intercepted", strconv.FormatUint(atomic.LoadUint64(&s.counters.intercepted), 10)},
+		), nil
+	case StatsSettings:
+		s.mu.Lock()
+		virtuals := len(s.virtuals)
+		s.mu.Unlock()
+		return []Stat{
+			{"addr", s.addr},
+			{"virtual_servers", strconv.Itoa(virtuals)},
+			{"key_max_length", strconv.Itoa(MaxKeyLength)},
+			{"line_max_length", strconv.Itoa(maxLineLength(s.MaxLineLength))},
+			{"reader_buffer_size", strconv.Itoa(ReaderBuffsize)},
+			{"writer_buffer_size", strconv.Itoa(WriterBuffsize)},
+			{"max_pending_responses", strconv.Itoa(s.MaxPendingResponses)},
+			{"async_requests", strconv.Itoa(s.AsyncRequests)},
+			{"max_async_handlers", strconv.Itoa(s.MaxAsyncHandlers)},
+			{"zero_copy", yesNo(s.ZeroCopy)},
+			{"read_timeout", s.ReadTimeout.String()},
+			{"max_protocol_errors", strconv.Itoa(s.MaxProtocolErrors)},
+			{"silent", yesNo(s.Silent)},
+			{"cancel_on_disconnect", yesNo(s.CancelOnDisconnect)},
+			{"max_conn_bandwidth", strconv.FormatInt(s.MaxConnBandwidth, 10)},
+			{"max_client_bandwidth", strconv.FormatInt(s.MaxClientBandwidth, 10)},
+			{"max_response_size", strconv.Itoa(s.MaxResponseSize)},
+			{"truncate_responses", yesNo(s.TruncateResponses)},
+			{"request_timeout_hints", yesNo(s.RequestTimeout != nil)},
+			{"accept_filter", yesNo(s.OnAccept != nil)},
+			{"request_hook", yesNo(s.OnRequest != nil)},
+			{"keep_raw_requests", yesNo(s.KeepRawRequest)},
+			{"batch_commands", yesNo(s.EnableBatchCommands)},
+			{"strict_registration", yesNo(s.StrictRegistration)},
+			{"copy_requests", yesNo(s.CopyRequests)},
+			{"metrics", yesNo(s.getMetrics() != nil)},
+			{"verbosity", strconv.Itoa(s.Verbosity())},
+			{"flags", flagsSetting(s.Flags)},
+		}, nil
+	case StatsConns:
+		conns := s.Connections()
+		stats := make([]Stat, 0, 2*len(conns))
+		for i, c := range conns {
+			state := "conn_waiting"
+			if c.Active {
+				state = "conn_parse_cmd"
+			}
+			id := strconv.Itoa(i) + ":"
+			stats = append(stats,
+				Stat{id + "addr", c.RemoteAddr.Network() + ":" + c.RemoteAddr.String()},
+				Stat{id + "state", state},
+				Stat{id + "protocol_errors", strconv.FormatUint(c.ProtocolErrors, 10)},
+			)
+		}
+		return stats, nil
+	}
+	return nil, ErrNotSupported
+}
+
+// ResetStats implements StatsResetter. It resets the counters of the server, and of its metrics
+// if they are a StatsResetter.
+func (s *Server) ResetStats(ctx context.Context) error {
+	atomic.StoreUint64(&s.counters.accepted, 0)
+	atomic.StoreUint64(&s.counters.rejected, 0)
+	atomic.StoreUint64(&s.counters.acceptErrors, 0)
+	atomic.StoreUint64(&s.counters.pendingSaturated, 0)
+	atomic.StoreUint64(&s.counters.readTimeouts, 0)
+	for i := range s.counters.protoErrs {
+		atomic.StoreUint64(&s.counters.protoErrs[i], 0)
+	}
+	atomic.StoreUint64(&s.counters.protoErrCloses, 0)
+	atomic.StoreUint64(&s.counters.mismatches, 0)
+	atomic.StoreUint64(&s.counters.truncated, 0)
+	atomic.StoreUint64(&s.counters.tooLarge, 0)
+	atomic.StoreUint64(&s.counters.throttled, 0)
+	atomic.StoreUint64(&s.counters.intercepted, 0)
+	if rs, ok := s.getMetrics().(StatsResetter); ok {
+		return rs.ResetStats(ctx)
+	}
+	return nil
+}
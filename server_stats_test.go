@@ -0,0 +1,209 @@
+package mc
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxPendingResponses(t *testing.T) {
+	port, _ := getFreePort()
+	s := NewServer("127.0.0.1:" + strconv.Itoa(port))
+	s.MaxPendingResponses = 2
+	s.RegisterFunc("get", DefaultGet)
+	s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", s.ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte(strings.Repeat("get a\r\n", 5)))
+	expected := strings.Repeat("END\r\n", 5)
+	buf := make([]byte, len(expected))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != expected {
+		t.Fatalf("unexpected responses: %q %v", buf, err)
+	}
+
+	stats, _ := s.Stats(context.Background(), "")
+	if n, _ := strconv.Atoi(statValue(stats, "pending_saturated")); n < 1 {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+}
+
+// statValue returns the value of the stat name, or "" if it is missing.
+func statValue(stats []Stat, name string) string {
+	for _, st := range stats {
+		if st.Name == name {
+			return st.Value
+		}
+	}
+	return ""
+}
+
+func TestOnAccept(t *testing.T) {
+	port, _ := getFreePort()
+	s := NewServer("127.0.0.1:" + strconv.Itoa(port))
+	s.RegisterFunc("get", DefaultGet)
+	accepted := 0
+	s.OnAccept = func(conn net.Conn) bool {
+		accepted++
+		return accepted%2 == 1
+	}
+	s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	if line := roundTrip(t, s.ln.Addr().String(), "get a\r\n"); line != "END\r\n" {
+		t.Errorf("unexpected response: %q", line)
+	}
+
+	conn, err := net.Dial("tcp", s.ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("rejected connection should be closed: %v", err)
+	}
+
+	stats, _ := s.Stats(context.Background(), "")
+	if statValue(stats, "total_connections") != "2" || statValue(stats, "rejected_connections") != "1" {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+}
+
+func TestServerStatsConns(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	stats, err := s.ProvideStats(context.Background(), StatsRequest{Scope: StatsConns})
+	if err != nil || statValue(stats, "0:addr") != "tcp:"+conn.LocalAddr().String() || statValue(stats, "0:state") != "conn_waiting" {
+		t.Errorf("unexpected stats conns: %v %v", stats, err)
+	}
+	if stats, err := s.Stats(context.Background(), "sizes"); err != ErrNotSupported {
+		t.Errorf("expected ErrNotSupported, got %v %v", stats, err)
+	}
+}
+
+func TestServerResetStats(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	h := NewLatencyHistograms()
+	s.SetMetrics(h)
+	s.counters.accepted = 3
+	h.ObserveLatency("get", time.Millisecond)
+
+	ms := MultiStats(s, NewMemoryStore(MemoryStoreOptions{}), testStats{})
+	res := &Response{}
+	if err := StatsHandler(ms)(context.Background(), &Request{Command: "stats", Keys: []string{"reset"}}, res); err != nil || res.Response != RespReset {
+		t.Fatalf("unexpected response: %q %v", res.Response, err)
+	}
+	stats, _ := s.Stats(context.Background(), "")
+	if statValue(stats, "total_connections") != "0" || h.Percentile("get", 50) != 0 {
+		t.Errorf("stats are not reset: %v", stats)
+	}
+}
+
+func TestServerStatsSettings(t *testing.T) {
+	s := NewServer("127.0.0.1:11211")
+	s.MaxPendingResponses = 8
+	s.EnableBatchCommands = true
+	s.Virtual("127.0.0.1:11212")
+
+	res := &Response{}
+	if err := StatsHandler(s)(context.Background(), &Request{Command: "stats", Keys: []string{"settings"}}, res); err != nil {
+		t.Fatalf("stats settings: %v", err)
+	}
+	for _, line := range []string{"STAT addr 127.0.0.1:11211\r\n", "STAT virtual_servers 1\r\n", "STAT key_max_length 250\r\n",
+		"STAT max_pending_responses 8\r\n", "STAT batch_commands yes\r\n", "STAT request_timeout_hints no\r\n"} {
+		if !strings.Contains(res.Response, line) {
+			t.Errorf("expected %q in %q", line, res.Response)
+		}
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	for _, silent := range []bool{false, true} {
+		s := NewServer("127.0.0.1:0")
+		s.ReadTimeout = 100 * time.Millisecond
+		s.Silent = silent
+		s.RegisterFunc("set", DefaultSet)
+		if err := s.Start(); err != nil {
+			t.Fatalf("failed to start: %v", err)
+		}
+
+		conn, err := net.Dial("tcp", s.ln.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+
+		// idle connections are not timed out
+		time.Sleep(200 * time.Millisecond)
+		conn.Write([]byte("set k 0 0 100\r\nabc"))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		buf, err := io.ReadAll(conn)
+		want := "CLIENT_ERROR read timeout\r\n"
+		if silent {
+			want = "" // closed without a reply
+		}
+		if err != nil || string(buf) != want {
+			t.Errorf("unexpected response with silent %v: %q %v", silent, buf, err)
+		}
+
+		stats, _ := s.Stats(context.Background(), "")
+		if statValue(stats, "read_timeouts") != "1" {
+			t.Errorf("unexpected stats: %v", stats)
+		}
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func TestClientCount(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := s.ClientCount(); n != 3 {
+		t.Errorf("expected 3 clients, got %d", n)
+	}
+	infos := s.Connections()
+	if len(infos) != 3 || infos[0].Active || infos[0].LocalAddr.String() != addr {
+		t.Errorf("unexpected connections %+v", infos)
+	}
+	stats, _ := s.Stats(context.Background(), "")
+	if statValue(stats, "curr_connections") != "3" {
+		t.Errorf("unexpected curr_connections %q", statValue(stats, "curr_connections"))
+	}
+
+	conns[0].Close()
+	time.Sleep(50 * time.Millisecond)
+	if n := s.ClientCount(); n != 2 {
+		t.Errorf("expected 2 clients after close, got %d", n)
+	}
+}
@@ -0,0 +1,97 @@
+package mc
+
+import (
+	"context"
+	"sync"
+)
+
+// Session stores values of a connection for stateful protocol features, like authentication
+// state, namespace selection or verbosity. Each connection served by a Server has its own session,
+// which handlers reach through their context by SessionKeys. Values live until the connection closes.
+type Session struct {
+	mu     sync.Mutex
+	values map[interface{}]interface{}
+}
+
+type sessionKey struct{}
+
+// NewSessionContext returns a copy of ctx with a new empty session, e.g. to call handlers
+// outside of a connection in tests.
+func NewSessionContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &Session{})
+}
+
+// SessionFromContext returns the session of ctx, or nil if ctx has none.
+func SessionFromContext(ctx context.Context) *Session {
+	sess, _ := ctx.Value(sessionKey{}).(*Session)
+	return sess
+}
+
+func (s *Session) get(key interface{}) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	return v, ok
+}
+
+func (s *Session) set(key, v interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = make(map[interface{}]interface{})
+	}
+	s.values[key] = v
+}
+
+func (s *Session) delete(key interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+}
+
+// SessionKey is the key of session values of type T. Keys are distinct even if their names are
+// equal, so features create their keys once, e.g. as package variables.
+type SessionKey[T any] struct {
+	name string
+}
+
+// NewSessionKey creates a key of session values. name is for debugging only.
+func NewSessionKey[T any](name string) *SessionKey[T] {
+	return &SessionKey[T]{name: name}
+}
+
+// String returns the name of the key.
+func (k *SessionKey[T]) String() string {
+	return k.name
+}
+
+// Get returns the value of the key in the session of ctx, and whether it is set.
+func (k *SessionKey[T]) Get(ctx context.Context) (T, bool) {
+	var zero T
+	sess := SessionFromContext(ctx)
+	if sess == nil {
+		return zero, false
+	}
+	v, ok := sess.get(k)
+	if !ok {
+		return zero, false
+	}
+	return v.(T), true
+}
+
+// Set sets the value of the key in the session of ctx. It returns false if ctx has no session.
+func (k *SessionKey[T]) Set(ctx context.Context, v T) bool {
+	sess := SessionFromContext(ctx)
+	if sess == nil {
+		return false
+	}
+	sess.set(k, v)
+	return true
+}
+
+// Delete removes the value of the key from the session of ctx.
+func (k *SessionKey[T]) Delete(ctx context.Context) {
+	if sess := SessionFromContext(ctx); sess != nil {
+		sess.delete(k)
+	}
+}
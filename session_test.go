@@ -0,0 +1,78 @@
+package mc
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+)
+
+func TestSessionKey(t *testing.T) {
+	user := NewSessionKey[string]("user")
+	other := NewSessionKey[string]("user")
+
+	if user.Set(context.Background(), "alice") {
+		t.Errorf("Set succeeded without a session")
+	}
+	ctx := NewSessionContext(context.Background())
+	if v, ok := user.Get(ctx); ok || v != "" {
+		t.Errorf("unset key has value %q", v)
+	}
+	user.Set(ctx, "alice")
+	if v, ok := user.Get(ctx); !ok || v != "alice" {
+		t.Errorf("got %q %v", v, ok)
+	}
+	if _, ok := other.Get(ctx); ok {
+		t.Errorf("keys of the same name share values")
+	}
+	user.Delete(ctx)
+	if _, ok := user.Get(ctx); ok {
+		t.Errorf("deleted key has a value")
+	}
+}
+
+func TestConnSessions(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	ns := NewSessionKey[string]("namespace")
+	s.RegisterFunc("select", func(ctx context.Context, req *Request, res *Response) error {
+		ns.Set(ctx, req.Key)
+		return res.OK()
+	})
+	s.RegisterFunc("get", func(ctx context.Context, req *Request, res *Response) error {
+		v, _ := ns.Get(ctx)
+		res.AddValue(req.Keys[0], 0, []byte(v), 0)
+		return res.End()
+	})
+
+	dial := func() (*bufio.Reader, *bufio.Writer, net.Conn) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		return bufio.NewReader(conn), bufio.NewWriter(conn), conn
+	}
+	call := func(r *bufio.Reader, w *bufio.Writer, req *Request) *Response {
+		WriteRequest(w, req)
+		w.Flush()
+		res, err := ReadResponse(r, req.Command)
+		if err != nil {
+			t.Fatalf("%s: %v", req.Command, err)
+		}
+		return res
+	}
+
+	r1, w1, c1 := dial()
+	defer c1.Close()
+	r2, w2, c2 := dial()
+	defer c2.Close()
+	call(r1, w1, &Request{Command: "select", Keys: []string{"a"}})
+	call(r2, w2, &Request{Command: "select", Keys: []string{"b"}})
+	if res := call(r1, w1, &Request{Command: "get", Keys: []string{"k"}}); string(res.Values[0].Data) != "a" {
+		t.Errorf("first connection has namespace %q", res.Values[0].Data)
+	}
+	if res := call(r2, w2, &Request{Command: "get", Keys: []string{"k"}}); string(res.Values[0].Data) != "b" {
+		t.Errorf("second connection has namespace %q", res.Values[0].Data)
+	}
+}
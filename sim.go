@@ -0,0 +1,94 @@
+package mc
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"time"
+)
+
+// SimEpoch is the time at which simulations start.
+var SimEpoch = time.Unix(1700000000, 0)
+
+// Simulation runs scripts of requests against a MemoryStore in a single goroutine with a
+// FakeClock, so expiration and eviction are deterministic and a failure scenario replays
+// byte for byte.
+//
+// A script is a sequence of requests in the text protocol, with the extra command
+// "advance <duration>" which advances the clock, e.g. "advance 1.5s". Responses are written to
+// the trace like a server writes them.
+type Simulation struct {
+	Store *MemoryStore
+	Clock *FakeClock
+
+	handlers map[string]HandlerFunc
+}
+
+// NewSimulation creates a simulation of a store with opts, whose clock is replaced by a
+// FakeClock at SimEpoch. Background rates are disabled, as they depend on the system time.
+// Handlers of RegisterStore are registered; all requests are handled in the calling goroutine,
+// so the store must not be resized during the simulation.
+func NewSimulation(opts MemoryStoreOptions) *Simulation {
+	clock := NewFakeClock(SimEpoch)
+	opts.Clock = clock
+	opts.RateInterval = 0
+	sim := &Simulation{Store: NewMemoryStore(opts), Clock: clock, handlers: make(map[string]HandlerFunc)}
+	RegisterStore(sim, sim.Store)
+	return sim
+}
+
+// RegisterFunc implements Registrar, so scripts can use other handlers too.
+func (sim *Simulation) RegisterFunc(cmd string, fn HandlerFunc) error {
+	sim.handlers[cmd] = fn
+	return nil
+}
+
+// Run runs the requests of script and writes their responses to trace.
+// Protocol errors of the script are returned, while errors of handlers are replied like servers do.
+// The script is like one connection, so handlers share a Session.
+func (sim *Simulation) Run(script io.Reader, trace io.Writer) error {
+	r := bufio.NewReader(script)
+	w := bufio.NewWriter(trace)
+	defer w.Flush()
+	ctx := NewSessionContext(context.Background())
+
+	opts := readOptions{generic: func(cmd string) bool { return cmd == "advance" }}
+	for {
+		b, err := r.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil && (b[0] == '\r' || b[0] == '\n') {
+			r.ReadLine() // blank lines separate steps of scripts
+			continue
+		}
+		req, err := readRequest(r, opts)
+		if err != nil {
+			return err
+		}
+		if req.Command == "advance" {
+			if len(req.Keys) != 1 {
+				return NewError("usage: advance <duration>")
+			}
+			d, err := time.ParseDuration(req.Keys[0])
+			if err != nil || d < 0 {
+				return NewError("bad duration " + req.Keys[0])
+			}
+			sim.Clock.Advance(d)
+			continue
+		}
+
+		res := &Response{}
+		fn, ok := sim.handlers[req.Command]
+		if !ok {
+			res.Response = RespErr + req.Command + " not implemented'"
+		} else if err := fn(ctx, req, res); err != nil {
+			setError(req, res, err)
+		}
+		if !ok || !req.Noreply {
+			if _, err := w.WriteString(res.String()); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package mc
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestSimulation(t *testing.T) {
+	script := "set a 0 10 1\r\n1\r\n" +
+		"set b 0 0 1 noreply\r\n2\r\n" +
+		"\r\n" +
+		"advance 9s\r\n" +
+		"get a b\r\n" +
+		"advance 1s\r\n" +
+		"get a b\r\n" +
+		"flush_all 5\r\n" +
+		"advance 5s\r\n" +
+		"get b\r\n" +
+		"bogus\r\n"
+	expected := "STORED\r\n" +
+		"VALUE a 0 1\r\n1\r\nVALUE b 0 1\r\n2\r\nEND\r\n" +
+		"VALUE b 0 1\r\n2\r\nEND\r\n" +
+		"OK\r\n" +
+		"END\r\n"
+
+	var trace bytes.Buffer
+	err := NewSimulation(MemoryStoreOptions{}).Run(strings.NewReader(script), &trace)
+	if _, ok := err.(Error); !ok || trace.String() != expected {
+		t.Errorf("unexpected trace %q: %v", trace.String(), err)
+	}
+}
+
+// randomScript returns a script of n random requests of few keys.
+func randomScript(seed int64, n int) string {
+	rnd := rand.New(rand.NewSource(seed))
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%d", rnd.Intn(50))
+		switch rnd.Intn(6) {
+		case 0, 1:
+			data := strings.Repeat("x", rnd.Intn(200))
+			fmt.Fprintf(&b, "set %s 0 %d %d\r\n%s\r\n", key, rnd.Intn(5), len(data), data)
+		case 2:
+			fmt.Fprintf(&b, "gets %s key%d\r\n", key, rnd.Intn(50))
+		case 3:
+			fmt.Fprintf(&b, "delete %s\r\n", key)
+		case 4:
+			fmt.Fprintf(&b, "advance %dms\r\n", rnd.Intn(2000))
+		case 5:
+			fmt.Fprintf(&b, "incr %s %d\r\n", key, rnd.Intn(10))
+		}
+	}
+	b.WriteString("stats\r\n")
+	return b.String()
+}
+
+func TestSimulationReplay(t *testing.T) {
+	opts := MemoryStoreOptions{Shards: 2, MaxBytes: 4096, Admission: true, Seed: 42}
+	for seed := int64(0); seed < 5; seed++ {
+		script := randomScript(seed, 2000)
+		var first, second bytes.Buffer
+		if err := NewSimulation(opts).Run(strings.NewReader(script), &first); err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		if err := NewSimulation(opts).Run(strings.NewReader(script), &second); err != nil {
+			t.Fatalf("replay: %v", err)
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Errorf("script %d: replay differs", seed)
+		}
+		if !strings.Contains(first.String(), "STAT evictions ") || strings.Contains(first.String(), "STAT evictions 0\r\n") {
+			t.Errorf("script %d: expected evictions in the simulation", seed)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package mc
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// StaleFunc looks up a possibly expired copy of key.
+type StaleFunc func(ctx context.Context, key string) (Value, bool)
+
+// StaleGetter is implemented by stores which keep expired items until they are removed,
+// so they can serve them while backends are down.
+// GetStale returns a copy of the item of key even if it has expired, or ErrNotFound.
+type StaleGetter interface {
+	GetStale(ctx context.Context, key string) (*Item, error)
+}
+
+// GetStale implements StaleGetter. Expired items are kept until they are evicted or replaced.
+// It doesn't count as an access of the item.
+func (s *MemoryStore) GetStale(ctx context.Context, key string) (*Item, error) {
+	it, _, ok := s.lookup(s.current(), key, s.opts.Clock.Now(), true)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return it, nil
+}
+
+// StoreStale returns a StaleFunc which looks up copies in st, including expired ones if st is
+// a StaleGetter, like a MemoryStore which caches the items of a backend.
+func StoreStale(st Store) StaleFunc {
+	get := st.Get
+	if sg, ok := st.(StaleGetter); ok {
+		get = sg.GetStale
+	}
+	return func(ctx context.Context, key string) (Value, bool) {
+		it, err := get(ctx, key)
+		if err != nil {
+			return Value{}, false
+		}
+		v := NewValue(it.Key, it.Flags, it.Data)
+		v.Cas = strconv.FormatUint(it.Cas, 10)
+		return v, true
+	}
+}
+
+// StaleOnError returns a middleware for get, gets and mg handlers. When the handler fails,
+// it serves the copies found by lookup instead of SERVER_ERROR, and keys without copies are misses.
+// Copies served for mg have the X flag, which marks stale items in the meta protocol.
+// If no key has a copy the error is returned as is, and so are expected errors like ErrNotFound.
+func StaleOnError(lookup StaleFunc) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request, res *Response) error {
+			err := next(ctx, req, res)
+			if err == nil || expectedError(err) {
+				return err
+			}
+			switch req.Command {
+			case "get", "gets":
+				return serveStale(ctx, lookup, req, res, err)
+			case "mg":
+				return serveMetaStale(ctx, lookup, req, res, err)
+			}
+			return err
+		}
+	}
+}
+
+// serveStale serves the copies of the keys of a get or gets request which failed with err.
+func serveStale(ctx context.Context, lookup StaleFunc, req *Request, res *Response, err error) error {
+	var values []Value
+	for _, key := range req.Keys {
+		if v, ok := lookup(ctx, key); ok {
+			if req.Command == "get" {
+				v.Cas = ""
+			}
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return err
+	}
+
+	log.Printf("serving %d stale values of %d keys: %v", len(values), len(req.Keys), err)
+	res.Values = values
+	res.Response = RespEnd
+	return nil
+}
+
+// serveMetaStale serves the copy of the key of an mg request which failed with err,
+// with the X flag.
+func serveMetaStale(ctx context.Context, lookup StaleFunc, req *Request, res *Response, err error) error {
+	v, ok := lookup(ctx, req.Key)
+	if !ok {
+		return err
+	}
+
+	log.Printf("serving a stale value of 1 key: %v", err)
+	*res = Response{}
+	// the TTL of a stale copy is unknown, so it is reported as 0
+	mg := MetaGetHandler(func(ctx context.Context, key string) (*MetaItem, error) {
+		return &MetaItem{Value: v}, nil
+	})
+	if err := mg(ctx, req, res); err != nil {
+		return err
+	}
+	if strings.HasPrefix(res.Response, "VA") || strings.HasPrefix(res.Response, "HD") {
+		res.Response += " X"
+	}
+	return nil
+}
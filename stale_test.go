@@ -0,0 +1,84 @@
+package mc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStaleOnError(t *testing.T) {
+	stale := map[string]Value{"a": {Key: "a", Flags: "0", Data: []byte("old")}}
+	lookup := func(ctx context.Context, key string) (Value, bool) {
+		v, ok := stale[key]
+		return v, ok
+	}
+	fn := StaleOnError(lookup)(func(ctx context.Context, req *Request, res *Response) error {
+		return errors.New("backend is down")
+	})
+
+	res := &Response{}
+	err := fn(context.Background(), &Request{Command: "get", Keys: []string{"a", "b"}}, res)
+	if err != nil {
+		t.Fatalf("expected stale value, got %v", err)
+	}
+	if res.String() != "VALUE a 0 3\r\nold\r\nEND\r\n" {
+		t.Errorf("unexpected response: %q", res.String())
+	}
+
+	err = fn(context.Background(), &Request{Command: "get", Keys: []string{"b"}}, &Response{})
+	if err == nil {
+		t.Errorf("expected the error without stale values")
+	}
+}
+
+func TestStaleOnErrorExpected(t *testing.T) {
+	lookup := func(ctx context.Context, key string) (Value, bool) {
+		return Value{Key: key, Flags: "0", Data: []byte("old")}, true
+	}
+	fn := StaleOnError(lookup)(func(ctx context.Context, req *Request, res *Response) error {
+		return ErrNotFound
+	})
+	res := &Response{}
+	if err := fn(context.Background(), &Request{Command: "get", Keys: []string{"a"}}, res); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if len(res.Values) != 0 {
+		t.Errorf("stale values are served for a miss: %v", res.Values)
+	}
+}
+
+func TestStaleOnErrorMeta(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Unix(1000, 0))
+	st := NewMemoryStore(MemoryStoreOptions{Clock: clock})
+	st.Set(ctx, &Item{Key: "a", Flags: 3, Data: []byte("old"), Expiration: clock.Now().Add(time.Second)})
+	clock.Advance(2 * time.Second)
+	if _, err := st.Get(ctx, "a"); err != ErrNotFound {
+		t.Fatalf("expected expired item, got %v", err)
+	}
+
+	fn := StaleOnError(StoreStale(st))(func(ctx context.Context, req *Request, res *Response) error {
+		return errors.New("backend is down")
+	})
+	res := &Response{}
+	err := fn(ctx, &Request{Command: "mg", Key: "a", Keys: []string{"a", "v", "f"}}, res)
+	if err != nil {
+		t.Fatalf("expected stale value, got %v", err)
+	}
+	if res.String() != "VA 3 f3 X\r\nold\r\n" {
+		t.Errorf("unexpected response: %q", res.String())
+	}
+
+	res = &Response{}
+	if err := fn(ctx, &Request{Command: "get", Keys: []string{"a"}}, res); err != nil {
+		t.Fatalf("expected stale value, got %v", err)
+	}
+	if res.String() != "VALUE a 3 3\r\nold\r\nEND\r\n" {
+		t.Errorf("unexpected response: %q", res.String())
+	}
+
+	if err := fn(ctx, &Request{Command: "mg", Key: "b", Keys: []string{"b", "v"}}, &Response{}); err == nil {
+		t.Errorf("expected the error without a stale value")
+	}
+}
@@ -0,0 +1,180 @@
+package mc
+
+import (
+	"context"
+	"strings"
+)
+
+// Stat is a statistic replied by the stats command as STAT <name> <value>.
+type Stat struct {
+	Name, Value string
+}
+
+// StatsReporter reports statistics of a group, which is the argument of the stats command
+// and empty for general statistics. It returns ErrNotSupported for unknown groups.
+type StatsReporter interface {
+	Stats(ctx context.Context, group string) ([]Stat, error)
+}
+
+// StatsScope is the sub-command of a stats request.
+type StatsScope string
+
+// Scopes of stats requests known by the server. Other scopes, like "rates", are passed to
+// reporters as is.
+const (
+	StatsGeneral  StatsScope = ""
+	StatsItems    StatsScope = "items"
+	StatsSlabs    StatsScope = "slabs"
+	StatsSettings StatsScope = "settings"
+	StatsSizes    StatsScope = "sizes"
+	StatsConns    StatsScope = "conns"
+	StatsReset    StatsScope = "reset"
+)
+
+// StatsRequest is a decoded stats command: stats [<scope> [<args>*]].
+type StatsRequest struct {
+	Scope StatsScope
+	// Args are the arguments after the scope, like the slab id and limit of "stats cachedump 1 10".
+	Args []string
+}
+
+// String returns the arguments of the stats command, which is the group of StatsReporter.
+func (r StatsRequest) String() string {
+	return strings.Join(append([]string{string(r.Scope)}, r.Args...), " ")
+}
+
+// ParseStatsRequest decodes the arguments of a stats command.
+// The known scopes, except the general statistics, take no arguments.
+func ParseStatsRequest(args []string) (StatsRequest, error) {
+	if len(args) == 0 {
+		return StatsRequest{}, nil
+	}
+	r := StatsRequest{Scope: StatsScope(args[0]), Args: args[1:]}
+	switch r.Scope {
+	case StatsItems, StatsSlabs, StatsSettings, StatsSizes, StatsConns, StatsReset:
+		if len(r.Args) > 0 {
+			return StatsRequest{}, NewError("bad arguments of stats " + string(r.Scope))
+		}
+	}
+	if len(r.Args) == 0 {
+		r.Args = nil
+	}
+	return r, nil
+}
+
+// StatsProvider is a StatsReporter which handles decoded stats requests, so it can switch on
+// scopes and use their arguments. StatsHandler and MultiStats prefer ProvideStats to Stats.
+// It returns ErrNotSupported for unknown scopes.
+type StatsProvider interface {
+	StatsReporter
+	ProvideStats(ctx context.Context, req StatsRequest) ([]Stat, error)
+}
+
+// StatsResetter is implemented by reporters whose counters can be reset by stats reset.
+// Gauges, like the number of items, are kept.
+type StatsResetter interface {
+	ResetStats(ctx context.Context) error
+}
+
+// provideStats returns the statistics of req reported by sr.
+func provideStats(ctx context.Context, sr StatsReporter, req StatsRequest) ([]Stat, error) {
+	if sp, ok := sr.(StatsProvider); ok {
+		return sp.ProvideStats(ctx, req)
+	}
+	return sr.Stats(ctx, req.String())
+}
+
+// StatsHandler handles the stats command with statistics reported by sr.
+// Stats reset replies RESET if sr is a StatsResetter.
+func StatsHandler(sr StatsReporter) HandlerFunc {
+	return func(ctx context.Context, req *Request, res *Response) error {
+		sreq, err := req.StatsRequest()
+		if err != nil {
+			return err
+		}
+		if rs, ok := sr.(StatsResetter); ok && sreq.Scope == StatsReset {
+			if err := rs.ResetStats(ctx); err != nil {
+				return err
+			}
+			return res.reply(RespReset)
+		}
+		stats, err := provideStats(ctx, sr, sreq)
+		if err != nil {
+			return err
+		}
+		return res.Stats(stats)
+	}
+}
+
+// MultiStats combines reporters. The general statistics are those of all reporters which
+// support them; other groups are reported by the first reporter which supports them.
+// Stats reset resets all reporters which are StatsResetters.
+func MultiStats(reporters ...StatsReporter) StatsReporter {
+	return multiStats(reporters)
+}
+
+type multiStats []StatsReporter
+
+func (ms multiStats) Stats(ctx context.Context, group string) ([]Stat, error) {
+	req, err := ParseStatsRequest(strings.Fields(group))
+	if err != nil {
+		return nil, err
+	}
+	return ms.ProvideStats(ctx, req)
+}
+
+func (ms multiStats) ResetStats(ctx context.Context) error {
+	found := false
+	for _, sr := range ms {
+		rs, ok := sr.(StatsResetter)
+		if !ok {
+			continue
+		}
+		if err := rs.ResetStats(ctx); err != nil && err != ErrNotSupported {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return ErrNotSupported
+	}
+	return nil
+}
+
+func (ms multiStats) ProvideStats(ctx context.Context, req StatsRequest) ([]Stat, error) {
+	var stats []Stat
+	found := false
+	for _, sr := range ms {
+		s, err := provideStats(ctx, sr, req)
+		if err == ErrNotSupported {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if req.Scope != StatsGeneral {
+			return s, nil
+		}
+		stats, found = append(stats, s...), true
+	}
+	if !found {
+		return nil, ErrNotSupported
+	}
+	return stats, nil
+}
+
+// onOff formats a boolean setting like memcached.
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// yesNo formats a boolean setting like memcached.
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,94 @@
+package mc
+
+import (
+	"bufio"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testStats map[string][]Stat
+
+func (ts testStats) Stats(ctx context.Context, group string) ([]Stat, error) {
+	stats, ok := ts[group]
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return stats, nil
+}
+
+func TestStatsHandler(t *testing.T) {
+	fn := StatsHandler(testStats{
+		"":      {{"pid", "1"}, {"uptime", "10"}},
+		"slabs": {{"active_slabs", "0"}},
+		"bad":   {{"a b", "1"}},
+	})
+	ctx := context.Background()
+
+	res := &Response{}
+	if err := fn(ctx, &Request{Command: "stats"}, res); err != nil || res.String() != "STAT pid 1\r\nSTAT uptime 10\r\nEND\r\n" {
+		t.Errorf("unexpected response: %q %v", res.String(), err)
+	}
+	res = &Response{}
+	if err := fn(ctx, &Request{Command: "stats", Keys: []string{"slabs"}}, res); err != nil || res.Response != "STAT active_slabs 0\r\nEND" {
+		t.Errorf("unexpected response: %q %v", res.Response, err)
+	}
+	if err := fn(ctx, &Request{Command: "stats", Keys: []string{"items"}}, &Response{}); err != ErrNotSupported {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+	if err := fn(ctx, &Request{Command: "stats", Keys: []string{"bad"}}, &Response{}); err != ErrInvalidResponse {
+		t.Errorf("expected ErrInvalidResponse, got %v", err)
+	}
+}
+
+func TestParseStatsRequest(t *testing.T) {
+	cases := []struct {
+		args []string
+		req  StatsRequest
+		ok   bool
+	}{
+		{nil, StatsRequest{}, true},
+		{[]string{"items"}, StatsRequest{Scope: StatsItems}, true},
+		{[]string{"reset"}, StatsRequest{Scope: StatsReset}, true},
+		{[]string{"cachedump", "1", "10"}, StatsRequest{Scope: "cachedump", Args: []string{"1", "10"}}, true},
+		{[]string{"rates"}, StatsRequest{Scope: "rates"}, true},
+		{[]string{"slabs", "1"}, StatsRequest{}, false},
+	}
+	for _, c := range cases {
+		req, err := ParseStatsRequest(c.args)
+		if (err == nil) != c.ok || !reflect.DeepEqual(req, c.req) {
+			t.Errorf("%q: unexpected request %+v: %v", c.args, req, err)
+		}
+	}
+
+	req, err := ReadRequest(bufio.NewReader(strings.NewReader("stats cachedump 1 10\r\n")))
+	if err != nil || req.Stats == nil || req.Stats.Scope != "cachedump" || req.Stats.String() != "cachedump 1 10" {
+		t.Errorf("unexpected request %+v: %v", req, err)
+	}
+	if _, err := ReadRequest(bufio.NewReader(strings.NewReader("stats reset all\r\n"))); err == nil {
+		t.Errorf("expected error for arguments of stats reset")
+	}
+}
+
+type testProvider struct{ testStats }
+
+func (tp testProvider) ProvideStats(ctx context.Context, req StatsRequest) ([]Stat, error) {
+	if req.Scope != "cachedump" {
+		return tp.Stats(ctx, string(req.Scope))
+	}
+	return []Stat{{"item:" + req.Args[0], "1"}}, nil
+}
+
+func TestMultiStatsProvider(t *testing.T) {
+	ms := MultiStats(testStats{"": {{"pid", "1"}}}, testProvider{testStats{"": {{"uptime", "10"}}}})
+	ctx := context.Background()
+
+	if stats, err := ms.Stats(ctx, ""); err != nil || len(stats) != 2 {
+		t.Errorf("unexpected general stats: %v %v", stats, err)
+	}
+	res := &Response{}
+	if err := StatsHandler(ms)(ctx, &Request{Command: "stats", Keys: []string{"cachedump", "k"}}, res); err != nil || res.Response != "STAT item:k 1\r\nEND" {
+		t.Errorf("unexpected response: %q %v", res.Response, err)
+	}
+}
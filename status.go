@@ -0,0 +1,266 @@
+package mc
+
+import (
+	"context"
+	"html/template"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// statusWindow is the period of the command rates and hot keys of status pages.
+const statusWindow = 10 * time.Second
+
+// statusHotKeys is the number of hot keys shown by status pages.
+const statusHotKeys = 20
+
+// statusGroups are the stats groups shown by status pages besides the general statistics,
+// if a reporter supports them.
+var statusGroups = []string{"settings", "rates", "latency", "usage"}
+
+// StatusPage is an HTML page of live statistics of a server for quick triage without external
+// monitoring: general stats, the rates of commands, hot keys and the open connections.
+// Rates and hot keys are counted in the last 10 to 20 seconds from a tap of the server, which
+// drops events under heavy load, so they are estimates. Keys are redacted by the redactor of the
+// server. The page refreshes itself every few seconds.
+type StatusPage struct {
+	s         *Server
+	reporters []StatsReporter
+	tap       *Tap
+	done      chan struct{}
+
+	mu        sync.Mutex
+	cur, prev statusCounts
+	curStart  time.Time
+	prevStart time.Time
+}
+
+// statusCounts counts requests of a window by command and by key.
+type statusCounts struct {
+	cmds map[string]uint64
+	keys map[string]uint64
+}
+
+func newStatusCounts() statusCounts {
+	return statusCounts{cmds: make(map[string]uint64), keys: make(map[string]uint64)}
+}
+
+// NewStatusPage creates a status page of s. sr reports the statistics of the page besides the
+// ones of s and of its Metrics, like a MemoryStore, and may be nil. The caller must call Close
+// to detach the tap of the page. See Server.ServeStatus.
+func NewStatusPage(s *Server, sr StatsReporter) *StatusPage {
+	reporters := []StatsReporter{s}
+	if sr != nil {
+		reporters = append(reporters, sr)
+	}
+	if m, ok := s.getMetrics().(StatsReporter); ok {
+		reporters = append(reporters, m)
+	}
+	now := clockOrSystem(s.Clock).Now()
+	p := &StatusPage{
+		s:         s,
+		reporters: reporters,
+		tap:       s.Tap(TapOptions{Buffer: 4096, HideData: true}),
+		done:      make(chan struct{}),
+		cur:       newStatusCounts(),
+		prev:      newStatusCounts(),
+		curStart:  now,
+		prevStart: now,
+	}
+	go p.collect()
+	return p
+}
+
+// Close detaches the tap of the page.
+func (p *StatusPage) Close() {
+	p.tap.Close()
+	<-p.done
+}
+
+// collect counts the events of the tap until it is closed.
+func (p *StatusPage) collect() {
+	defer close(p.done)
+	for e := range p.tap.C {
+		p.mu.Lock()
+		p.rotate(e.Time)
+		p.cur.cmds[e.Request.Command]++
+		if e.Request.Key != "" && len(e.Request.Keys) == 0 {
+			p.cur.keys[e.Request.Key]++
+		}
+		// the arguments of stats are not keys
+		for i := 0; e.Request.Stats == nil && i < len(e.Request.Keys); i++ {
+			p.cur.keys[e.Request.Keys[i]]++
+		}
+		p.mu.Unlock()
+	}
+}
+
+// rotate starts a new window if the current one is over. It must be called with mu held.
+func (p *StatusPage) rotate(now time.Time) {
+	if now.Sub(p.curStart) < statusWindow {
+		return
+	}
+	if now.Sub(p.curStart) < 2*statusWindow {
+		p.prev, p.prevStart = p.cur, p.curStart
+	} else {
+		p.prev, p.prevStart = newStatusCounts(), now.Add(-statusWindow)
+	}
+	p.cur, p.curStart = newStatusCounts(), now
+}
+
+// statusRate is a rate of requests per second of a command or a key.
+type statusRate struct {
+	Name string
+	Rate float64
+}
+
+// rates returns the rates of the current and previous windows, highest first, at most n if
+// n > 0. It must be called with mu held.
+func (p *StatusPage) rates(now time.Time, prev, cur map[string]uint64, n int) []statusRate {
+	secs := now.Sub(p.prevStart).Seconds()
+	if secs < 1 {
+		secs = 1
+	}
+	rates := make([]statusRate, 0, len(cur))
+	for name, c := range cur {
+		rates = append(rates, statusRate{name, float64(c+prev[name]) / secs})
+	}
+	for name, c := range prev {
+		if _, ok := cur[name]; !ok {
+			rates = append(rates, statusRate{name, float64(c) / secs})
+		}
+	}
+	sort.Slice(rates, func(i, j int) bool {
+		if rates[i].Rate != rates[j].Rate {
+			return rates[i].Rate > rates[j].Rate
+		}
+		return rates[i].Name < rates[j].Name
+	})
+	if n > 0 && len(rates) > n {
+		rates = rates[:n]
+	}
+	return rates
+}
+
+// statusGroup is a table of statistics of a status page.
+type statusGroup struct {
+	Name  string
+	Stats []Stat
+}
+
+// statusData is rendered by statusTemplate.
+type statusData struct {
+	Time     time.Time
+	Groups   []statusGroup
+	Commands []statusRate
+	HotKeys  []statusRate
+	Conns    []ConnInfo
+}
+
+// ServeHTTP renders the page.
+func (p *StatusPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	now := clockOrSystem(p.s.Clock).Now()
+	data := statusData{Time: now, Conns: p.s.Connections()}
+	for _, group := range append([]string{""}, statusGroups...) {
+		// unlike MultiStats, every reporter is shown, like the settings of both server and store
+		var stats []Stat
+		for _, sr := range p.reporters {
+			if s, err := sr.Stats(ctx, group); err == nil {
+				stats = append(stats, s...)
+			}
+		}
+		if len(stats) == 0 {
+			continue
+		}
+		name := group
+		if name == "" {
+			name = "general"
+		}
+		data.Groups = append(data.Groups, statusGroup{name, stats})
+	}
+
+	p.mu.Lock()
+	p.rotate(now)
+	data.Commands = p.rates(now, p.prev.cmds, p.cur.cmds, 0)
+	data.HotKeys = p.rates(now, p.prev.keys, p.cur.keys, statusHotKeys)
+	p.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTemplate.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ServeStatus serves a StatusPage of s over HTTP on addr, which must be another port than the
+// memcached ones, from Start until Stop. sr is passed to NewStatusPage. Start fails if addr can't
+// be listened on. It must be called before Start.
+func (s *Server) ServeStatus(addr string, sr StatsReporter) {
+	var hs *http.Server
+	var page *StatusPage
+	s.OnStart(func() error {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		page = NewStatusPage(s, sr)
+		hs = &http.Server{Handler: page}
+		go hs.Serve(ln)
+		return nil
+	})
+	s.OnStop(func() {
+		if hs == nil {
+			return
+		}
+		hs.Shutdown(context.Background())
+		page.Close()
+	})
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"rate": func(r float64) string { return strconv.FormatFloat(r, 'f', 1, 64) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>memcached status</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+</style>
+</head>
+<body>
+<h1>memcached status</h1>
+<p>{{.Time.Format "2006-01-02 15:04:05 MST"}}</p>
+
+<h2>Commands</h2>
+<table>
+<tr><th>command</th><th>requests/s</th></tr>
+{{range .Commands}}<tr><td>{{.Name}}</td><td>{{rate .Rate}}</td></tr>
+{{end}}</table>
+
+<h2>Hot keys</h2>
+<table>
+<tr><th>key</th><th>requests/s</th></tr>
+{{range .HotKeys}}<tr><td>{{.Name}}</td><td>{{rate .Rate}}</td></tr>
+{{end}}</table>
+
+<h2>Connections</h2>
+<table>
+<tr><th>remote</th><th>local</th><th>active</th><th>verbosity</th><th>protocol errors</th></tr>
+{{range .Conns}}<tr><td>{{.RemoteAddr}}</td><td>{{.LocalAddr}}</td><td>{{.Active}}</td><td>{{.Verbosity}}</td><td>{{.ProtocolErrors}}</td></tr>
+{{end}}</table>
+
+{{range .Groups}}<h2>Stats {{.Name}}</h2>
+<table>
+{{range .Stats}}<tr><td>{{.Name}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,80 @@
+package mc
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+func TestStatusPage(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	st := NewMemoryStore(MemoryStoreOptions{})
+	defer st.Close()
+	RegisterStore(s, st)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer s.Stop()
+	page := NewStatusPage(s, st)
+	defer page.Close()
+
+	mc := memcache.New(s.Addr().String())
+	mc.Set(&memcache.Item{Key: "hot", Value: []byte("v")})
+	for i := 0; i < 5; i++ {
+		mc.Get("hot")
+	}
+
+	var body string
+	for i := 0; i < 100 && !strings.Contains(body, "<td>hot</td>"); i++ {
+		time.Sleep(10 * time.Millisecond)
+		rec := httptest.NewRecorder()
+		page.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		body = rec.Body.String()
+	}
+	for _, s := range []string{"<td>hot</td>", "<td>gets</td>", "<td>curr_connections</td>", "Stats settings", "<td>hash_algorithm</td>"} {
+		if !strings.Contains(body, s) {
+			t.Errorf("status page has no %s: %s", s, body)
+		}
+	}
+}
+
+func TestServeStatus(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	s := NewServer("127.0.0.1:0")
+	s.ServeStatus(addr, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("failed to get the status page: %v", err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(b), "memcached status") {
+		t.Errorf("unexpected status page %d: %s", resp.StatusCode, b)
+	}
+	s.Stop()
+	if _, err := http.Get("http://" + addr + "/"); err == nil {
+		t.Errorf("status page is served after Stop")
+	}
+
+	s = NewServer("127.0.0.1:0")
+	s.ServeStatus("256.0.0.1:0", nil)
+	if err := s.Start(); err == nil {
+		s.Stop()
+		t.Errorf("server starts without its status page")
+	}
+}
@@ -0,0 +1,163 @@
+package mc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Item is an item in a store.
+type Item struct {
+	Key   string
+	Flags uint32
+	Data  []byte
+	// Cas is the unique version of the item, assigned by the store when the item is stored.
+	Cas uint64
+	// Expiration is when the item expires. Zero means it never expires.
+	Expiration time.Time
+	// Version is the version of the last write in multi-writer setups, see LastWriteWins.
+	Version ItemVersion
+}
+
+// Expired returns whether the item has expired at now.
+func (it *Item) Expired(now time.Time) bool {
+	return !it.Expiration.IsZero() && !now.Before(it.Expiration)
+}
+
+// SimpleStore is a store of single key operations.
+// Get and Delete return ErrNotFound for missing keys.
+type SimpleStore interface {
+	Get(ctx context.Context, key string) (*Item, error)
+	Set(ctx context.Context, item *Item) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Store is a store which also supports batch operations, so remote backends
+// can amortize round-trips. All operations should honor deadlines of ctx.
+type Store interface {
+	SimpleStore
+	// MGet returns found items by their keys. Missing keys are absent in the result.
+	MGet(ctx context.Context, keys []string) (map[string]*Item, error)
+	// MSet stores all items.
+	MSet(ctx context.Context, items []*Item) error
+}
+
+// Flusher is implemented by stores which can remove all items.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// Peeker is implemented by stores which read items without counting the reads as accesses,
+// so background readers like a Syncer neither skew hit rates nor keep cold items from eviction.
+// Peek returns found items by their keys like MGet.
+type Peeker interface {
+	Peek(ctx context.Context, keys []string) (map[string]*Item, error)
+}
+
+// peek reads items with the Peeker of st, or with MGet if it is not a Peeker.
+func peek(ctx context.Context, st Store, keys []string) (map[string]*Item, error) {
+	if p, ok := st.(Peeker); ok {
+		return p.Peek(ctx, keys)
+	}
+	return st.MGet(ctx, keys)
+}
+
+// Updater is implemented by stores which modify items atomically.
+// Update calls fn with a copy of the unexpired item of key, or nil if there is none, and stores
+// the item returned by fn unless fn returns an error, which Update returns then.
+// No other writes of key happen between reading and storing the item.
+type Updater interface {
+	Update(ctx context.Context, key string, fn func(it *Item) (*Item, error)) error
+}
+
+// Incrementer is implemented by stores which increment and decrement values atomically.
+// Incr adds delta to the value of key, wrapping around at 2^64 like memcached, or subtracts it
+// if decr is set, stopping at 0, and returns the new value. It returns ErrNotFound for missing
+// keys and ErrNonNumeric for values which are not decimal 64-bit unsigned integers.
+type Incrementer interface {
+	Incr(ctx context.Context, key string, delta uint64, decr bool) (uint64, error)
+}
+
+// incrValue returns the value of data after incr or decr by delta.
+func incrValue(data []byte, delta uint64, decr bool) (uint64, error) {
+	n, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, ErrNonNumeric
+	}
+	switch {
+	case !decr:
+		return n + delta, nil // wraps around on overflow
+	case n < delta:
+		return 0, nil
+	default:
+		return n - delta, nil
+	}
+}
+
+// Batch lifts a SimpleStore to a Store whose batch operations run the single key operations one by one.
+// It returns s itself if s is already a Store.
+func Batch(s SimpleStore) Store {
+	if st, ok := s.(Store); ok {
+		return st
+	}
+	return batchStore{s}
+}
+
+type batchStore struct {
+	SimpleStore
+}
+
+func (s batchStore) MGet(ctx context.Context, keys []string) (map[string]*Item, error) {
+	items := make(map[string]*Item, len(keys))
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		it, err := s.Get(ctx, key)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		items[key] = it
+	}
+	return items, nil
+}
+
+func (s batchStore) MSet(ctx context.Context, items []*Item) error {
+	for _, it := range items {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.Set(ctx, it); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush flushes the underlying store if it is a Flusher.
+func (s batchStore) Flush(ctx context.Context) error {
+	if f, ok := s.SimpleStore.(Flusher); ok {
+		return f.Flush(ctx)
+	}
+	return ErrNotSupported
+}
+
+// expiration converts exptime of requests to the expiration time.
+// 0 means never, values up to RealtimeMaxDelta are seconds from now, larger values are unix times,
+// and negative values expire immediately.
+func expiration(exptime int64, now time.Time) time.Time {
+	switch {
+	case exptime == 0:
+		return time.Time{}
+	case exptime < 0:
+		return now
+	case exptime <= RealtimeMaxDelta:
+		return now.Add(time.Duration(exptime) * time.Second)
+	default:
+		return time.Unix(exptime, 0)
+	}
+}
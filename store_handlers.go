@@ -0,0 +1,300 @@
+package mc
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Registrar registers handlers. It is implemented by Server, VirtualServer and Group.
+type Registrar interface {
+	RegisterFunc(cmd string, fn HandlerFunc) error
+}
+
+// storeClock returns the clock of st if it has a method Clock() Clock, or SystemClock.
+func storeClock(st Store) Clock {
+	var inner interface{} = st
+	if bs, ok := st.(batchStore); ok {
+		inner = bs.SimpleStore
+	}
+	if c, ok := inner.(interface{ Clock() Clock }); ok {
+		return c.Clock()
+	}
+	return SystemClock
+}
+
+// RegisterStore registers handlers of all storage, retrieval, deletion, touch, incr/decr and flush_all
+// commands backed by st, and the stats command if st is a StatsReporter.
+// The handlers use the clock of st if it has a method Clock() Clock, like MemoryStore.
+// Commands which read and then modify items, like incr and cas, are atomic: they use st's Updater
+// and Incrementer if it has them, otherwise the handlers serialize writes of each key, which is atomic only if
+// nothing else writes st.
+func RegisterStore(r Registrar, st Store) error {
+	h := &storeHandlers{st: st, clock: SystemClock}
+	var inner interface{} = st
+	if bs, ok := st.(batchStore); ok {
+		inner = bs.SimpleStore
+	}
+	h.updater, _ = inner.(Updater)
+	h.incrementer, _ = inner.(Incrementer)
+	h.clock = storeClock(st)
+	handlers := map[string]HandlerFunc{
+		"get":       h.get,
+		"gets":      h.get,
+		"set":       h.set,
+		"add":       h.add,
+		"replace":   h.replace,
+		"append":    h.concat,
+		"prepend":   h.concat,
+		"cas":       h.cas,
+		"delete":    h.delete,
+		"touch":     h.touch,
+		"incr":      h.incr,
+		"decr":      h.incr,
+		"flush_all": h.flushAll,
+	}
+	if sr, ok := st.(StatsReporter); ok {
+		handlers["stats"] = StatsHandler(sr)
+	}
+	for cmd, fn := range handlers {
+		if err := r.RegisterFunc(cmd, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockStripes is the number of locks which serialize writes of keys for stores without an Updater.
+const lockStripes = 256
+
+type storeHandlers struct {
+	st          Store
+	updater     Updater     // nil if st doesn't have one
+	incrementer Incrementer // nil if st doesn't have one
+	clock       Clock       // of st if it has a Clock method
+	locks       [lockStripes]sync.Mutex
+
+	flushMu   sync.Mutex
+	stopFlush func() bool // cancels the pending delayed flush_all
+}
+
+// newItem creates an item of a storage request. Its Data is copied if it's borrowed from the
+// read buffer, since stores keep it.
+func newItem(req *Request, now time.Time) *Item {
+	flags, _ := req.FlagsUint32()
+	data := req.Data
+	if req.borrowed {
+		data = append([]byte(nil), data...)
+	}
+	return &Item{
+		Key:        req.Key,
+		Flags:      flags,
+		Data:       data,
+		Expiration: expiration(req.Exptime, now),
+	}
+}
+
+func (h *storeHandlers) get(ctx context.Context, req *Request, res *Response) error {
+	items, err := h.st.MGet(ctx, req.Keys)
+	if err != nil {
+		return err
+	}
+	for _, key := range req.Keys {
+		it, ok := items[key]
+		if !ok {
+			continue
+		}
+		var cas uint64
+		if req.Command == "gets" {
+			cas = it.Cas
+		}
+		if err := res.AddValue(key, it.Flags, it.Data, cas); err != nil {
+			return err
+		}
+	}
+	return res.End()
+}
+
+func (h *storeHandlers) set(ctx context.Context, req *Request, res *Response) error {
+	defer h.lock(req.Key)()
+	if err := h.st.Set(ctx, newItem(req, h.clock.Now())); err != nil {
+		return err
+	}
+	return res.Stored()
+}
+
+func (h *storeHandlers) add(ctx context.Context, req *Request, res *Response) error {
+	err := h.update(ctx, req.Key, func(it *Item) (*Item, error) {
+		if it != nil {
+			return nil, ErrNotStored
+		}
+		return newItem(req, h.clock.Now()), nil
+	})
+	if err != nil {
+		return err
+	}
+	return res.Stored()
+}
+
+func (h *storeHandlers) replace(ctx context.Context, req *Request, res *Response) error {
+	err := h.update(ctx, req.Key, func(it *Item) (*Item, error) {
+		if it == nil {
+			return nil, ErrNotStored
+		}
+		return newItem(req, h.clock.Now()), nil
+	})
+	if err != nil {
+		return err
+	}
+	return res.Stored()
+}
+
+func (h *storeHandlers) concat(ctx context.Context, req *Request, res *Response) error {
+	err := h.update(ctx, req.Key, func(it *Item) (*Item, error) {
+		if it == nil {
+			return nil, ErrNotStored
+		}
+		data := make([]byte, 0, len(it.Data)+len(req.Data))
+		if req.Command == "append" {
+			data = append(append(data, it.Data...), req.Data...)
+		} else {
+			data = append(append(data, req.Data...), it.Data...)
+		}
+		it.Data = data
+		return it, nil
+	})
+	if err != nil {
+		return err
+	}
+	return res.Stored()
+}
+
+func (h *storeHandlers) cas(ctx context.Context, req *Request, res *Response) error {
+	err := h.update(ctx, req.Key, func(it *Item) (*Item, error) {
+		if it == nil {
+			return nil, ErrNotFound
+		}
+		if strconv.FormatUint(it.Cas, 10) != req.Cas {
+			return nil, ErrExists
+		}
+		return newItem(req, h.clock.Now()), nil
+	})
+	if err != nil {
+		return err
+	}
+	return res.Stored()
+}
+
+func (h *storeHandlers) delete(ctx context.Context, req *Request, res *Response) error {
+	defer h.lock(req.Key)()
+	if err := h.st.Delete(ctx, req.Key); err != nil {
+		return err
+	}
+	return res.Deleted()
+}
+
+func (h *storeHandlers) touch(ctx context.Context, req *Request, res *Response) error {
+	err := h.update(ctx, req.Key, func(it *Item) (*Item, error) {
+		if it == nil {
+			return nil, ErrNotFound
+		}
+		it.Expiration = expiration(req.Exptime, h.clock.Now())
+		return it, nil
+	})
+	if err != nil {
+		return err
+	}
+	return res.Touched()
+}
+
+func (h *storeHandlers) incr(ctx context.Context, req *Request, res *Response) error {
+	decr := req.Command == "decr"
+	if h.incrementer != nil {
+		n, err := h.incrementer.Incr(ctx, req.Key, req.Value, decr)
+		if err != nil {
+			return err
+		}
+		return res.Numeric(n)
+	}
+
+	var n uint64
+	err := h.update(ctx, req.Key, func(it *Item) (*Item, error) {
+		if it == nil {
+			return nil, ErrNotFound
+		}
+		var err error
+		if n, err = incrValue(it.Data, req.Value, decr); err != nil {
+			return nil, err
+		}
+		it.Data = []byte(strconv.FormatUint(n, 10))
+		return it, nil
+	})
+	if err != nil {
+		return err
+	}
+	return res.Numeric(n)
+}
+
+// update modifies the item of key atomically with fn, which gets nil if the item is missing.
+// It uses the store's Updater if there is one, otherwise the stripe lock of key serializes
+// the handlers which write key.
+func (h *storeHandlers) update(ctx context.Context, key string, fn func(it *Item) (*Item, error)) error {
+	if h.updater != nil {
+		return h.updater.Update(ctx, key, fn)
+	}
+
+	defer h.lock(key)()
+	it, err := h.st.Get(ctx, key)
+	if err == ErrNotFound {
+		it, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+	if it, err = fn(it); err != nil {
+		return err
+	}
+	return h.st.Set(ctx, it)
+}
+
+// lock locks the stripe of key and returns the function to unlock it.
+// It does nothing if the store has an Updater.
+func (h *storeHandlers) lock(key string) (unlock func()) {
+	if h.updater != nil {
+		return func() {}
+	}
+	mu := &h.locks[fnv32a(key)%uint32(len(h.locks))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// flushAll flushes the store, or schedules the flush if the request has a delay.
+// Like memcached, every flush_all replaces the pending delayed one.
+func (h *storeHandlers) flushAll(ctx context.Context, req *Request, res *Response) error {
+	f, ok := h.st.(Flusher)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	h.flushMu.Lock()
+	defer h.flushMu.Unlock()
+	if h.stopFlush != nil {
+		h.stopFlush()
+		h.stopFlush = nil
+	}
+	now := h.clock.Now()
+	if delay := expiration(req.Exptime, now).Sub(now); req.Exptime > 0 && delay > 0 {
+		h.stopFlush = h.clock.AfterFunc(delay, func() {
+			if err := f.Flush(context.Background()); err != nil {
+				log.Printf("delayed flush_all failed: %v", err)
+			}
+		})
+		return res.OK()
+	}
+	if err := f.Flush(ctx); err != nil {
+		return err
+	}
+	return res.OK()
+}
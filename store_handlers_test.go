@@ -0,0 +1,189 @@
+package mc
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// handlerMap is a Registrar for calling handlers directly.
+type handlerMap map[string]HandlerFunc
+
+func (m handlerMap) RegisterFunc(cmd string, fn HandlerFunc) error {
+	m[cmd] = fn
+	return nil
+}
+
+func (m handlerMap) call(req *Request) *Response {
+	res := &Response{}
+	if err := m[req.Command](context.Background(), req, res); err != nil {
+		setError(req, res, err)
+	}
+	return res
+}
+
+// lockedMapStore is a SimpleStore without an Updater which is safe for concurrent use.
+type lockedMapStore struct {
+	mu    sync.Mutex
+	items map[string]Item
+	cas   uint64
+}
+
+func (s *lockedMapStore) Get(ctx context.Context, key string) (*Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, ok := s.items[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &it, nil
+}
+
+func (s *lockedMapStore) Set(ctx context.Context, item *Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cas++
+	item.Cas = s.cas
+	s.items[item.Key] = *item
+	return nil
+}
+
+func (s *lockedMapStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.items[key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.items, key)
+	return nil
+}
+
+func TestStoreHandlersConcurrency(t *testing.T) {
+	stores := map[string]Store{
+		"memory":  NewMemoryStore(MemoryStoreOptions{Shards: 2}),
+		"arena":   NewMemoryStore(MemoryStoreOptions{Shards: 2, Arena: true, Index: IndexReadMostly}),
+		"striped": Batch(&lockedMapStore{items: map[string]Item{}}),
+	}
+	for name, st := range stores {
+		t.Run(name, func(t *testing.T) { testStoreHandlersConcurrency(t, st) })
+	}
+}
+
+func testStoreHandlersConcurrency(t *testing.T, st Store) {
+	h := handlerMap{}
+	RegisterStore(h, st)
+	h.call(&Request{Command: "set", Key: "counter", Flags: "0", Data: []byte("0")})
+	h.call(&Request{Command: "set", Key: "log", Flags: "0", Data: []byte{}})
+	h.call(&Request{Command: "set", Key: "versioned", Flags: "0", Data: []byte("0")})
+
+	const workers, ops = 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < ops; i++ {
+				h.call(&Request{Command: "incr", Key: "counter", Value: 2})
+				h.call(&Request{Command: "decr", Key: "counter", Value: 1})
+				h.call(&Request{Command: "append", Key: "log", Data: []byte("a")})
+				h.call(&Request{Command: "prepend", Key: "log", Data: []byte("p")})
+				h.call(&Request{Command: "add", Key: "once" + strconv.Itoa(i), Flags: strconv.Itoa(w), Data: []byte("x")})
+				h.call(&Request{Command: "touch", Key: "counter", Exptime: 100})
+
+				// optimistic increments with gets and cas
+				for {
+					res := h.call(&Request{Command: "gets", Keys: []string{"versioned"}})
+					n, _ := strconv.Atoi(string(res.Values[0].Data))
+					res = h.call(&Request{Command: "cas", Key: "versioned", Flags: "0", Cas: res.Values[0].Cas,
+						Data: []byte(strconv.Itoa(n + 1))})
+					if res.Response == RespStored {
+						break
+					}
+					if res.Response != RespExists {
+						t.Errorf("unexpected cas response: %q", res.Response)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	get := func(key string) string {
+		res := h.call(&Request{Command: "get", Keys: []string{key}})
+		if len(res.Values) != 1 {
+			t.Fatalf("%s is missing", key)
+		}
+		return string(res.Values[0].Data)
+	}
+	total := workers * ops
+	if v := get("counter"); v != strconv.Itoa(total) {
+		t.Errorf("expected counter %d, got %s", total, v)
+	}
+	if v := get("log"); len(v) != 2*total || v[0] != 'p' || v[len(v)-1] != 'a' {
+		t.Errorf("unexpected log of length %d", len(v))
+	}
+	if v := get("versioned"); v != strconv.Itoa(total) {
+		t.Errorf("expected version %d, got %s", total, v)
+	}
+	// exactly one add of each key succeeds, so all of them have the flags of their first writer
+	for i := 0; i < ops; i++ {
+		res := h.call(&Request{Command: "get", Keys: []string{"once" + strconv.Itoa(i)}})
+		if len(res.Values) != 1 || string(res.Values[0].Data) != "x" {
+			t.Errorf("unexpected value of once%d: %+v", i, res.Values)
+		}
+	}
+}
+
+func TestIncrOverflow(t *testing.T) {
+	stores := map[string]Store{
+		"memory":  NewMemoryStore(MemoryStoreOptions{}),
+		"arena":   NewMemoryStore(MemoryStoreOptions{Arena: true}),
+		"striped": Batch(&lockedMapStore{items: map[string]Item{}}),
+	}
+	for name, st := range stores {
+		h := handlerMap{}
+		RegisterStore(h, st)
+		h.call(&Request{Command: "set", Key: "n", Flags: "7", Data: []byte("18446744073709551614")})
+		h.call(&Request{Command: "set", Key: "s", Flags: "0", Data: []byte("abc")})
+
+		for _, c := range []struct {
+			cmd   string
+			key   string
+			delta uint64
+			res   string
+		}{
+			{"incr", "n", 1, "18446744073709551615"},
+			{"incr", "n", 3, "2"}, // wraps around
+			{"decr", "n", 5, "0"}, // stops at 0
+			{"incr", "n", 18446744073709551615, "18446744073709551615"},
+			{"incr", "s", 1, "CLIENT_ERROR cannot increment or decrement non-numeric value"},
+			{"decr", "missing", 1, RespNotFound},
+		} {
+			if res := h.call(&Request{Command: c.cmd, Key: c.key, Value: c.delta}); res.Response != c.res {
+				t.Errorf("%s: %s %s %d: expected %q, got %q", name, c.cmd, c.key, c.delta, c.res, res.Response)
+			}
+		}
+		if res := h.call(&Request{Command: "get", Keys: []string{"n"}}); len(res.Values) != 1 || res.Values[0].Flags != "7" {
+			t.Errorf("%s: incr should keep flags: %+v", name, res.Values)
+		}
+	}
+
+	if _, err := testReq("incr n -1\r\n", t); err == nil {
+		t.Errorf("negative delta should be rejected")
+	}
+}
+
+func TestStoreHandlersCopyBorrowedData(t *testing.T) {
+	h := handlerMap{}
+	RegisterStore(h, NewMemoryStore(MemoryStoreOptions{Shards: 2}))
+	buf := []byte("abc")
+	h.call(&Request{Command: "set", Key: "k", Flags: "0", Data: buf, borrowed: true})
+	copy(buf, "XYZ") // the read buffer is reused
+
+	res := h.call(&Request{Command: "get", Keys: []string{"k"}})
+	if len(res.Values) != 1 || string(res.Values[0].Data) != "abc" {
+		t.Errorf("unexpected values: %+v", res.Values)
+	}
+}
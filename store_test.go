@@ -0,0 +1,74 @@
+package mc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// mapStore is a SimpleStore for tests.
+type mapStore map[string]*Item
+
+func (m mapStore) Get(ctx context.Context, key string) (*Item, error) {
+	it, ok := m[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return it, nil
+}
+
+func (m mapStore) Set(ctx context.Context, item *Item) error {
+	m[item.Key] = item
+	return nil
+}
+
+func (m mapStore) Delete(ctx context.Context, key string) error {
+	if _, ok := m[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m, key)
+	return nil
+}
+
+func TestBatch(t *testing.T) {
+	st := Batch(mapStore{})
+	ctx := context.Background()
+
+	if err := st.MSet(ctx, []*Item{{Key: "a", Data: []byte("1")}, {Key: "b", Data: []byte("2")}}); err != nil {
+		t.Fatalf("MSet %v", err)
+	}
+	items, err := st.MGet(ctx, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("MGet %v", err)
+	}
+	if len(items) != 2 || string(items["b"].Data) != "2" {
+		t.Errorf("unexpected items: %v", items)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := st.MGet(canceled, []string{"a"}); err != context.Canceled {
+		t.Errorf("expected canceled error, got %v", err)
+	}
+
+	mem := NewMemoryStore(MemoryStoreOptions{})
+	if Batch(mem) != Store(mem) {
+		t.Errorf("Batch should return a Store as is")
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	now := time.Unix(1000000000, 0)
+	if !expiration(0, now).IsZero() {
+		t.Errorf("0 should never expire")
+	}
+	if e := expiration(10, now); !e.Equal(now.Add(10 * time.Second)) {
+		t.Errorf("relative exptime: %v", e)
+	}
+	if e := expiration(2000000000, now); !e.Equal(time.Unix(2000000000, 0)) {
+		t.Errorf("absolute exptime: %v", e)
+	}
+	if e := expiration(-1, now); !(&Item{Expiration: e}).Expired(now) {
+		t.Errorf("negative exptime should expire immediately")
+	}
+}
@@ -0,0 +1,74 @@
+package mc
+
+import (
+	"bufio"
+	"context"
+	"strconv"
+	"strings"
+)
+
+// StreamWriter writes the values of a response directly to the connection, for extension
+// commands which reply arbitrary amounts of data, like scans or dumps, without buffering them
+// in a Response. Handlers get it from their context by StreamFromContext.
+//
+// Once a handler writes to the stream, the values of its Response are not written. The Response
+// line, like END or a SERVER_ERROR for a failure in the middle, is still written after the
+// streamed chunks as the terminator. Streamed values are not limited by Server.MaxResponseSize,
+// which bounds buffered responses only.
+type StreamWriter struct {
+	w    *bufio.Writer
+	used bool
+}
+
+type streamKey struct{}
+
+// StreamFromContext returns the stream of the connection of a request, or nil if responses can't
+// be streamed, e.g. for AsyncRequests, whose responses are reordered, and for noreply requests,
+// whose responses are not written. Handlers must then reply by their Response.
+func StreamFromContext(ctx context.Context) *StreamWriter {
+	sw, _ := ctx.Value(streamKey{}).(*StreamWriter)
+	return sw
+}
+
+// WriteValue writes a value like Response.AddValue. cas is omitted if it is 0.
+func (sw *StreamWriter) WriteValue(key string, flags uint32, data []byte, cas uint64) error {
+	if !validKey(key) {
+		return ErrInvalidResponse
+	}
+	v := NewValue(key, flags, data)
+	if cas != 0 {
+		v.Cas = strconv.FormatUint(cas, 10)
+	}
+	sw.used = true
+	sw.w.Write(appendValueLine(nil, &v))
+	sw.w.Write(data)
+	_, err := sw.w.WriteString("\r\n")
+	return err
+}
+
+// WriteLine writes a line, like a STAT line, without the line ending. It fails for lines with
+// line breaks, which would be taken for other responses.
+func (sw *StreamWriter) WriteLine(line string) error {
+	if strings.ContainsAny(line, "\r\n") {
+		return ErrInvalidResponse
+	}
+	sw.used = true
+	sw.w.WriteString(line)
+	_, err := sw.w.WriteString("\r\n")
+	return err
+}
+
+// Flush sends the buffered chunks to the client.
+func (sw *StreamWriter) Flush() error {
+	return sw.w.Flush()
+}
+
+// streamed returns whether the stream of ctx has been written since the last call.
+func streamed(ctx context.Context) bool {
+	sw := StreamFromContext(ctx)
+	if sw == nil || !sw.used {
+		return false
+	}
+	sw.used = false
+	return true
+}
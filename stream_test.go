@@ -0,0 +1,72 @@
+package mc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestStreamWriter(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.RegisterFunc("scan", func(ctx context.Context, req *Request, res *Response) error {
+		sw := StreamFromContext(ctx)
+		if sw == nil {
+			return res.ServerError("no stream")
+		}
+		for i := 0; i < 3; i++ {
+			if err := sw.WriteValue(fmt.Sprintf("k%d", i), 0, []byte("v"), 0); err != nil {
+				return err
+			}
+		}
+		if sw.WriteLine("bad\r\nline") != ErrInvalidResponse || sw.WriteValue("bad key", 0, nil, 0) != ErrInvalidResponse {
+			t.Errorf("invalid chunks are written")
+		}
+		// the values of the response are ignored
+		res.AddValue("ignored", 0, []byte("x"), 0)
+		return res.End()
+	})
+	s.RegisterFunc("version", func(ctx context.Context, req *Request, res *Response) error {
+		return res.Version("1")
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	// noreply requests don't stream values either
+	conn.Write([]byte("scan\r\nscan noreply\r\nversion\r\n"))
+
+	want := "VALUE k0 0 1\r\nv\r\nVALUE k1 0 1\r\nv\r\nVALUE k2 0 1\r\nv\r\nEND\r\nVERSION 1\r\n"
+	b := make([]byte, len(want))
+	if _, err := io.ReadFull(bufio.NewReader(conn), b); err != nil || string(b) != want {
+		t.Errorf("unexpected response %q: %v", b, err)
+	}
+}
+
+func TestStreamWriterAsync(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	s.AsyncRequests = 4
+	s.RegisterFunc("scan", func(ctx context.Context, req *Request, res *Response) error {
+		if StreamFromContext(ctx) != nil {
+			return res.ServerError("stream of async requests")
+		}
+		return res.End()
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer s.Stop()
+
+	if line := roundTrip(t, s.Addr().String(), "scan\r\n"); !strings.HasPrefix(line, RespEnd) {
+		t.Errorf("unexpected response %q", line)
+	}
+}
@@ -0,0 +1,204 @@
+package mc
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Digester is implemented by stores which return digests of items, so replicas can find
+// divergent items without transferring their data.
+type Digester interface {
+	// Digests returns the digests of found items, see ItemDigest.
+	Digests(ctx context.Context, keys []string) (map[string]uint64, error)
+}
+
+// ItemDigest returns the digest of the flags, data and expiration of an item in seconds.
+func ItemDigest(it *Item) uint64 {
+	h := fnv.New64a()
+	var buf [12]byte
+	binary.BigEndian.PutUint32(buf[:4], it.Flags)
+	if !it.Expiration.IsZero() {
+		binary.BigEndian.PutUint64(buf[4:], uint64(it.Expiration.Unix()))
+	}
+	h.Write(buf[:])
+	h.Write(it.Data)
+	return h.Sum64()
+}
+
+// Digests implements Digester. Reading digests doesn't count as accesses of items.
+func (s *MemoryStore) Digests(ctx context.Context, keys []string) (map[string]uint64, error) {
+	items, err := s.Peek(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	digests := make(map[string]uint64, len(items))
+	for key, it := range items {
+		digests[key] = ItemDigest(it)
+	}
+	return digests, nil
+}
+
+// RangeStore is a Store whose keys can be ranged.
+type RangeStore interface {
+	Store
+	KeyRanger
+}
+
+// SyncStats are the numbers of items checked and repaired by a Syncer.
+type SyncStats struct {
+	Checked  uint64
+	Repaired uint64 // local items replaced by those of the primary
+	Removed  uint64 // local items missing in the primary
+}
+
+// Syncer repairs a replica in the background (anti-entropy): it compares the items of Local with
+// those of Primary in batches, replaces stale ones and removes those missing in Primary.
+// Items missing in Local are left to read-through, see ReadReplica.
+// Items are repaired by Apply if Local is an Applier, e.g. a LWWStore, so newer local items are kept.
+// Digests are compared first if Primary is a Digester, so only divergent items are fetched.
+// Local items are read with Peek if Local is a Peeker, so syncing doesn't count as accesses.
+type Syncer struct {
+	Local   RangeStore
+	Primary Store
+	// Interval is the time between passes over all keys. Default is 1 minute.
+	Interval time.Duration
+	// BatchSize is the number of keys compared at a time. Default is 100.
+	BatchSize int
+	// KeysPerSecond limits the keys compared per second to bound the bandwidth used.
+	// 0 means no limit.
+	KeysPerSecond int
+
+	checked, repaired, removed uint64
+}
+
+// Run runs passes until ctx is done.
+func (s *Syncer) Run(ctx context.Context) error {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	for {
+		if err := s.Pass(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("replica sync pass failed, retrying in %v: %v", interval, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Pass compares all keys of Local once.
+func (s *Syncer) Pass(ctx context.Context) error {
+	size := s.BatchSize
+	if size <= 0 {
+		size = 100
+	}
+
+	var keys []string
+	err := s.Local.Range(ctx, func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	for i := 0; i < len(keys); i += size {
+		end := i + size
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := s.syncKeys(ctx, keys[i:end]); err != nil {
+			return err
+		}
+
+		if s.KeysPerSecond > 0 {
+			// sleeps until the compared keys are within the limit
+			due := start.Add(time.Duration(end) * time.Second / time.Duration(s.KeysPerSecond))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Until(due)):
+			}
+		}
+	}
+	return nil
+}
+
+// syncKeys compares and repairs the items of keys.
+func (s *Syncer) syncKeys(ctx context.Context, keys []string) error {
+	local, err := peek(ctx, s.Local, keys)
+	if err != nil {
+		return err
+	}
+	atomic.AddUint64(&s.checked, uint64(len(keys)))
+
+	fetch := keys
+	if d, ok := s.Primary.(Digester); ok {
+		digests, err := d.Digests(ctx, keys)
+		if err != nil {
+			return err
+		}
+		fetch = nil
+		for _, key := range keys {
+			it, ok := local[key]
+			digest, found := digests[key]
+			if !ok && !found {
+				continue
+			}
+			if !ok || !found || ItemDigest(it) != digest {
+				fetch = append(fetch, key)
+			}
+		}
+		if len(fetch) == 0 {
+			return nil
+		}
+	}
+
+	primary, err := s.Primary.MGet(ctx, fetch)
+	if err != nil {
+		return err
+	}
+	for _, key := range fetch {
+		it, ok := primary[key]
+		if !ok {
+			if _, exists := local[key]; exists && s.Local.Delete(ctx, key) == nil {
+				atomic.AddUint64(&s.removed, 1)
+			}
+			continue
+		}
+		if old, exists := local[key]; exists && ItemDigest(old) == ItemDigest(it) {
+			continue
+		}
+		cp := *it
+		if a, ok := s.Local.(Applier); ok {
+			err = a.Apply(ctx, &cp)
+		} else {
+			err = s.Local.Set(ctx, &cp)
+		}
+		if err == ErrNotStored {
+			continue // the local item is newer
+		}
+		if err != nil {
+			return err
+		}
+		atomic.AddUint64(&s.repaired, 1)
+	}
+	return nil
+}
+
+// Stats returns the numbers of items checked and repaired so far.
+func (s *Syncer) Stats() SyncStats {
+	return SyncStats{
+		Checked:  atomic.LoadUint64(&s.checked),
+		Repaired: atomic.LoadUint64(&s.repaired),
+		Removed:  atomic.LoadUint64(&s.removed),
+	}
+}
@@ -0,0 +1,86 @@
+package mc
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// plainStore hides the optional interfaces of a store, like Digester and Updater.
+type plainStore struct {
+	Store
+}
+
+func TestSyncer(t *testing.T) {
+	for _, digests := range []bool{true, false} {
+		ctx := context.Background()
+		local, primary := NewMemoryStore(MemoryStoreOptions{}), NewMemoryStore(MemoryStoreOptions{})
+		for i := 0; i < 10; i++ {
+			it := &Item{Key: "k" + strconv.Itoa(i), Data: []byte("v")}
+			local.Set(ctx, it)
+			primary.Set(ctx, &Item{Key: it.Key, Data: it.Data})
+		}
+		primary.Set(ctx, &Item{Key: "k1", Data: []byte("new")})
+		primary.Set(ctx, &Item{Key: "k2", Data: []byte("v"), Flags: 1})
+		primary.Delete(ctx, "k3")
+
+		s := &Syncer{Local: local, Primary: primary, BatchSize: 3}
+		if !digests {
+			s.Primary = plainStore{primary}
+		}
+		lc, pc := local.Counters(), primary.Counters()
+		if err := s.Pass(ctx); err != nil {
+			t.Fatalf("Pass %v", err)
+		}
+		if c := local.Counters(); c.Gets != lc.Gets {
+			t.Errorf("syncing counts %d gets of local", c.Gets-lc.Gets)
+		}
+		if c := primary.Counters(); digests && c.Gets-pc.Gets != 3 {
+			t.Errorf("digests count as gets of primary: %d gets for 3 divergent items", c.Gets-pc.Gets)
+		}
+		if st := s.Stats(); st != (SyncStats{Checked: 10, Repaired: 2, Removed: 1}) {
+			t.Errorf("unexpected stats: %+v", st)
+		}
+		if it, _ := local.Get(ctx, "k1"); string(it.Data) != "new" {
+			t.Errorf("stale item is not repaired: %q", it.Data)
+		}
+		if it, _ := local.Get(ctx, "k2"); it.Flags != 1 {
+			t.Errorf("stale flags are not repaired: %d", it.Flags)
+		}
+		if _, err := local.Get(ctx, "k3"); err != ErrNotFound {
+			t.Errorf("item missing in primary is not removed: %v", err)
+		}
+	}
+}
+
+func TestSyncerRateLimit(t *testing.T) {
+	ctx := context.Background()
+	local := NewMemoryStore(MemoryStoreOptions{})
+	for i := 0; i < 20; i++ {
+		local.Set(ctx, &Item{Key: "k" + strconv.Itoa(i)})
+	}
+	s := &Syncer{Local: local, Primary: local, BatchSize: 5, KeysPerSecond: 100}
+	start := time.Now()
+	s.Pass(ctx)
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("20 keys at 100 keys/s took %v", d)
+	}
+}
+
+// unrangedStore is a RangeStore whose keys can't be ranged.
+type unrangedStore struct {
+	Store
+}
+
+func (unrangedStore) Range(ctx context.Context, fn func(key string) bool) error {
+	return ErrNotSupported
+}
+
+func TestSyncerRangeError(t *testing.T) {
+	st := NewMemoryStore(MemoryStoreOptions{})
+	s := &Syncer{Local: unrangedStore{st}, Primary: st}
+	if err := s.Pass(context.Background()); err != ErrNotSupported {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
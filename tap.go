@@ -0,0 +1,234 @@
+package mc
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TapEvent is a request and its response observed by a tap.
+type TapEvent struct {
+	Time     time.Time
+	Remote   net.Addr
+	Request  *Request
+	Response *Response
+}
+
+// String formats the event as one line. Data blocks are shown as their sizes.
+func (e TapEvent) String() string {
+	var keys []string
+	if e.Request.Key != "" {
+		keys = []string{e.Request.Key}
+	} else {
+		keys = e.Request.Keys
+	}
+	return fmt.Sprintf("%s %v %s %s bytes=%d values=%d -> %s",
+		e.Time.Format(time.RFC3339Nano), e.Remote, e.Request.Command, strings.Join(keys, " "),
+		len(e.Request.Data), len(e.Response.Values), e.Response.Response)
+}
+
+// TapFilter selects requests observed by a tap. Zero fields match everything.
+type TapFilter struct {
+	// Commands are command names to match.
+	Commands []string
+	// KeyPrefix matches requests which have a key with this prefix.
+	KeyPrefix string
+	// ClientIP matches requests from this client IP.
+	ClientIP string
+}
+
+func (f *TapFilter) match(remote net.Addr, req *Request) bool {
+	if len(f.Commands) > 0 {
+		found := false
+		for _, cmd := range f.Commands {
+			if cmd == req.Command {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.KeyPrefix != "" {
+		found := strings.HasPrefix(req.Key, f.KeyPrefix)
+		for i := 0; !found && i < len(req.Keys); i++ {
+			found = strings.HasPrefix(req.Keys[i], f.KeyPrefix)
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.ClientIP != "" {
+		if remote == nil {
+			return false
+		}
+		host, _, err := net.SplitHostPort(remote.String())
+		if err != nil {
+			host = remote.String()
+		}
+		if host != f.ClientIP {
+			return false
+		}
+	}
+
+	return true
+}
+
+// TapOptions configures a tap.
+type TapOptions struct {
+	Filter TapFilter
+	// Rate is the max number of events per second, 0 means unlimited.
+	Rate int
+	// Buffer is the capacity of the event channel. Default is 128.
+	Buffer int
+	// HideData removes data blocks of requests and values of responses.
+	HideData bool
+	// Redactor redacts keys and data of events. The redactor of the server is used if it is nil.
+	Redactor Redactor
+}
+
+// Tap is a live stream of requests and responses handled by a server.
+// Events are dropped if the consumer is slow or the rate limit is exceeded.
+type Tap struct {
+	dropped uint64 // accessed atomically, first so it is 64-bit aligned on 32-bit platforms
+
+	// C delivers events. It is closed by Close.
+	C <-chan TapEvent
+
+	c    chan TapEvent
+	s    *Server
+	opts TapOptions
+
+	mu     sync.Mutex
+	closed bool
+	window time.Time
+	count  int
+}
+
+// Tap attaches a tap to the server.
+// The caller must call Close to detach it.
+func (s *Server) Tap(opts TapOptions) *Tap {
+	if opts.Buffer <= 0 {
+		opts.Buffer = 128
+	}
+	c := make(chan TapEvent, opts.Buffer)
+	t := &Tap{C: c, c: c, s: s, opts: opts}
+
+	s.taps.Store(t, struct{}{})
+	atomic.AddInt32(&s.tapCount, 1)
+	return t
+}
+
+// Dropped returns the number of dropped events.
+func (t *Tap) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// Close detaches the tap from the server and closes C.
+func (t *Tap) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	t.closed = true
+	t.s.taps.Delete(t)
+	atomic.AddInt32(&t.s.tapCount, -1)
+	close(t.c)
+}
+
+// WriteTo writes events to w, one per line, until the tap is closed or writing fails.
+func (t *Tap) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for e := range t.C {
+		n, err := io.WriteString(w, e.String()+"\n")
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func (t *Tap) publish(e TapEvent) {
+	if !t.opts.Filter.match(e.Remote, e.Request) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	if t.opts.Rate > 0 {
+		if e.Time.Sub(t.window) >= time.Second {
+			t.window = e.Time
+			t.count = 0
+		}
+		if t.count >= t.opts.Rate {
+			atomic.AddUint64(&t.dropped, 1)
+			return
+		}
+		t.count++
+	}
+
+	if t.opts.HideData {
+		e = hideData(e)
+	}
+	r := t.opts.Redactor
+	if r == nil {
+		r = t.s.getRedactor()
+	}
+	e.Request, e.Response = RedactRequest(r, e.Request), RedactResponse(r, e.Response)
+	select {
+	case t.c <- e:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+// hideData returns a copy of e without data blocks.
+func hideData(e TapEvent) TapEvent {
+	req := *e.Request
+	req.Data, req.Raw = nil, nil
+	if req.Batch != nil {
+		req.Batch = make([]*Request, len(e.Request.Batch))
+		for i, item := range e.Request.Batch {
+			c := *item
+			c.Data, c.Raw = nil, nil
+			req.Batch[i] = &c
+		}
+	}
+	res := *e.Response
+	res.Values = make([]Value, len(e.Response.Values))
+	for i, v := range e.Response.Values {
+		v.Data = nil
+		res.Values[i] = v
+	}
+	res.Data = nil
+	e.Request, e.Response = &req, &res
+	return e
+}
+
+// publishTaps sends a handled request to all attached taps.
+func (s *Server) publishTaps(remote net.Addr, req *Request, res *Response) {
+	if atomic.LoadInt32(&s.tapCount) == 0 {
+		return
+	}
+
+	if s.ZeroCopy {
+		req = req.Clone() // taps read events after the buffer is reused
+	}
+	e := TapEvent{Time: clockOrSystem(s.Clock).Now(), Remote: remote, Request: req, Response: res}
+	s.taps.Range(func(k, v interface{}) bool {
+		k.(*Tap).publish(e)
+		return true
+	})
+}
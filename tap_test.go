@@ -0,0 +1,67 @@
+package mc
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTapFilter(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}
+
+	cases := []struct {
+		filter TapFilter
+		req    *Request
+		match  bool
+	}{
+		{TapFilter{}, &Request{Command: "get"}, true},
+		{TapFilter{Commands: []string{"set"}}, &Request{Command: "get"}, false},
+		{TapFilter{KeyPrefix: "user:"}, &Request{Command: "get", Keys: []string{"a", "user:1"}}, true},
+		{TapFilter{KeyPrefix: "user:"}, &Request{Command: "set", Key: "session:1"}, false},
+		{TapFilter{ClientIP: "10.0.0.1"}, &Request{Command: "get"}, true},
+		{TapFilter{ClientIP: "10.0.0.2"}, &Request{Command: "get"}, false},
+	}
+
+	for i, c := range cases {
+		if got := c.filter.match(remote, c.req); got != c.match {
+			t.Errorf("case %d: expected %v, got %v", i, c.match, got)
+		}
+	}
+}
+
+func TestTap(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+	s.RegisterFunc("set", DefaultSet)
+
+	tap := s.Tap(TapOptions{Filter: TapFilter{Commands: []string{"set"}}, HideData: true})
+	defer tap.Close()
+
+	roundTrip(t, addr, "get foo\r\n")
+	roundTrip(t, addr, "set foo 0 0 3\r\nbar\r\n")
+
+	select {
+	case e := <-tap.C:
+		if e.Request.Command != "set" || e.Request.Key != "foo" || e.Response.Response != RespStored {
+			t.Errorf("unexpected event: %s", e)
+		}
+		if e.Request.Data != nil {
+			t.Errorf("data is not hidden: %q", e.Request.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no tap event")
+	}
+}
+
+func TestTapRate(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	tap := s.Tap(TapOptions{Rate: 2})
+	defer tap.Close()
+
+	for i := 0; i < 5; i++ {
+		s.publishTaps(nil, &Request{Command: "get"}, &Response{})
+	}
+	if len(tap.C) != 2 || tap.Dropped() != 3 {
+		t.Errorf("expected 2 events and 3 dropped, got %d and %d", len(tap.C), tap.Dropped())
+	}
+}
@@ -0,0 +1,34 @@
+package mc
+
+import (
+	"strconv"
+	"time"
+)
+
+// metaCommands are the meta commands whose arguments after the key are flags.
+var metaCommands = map[string]bool{"mg": true, "ms": true, "md": true, "ma": true, "me": true}
+
+// MetaTimeoutFlag returns a Server.RequestTimeout which reads timeout hints in milliseconds from
+// the flag of meta commands, e.g. "mg foo v X250" with flag 'X' has a timeout of 250ms.
+// The flag should be a letter which the meta protocol doesn't use. It is removed from the request,
+// so handlers see the flags they know only.
+func MetaTimeoutFlag(flag byte) func(req *Request) time.Duration {
+	return func(req *Request) time.Duration {
+		if !metaCommands[req.Command] || len(req.Keys) < 2 {
+			return 0
+		}
+		for i := 1; i < len(req.Keys); i++ {
+			f := req.Keys[i]
+			if len(f) < 2 || f[0] != flag {
+				continue
+			}
+			ms, err := strconv.ParseUint(f[1:], 10, 32)
+			if err != nil {
+				continue
+			}
+			req.Keys = append(req.Keys[:i:i], req.Keys[i+1:]...)
+			return time.Duration(ms) * time.Millisecond
+		}
+		return 0
+	}
+}
@@ -0,0 +1,57 @@
+package mc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetaTimeoutFlag(t *testing.T) {
+	fn := MetaTimeoutFlag('X')
+	req := &Request{Command: "mg", Key: "foo", Keys: []string{"foo", "v", "X250", "t"}}
+	if d := fn(req); d != 250*time.Millisecond {
+		t.Errorf("expected 250ms, got %v", d)
+	}
+	if strings.Join(req.Keys, " ") != "foo v t" {
+		t.Errorf("the flag is not removed: %v", req.Keys)
+	}
+
+	for _, req := range []*Request{
+		{Command: "mg", Keys: []string{"foo", "v"}},
+		{Command: "mg", Keys: []string{"X250"}},
+		{Command: "mg", Keys: []string{"foo", "Xabc"}},
+		{Command: "get", Keys: []string{"foo", "X250"}},
+	} {
+		if d := fn(req); d != 0 {
+			t.Errorf("unexpected timeout %v of %+v", d, req)
+		}
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	port, _ := getFreePort()
+	s := NewServer("127.0.0.1:" + strconv.Itoa(port))
+	s.RequestTimeout = MetaTimeoutFlag('X')
+	s.RegisterFunc("mg", func(ctx context.Context, req *Request, res *Response) error {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return res.reply("HD")
+		}
+		if time.Until(deadline) > time.Second {
+			return res.ServerError("bad deadline")
+		}
+		return res.reply("HD " + strings.Join(req.Keys[1:], " ") + " deadline")
+	})
+	s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	if line := roundTrip(t, s.ln.Addr().String(), "mg foo v X500\r\n"); line != "HD v deadline\r\n" {
+		t.Errorf("unexpected response: %q", line)
+	}
+	if line := roundTrip(t, s.ln.Addr().String(), "mg foo v\r\n"); line != "HD\r\n" {
+		t.Errorf("unexpected response: %q", line)
+	}
+}
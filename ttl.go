@@ -0,0 +1,143 @@
+package mc
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// MetaItem is an item with its metadata, returned by lookups of meta and ttl commands.
+type MetaItem struct {
+	Value
+	// TTL is the remaining time to live. Negative means the item never expires.
+	TTL time.Duration
+}
+
+// MetaLookup looks up an item. It returns nil for a miss.
+type MetaLookup func(ctx context.Context, key string) (*MetaItem, error)
+
+// StoreMetaLookup returns a MetaLookup of the items of st, for TTLHandler and MetaGetHandler.
+// TTLs are counted by the clock of st if it has a method Clock() Clock, like MemoryStore.
+func StoreMetaLookup(st Store) MetaLookup {
+	clock := storeClock(st)
+	return func(ctx context.Context, key string) (*MetaItem, error) {
+		it, err := st.Get(ctx, key)
+		if err == ErrNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		item := &MetaItem{Value: NewValue(it.Key, it.Flags, it.Data), TTL: -1}
+		if it.Cas != 0 {
+			item.Cas = strconv.FormatUint(it.Cas, 10)
+		}
+		if !it.Expiration.IsZero() {
+			if item.TTL = it.Expiration.Sub(clock.Now()); item.TTL < 0 {
+				item.TTL = 0
+			}
+		}
+		return item, nil
+	}
+}
+
+// ttlSeconds returns the remaining seconds of item, rounded up, or -1 if it never expires.
+func ttlSeconds(item *MetaItem) int64 {
+	if item.TTL < 0 {
+		return -1
+	}
+	return int64((item.TTL + time.Second - 1) / time.Second)
+}
+
+// TTLHandler returns a handler of the ttl extension command:
+//
+//	ttl <key>\r\n
+//
+// It replies the remaining seconds of the key, -1 if the key never expires or -2 if the key does not exist.
+//
+// Register it to enable the command:
+//
+//	s.RegisterFunc("ttl", mc.TTLHandler(lookup))
+func TTLHandler(lookup MetaLookup) HandlerFunc {
+	return func(ctx context.Context, req *Request, res *Response) error {
+		if req.Key == "" {
+			res.Response = RespClientErr + `too few params to command "ttl"`
+			return nil
+		}
+		item, err := lookup(ctx, req.Key)
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			res.Response = "-2"
+			return nil
+		}
+		res.Response = strconv.FormatInt(ttlSeconds(item), 10)
+		return nil
+	}
+}
+
+// MetaGetHandler returns a handler of the meta get command:
+//
+//	mg <key> <flag>*\r\n
+//
+// Supported flags are v (return the value), t (return TTL, -1 for no expiration), f (return client flags),
+// s (return size), k (return key), c (return cas, omitted for items without one) and O<opaque>
+// (return opaque).
+// It replies "VA <size> <flag>*" followed by the data block if v is set, "HD <flag>*" otherwise,
+// or "EN" for a miss.
+//
+// Register it to enable the command, e.g. with the items of a store:
+//
+//	s.RegisterFunc("mg", mc.MetaGetHandler(mc.StoreMetaLookup(st)))
+func MetaGetHandler(lookup MetaLookup) HandlerFunc {
+	return func(ctx context.Context, req *Request, res *Response) error {
+		if req.Key == "" {
+			return res.ClientError(`too few params to command "mg"`)
+		}
+		item, err := lookup(ctx, req.Key)
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			return res.MetaMiss()
+		}
+
+		var withValue bool
+		var ret []string
+		for _, f := range req.Keys[1:] {
+			if f == "" {
+				continue
+			}
+			switch f[0] {
+			case 'v':
+				withValue = true
+			case 't':
+				ret = append(ret, "t"+strconv.FormatInt(ttlSeconds(item), 10))
+			case 'f':
+				flags := item.Flags
+				if flags == "" {
+					flags = "0"
+				}
+				ret = append(ret, "f"+flags)
+			case 's':
+				ret = append(ret, "s"+strconv.Itoa(len(item.Data)))
+			case 'k':
+				ret = append(ret, "k"+req.Key)
+			case 'c':
+				if item.Cas != "" {
+					ret = append(ret, "c"+item.Cas)
+				}
+			case 'O':
+				ret = append(ret, f)
+			default:
+				return res.ClientError("invalid flag " + f)
+			}
+		}
+
+		if !withValue {
+			return res.MetaHit(ret...)
+		}
+		return res.MetaValue(item.Data, ret...)
+	}
+}
@@ -0,0 +1,78 @@
+package mc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func testMetaLookup(ctx context.Context, key string) (*MetaItem, error) {
+	switch key {
+	case "forever":
+		return &MetaItem{Value: Value{Key: key, Flags: "3", Data: []byte("abc"), Cas: "7"}, TTL: -1}, nil
+	case "soon":
+		return &MetaItem{Value: Value{Key: key, Data: []byte("x")}, TTL: 1500 * time.Millisecond}, nil
+	}
+	return nil, nil
+}
+
+func TestTTLHandler(t *testing.T) {
+	fn := TTLHandler(testMetaLookup)
+	cases := map[string]string{"forever": "-1", "soon": "2", "missing": "-2"}
+	for key, expected := range cases {
+		res := &Response{}
+		fn(context.Background(), &Request{Command: "ttl", Key: key, Keys: []string{key}}, res)
+		if res.Response != expected {
+			t.Errorf("%s: expected %s, got %s", key, expected, res.Response)
+		}
+	}
+}
+
+func TestMetaGetHandler(t *testing.T) {
+	fn := MetaGetHandler(testMetaLookup)
+	cases := []struct {
+		keys     []string
+		expected string
+	}{
+		{[]string{"forever", "v", "t", "f", "c"}, "VA 3 t-1 f3 c7\r\nabc\r\n"},
+		{[]string{"soon", "t", "s", "k", "Oabc"}, "HD t2 s1 ksoon Oabc\r\n"},
+		{[]string{"missing", "v"}, "EN\r\n"},
+		{[]string{"soon", "z"}, RespClientErr + "invalid flag z\r\n"},
+		// items without cas have none to return
+		{[]string{"soon", "c", "s"}, "HD s1\r\n"},
+	}
+	for _, c := range cases {
+		res := &Response{}
+		fn(context.Background(), &Request{Command: "mg", Key: c.keys[0], Keys: c.keys}, res)
+		if res.String() != c.expected {
+			t.Errorf("%v: expected %q, got %q", c.keys, c.expected, res.String())
+		}
+	}
+}
+
+func TestStoreMetaLookup(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Unix(1000, 0))
+	st := NewMemoryStore(MemoryStoreOptions{Clock: clock})
+	st.Set(ctx, &Item{Key: "soon", Flags: 3, Data: []byte("abc"), Expiration: clock.Now().Add(10 * time.Second)})
+	st.Set(ctx, &Item{Key: "forever", Data: []byte("x")})
+	clock.Advance(4 * time.Second)
+
+	lookup := StoreMetaLookup(st)
+	it, err := lookup(ctx, "soon")
+	if err != nil || it == nil || it.TTL != 6*time.Second || it.Flags != "3" || string(it.Data) != "abc" || it.Cas == "" {
+		t.Errorf("unexpected item %+v: %v", it, err)
+	}
+	if it, err := lookup(ctx, "forever"); err != nil || it == nil || it.TTL >= 0 {
+		t.Errorf("unexpected item %+v: %v", it, err)
+	}
+	if it, err := lookup(ctx, "missing"); err != nil || it != nil {
+		t.Errorf("expected a miss, got %+v: %v", it, err)
+	}
+
+	res := &Response{}
+	MetaGetHandler(lookup)(ctx, &Request{Command: "mg", Key: "soon", Keys: []string{"soon", "v", "t", "f"}}, res)
+	if res.String() != "VA 3 t6 f3\r\nabc\r\n" {
+		t.Errorf("unexpected response %q", res.String())
+	}
+}
@@ -0,0 +1,233 @@
+package mc
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotLocked is returned by a Txn for keys which are not locked by it.
+var ErrNotLocked = errors.New("key is not locked")
+
+// Txn reads and writes items of the keys locked by WithLock.
+// Writes are buffered and visible to later reads of the Txn; they are applied together when
+// the function of WithLock returns nil and discarded otherwise.
+type Txn interface {
+	// Get returns a copy of the item, or ErrNotFound.
+	Get(key string) (*Item, error)
+	// Set stores a copy of the item. Its cas is assigned when the Txn is committed.
+	Set(item *Item) error
+	// Delete deletes the item, or returns ErrNotFound.
+	Delete(key string) error
+}
+
+// Locker is implemented by stores which lock several keys at once, so handlers can
+// implement multi-key operations atomically, e.g. moving a value from one key to another.
+type Locker interface {
+	WithLock(ctx context.Context, keys []string, fn func(txn Txn) error) error
+}
+
+// WithLock implements Locker. It locks the shards of keys in a fixed order, so concurrent calls
+// never deadlock, and other keys in these shards are locked too. fn must not call other methods
+// of the store. Like any stored item, items written by fn may be evicted, or dropped by the
+// admission policy, once they are committed.
+func (s *MemoryStore) WithLock(ctx context.Context, keys []string, fn func(txn Txn) error) error {
+	l, unlock := s.lockKeys(keys...)
+	defer unlock()
+
+	txn := &memTxn{s: s, l: l, now: s.opts.Clock.Now(), keys: make(map[string]bool, len(keys)), writes: make(map[string]*Item)}
+	for _, key := range keys {
+		txn.keys[key] = true
+	}
+	if err := fn(txn); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// rejects the whole txn rather than applying a part of it
+	for _, it := range txn.writes {
+		if it != nil && !s.fits(l, it) {
+			return ErrTooLarge
+		}
+	}
+	if err := txn.checkQuotas(); err != nil {
+		return err
+	}
+	// deletes first, so they make room in quotas for the sets
+	for _, key := range txn.order {
+		if txn.writes[key] == nil {
+			if e, ok := l.shard(key).items.get(key); ok {
+				l.shard(key).remove(e)
+			}
+		}
+	}
+	for _, key := range txn.order {
+		if it := txn.writes[key]; it != nil {
+			if err := s.store(l.shard(key), it, txn.now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// txnUsage is the usage of a prefix in a shard while the writes of a txn are checked.
+type txnUsage struct {
+	items int
+	bytes int64
+}
+
+// checkQuotas returns the error of the first write of the txn which the quota of its prefix
+// would reject, counting the writes of the txn before it with deletes first, like WithLock
+// applies them. Expired items of prefixes which reject writes beyond their quotas are removed
+// first, since they would make room for the writes anyway, so storing the writes then evicts
+// nothing of these prefixes and can't fail.
+func (t *memTxn) checkQuotas() error {
+	usages := make(map[*shard]map[string]*txnUsage)
+	// usage returns the usage of the prefix of key with a quota, or nil if it has none
+	usage := func(sh *shard, key string) (*txnUsage, PrefixQuota) {
+		if sh.quotas == nil {
+			return nil, PrefixQuota{}
+		}
+		prefix, ok := keyPrefix(key, sh.delim)
+		if !ok {
+			return nil, PrefixQuota{}
+		}
+		q, ok := sh.quotas[prefix]
+		if !ok {
+			return nil, PrefixQuota{}
+		}
+		if usages[sh] == nil {
+			usages[sh] = make(map[string]*txnUsage)
+		}
+		if u, ok := usages[sh][prefix]; ok {
+			return u, q
+		}
+		if q.Reject {
+			sh.evictExpired(prefix, t.now)
+		}
+		u := &txnUsage{}
+		if pu := sh.prefixes[prefix]; pu != nil {
+			u.items, u.bytes = pu.items, pu.bytes
+		}
+		usages[sh][prefix] = u
+		return u, q
+	}
+
+	for _, key := range t.order {
+		sh := t.l.shard(key)
+		if t.writes[key] != nil {
+			continue
+		}
+		if u, _ := usage(sh, key); u != nil {
+			if old, ok := sh.items.get(key); ok {
+				u.items, u.bytes = u.items-1, u.bytes-old.size
+			}
+		}
+	}
+	for _, key := range t.order {
+		sh, it := t.l.shard(key), t.writes[key]
+		if it == nil {
+			continue
+		}
+		u, q := usage(sh, key)
+		if u == nil {
+			continue
+		}
+		size, _ := t.s.entrySize(it)
+		if q.MaxBytes > 0 && size > q.MaxBytes {
+			return ErrTooLarge
+		}
+		u.items, u.bytes = u.items+1, u.bytes+size
+		if old, ok := sh.items.get(key); ok {
+			u.items, u.bytes = u.items-1, u.bytes-old.size
+		}
+		// other items of prefixes which don't reject writes are evicted for room
+		if q.Reject && (q.MaxItems > 0 && u.items > q.MaxItems || q.MaxBytes > 0 && u.bytes > q.MaxBytes) {
+			return ErrOutOfMemory
+		}
+	}
+	return nil
+}
+
+// memTxn is the Txn of MemoryStore.
+type memTxn struct {
+	s      *MemoryStore
+	l      *layout
+	now    time.Time
+	keys   map[string]bool
+	writes map[string]*Item // nil for deleted items
+	order  []string         // keys of writes in order
+}
+
+func (t *memTxn) Get(key string) (*Item, error) {
+	if !t.keys[key] {
+		return nil, ErrNotLocked
+	}
+	if it, ok := t.writes[key]; ok {
+		if it == nil {
+			return nil, ErrNotFound
+		}
+		cp := *it
+		return &cp, nil
+	}
+
+	e, ok := t.l.shard(key).items.get(key)
+	if !ok || e.item.Expired(t.now) {
+		return nil, ErrNotFound
+	}
+	it := e.item
+	if e.chunk != nil {
+		it.Data = append([]byte(nil), e.chunk.buf...)
+	}
+	return &it, nil
+}
+
+func (t *memTxn) Set(item *Item) error {
+	if !t.keys[item.Key] {
+		return ErrNotLocked
+	}
+	cp := *item
+	t.write(item.Key, &cp)
+	return nil
+}
+
+func (t *memTxn) Delete(key string) error {
+	if _, err := t.Get(key); err != nil {
+		return err
+	}
+	t.write(key, nil)
+	return nil
+}
+
+func (t *memTxn) write(key string, it *Item) {
+	if _, ok := t.writes[key]; !ok {
+		t.order = append(t.order, key)
+	}
+	t.writes[key] = it
+}
+
+// fits returns whether the item can be stored in l without ErrTooLarge.
+func (s *MemoryStore) fits(l *layout, it *Item) bool {
+	size, ok := s.entrySize(it)
+	if !ok {
+		return false
+	}
+	max := l.shard(it.Key).max
+	return max == 0 || size <= max
+}
+
+// entrySize returns the size of the entry of it, or false if no slab class of the arena fits it.
+func (s *MemoryStore) entrySize(it *Item) (int64, bool) {
+	size := int64(len(it.Key)+len(it.Data)) + itemOverhead
+	if s.arena != nil {
+		c := s.arena.class(len(it.Data))
+		if c == nil {
+			return 0, false
+		}
+		size += int64(c.size - len(it.Data))
+	}
+	return size, true
+}
@@ -0,0 +1,139 @@
+package mc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// move moves the value of from to to.
+func move(st *MemoryStore, from, to string) error {
+	return st.WithLock(context.Background(), []string{from, to}, func(txn Txn) error {
+		it, err := txn.Get(from)
+		if err != nil {
+			return err
+		}
+		if err := txn.Delete(from); err != nil {
+			return err
+		}
+		it.Key = to
+		return txn.Set(it)
+	})
+}
+
+func TestWithLock(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore(MemoryStoreOptions{Shards: 4})
+	st.Set(ctx, &Item{Key: "a", Data: []byte("1")})
+
+	if err := move(st, "a", "b"); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	if _, err := st.Get(ctx, "a"); err != ErrNotFound {
+		t.Errorf("a should be moved: %v", err)
+	}
+	if it, err := st.Get(ctx, "b"); err != nil || string(it.Data) != "1" || it.Cas == 0 {
+		t.Errorf("unexpected b: %+v %v", it, err)
+	}
+	if err := move(st, "a", "b"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	err := st.WithLock(ctx, []string{"b"}, func(txn Txn) error {
+		txn.Delete("b")
+		if _, err := txn.Get("b"); err != ErrNotFound {
+			t.Errorf("txn should see its own delete: %v", err)
+		}
+		if err := txn.Set(&Item{Key: "c"}); err != ErrNotLocked {
+			t.Errorf("expected ErrNotLocked, got %v", err)
+		}
+		return ErrNotStored
+	})
+	if err != ErrNotStored {
+		t.Errorf("expected the error of fn, got %v", err)
+	}
+	if _, err := st.Get(ctx, "b"); err != nil {
+		t.Errorf("writes of failed txn should be discarded: %v", err)
+	}
+}
+
+func TestWithLockConcurrency(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore(MemoryStoreOptions{Shards: 8, Arena: true})
+	const accounts = 10
+	for i := 0; i < accounts; i++ {
+		st.Set(ctx, &Item{Key: "acct" + strconv.Itoa(i), Data: []byte("100")})
+	}
+
+	// transfers between random pairs never deadlock and keep the total
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				from, to := "acct"+strconv.Itoa((w+i)%accounts), "acct"+strconv.Itoa((w*3+i*7+1)%accounts)
+				if from == to {
+					continue
+				}
+				st.WithLock(ctx, []string{to, from}, func(txn Txn) error {
+					a, _ := txn.Get(from)
+					b, _ := txn.Get(to)
+					x, _ := strconv.Atoi(string(a.Data))
+					y, _ := strconv.Atoi(string(b.Data))
+					a.Data, b.Data = []byte(strconv.Itoa(x-1)), []byte(strconv.Itoa(y+1))
+					txn.Set(a)
+					return txn.Set(b)
+				})
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	total := 0
+	for i := 0; i < accounts; i++ {
+		it, _ := st.Get(ctx, "acct"+strconv.Itoa(i))
+		n, _ := strconv.Atoi(string(it.Data))
+		total += n
+	}
+	if total != accounts*100 {
+		t.Errorf("expected total %d, got %d", accounts*100, total)
+	}
+}
+
+func TestWithLockQuota(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore(MemoryStoreOptions{
+		Shards:          1,
+		PrefixDelimiter: ":",
+		PrefixQuotas:    map[string]PrefixQuota{"s": {MaxItems: 2, Reject: true}},
+	})
+	st.Set(ctx, &Item{Key: "s:1", Data: []byte("1")})
+
+	// the second write is over the quota, so neither is applied
+	err := st.WithLock(ctx, []string{"s:1", "s:2", "s:3"}, func(txn Txn) error {
+		txn.Set(&Item{Key: "s:2", Data: []byte("2")})
+		return txn.Set(&Item{Key: "s:3", Data: []byte("3")})
+	})
+	if !errors.Is(err, ErrOutOfMemory) {
+		t.Errorf("expected ErrOutOfMemory, got %v", err)
+	}
+	if _, err := st.Get(ctx, "s:2"); err != ErrNotFound {
+		t.Errorf("a write of a rejected txn is applied: %v", err)
+	}
+
+	// deletes of the txn make room for its sets
+	err = st.WithLock(ctx, []string{"s:1", "s:2", "s:3"}, func(txn Txn) error {
+		txn.Set(&Item{Key: "s:2", Data: []byte("2")})
+		txn.Set(&Item{Key: "s:3", Data: []byte("3")})
+		return txn.Delete("s:1")
+	})
+	if err != nil {
+		t.Fatalf("WithLock: %v", err)
+	}
+	if n := st.PrefixStats()["s"].Items; n != 2 {
+		t.Errorf("unexpected items: %d", n)
+	}
+}
@@ -0,0 +1,87 @@
+package mc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// Codec encodes and decodes values of type T. Flags let a codec mark the encoding of stored data.
+type Codec[T any] interface {
+	Encode(v T) (data []byte, flags uint32, err error)
+	Decode(data []byte, flags uint32) (T, error)
+}
+
+// JSONCodec encodes values as JSON. Its data has flags 0.
+type JSONCodec[T any] struct{}
+
+// Encode encodes v as JSON.
+func (JSONCodec[T]) Encode(v T) ([]byte, uint32, error) {
+	data, err := json.Marshal(v)
+	return data, 0, err
+}
+
+// Decode decodes JSON data.
+func (JSONCodec[T]) Decode(data []byte, flags uint32) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// StringCodec stores strings as is. Its data has flags 0.
+type StringCodec struct{}
+
+// Encode returns the bytes of v.
+func (StringCodec) Encode(v string) ([]byte, uint32, error) {
+	return []byte(v), 0, nil
+}
+
+// Decode returns data as a string.
+func (StringCodec) Decode(data []byte, flags uint32) (string, error) {
+	return string(data), nil
+}
+
+// Typed returns a get/gets handler which looks up every key by fn and encodes the results by codec.
+// fn returns ErrNotFound for missing keys.
+func Typed[T any](codec Codec[T], fn func(ctx context.Context, key string) (T, error)) HandlerFunc {
+	return func(ctx context.Context, req *Request, res *Response) error {
+		for _, key := range req.Keys {
+			v, err := fn(ctx, key)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			data, flags, err := codec.Encode(v)
+			if err != nil {
+				return err
+			}
+			if err := res.AddValue(key, flags, data, 0); err != nil {
+				return err
+			}
+		}
+		return res.End()
+	}
+}
+
+// TypedSet returns a set handler which decodes data of requests by codec and passes the results to fn.
+// Data which fails to decode is rejected with CLIENT_ERROR.
+func TypedSet[T any](codec Codec[T], fn func(ctx context.Context, key string, v T, exptime int64) error) HandlerFunc {
+	return func(ctx context.Context, req *Request, res *Response) error {
+		flags, err := req.FlagsUint32()
+		if err != nil {
+			return res.ClientError(err.Error())
+		}
+		v, err := codec.Decode(req.Data, flags)
+		if err != nil {
+			return res.ClientError("bad data")
+		}
+
+		if err := fn(ctx, req.Key, v, req.Exptime); err != nil {
+			return err
+		}
+		return res.Stored()
+	}
+}
@@ -0,0 +1,46 @@
+package mc
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+type testUser struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestTyped(t *testing.T) {
+	users := map[string]testUser{}
+
+	set := TypedSet[testUser](JSONCodec[testUser]{}, func(ctx context.Context, key string, u testUser, exptime int64) error {
+		users[key] = u
+		return nil
+	})
+	get := Typed[testUser](JSONCodec[testUser]{}, func(ctx context.Context, key string) (testUser, error) {
+		u, ok := users[key]
+		if !ok {
+			return u, ErrNotFound
+		}
+		return u, nil
+	})
+
+	res := &Response{}
+	set(context.Background(), &Request{Command: "set", Key: "u1", Flags: "0", Data: []byte(`{"name":"tom","age":3}`)}, res)
+	if res.Response != RespStored || users["u1"].Name != "tom" {
+		t.Fatalf("failed to set: %s %+v", res.Response, users)
+	}
+
+	res = &Response{}
+	set(context.Background(), &Request{Command: "set", Key: "u2", Flags: "0", Data: []byte(`{`)}, res)
+	if !strings.HasPrefix(res.Response, RespClientErr) {
+		t.Errorf("expected client error for bad data, got %s", res.Response)
+	}
+
+	res = &Response{}
+	get(context.Background(), &Request{Command: "get", Keys: []string{"u1", "u2"}}, res)
+	if res.String() != "VALUE u1 0 22\r\n{\"name\":\"tom\",\"age\":3}\r\nEND\r\n" {
+		t.Errorf("unexpected response: %q", res.String())
+	}
+}
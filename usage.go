@@ -0,0 +1,96 @@
+package mc
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// StoreUsage is a snapshot of the memory used by a MemoryStore, for capacity planning.
+type StoreUsage struct {
+	Items     int
+	Bytes     int64 // estimated memory used by items
+	MaxBytes  int64 // 0 if unlimited
+	Evictions uint64
+	// Expired is the number of items which have expired but still use memory until they are
+	// evicted, replaced or deleted.
+	Expired int
+	// OldestAge is the time since the least recently accessed item was accessed, which is how
+	// long unused items stay in the store before they are evicted.
+	OldestAge time.Duration
+	// LoadFactor is the number of items in the fullest shard relative to the mean of all shards.
+	// It is 1 if keys are spread evenly, and larger if some shards fill and evict earlier.
+	LoadFactor float64
+	Shards     []ShardUsage
+}
+
+// ShardUsage is the memory used by a shard of a MemoryStore.
+type ShardUsage struct {
+	Items    int
+	Bytes    int64
+	MaxBytes int64 // 0 if unlimited
+}
+
+// Occupancy returns the proportion of MaxBytes used, or 0 if it is unlimited.
+func (u ShardUsage) Occupancy() float64 {
+	if u.MaxBytes <= 0 {
+		return 0
+	}
+	return float64(u.Bytes) / float64(u.MaxBytes)
+}
+
+// Usage returns the memory usage of the store. It reads all items, shard by shard,
+// so it is meant for occasional introspection rather than every stats request.
+func (s *MemoryStore) Usage() StoreUsage {
+	now := s.opts.Clock.Now()
+	u := StoreUsage{MaxBytes: s.current().maxBytes, Evictions: s.Counters().Evictions}
+	oldest := now.UnixNano()
+	maxItems := 0
+	for _, sh := range s.shards() {
+		sh.mu.RLock()
+		su := ShardUsage{Items: sh.items.size(), Bytes: sh.bytes, MaxBytes: sh.max}
+		sh.items.each(func(k string, e *entry) {
+			if e.item.Expired(now) {
+				u.Expired++
+			}
+			if t := atomic.LoadInt64(&e.lastAccess); t < oldest {
+				oldest = t
+			}
+		})
+		sh.mu.RUnlock()
+
+		u.Items += su.Items
+		u.Bytes += su.Bytes
+		if su.Items > maxItems {
+			maxItems = su.Items
+		}
+		u.Shards = append(u.Shards, su)
+	}
+	u.OldestAge = time.Duration(now.UnixNano() - oldest)
+	if u.Items > 0 {
+		u.LoadFactor = float64(maxItems) * float64(len(u.Shards)) / float64(u.Items)
+	}
+	return u
+}
+
+// stats returns the usage as stats, with the shards as shard:<n>:<stat>.
+func (u StoreUsage) stats() []Stat {
+	stats := []Stat{
+		{"curr_items", strconv.Itoa(u.Items)},
+		{"bytes", strconv.FormatInt(u.Bytes, 10)},
+		{"limit_maxbytes", strconv.FormatInt(u.MaxBytes, 10)},
+		{"evictions", strconv.FormatUint(u.Evictions, 10)},
+		{"expired", strconv.Itoa(u.Expired)},
+		{"oldest_age", strconv.FormatInt(int64(u.OldestAge/time.Second), 10)},
+		{"load_factor", strconv.FormatFloat(u.LoadFactor, 'f', 2, 64)},
+	}
+	for i, su := range u.Shards {
+		id := "shard:" + strconv.Itoa(i) + ":"
+		stats = append(stats,
+			Stat{id + "items", strconv.Itoa(su.Items)},
+			Stat{id + "bytes", strconv.FormatInt(su.Bytes, 10)},
+			Stat{id + "occupancy", strconv.FormatFloat(su.Occupancy(), 'f', 4, 64)},
+		)
+	}
+	return stats
+}
@@ -0,0 +1,53 @@
+package mc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreUsage(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Unix(1000, 0))
+	st := NewMemoryStore(MemoryStoreOptions{Shards: 4, MaxBytes: 4 << 20, Clock: clock})
+	if u := st.Usage(); u.Items != 0 || u.OldestAge != 0 || u.LoadFactor != 0 || len(u.Shards) != 4 {
+		t.Errorf("unexpected usage of an empty store: %+v", u)
+	}
+
+	for i := 0; i < 100; i++ {
+		st.Set(ctx, &Item{Key: "k" + strconv.Itoa(i), Data: []byte("v"), Expiration: clock.Now().Add(time.Duration(i) * time.Second)})
+		clock.Advance(time.Second)
+	}
+	clock.Advance(10 * time.Second)
+
+	u := st.Usage()
+	if u.Items != 100 || u.Bytes != st.Bytes() || u.MaxBytes != 4<<20 {
+		t.Errorf("unexpected usage: %+v", u)
+	}
+	// ki expires at 1000+2i, so k0..k55 have expired at 1110
+	if u.Expired != 56 || u.OldestAge != 110*time.Second {
+		t.Errorf("unexpected expired %d and oldest age %v", u.Expired, u.OldestAge)
+	}
+	items, maxItems := 0, 0
+	for _, su := range u.Shards {
+		items += su.Items
+		if su.Items > maxItems {
+			maxItems = su.Items
+		}
+		if su.MaxBytes != 1<<20 || su.Occupancy() != float64(su.Bytes)/(1<<20) {
+			t.Errorf("unexpected shard usage: %+v", su)
+		}
+	}
+	if items != 100 || u.LoadFactor != float64(maxItems)/25 || u.LoadFactor < 1 {
+		t.Errorf("unexpected load factor %v of shards %+v", u.LoadFactor, u.Shards)
+	}
+
+	res := &Response{}
+	StatsHandler(st)(ctx, &Request{Command: "stats", Keys: []string{"usage"}}, res)
+	if !strings.Contains(res.Response, "STAT expired 56\r\nSTAT oldest_age 110\r\n") ||
+		!strings.Contains(res.Response, "STAT shard:3:items ") {
+		t.Errorf("unexpected stats usage: %q", res.Response)
+	}
+}
@@ -0,0 +1,74 @@
+package mc
+
+import (
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"sync/atomic"
+)
+
+// Verbosity levels of the debug logging of servers. Errors are always logged.
+const (
+	// VerbosityRequests logs every request, redacted by the redactor of the server.
+	VerbosityRequests = 1
+	// VerbosityResponses logs every response line too.
+	VerbosityResponses = 2
+)
+
+// SetVerbosity sets the verbosity level of all connections, see VerbosityRequests.
+// Clients set it by the verbosity command too, unless a handler of it is registered.
+func (s *Server) SetVerbosity(level int) {
+	atomic.StoreInt32(&s.verbosity, int32(level))
+}
+
+// Verbosity returns the verbosity level of all connections.
+func (s *Server) Verbosity() int {
+	return int(atomic.LoadInt32(&s.verbosity))
+}
+
+// SetConnVerbosity sets the verbosity level of the connections from remoteAddr, as reported by
+// Connections, so a single client can be debugged without logging all traffic. Connections
+// log at the higher of their level and the level of all connections. It returns whether
+// there is such a connection.
+func (s *Server) SetConnVerbosity(remoteAddr string, level int) bool {
+	found := false
+	s.clients.Range(func(k, v interface{}) bool {
+		if k.(net.Conn).RemoteAddr().String() == remoteAddr {
+			atomic.StoreInt32(&v.(*connState).verbosity, int32(level))
+			found = true
+		}
+		return true
+	})
+	return found
+}
+
+// connVerbosity returns the verbosity level of a connection.
+func (s *Server) connVerbosity(st *connState) int {
+	level := atomic.LoadInt32(&st.verbosity)
+	if global := atomic.LoadInt32(&s.verbosity); global > level {
+		level = global
+	}
+	return int(level)
+}
+
+// logExchange logs the request and response of a connection according to its verbosity level.
+func (s *Server) logExchange(conn net.Conn, st *connState, req *Request, res *Response) {
+	level := s.connVerbosity(st)
+	if level >= VerbosityRequests {
+		log.Printf("%s > %s", conn.RemoteAddr(), FormatRequest(s.getRedactor(), req))
+	}
+	if level >= VerbosityResponses {
+		log.Printf("%s < %s", conn.RemoteAddr(), FormatResponse(s.getRedactor(), res))
+	}
+}
+
+// verbosityCmd handles verbosity <level> [noreply], which sets the level of all connections.
+func (s *Server) verbosityCmd(ctx context.Context, req *Request, res *Response) error {
+	level, err := strconv.Atoi(req.Key)
+	if len(req.Keys) != 1 || err != nil || level < 0 {
+		return NewError("bad command line format. Usage: verbosity <level> [noreply]")
+	}
+	s.SetVerbosity(level)
+	return res.OK()
+}
@@ -0,0 +1,69 @@
+package mc
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is a buffer for logs written by server goroutines.
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestVerbosity(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+	var logs lockedBuffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	if line := roundTrip(t, addr, "verbosity x\r\n"); !strings.Contains(line, "bad command line format") {
+		t.Errorf("unexpected response %q", line)
+	}
+	if line := roundTrip(t, addr, "verbosity 2\r\n"); line != "OK\r\n" || s.Verbosity() != 2 {
+		t.Errorf("unexpected response %q, verbosity %d", line, s.Verbosity())
+	}
+	roundTrip(t, addr, "get all\r\n")
+	if l := logs.String(); !strings.Contains(l, "> get all\n") || !strings.Contains(l, "< ERROR get not implemented'\n") {
+		t.Errorf("requests and responses are not logged: %s", l)
+	}
+
+	s.SetVerbosity(0)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	if !s.SetConnVerbosity(conn.LocalAddr().String(), VerbosityRequests) || s.Connections()[0].Verbosity != VerbosityRequests {
+		t.Fatalf("connection verbosity is not set: %+v", s.Connections())
+	}
+	roundTrip(t, addr, "get other\r\n")
+	conn.Write([]byte("get traced\r\n"))
+	time.Sleep(50 * time.Millisecond)
+	if l := logs.String(); strings.Contains(l, "> get other") || !strings.Contains(l, "> get traced") {
+		t.Errorf("unexpected logs of connection verbosity: %s", l)
+	}
+	if s.SetConnVerbosity("127.0.0.1:1", 1) {
+		t.Errorf("verbosity set for a missing connection")
+	}
+}
@@ -0,0 +1,70 @@
+package mc
+
+import (
+	"context"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// modulePath is the path of this module in build info.
+const modulePath = "github.com/rpcxio/gomemcached"
+
+var (
+	buildOnce     sync.Once
+	buildVersion  = "(devel)"
+	buildRevision string
+)
+
+// readBuildInfo reads the version of this module and the git revision of the binary.
+func readBuildInfo() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	if info.Main.Path == modulePath && info.Main.Version != "" {
+		buildVersion = info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			buildVersion = dep.Version
+		}
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			buildRevision = s.Value
+		}
+	}
+	if len(buildRevision) > 12 {
+		buildRevision = buildRevision[:12]
+	}
+}
+
+// Version returns the version replied by the built-in version command: the module version,
+// the git revision of the binary if it is known, and the protocol extensions the server
+// supports, e.g. "v1.2.0 rev:0123456789ab features:meta,batch".
+// The built-in command is used unless a "version" handler is registered.
+func (s *Server) Version() string {
+	buildOnce.Do(readBuildInfo)
+
+	v := buildVersion
+	if buildRevision != "" {
+		v += " rev:" + buildRevision
+	}
+	var features []string
+	if s.root.registered("mg") || s.root.registered("ms") {
+		features = append(features, "meta")
+	}
+	if s.EnableBatchCommands {
+		features = append(features, "batch")
+	}
+	if len(features) > 0 {
+		v += " features:" + strings.Join(features, ",")
+	}
+	return v
+}
+
+// version handles the version command.
+func (s *Server) version(ctx context.Context, req *Request, res *Response) error {
+	return res.Version(s.Version())
+}
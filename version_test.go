@@ -0,0 +1,25 @@
+package mc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	if line := roundTrip(t, addr, "version\r\n"); line != "VERSION "+s.Version()+"\r\n" || strings.Contains(s.Version(), "features:") {
+		t.Errorf("unexpected response: %q", line)
+	}
+
+	s.RegisterFunc("mg", DefaultGet)
+	if v := s.Version(); !strings.HasSuffix(v, " features:meta") {
+		t.Errorf("unexpected version: %q", v)
+	}
+
+	s.RegisterFunc("version", DefaultVersion)
+	if line := roundTrip(t, addr, "version\r\n"); line != "VERSION 1\r\n" {
+		t.Errorf("registered handler should override the built-in one: %q", line)
+	}
+}
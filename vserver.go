@@ -0,0 +1,101 @@
+package mc
+
+import (
+	"log"
+	"net"
+)
+
+// VirtualServer serves connections of its own listener with its own handlers, e.g. to serve
+// tenants on different ports. It shares everything else with its server: metrics, taps,
+// recorders, redactors, settings, counters and the lifecycle of Start, Shutdown and Stop.
+type VirtualServer struct {
+	// Silent closes connections of the virtual server at their first protocol error without
+	// replying, like Server.Silent. It must be set before Start.
+	Silent bool
+
+	s    *Server
+	addr string
+	ln   net.Listener
+	h    *handlers
+}
+
+// Virtual adds a virtual server listening on addr, which has the formats of NewServer.
+// It must be called before Start, which starts the virtual servers too.
+func (s *Server) Virtual(addr string) *VirtualServer {
+	vs := &VirtualServer{s: s, addr: addr, h: newHandlers()}
+	s.mu.Lock()
+	s.virtuals = append(s.virtuals, vs)
+	s.mu.Unlock()
+	return vs
+}
+
+// RegisterFunc registers a handler of this virtual server, like Server.RegisterFunc.
+func (vs *VirtualServer) RegisterFunc(cmd string, fn HandlerFunc) error {
+	return vs.h.register(cmd, fn, vs.s.StrictRegistration)
+}
+
+// UnregisterFunc removes a handler of this virtual server.
+func (vs *VirtualServer) UnregisterFunc(cmd string) {
+	vs.h.unregister(cmd)
+}
+
+// SetDefaultHandler sets the default handler of this virtual server, like Server.SetDefaultHandler.
+func (vs *VirtualServer) SetDefaultHandler(fn HandlerFunc) {
+	vs.h.setDefault(fn)
+}
+
+// Group creates a handler group of this virtual server.
+func (vs *VirtualServer) Group(mws ...Middleware) *Group {
+	return &Group{r: vs, mws: append([]Middleware(nil), mws...)}
+}
+
+// Addr returns the address the virtual server listens on, or nil if it has not started.
+func (vs *VirtualServer) Addr() net.Addr {
+	if vs.ln == nil {
+		return nil
+	}
+	return vs.ln.Addr()
+}
+
+// startVirtuals listens on addresses of all virtual servers and serves them. Virtual servers
+// use the inherited listeners in order instead, see Handoff.
+// No virtual server is started if any of them fails to listen.
+func (s *Server) startVirtuals(inherited []net.Listener) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// listeners inherited for virtual servers which this process doesn't have are not used
+	for i := len(s.virtuals); i < len(inherited); i++ {
+		inherited[i].Close()
+	}
+	for i, vs := range s.virtuals {
+		if i < len(inherited) {
+			vs.ln = inherited[i]
+			continue
+		}
+		ln, err := listen(vs.addr)
+		if err != nil {
+			for _, started := range s.virtuals[:i] {
+				started.ln.Close()
+			}
+			return err
+		}
+		vs.ln = ln
+	}
+	for _, vs := range s.virtuals {
+		log.Printf("memcached virtual server starts on %s", vs.ln.Addr())
+		go s.serveListener(vs.ln, vs.h, vs.Silent)
+	}
+	return nil
+}
+
+// closeVirtuals closes listeners of virtual servers.
+func (s *Server) closeVirtuals() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, vs := range s.virtuals {
+		if vs.ln != nil {
+			vs.ln.Close()
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package mc
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVirtualServer(t *testing.T) {
+	port, _ := getFreePort()
+	s := NewServer("127.0.0.1:" + strconv.Itoa(port))
+	RegisterStore(s, NewMemoryStore(MemoryStoreOptions{}))
+	vs := s.Virtual("127.0.0.1:0")
+	RegisterStore(vs, NewMemoryStore(MemoryStoreOptions{}))
+	metrics := NewLatencyHistograms()
+	s.SetMetrics(metrics)
+
+	if vs.Addr() != nil {
+		t.Errorf("virtual server should not listen before Start")
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+	addrA, addrB := s.ln.Addr().String(), vs.Addr().String()
+
+	if line := roundTrip(t, addrA, "set k 0 0 1\r\na\r\n"); line != "STORED\r\n" {
+		t.Fatalf("unexpected response: %q", line)
+	}
+	if line := roundTrip(t, addrA, "get k\r\n"); line != "VALUE k 0 1\r\n" {
+		t.Errorf("unexpected response of tenant A: %q", line)
+	}
+	if line := roundTrip(t, addrB, "get k\r\n"); line != "END\r\n" {
+		t.Errorf("tenant B should not see items of tenant A: %q", line)
+	}
+
+	vs.UnregisterFunc("get")
+	if line := roundTrip(t, addrB, "get k\r\n"); line != "ERROR get not implemented'\r\n" {
+		t.Errorf("unexpected response: %q", line)
+	}
+	if line := roundTrip(t, addrA, "get k\r\n"); line != "VALUE k 0 1\r\n" {
+		t.Errorf("handlers of tenant A should not change: %q", line)
+	}
+
+	// metrics are shared, the get without a handler is not observed
+	if stats, _ := metrics.Stats(context.Background(), "latency"); statValue(stats, "get:count") != "3" {
+		t.Errorf("unexpected latency stats: %v", stats)
+	}
+
+	s.Stop()
+	if conn, err := net.Dial("tcp", addrB); err == nil {
+		conn.Close()
+		t.Errorf("virtual server should be stopped with its server")
+	}
+}